@@ -0,0 +1,604 @@
+package smallben
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"time"
+)
+
+// DefaultBoltTimeout is the default time to wait for the lock
+// on the database file, held by other processes.
+const DefaultBoltTimeout = 5 * time.Second
+
+var (
+	// ErrBoltJobNotFound is the error returned by RepositoryBolt
+	// when some of the involved jobs are not found.
+	// It is ErrJobNotFound.
+	ErrBoltJobNotFound = ErrJobNotFound
+	// ErrBoltDuplicateJob is returned by RepositoryBolt
+	// when adding a job that already exists.
+	// It is ErrDuplicateJob.
+	ErrBoltDuplicateJob = ErrDuplicateJob
+)
+
+// The buckets of the database. The jobs are stored as JSON, by ID, while the
+// index buckets map the concatenation of the indexed value and the ID of a job
+// to nothing, except for the names, mapping the super group and the name to the ID.
+var (
+	boltJobs             = []byte("jobs")
+	boltJobsByGroup      = []byte("jobs_by_group")
+	boltJobsBySuperGroup = []byte("jobs_by_super_group")
+	boltJobsByPaused     = []byte("jobs_by_paused")
+	boltJobsByName       = []byte("jobs_by_name")
+)
+
+// RepositoryBolt implements the Repository interface on top of an embedded
+// bbolt database, for deployments that do not want a separate database server.
+// Every operation is a single bbolt transaction, so it is atomic.
+type RepositoryBolt struct {
+	db *bolt.DB
+}
+
+// RepositoryBoltConfig is the configuration of RepositoryBolt.
+type RepositoryBoltConfig struct {
+	// Path is the path of the database file,
+	// created if it does not exist.
+	Path string
+	// Timeout is how long to wait for the lock on the database file,
+	// which can be opened by one process only.
+	// If zero, DefaultBoltTimeout is used.
+	Timeout time.Duration
+}
+
+// NewRepositoryBolt returns an instance of RepositoryBolt
+// storing the jobs in the file config.Path.
+func NewRepositoryBolt(config *RepositoryBoltConfig) (*RepositoryBolt, error) {
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = DefaultBoltTimeout
+	}
+	db, err := bolt.Open(config.Path, os.FileMode(0600), &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltJobs, boltJobsByGroup, boltJobsBySuperGroup, boltJobsByPaused, boltJobsByName} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &RepositoryBolt{db: db}, nil
+}
+
+// Close closes the database, releasing its file.
+func (r *RepositoryBolt) Close() error {
+	return r.db.Close()
+}
+
+// Ping checks whether the database is open.
+// It implements the HealthCheckRepository interface.
+func (r *RepositoryBolt) Ping(ctx context.Context) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		return nil
+	})
+}
+
+// ErrorTypeIfMismatchCount returns ErrBoltJobNotFound.
+func (r *RepositoryBolt) ErrorTypeIfMismatchCount() error {
+	return ErrBoltJobNotFound
+}
+
+// AddJobs adds `jobs` to the database, failing with ErrBoltDuplicateJob
+// if any of them already exists. None of the jobs is added in case of errors.
+func (r *RepositoryBolt) AddJobs(jobs []JobWithSchedule) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for i := range jobs {
+			rawJob, err := jobs[i].BuildJob()
+			if err != nil {
+				return err
+			}
+			old, err := boltGetJob(tx, rawJob.ID)
+			if err != nil {
+				return err
+			}
+			if old != nil {
+				return fmt.Errorf("%w: %d", ErrBoltDuplicateJob, rawJob.ID)
+			}
+			setCreationTimes(&rawJob)
+			if err = boltPutJob(tx, &rawJob, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertJobs adds the jobs of `jobs` that are not in the database,
+// and updates the other ones, in a single transaction.
+// The `paused`, `created_at`, `last_run_at` and `last_outcome` fields
+// of the updated jobs are left untouched, while their labels are replaced.
+func (r *RepositoryBolt) UpsertJobs(jobs []JobWithSchedule) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for i := range jobs {
+			rawJob, err := jobs[i].BuildJob()
+			if err != nil {
+				return err
+			}
+			old, err := boltGetJob(tx, rawJob.ID)
+			if err != nil {
+				return err
+			}
+			if old != nil {
+				rawJob.Paused = old.Paused
+				rawJob.CreatedAt = old.CreatedAt
+				rawJob.UpdatedAt = time.Now()
+				rawJob.LastRunAt = old.LastRunAt
+				rawJob.LastOutcome = old.LastOutcome
+			} else {
+				setCreationTimes(&rawJob)
+			}
+			if err = boltPutJob(tx, &rawJob, old); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetJob returns the JobWithSchedule whose id is `jobID`.
+// In case the job is not found, ErrBoltJobNotFound is returned.
+func (r *RepositoryBolt) GetJob(jobID int64) (JobWithSchedule, error) {
+	var rawJob *RawJob
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rawJob, err = boltGetJob(tx, jobID)
+		return err
+	})
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	if rawJob == nil {
+		return JobWithSchedule{}, ErrBoltJobNotFound
+	}
+	return rawJob.ToJobWithSchedule()
+}
+
+// GetJobByName returns the JobWithSchedule of the super group `superGroupID`
+// whose name is `name`. In case the job is not found, ErrBoltJobNotFound is returned.
+func (r *RepositoryBolt) GetJobByName(superGroupID int64, name string) (JobWithSchedule, error) {
+	var rawJob *RawJob
+	err := r.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(boltJobsByName).Get(boltNameKey(superGroupID, name))
+		if id == nil {
+			return nil
+		}
+		var err error
+		rawJob, err = boltGetJob(tx, boltDecodeID(id))
+		return err
+	})
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	if rawJob == nil {
+		return JobWithSchedule{}, ErrBoltJobNotFound
+	}
+	return rawJob.ToJobWithSchedule()
+}
+
+// PauseJobs pauses the jobs whose id are in `jobs`, resetting their
+// cron_id and next_run_at fields. The jobs found are paused anyway, but
+// ErrBoltJobNotFound is returned if some of the jobs are not found.
+func (r *RepositoryBolt) PauseJobs(jobs []RawJob) error {
+	return r.updateJobs(getIdsFromJobRawList(jobs), func(job *RawJob) {
+		job.Paused = true
+		job.CronID = 0
+		job.NextRunAt = nil
+	})
+}
+
+// ResumeJobs resumes the jobs whose id are in `jobs`. The jobs found are resumed
+// anyway, but ErrBoltJobNotFound is returned if some of the jobs are not found.
+func (r *RepositoryBolt) ResumeJobs(jobs []JobWithSchedule) error {
+	return r.updateJobs(getIdsFromJobsWithScheduleList(jobs), func(job *RawJob) {
+		job.Paused = false
+	})
+}
+
+// updateJobs updates the jobs whose id are in `ids` by `update`,
+// returning ErrBoltJobNotFound if some of them are not found,
+// after updating the other ones.
+func (r *RepositoryBolt) updateJobs(ids []int64, update func(job *RawJob)) error {
+	updated := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		seen := make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			old, err := boltGetJob(tx, id)
+			if err != nil {
+				return err
+			}
+			if old == nil {
+				continue
+			}
+			job := *old
+			update(&job)
+			if err = boltPutJob(tx, &job, old); err != nil {
+				return err
+			}
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if updated != len(ids) {
+		return ErrBoltJobNotFound
+	}
+	return nil
+}
+
+// GetAllJobsToExecute returns all the jobs whose `paused` field is set to `false`.
+func (r *RepositoryBolt) GetAllJobsToExecute() ([]JobWithSchedule, error) {
+	paused := false
+	rawJobs, err := r.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// GetJobsByIds returns all the jobs whose ids are in `jobsID`.
+// Returns ErrBoltJobNotFound in case there are less jobs than the requested ones.
+func (r *RepositoryBolt) GetJobsByIds(jobsID []int64) ([]JobWithSchedule, error) {
+	rawJobs, err := r.ListJobs(&ListJobsOptions{JobIDs: jobsID})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// DeleteJobsByIds deletes the jobs whose ids are in `jobsID`, returning
+// ErrBoltJobNotFound, without deleting any job, if some of them are not found.
+func (r *RepositoryBolt) DeleteJobsByIds(jobsID []int64) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		deleted := 0
+		seen := make(map[int64]bool, len(jobsID))
+		for _, id := range jobsID {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			old, err := boltGetJob(tx, id)
+			if err != nil {
+				return err
+			}
+			if old == nil {
+				continue
+			}
+			if err = boltDeleteJob(tx, old); err != nil {
+				return err
+			}
+			deleted++
+		}
+		if deleted != len(jobsID) {
+			return ErrBoltJobNotFound
+		}
+		return nil
+	})
+}
+
+// SetCronId updates the cron_id and next_run_at fields of `jobs`, returning
+// ErrBoltJobNotFound, without updating any job, if some of them are not found.
+func (r *RepositoryBolt) SetCronId(jobs []JobWithSchedule) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for _, job := range jobs {
+			err := boltUpdateJob(tx, job.rawJob.ID, func(rawJob *RawJob) {
+				rawJob.CronID = job.rawJob.CronID
+				rawJob.NextRunAt = job.rawJob.NextRunAt
+				rawJob.UpdatedAt = time.Now()
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetCronIdAndChangeScheduleAndJobInput updates the fields `cron_id`, `cron_expression`,
+// `next_run_at` and `serialized_job_input` of jobs, returning ErrBoltJobNotFound,
+// without updating any job, if some of them are not found.
+//
+// In particular, the job input must have been set internally, since
+// this call will encode the job input.
+func (r *RepositoryBolt) SetCronIdAndChangeScheduleAndJobInput(jobs []JobWithSchedule) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for _, job := range jobs {
+			if err := job.encodeJobInput(); err != nil {
+				return err
+			}
+			err := boltUpdateJob(tx, job.rawJob.ID, func(rawJob *RawJob) {
+				rawJob.CronID = job.rawJob.CronID
+				rawJob.CronExpression = job.rawJob.CronExpression
+				rawJob.NextRunAt = job.rawJob.NextRunAt
+				rawJob.SerializedJobInput = job.rawJob.SerializedJobInput
+				rawJob.UpdatedAt = time.Now()
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetNextRunAt updates the next_run_at field of the job whose id is `jobID`.
+func (r *RepositoryBolt) SetNextRunAt(jobID int64, nextRunAt *time.Time) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return boltUpdateJob(tx, jobID, func(rawJob *RawJob) {
+			rawJob.NextRunAt = nextRunAt
+		})
+	})
+}
+
+// SetLastRun updates the last_run_at and last_outcome fields
+// of the job whose id is `jobID`.
+func (r *RepositoryBolt) SetLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return boltUpdateJob(tx, jobID, func(rawJob *RawJob) {
+			rawJob.LastRunAt = &lastRunAt
+			rawJob.LastOutcome = outcome
+		})
+	})
+}
+
+// ListJobs list all jobs using options. If nil, no options will
+// be used, thus returning all the jobs.
+func (r *RepositoryBolt) ListJobs(options ToListOptions) ([]RawJob, error) {
+	var jobs []RawJob
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		jobs, err = boltCandidates(tx, options)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if jobs, err = listJobsInMemory(jobs, options); err != nil {
+		return nil, err
+	}
+	if options != nil {
+		convertedOptions := options.toListOptions()
+		if convertedOptions.byIDsOnly() && len(jobs) != len(convertedOptions.JobIDs) {
+			return jobs, ErrBoltJobNotFound
+		}
+	}
+	return jobs, nil
+}
+
+// CountJobs counts the jobs using options. If nil, no options will
+// be used, thus counting all the jobs.
+func (r *RepositoryBolt) CountJobs(options ToListOptions) (int64, error) {
+	var jobs []RawJob
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		jobs, err = boltCandidates(tx, options)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(filterJobsInMemory(jobs, options))), nil
+}
+
+// boltCandidates returns the jobs that may match `options`, by using the most
+// selective index available, to be filtered by filterJobsInMemory.
+func boltCandidates(tx *bolt.Tx, options ToListOptions) ([]RawJob, error) {
+	if options == nil {
+		return boltAllJobs(tx)
+	}
+	convertedOptions := options.toListOptions()
+	switch {
+	case len(convertedOptions.JobIDs) > 0:
+		return boltJobsByID(tx, convertedOptions.JobIDs)
+	case len(convertedOptions.SuperGroupIDs) > 0:
+		return boltJobsByIndex(tx, boltJobsBySuperGroup, boltIndexPrefixes(convertedOptions.SuperGroupIDs))
+	case len(convertedOptions.GroupIDs) > 0:
+		return boltJobsByIndex(tx, boltJobsByGroup, boltIndexPrefixes(convertedOptions.GroupIDs))
+	case convertedOptions.Paused != nil:
+		return boltJobsByIndex(tx, boltJobsByPaused, [][]byte{boltPausedPrefix(*convertedOptions.Paused)})
+	default:
+		return boltAllJobs(tx)
+	}
+}
+
+// boltAllJobs returns all the jobs.
+func boltAllJobs(tx *bolt.Tx) ([]RawJob, error) {
+	var jobs []RawJob
+	err := tx.Bucket(boltJobs).ForEach(func(_, value []byte) error {
+		var job RawJob
+		if err := json.Unmarshal(value, &job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	return jobs, err
+}
+
+// boltJobsByID returns the jobs whose id are in `ids`, skipping the missing ones.
+func boltJobsByID(tx *bolt.Tx, ids []int64) ([]RawJob, error) {
+	jobs := make([]RawJob, 0, len(ids))
+	seen := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		job, err := boltGetJob(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		if job != nil {
+			jobs = append(jobs, *job)
+		}
+	}
+	return jobs, nil
+}
+
+// boltJobsByIndex returns the jobs whose key in the index `bucket`
+// starts with one of `prefixes`.
+func boltJobsByIndex(tx *bolt.Tx, bucket []byte, prefixes [][]byte) ([]RawJob, error) {
+	var ids []int64
+	cursor := tx.Bucket(bucket).Cursor()
+	for _, prefix := range prefixes {
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			ids = append(ids, boltDecodeID(key[len(key)-8:]))
+		}
+	}
+	return boltJobsByID(tx, ids)
+}
+
+// boltGetJob returns the job whose id is `id`, nil if it is not found.
+func boltGetJob(tx *bolt.Tx, id int64) (*RawJob, error) {
+	value := tx.Bucket(boltJobs).Get(boltEncodeID(id))
+	if value == nil {
+		return nil, nil
+	}
+	var job RawJob
+	if err := json.Unmarshal(value, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// boltUpdateJob updates the job whose id is `id` by `update`,
+// returning ErrBoltJobNotFound if it is not found.
+func boltUpdateJob(tx *bolt.Tx, id int64, update func(job *RawJob)) error {
+	old, err := boltGetJob(tx, id)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return ErrBoltJobNotFound
+	}
+	job := *old
+	update(&job)
+	return boltPutJob(tx, &job, old)
+}
+
+// boltPutJob stores `job` and its index entries, replacing the ones of `old`,
+// if not nil. It returns ErrDuplicateJobName if another job of the same
+// super group has the same name.
+func boltPutJob(tx *bolt.Tx, job *RawJob, old *RawJob) error {
+	if job.Name != "" {
+		id := tx.Bucket(boltJobsByName).Get(boltNameKey(job.SuperGroupID, job.Name))
+		if id != nil && boltDecodeID(id) != job.ID {
+			return fmt.Errorf("%w: %s", ErrDuplicateJobName, job.Name)
+		}
+	}
+	if old != nil {
+		if err := boltDeleteIndexes(tx, old); err != nil {
+			return err
+		}
+	}
+	value, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(boltJobs).Put(boltEncodeID(job.ID), value); err != nil {
+		return err
+	}
+	for bucket, key := range boltIndexKeys(job) {
+		if err = tx.Bucket([]byte(bucket)).Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	if job.Name != "" {
+		return tx.Bucket(boltJobsByName).Put(boltNameKey(job.SuperGroupID, job.Name), boltEncodeID(job.ID))
+	}
+	return nil
+}
+
+// boltDeleteJob deletes `job` and its index entries.
+func boltDeleteJob(tx *bolt.Tx, job *RawJob) error {
+	if err := boltDeleteIndexes(tx, job); err != nil {
+		return err
+	}
+	return tx.Bucket(boltJobs).Delete(boltEncodeID(job.ID))
+}
+
+// boltDeleteIndexes deletes the index entries of `job`.
+func boltDeleteIndexes(tx *bolt.Tx, job *RawJob) error {
+	for bucket, key := range boltIndexKeys(job) {
+		if err := tx.Bucket([]byte(bucket)).Delete(key); err != nil {
+			return err
+		}
+	}
+	if job.Name != "" {
+		return tx.Bucket(boltJobsByName).Delete(boltNameKey(job.SuperGroupID, job.Name))
+	}
+	return nil
+}
+
+// boltIndexKeys returns the keys of the index entries
+// of `job`, by the name of their bucket.
+func boltIndexKeys(job *RawJob) map[string][]byte {
+	return map[string][]byte{
+		string(boltJobsByGroup):      append(boltEncodeID(job.GroupID), boltEncodeID(job.ID)...),
+		string(boltJobsBySuperGroup): append(boltEncodeID(job.SuperGroupID), boltEncodeID(job.ID)...),
+		string(boltJobsByPaused):     append(boltPausedPrefix(job.Paused), boltEncodeID(job.ID)...),
+	}
+}
+
+// boltIndexPrefixes returns the prefixes of the
+// index entries of the jobs having one of `values`.
+func boltIndexPrefixes(values []int64) [][]byte {
+	prefixes := make([][]byte, len(values))
+	for i, value := range values {
+		prefixes[i] = boltEncodeID(value)
+	}
+	return prefixes
+}
+
+// boltPausedPrefix returns the prefix of the index
+// entries of the jobs whose paused field is `paused`.
+func boltPausedPrefix(paused bool) []byte {
+	if paused {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// boltNameKey returns the key of the job of
+// the super group `superGroupID` named `name`.
+func boltNameKey(superGroupID int64, name string) []byte {
+	return append(boltEncodeID(superGroupID), name...)
+}
+
+// boltEncodeID encodes `id` in big endian, so that the keys are sorted by ID.
+func boltEncodeID(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// boltDecodeID decodes an ID encoded by boltEncodeID.
+func boltDecodeID(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key))
+}
@@ -0,0 +1,107 @@
+package smallben
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newBoltTestRepository returns a RepositoryBolt on a new file,
+// along with its path. The file is closed and removed when the test ends.
+func newBoltTestRepository(t *testing.T) (*RepositoryBolt, string) {
+	path := filepath.Join(newTestDir(t), "jobs.bolt")
+	repository, err := NewRepositoryBolt(&RepositoryBoltConfig{Path: path})
+	if err != nil {
+		t.Fatalf("Cannot open the database: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = repository.Close() })
+	return repository, path
+}
+
+// TestRepositoryBoltIndexes tests that the index entries
+// follow the jobs when they are updated and deleted.
+func TestRepositoryBoltIndexes(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+
+	jobs := []JobWithSchedule{
+		{rawJob: RawJob{ID: 1, GroupID: 1, SuperGroupID: 1, Name: "first", CronExpression: "@every 1s"},
+			run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 1}},
+		{rawJob: RawJob{ID: 2, GroupID: 2, SuperGroupID: 1, CronExpression: "@every 1s"},
+			run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 2}},
+	}
+	if err := repository.AddJobs(jobs); err != nil {
+		t.Fatalf("Cannot add jobs: %s", err.Error())
+	}
+	checkErrorIsOf(repository.AddJobs(jobs[:1]), ErrBoltDuplicateJob, t)
+
+	// move the first job to another group, and rename it
+	jobs[0].rawJob.GroupID = 2
+	jobs[0].rawJob.Name = "renamed"
+	if err := repository.UpsertJobs(jobs[:1]); err != nil {
+		t.Fatalf("Cannot upsert job: %s", err.Error())
+	}
+	if err := repository.PauseJobs([]RawJob{jobs[1].rawJob}); err != nil {
+		t.Fatalf("Cannot pause job: %s", err.Error())
+	}
+
+	paused := true
+	tests := []struct {
+		options  ListJobsOptions
+		expected []int64
+	}{
+		{options: ListJobsOptions{GroupIDs: []int64{1}}, expected: []int64{}},
+		{options: ListJobsOptions{GroupIDs: []int64{2}}, expected: []int64{1, 2}},
+		{options: ListJobsOptions{SuperGroupIDs: []int64{1}}, expected: []int64{1, 2}},
+		{options: ListJobsOptions{Paused: &paused}, expected: []int64{2}},
+		{options: ListJobsOptions{SuperGroupIDs: []int64{1}, JobNames: []string{"renamed"}}, expected: []int64{1}},
+	}
+	for _, test := range tests {
+		rawJobs, err := repository.ListJobs(&test.options)
+		if err != nil {
+			t.Errorf("Cannot list jobs: %s", err.Error())
+			continue
+		}
+		if ids := getIdsFromJobRawList(rawJobs); !equalIDs(ids, test.expected) {
+			t.Errorf("Wrong jobs for %+v. Got: %v, expected: %v", test.options, ids, test.expected)
+		}
+	}
+	if _, err := repository.GetJobByName(1, "first"); err != ErrBoltJobNotFound {
+		t.Errorf("The old name should have been removed: %v", err)
+	}
+	if _, err := repository.GetJobByName(1, "renamed"); err != nil {
+		t.Errorf("Cannot get job by name: %s", err.Error())
+	}
+
+	// a failed deletion deletes nothing
+	checkErrorIsOf(repository.DeleteJobsByIds([]int64{1, 3}), ErrBoltJobNotFound, t)
+	if err := repository.DeleteJobsByIds([]int64{1, 2}); err != nil {
+		t.Fatalf("Cannot delete jobs: %s", err.Error())
+	}
+	count, err := repository.CountJobs(&ListJobsOptions{GroupIDs: []int64{2}})
+	if err != nil || count != 0 {
+		t.Errorf("The index entries should have been deleted. Got: %d, %v", count, err)
+	}
+}
+
+// TestRepositoryBoltLock tests that the database
+// file cannot be opened twice at the same time.
+func TestRepositoryBoltLock(t *testing.T) {
+	_, path := newBoltTestRepository(t)
+
+	if _, err := NewRepositoryBolt(&RepositoryBoltConfig{Path: path, Timeout: 100 * time.Millisecond}); err == nil {
+		t.Errorf("The database has been opened twice")
+	}
+}
+
+// equalIDs returns whether `a` and `b` contain the same IDs, in the same order.
+func equalIDs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,225 @@
+package smallben
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrJobChained is returned when deleting a job that is
+// executed on success or on failure of a job not deleted as well.
+var ErrJobChained = errors.New("job is chained to other jobs")
+
+// chainLookupSize is the maximum number of IDs looked up at once by
+// JobFilters.TriggersJobIDs, keeping the SQL expressions small.
+const chainLookupSize = 100
+
+// checkChains makes sure the OnSuccess and OnFailure of `jobs` are jobs
+// that exist, among `jobs` or in `repository`, returning a BatchError otherwise.
+// It returns the IDs of the jobs of `jobs` that are triggered by other jobs,
+// i.e., the ones that are allowed to have an empty cron expression.
+func (s *SmallBen) checkChains(ctx context.Context, repository Repository, jobs []Job) (map[int64]bool, error) {
+	inBatch := make(map[int64]bool, len(jobs))
+	for _, job := range jobs {
+		inBatch[job.ID] = true
+	}
+	triggered := make(map[int64]bool)
+	// maps each chained job not in `jobs` to the jobs triggering it
+	triggeredBy := make(map[int64][]int64)
+	for _, job := range jobs {
+		for _, id := range append(append([]int64(nil), job.OnSuccess...), job.OnFailure...) {
+			if inBatch[id] {
+				triggered[id] = true
+			} else {
+				triggeredBy[id] = append(triggeredBy[id], job.ID)
+			}
+		}
+	}
+
+	batch := &BatchError{}
+	if len(triggeredBy) > 0 {
+		ids := make([]int64, 0, len(triggeredBy))
+		for id := range triggeredBy {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		var existing []RawJob
+		if err := s.traceRepository(ctx, "ListJobs", func() error {
+			var err error
+			existing, err = repository.ListJobs(&ListJobsOptions{JobIDs: ids, AllowMissing: true})
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		found := make(map[int64]bool, len(existing))
+		for _, job := range existing {
+			found[job.ID] = true
+		}
+		for _, id := range ids {
+			if found[id] {
+				continue
+			}
+			for _, jobID := range triggeredBy[id] {
+				batch.add(jobID, fmt.Errorf("%w: job %d is chained to it", ErrJobNotFound, id))
+			}
+		}
+	}
+	if err := batch.orNil(); err != nil {
+		return nil, err
+	}
+
+	// only the jobs without a schedule need to be
+	// triggered by the jobs already in the repository.
+	var unscheduled []int64
+	for _, job := range jobs {
+		if job.CronExpression == "" && len(job.UpstreamIDs) == 0 && !triggered[job.ID] {
+			unscheduled = append(unscheduled, job.ID)
+		}
+	}
+	triggering, err := s.listTriggering(ctx, repository, unscheduled)
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range triggering {
+		// the jobs in `jobs` are replaced by their new version
+		if inBatch[job.ID] {
+			continue
+		}
+		for _, encoded := range []string{job.OnSuccess, job.OnFailure} {
+			ids, err := decodeIDs(encoded)
+			if err != nil {
+				return nil, &JobError{JobID: job.ID, Err: err}
+			}
+			for _, id := range ids {
+				triggered[id] = true
+			}
+		}
+	}
+	return triggered, nil
+}
+
+// checkChainedDelete makes sure that deleting `jobsID` does not leave any job
+// of `repository` triggering, on success or on failure, one of the deleted jobs.
+func (s *SmallBen) checkChainedDelete(ctx context.Context, repository Repository, jobsID []int64) error {
+	triggering, err := s.listTriggering(ctx, repository, jobsID)
+	if err != nil {
+		return err
+	}
+	deleted := make(map[int64]bool, len(jobsID))
+	for _, id := range jobsID {
+		deleted[id] = true
+	}
+	for _, job := range triggering {
+		if deleted[job.ID] {
+			continue
+		}
+		for _, encoded := range []string{job.OnSuccess, job.OnFailure} {
+			ids, err := decodeIDs(encoded)
+			if err != nil {
+				return &JobError{JobID: job.ID, Err: err}
+			}
+			for _, id := range ids {
+				if deleted[id] {
+					return fmt.Errorf("%w: job %d is triggered by job %d", ErrJobChained, id, job.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// listTriggering returns the jobs of `repository` triggering,
+// on success or on failure, at least one of the jobs of `jobsID`.
+func (s *SmallBen) listTriggering(ctx context.Context, repository Repository, jobsID []int64) ([]RawJob, error) {
+	var triggering []RawJob
+	for start := 0; start < len(jobsID); start += chainLookupSize {
+		end := start + chainLookupSize
+		if end > len(jobsID) {
+			end = len(jobsID)
+		}
+		if err := s.traceRepository(ctx, "ListJobs", func() error {
+			jobs, err := repository.ListJobs(&ListJobsOptions{JobFilters: JobFilters{TriggersJobIDs: jobsID[start:end]}})
+			triggering = append(triggering, jobs...)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return triggering, nil
+}
+
+// runJob is executed each time a job fires.
+// If the job is the root of a workflow, a new workflow
+// run is started, otherwise the job is executed and the
+// jobs chained to it are triggered according to its outcome.
+func (s *SmallBen) runJob(job JobWithSchedule) {
+	s.updateNextRun(job)
+	if s.workflows.isRoot(job.rawJob.ID) {
+		s.runWorkflow(job)
+		return
+	}
+	// the outcome has been already logged
+	_ = s.runChain(job)
+}
+
+// updateNextRun records the time of the next execution of `job`, that just fired.
+// It is stored asynchronously, see runRecorder.
+func (s *SmallBen) updateNextRun(job JobWithSchedule) {
+	s.runs.setNextRun(job.rawJob.ID, job.schedule.Next(time.Now().In(s.scheduler.cron.Location())))
+}
+
+// runChain executes `job` and then, recursively, the jobs chained to it,
+// returning the outcome of `job`. Each job is executed at most once
+// in the same chain, so cycles between chained jobs are broken.
+func (s *SmallBen) runChain(job JobWithSchedule) error {
+	return s.runChained(job, make(map[int64]bool))
+}
+
+// runChained executes `job`, storing its outcome, and the jobs
+// chained to it, skipping the ones in `executed`.
+func (s *SmallBen) runChained(job JobWithSchedule, executed map[int64]bool) error {
+	executed[job.rawJob.ID] = true
+	err := s.execute(job)
+
+	// pick up the jobs to trigger according to the outcome
+	outcome := JobOutcomeSucceeded
+	encodedNext := job.rawJob.OnSuccess
+	parentError := ""
+	if err != nil {
+		s.logger.Error(err, "Running job", "Progress", "Error", "ID", job.rawJob.ID)
+		outcome = JobOutcomeFailed
+		encodedNext = job.rawJob.OnFailure
+		parentError = err.Error()
+	}
+	next, decodeErr := decodeIDs(encodedNext)
+	if decodeErr != nil {
+		s.logger.Error(decodeErr, "Triggering chained jobs", "Progress", "Error", "Details", "DecodingIDs", "ID", job.rawJob.ID)
+		return err
+	}
+
+	for _, id := range next {
+		if executed[id] {
+			s.logger.Info("Triggering chained jobs", "Progress", "Skipped", "Details", "AlreadyExecuted", "ParentID", job.rawJob.ID, "ID", id)
+			continue
+		}
+		// always grab the latest version of the job
+		chained, getErr := s.repository.GetJob(id)
+		if getErr != nil {
+			s.logger.Error(getErr, "Triggering chained jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "ParentID", job.rawJob.ID, "ID", id)
+			continue
+		}
+		if chained.rawJob.Paused {
+			s.logger.Info("Triggering chained jobs", "Progress", "Skipped", "Details", "Paused", "ParentID", job.rawJob.ID, "ID", id)
+			s.metrics.executions.skipRun(&chained.rawJob)
+			continue
+		}
+		s.logger.Info("Triggering chained jobs", "Progress", "InProgress", "ParentID", job.rawJob.ID, "ID", id, "Outcome", outcome)
+		chained.runInput.ParentJobID = job.rawJob.ID
+		chained.runInput.ParentOutcome = outcome
+		chained.runInput.ParentError = parentError
+		_ = s.runChained(chained, executed)
+	}
+	return err
+}
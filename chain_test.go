@@ -0,0 +1,242 @@
+package smallben
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chainExecuted keeps track of the execution
+// of the jobs during the chain tests.
+var chainExecuted = struct {
+	data map[int64]CronJobInput
+	lock sync.Mutex
+}{data: make(map[int64]CronJobInput)}
+
+// ChainTestCronJob records its input and fails
+// if input.OtherInputs["fail"] is true.
+type ChainTestCronJob struct{}
+
+func (c *ChainTestCronJob) Run(input CronJobInput) {
+	_ = c.RunWithResult(input)
+}
+
+func (c *ChainTestCronJob) RunWithResult(input CronJobInput) error {
+	chainExecuted.lock.Lock()
+	defer chainExecuted.lock.Unlock()
+	chainExecuted.data[input.JobID] = input
+	if fail, ok := input.OtherInputs["fail"].(bool); ok && fail {
+		return errors.New("failing on purpose")
+	}
+	return nil
+}
+
+func init() {
+	gob.Register(&ChainTestCronJob{})
+}
+
+func TestExecuteJob(t *testing.T) {
+	err := executeJob(context.Background(), JobWithSchedule{
+		run:      &ChainTestCronJob{},
+		runInput: CronJobInput{JobID: 1000, OtherInputs: map[string]interface{}{"fail": true}},
+	})
+	checkErrorMsg(err, "failing on purpose", t)
+
+	err = executeJob(context.Background(), JobWithSchedule{run: &WorkflowTestFailingCronJob{}})
+	checkErrorMsg(err, "job panicked", t)
+
+	err = executeJob(context.Background(), JobWithSchedule{run: &TestCronJobNoop{}})
+	if err != nil {
+		t.Errorf("A job without result should always succeed: %s\n", err.Error())
+	}
+}
+
+func (s *SmallBenTestSuite) TestChain(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// jobs without a schedule are not scheduled
+	if len(s.smallBen.scheduler.cron.Entries()) != 1 {
+		t.Errorf("Only the first job should have been scheduled. Got: %d\n", len(s.smallBen.scheduler.cron.Entries()))
+	}
+
+	// the chains are persisted
+	jobs, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs[0].OnSuccess) != 1 || len(jobs[0].OnFailure) != 1 {
+		t.Errorf("Chains have not been persisted. Got: %+v\n", jobs[0])
+	}
+
+	// wait for the first job to fire, then stop
+	// to wait for the chain to complete.
+	time.Sleep(1500 * time.Millisecond)
+	s.smallBen.Stop()
+
+	chainExecuted.lock.Lock()
+	defer chainExecuted.lock.Unlock()
+
+	if _, ok := chainExecuted.data[202]; ok {
+		t.Errorf("The job chained on success has been executed\n")
+	}
+	input, ok := chainExecuted.data[203]
+	if !ok {
+		t.Errorf("The job chained on failure has not been executed\n")
+		t.FailNow()
+	}
+	if input.ParentJobID != 201 || input.ParentOutcome != JobOutcomeFailed || input.ParentError != "failing on purpose" {
+		t.Errorf("Wrong parent. Got: %d %s %s\n", input.ParentJobID, input.ParentOutcome, input.ParentError)
+	}
+	// 204 has been triggered by 203, while
+	// 201 has not been triggered once again
+	input, ok = chainExecuted.data[204]
+	if !ok || input.ParentJobID != 203 || input.ParentOutcome != JobOutcomeSucceeded {
+		t.Errorf("The job chained on success of 203 has not been executed properly\n")
+	}
+	if chainExecuted.data[201].ParentJobID != 0 {
+		t.Errorf("201 has been triggered by the chain\n")
+	}
+
+	// the runs have been stored by Stop
+	jobs, err = s.smallBen.ListJobs(&ListJobsOptions{JobIDs: []int64{201, 203}})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if jobs[0].LastOutcome() != JobOutcomeFailed || jobs[0].LastRunAt().IsZero() || jobs[0].NextRunAt().IsZero() {
+		t.Errorf("The runs of 201 have not been stored. Got: %s %v %v\n", jobs[0].LastOutcome(), jobs[0].LastRunAt(), jobs[0].NextRunAt())
+	}
+	if jobs[1].LastOutcome() != JobOutcomeSucceeded || !jobs[1].NextRunAt().IsZero() {
+		t.Errorf("The runs of 203 have not been stored. Got: %s %v\n", jobs[1].LastOutcome(), jobs[1].NextRunAt())
+	}
+}
+
+func TestSmallBenChain(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.jobs = ChainJobsToUse
+		test.TestChain(t)
+		test.teardown(false, t)
+	}
+}
+
+// ChainJobsToUse is a chain where 201 fails,
+// triggering 203 which succeeds, triggering 204 and 201.
+var ChainJobsToUse = []Job{
+	{
+		ID:             201,
+		GroupID:        1,
+		SuperGroupID:   1,
+		CronExpression: "@every 1s",
+		Job:            &ChainTestCronJob{},
+		JobInput:       map[string]interface{}{"fail": true},
+		OnSuccess:      []int64{202},
+		OnFailure:      []int64{203},
+	}, {
+		ID:           202,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &ChainTestCronJob{},
+		JobInput:     map[string]interface{}{},
+	}, {
+		ID:           203,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &ChainTestCronJob{},
+		JobInput:     map[string]interface{}{},
+		OnSuccess:    []int64{204, 201},
+	}, {
+		ID:           204,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &ChainTestCronJob{},
+		JobInput:     map[string]interface{}{},
+	},
+}
+
+func (s *SmallBenTestSuite) TestChainChecks(t *testing.T) {
+	err := s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// a job without a schedule must be triggered by another job
+	err = s.smallBen.AddJobs([]Job{{ID: 211, GroupID: 1, SuperGroupID: 1, Job: &ChainTestCronJob{}}})
+	if !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("A job without a schedule has been added: %v\n", err)
+	}
+	// which can be added along with it...
+	triggered := []Job{
+		{ID: 211, GroupID: 1, SuperGroupID: 1, Job: &ChainTestCronJob{}},
+		{ID: 212, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 1h", Job: &ChainTestCronJob{}, OnFailure: []int64{211}},
+	}
+	err = s.smallBen.AddJobs(triggered)
+	if err != nil {
+		t.Errorf("Fail to add a job triggered by the batch: %s\n", err.Error())
+	} else {
+		err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{211, 212}}})
+		if err != nil {
+			t.Errorf("Fail to delete a job with the jobs triggering it: %s\n", err.Error())
+		}
+	}
+	// ...or already in the repository
+	_, err = s.smallBen.UpsertJobs([]Job{ChainJobsToUse[3]})
+	if err != nil {
+		t.Errorf("Fail to upsert a job triggered by the repository: %s\n", err.Error())
+	}
+
+	// the chained jobs must exist
+	err = s.smallBen.AddJobs([]Job{{ID: 213, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 1h",
+		Job: &ChainTestCronJob{}, OnSuccess: []int64{202, 299}}})
+	if !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("A job chained to a missing job has been added: %v\n", err)
+	}
+	var batch *BatchError
+	if !errors.As(err, &batch) || len(batch.Errors) != 1 || batch.Errors[0].JobID != 213 {
+		t.Errorf("Wrong error: %v\n", err)
+	}
+
+	// the chained jobs cannot be deleted alone
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{202}}})
+	if !errors.Is(err, ErrJobChained) {
+		t.Errorf("A chained job has been deleted: %v\n", err)
+	}
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{203, 204}}})
+	if !errors.Is(err, ErrJobChained) {
+		t.Errorf("A chained job has been deleted: %v\n", err)
+	}
+	jobs, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: getIdsFromJobList(s.jobs)})
+	if err != nil {
+		t.Errorf("The chained jobs have been deleted: %s\n", err.Error())
+	} else if len(jobs) != len(s.jobs) {
+		t.Errorf("The chained jobs have been deleted. Got: %d\n", len(jobs))
+	}
+}
+
+func TestSmallBenChainChecks(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.jobs = ChainJobsToUse
+		test.TestChainChecks(t)
+		test.teardown(false, t)
+	}
+}
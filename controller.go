@@ -1,10 +1,14 @@
 package smallben
 
 import (
+	"context"
+	"errors"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/trace"
 	"sync"
+	"time"
 )
 
 // Config is the struct configuring the overall
@@ -14,12 +18,45 @@ type Config struct {
 	SchedulerConfig SchedulerConfig
 	// Logger is the logger to use.
 	Logger logr.Logger
+	// Listeners are notified of the events about jobs.
+	// More listeners can be added by using SmallBen.Subscribe.
+	Listeners []Listener
+	// ListenerBufferSize is the number of events buffered for each
+	// listener: when the buffer is full, new events are dropped.
+	// If not set, DefaultListenerBufferSize is used.
+	ListenerBufferSize int
+	// Metrics configures the metrics about
+	// the executions of the jobs.
+	Metrics MetricsConfig
+	// TracerProvider provides the tracer used to trace operations
+	// and job executions. If nil, the global one is used.
+	TracerProvider trace.TracerProvider
+	// IDGenerator generates the IDs of the jobs added with a zero ID.
+	// If nil, a SnowflakeGenerator for node 0 is used.
+	IDGenerator IDGenerator
+	// JobTypes maps the names of the job types used in the
+	// manifests to the CronJob executing them.
+	JobTypes map[string]CronJob
+	// RunsFlushInterval is how often the runs of the executed jobs,
+	// i.e., their next_run_at, last_run_at and last_outcome, are stored
+	// in the repository, in batches, so that the executions never wait for it.
+	// If not set, DefaultRunsFlushInterval is used, while if negative
+	// the runs are never stored.
+	RunsFlushInterval time.Duration
 }
 
 // SmallBen is the struct managing the persistent
 // scheduler state.
 // SmallBen is *goroutine-safe*, since all access are protected by
 // a r-w lock.
+//
+// The operations changing the jobs store them first, and only then apply
+// the changes to the scheduler and emit their events. If the repository implements
+// OutboxRepository, the jobs are stored, along with a journal of the changes, within
+// a transaction, and the changes left in the journal, e.g., because of a crash, are
+// applied by Start. If the changes are stored but they cannot be applied, e.g., because
+// storing the cron_id of the jobs fails, the error matches ErrNotApplied: the changes
+// are not rolled back, and the jobs are scheduled by the next Start.
 type SmallBen struct {
 	// repository is the storage backend.
 	repository Repository
@@ -36,11 +73,48 @@ type SmallBen struct {
 	// started. In that case, subsequent calls to the Start
 	// method does not start the scheduler once again.
 	started bool
+	// running specifies if the cron
+	// loop is running.
+	running bool
+	// fillErr is the error returned by
+	// fill, if any.
+	fillErr error
 	// metrics keeps the prometheus metrics
 	// SmallBen export
 	metrics metrics
 	// logger is the logger used
 	logger logr.Logger
+	// workflows keeps the dependencies
+	// between jobs.
+	workflows *workflowGraph
+	// events delivers the events
+	// to the listeners.
+	events *eventDispatcher
+	// tracer traces operations
+	// and job executions.
+	tracer trace.Tracer
+	// resyncInterval is how often the
+	// metrics are resynced.
+	resyncInterval time.Duration
+	// stopResync stops the resync
+	// of the metrics.
+	stopResync chan struct{}
+	// runs keeps the runs of the executed
+	// jobs until they are stored.
+	runs *runRecorder
+	// runsInterval is how often
+	// the runs are stored.
+	runsInterval time.Duration
+	// stopRuns stops storing the runs, and
+	// runsDone is closed once stopped.
+	stopRuns chan struct{}
+	runsDone chan struct{}
+	// idGenerator generates the IDs
+	// of the jobs.
+	idGenerator IDGenerator
+	// jobTypes are the job types
+	// used by the manifests.
+	jobTypes map[string]CronJob
 }
 
 // New creates a new instance of SmallBen.
@@ -48,12 +122,36 @@ type SmallBen struct {
 // for the scheduler.
 func New(repository Repository, config *Config) *SmallBen {
 	scheduler := newScheduler(&config.SchedulerConfig)
-	return &SmallBen{
-		repository: repository,
-		scheduler:  scheduler,
-		metrics:    newMetrics(),
-		logger:     config.Logger,
+	smallBen := &SmallBen{
+		repository:     repository,
+		scheduler:      scheduler,
+		metrics:        newMetrics(config.Metrics),
+		logger:         config.Logger,
+		workflows:      newWorkflowGraph(),
+		events:         newEventDispatcher(config.ListenerBufferSize, config.Logger),
+		tracer:         newTracer(config.TracerProvider),
+		resyncInterval: config.Metrics.ResyncInterval,
+		runs:           newRunRecorder(config.RunsFlushInterval < 0),
+		runsInterval:   config.RunsFlushInterval,
+		idGenerator:    config.IDGenerator,
+		jobTypes:       config.JobTypes,
+	}
+	if smallBen.idGenerator == nil {
+		smallBen.idGenerator = &SnowflakeGenerator{}
+	}
+	if smallBen.resyncInterval == 0 {
+		smallBen.resyncInterval = DefaultMetricsResyncInterval
 	}
+	if smallBen.runsInterval == 0 {
+		smallBen.runsInterval = DefaultRunsFlushInterval
+	}
+	for _, listener := range config.Listeners {
+		smallBen.events.subscribe(listener)
+	}
+	// jobs are executed through SmallBen,
+	// to handle workflows.
+	smallBen.scheduler.execute = smallBen.runJob
+	return smallBen
 }
 
 // RegisterMetrics registers the prometheus metrics to registry.
@@ -76,12 +174,27 @@ func (s *SmallBen) Start() error {
 		s.scheduler.cron.Start()
 		// and mark it as started.
 		s.started = true
+		s.running = true
+		// keep the metrics in sync with the repository.
+		if s.resyncInterval > 0 {
+			s.stopResync = make(chan struct{})
+			go s.resyncMetrics(s.resyncInterval, s.stopResync)
+		}
+		// store the runs of the jobs.
+		if s.runsInterval > 0 {
+			s.stopRuns, s.runsDone = make(chan struct{}), make(chan struct{})
+			go s.recordRuns(s.runsInterval, s.stopRuns, s.runsDone)
+		}
 		// now, we fill in the scheduler
 		s.logger.Info("Starting", "Progress", "InProgress", "Details", "Filling")
 		var err error
 		if err = s.fill(); err != nil {
 			s.logger.Info("Starting", "Progress", "InProgress", "Details", "Filling done")
+		} else {
+			// then, apply the changes left in the outbox.
+			err = s.replayOutbox()
 		}
+		s.fillErr = err
 		return err
 	}
 	s.logger.Info("Starting", "Progress", "Done")
@@ -89,148 +202,158 @@ func (s *SmallBen) Start() error {
 }
 
 // Stop stops the SmallBen. This call will block until
-// all *running* jobs have finished their current execution.
+// all *running* jobs have finished their current execution,
+// and their runs have been stored.
+// Then, the listeners are unregistered: they still receive
+// the events emitted so far, but no new ones.
 func (s *SmallBen) Stop() {
 	s.logger.Info("Stopping", "Progress", "InProgress")
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	if s.stopResync != nil {
+		close(s.stopResync)
+		s.stopResync = nil
+	}
+	s.running = false
 	ctx := s.scheduler.cron.Stop()
 	// Wait on ctx.Done() till all jobsToAdd have finished, then left.
 	<-ctx.Done()
+	if s.stopRuns != nil {
+		close(s.stopRuns)
+		<-s.runsDone
+		s.stopRuns = nil
+	}
+	s.flushRuns()
+	s.events.close()
 	s.logger.Info("Stopping", "Progress", "Done")
 }
 
 // AddJobs add `jobs` to the scheduler.
-func (s *SmallBen) AddJobs(jobs []Job) error {
-	s.logger.Info("Adding jobs", "Progress", "InProgress", "IDs", getIdsFromJobList(jobs))
-
+// Jobs whose ID is zero are assigned a new ID by the IDGenerator:
+// the ID is set in `jobs`, so that the caller can retrieve it.
+// The errors of the jobs, e.g., ErrDuplicateJob or ErrInvalidSchedule,
+// are returned as a BatchError listing all of them.
+func (s *SmallBen) AddJobs(jobs []Job) (err error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	defer func() { err = duplicate(s.repository, getIdsFromJobList(jobs), err) }()
 
+	return s.journaled(func(tx *Tx) error { return tx.addJobs(jobs) })
+}
+
+// prepareJobs builds the JobWithSchedule of each job of `jobs`, to be added
+// to `repository`, making sure their names, their chains and their upstreams are valid.
+// The errors of all the jobs are returned as a BatchError. The IDs already taken
+// are reported by the repository when adding the jobs, see duplicate.
+func (s *SmallBen) prepareJobs(ctx context.Context, repository Repository, jobs []Job) ([]JobWithSchedule, error) {
+	// make sure the chained jobs exist
+	triggered, err := s.checkChains(ctx, repository, jobs)
+	if err != nil {
+		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "CheckingChains", "IDs", getIdsFromJobList(jobs))
+		return nil, err
+	}
+
+	batch := &BatchError{}
 	// build the JobWithSchedule struct for each requested Job
 	jobsWithSchedule := make([]JobWithSchedule, len(jobs))
+	seen := make(map[int64]bool, len(jobs))
 	for i, rawJob := range jobs {
+		if seen[rawJob.ID] {
+			batch.add(rawJob.ID, ErrDuplicateJob)
+			continue
+		}
+		seen[rawJob.ID] = true
+		rawJob.triggered = triggered[rawJob.ID]
 		job, err := rawJob.toJobWithSchedule()
-		// returning on the first error
 		if err != nil {
 			s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "BuildingJobWithSchedule", "ID", rawJob.ID)
-			return err
+			batch.add(rawJob.ID, err)
+			continue
 		}
 		jobsWithSchedule[i] = job
 	}
 
-	// now, add them to the scheduler
-	s.logger.Info("Adding jobs", "Progress", "InProgress", "Details", "AddingToScheduler", "IDs", getIdsFromJobList(jobs))
-	s.scheduler.AddJobs(jobsWithSchedule)
-
-	// now, store them in the database
-	s.logger.Info("Adding jobs", "Progress", "InProgress", "Details", "AddingToRepository", "IDs", getIdsFromJobList(jobs))
+	// make sure the names are unique
+	if err := s.checkNames(ctx, repository, jobs); err != nil {
+		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "CheckingNames", "IDs", getIdsFromJobList(jobs))
+		var names *BatchError
+		if !errors.As(err, &names) {
+			return nil, err
+		}
+		batch.Errors = append(batch.Errors, names.Errors...)
+	}
+	if err := batch.orNil(); err != nil {
+		return nil, err
+	}
 
-	if err := s.repository.AddJobs(jobsWithSchedule); err != nil {
-		// in case of errors, we remove all those jobs from the scheduler
-		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "AddingToRepository", "IDs", getIdsFromJobList(jobs))
+	// make sure the upstreams are valid
+	if err := s.checkUpstreams(ctx, repository, jobs); err != nil {
+		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "CheckingUpstreams", "IDs", getIdsFromJobList(jobs))
+		return nil, err
+	}
+	return jobsWithSchedule, nil
+}
 
-		s.logger.Info("Adding jobs", "Progress", "Cleaning", "Details", "CleaningScheduler", "IDs", getIdsFromJobList(jobs))
-		s.scheduler.DeleteJobsWithSchedule(jobsWithSchedule)
+// checkUpstreams makes sure the upstreams of `jobs` are valid,
+// i.e., they exist in `repository` and they do not create cycles.
+func (s *SmallBen) checkUpstreams(ctx context.Context, repository Repository, jobs []Job) error {
+	withUpstreams := false
+	for _, job := range jobs {
+		if len(job.UpstreamIDs) > 0 {
+			withUpstreams = true
+			break
+		}
+	}
+	if !withUpstreams {
+		return nil
+	}
+	if _, ok := repository.(WorkflowRepository); !ok {
+		return ErrWorkflowsNotSupported
+	}
+	unknown, err := s.workflows.check(jobs)
+	if err != nil {
 		return err
 	}
-	// increment the metrics
-	s.metrics.addJobs(len(jobs))
-	s.logger.Info("Adding jobs", "Progress", "Done", "IDs", getIdsFromJobList(jobs))
+	if len(unknown) > 0 {
+		// upstreams not in the graph must exist
+		// in the repository.
+		return s.traceRepository(ctx, "ListJobs", func() error {
+			_, err := repository.ListJobs(&ListJobsOptions{JobIDs: unknown})
+			return err
+		})
+	}
 	return nil
 }
 
 // DeleteJobs deletes permanently jobs according to options.
 // It returns an error of type repository.ErrorTypeIfMismatchCount() if the number
-// of deleted jobs does not match the expected one.
-func (s *SmallBen) DeleteJobs(options *DeleteOptions) error {
+// of deleted jobs does not match the expected one. The error matches ErrJobNotFound
+// too, and it is a BatchError listing the jobs of options.JobIDs that were missing.
+func (s *SmallBen) DeleteJobs(options *DeleteOptions) (err error) {
 
 	s.logger.Info("Deleting jobs", "Progress", "InProgress")
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	// report which jobs have not been found
+	defer func() { err = notFound(s.repository, options.JobIDs, err) }()
 
-	// we need for the metrics later
-	// since we don't if these jobs
-	// are in running or not.
-	beforeJobs := s.scheduler.cron.Entries()
-
-	// grab the jobs
-	// we need to know the cron id
-	jobs, err := s.repository.ListJobs(options)
-	if err != nil {
-		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", getIdsFromJobRawList(jobs))
-		return err
-	}
-
-	// if no jobs are returned then
-	// throws an error and exit
-	if len(jobs) == 0 {
-		s.logger.Info("Deleting jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", "No jobs found")
-		return s.repository.ErrorTypeIfMismatchCount()
-	}
-
-	s.logger.Info("Deleting jobs", "Progress", "InProgress", "IDs", getIdsFromJobRawList(jobs))
-
-	// now delete them
-	s.logger.Info("Deleting jobs", "Progress", "InProgress", "Details", "DeletingFromRepository", "IDs", getIdsFromJobRawList(jobs))
-	if err = s.repository.DeleteJobsByIds(getIdsFromJobRawList(jobs)); err != nil {
-		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "DeletingFromRepository", "IDs", getIdsFromJobRawList(jobs))
-		return err
-	}
-	s.logger.Info("Deleting jobs ", "Progress", "InProgress", "Details", "DeletingFromScheduler", "IDs", getIdsFromJobRawList(jobs))
-
-	// if here, the deletion from the database was fine
-	// so we can safely remove them from the scheduler.
-	s.scheduler.DeleteJobs(jobs)
-
-	// update the metrics
-	s.metrics.postDelete(beforeJobs, jobs)
-	s.logger.Info("Deleting jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
-	return nil
+	return s.journaled(func(tx *Tx) error { return tx.deleteJobs(options) })
 }
 
 // PauseJobs pauses the jobs according to the filter defined in options.
 // If no jobs matching options are found, an error of type ErrorTypeIfMismatchCount
-// is returned.
-func (s *SmallBen) PauseJobs(options *PauseResumeOptions) error {
+// is returned, matching ErrJobNotFound as DeleteJobs does.
+func (s *SmallBen) PauseJobs(options *PauseResumeOptions) (err error) {
 
 	s.logger.Info("Pausing jobs", "Progress", "InProgress")
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	// report which jobs have not been found
+	defer func() { err = notFound(s.repository, options.JobIDs, err) }()
 
-	// grab the corresponding jobs
-	jobs, err := s.repository.ListJobs(options)
-	if err != nil {
-		s.logger.Error(err, "Pausing jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", getIdsFromJobRawList(jobs))
-		return err
-	}
-
-	// if no jobs are returned then
-	// throws an error and exit
-	if len(jobs) == 0 {
-		s.logger.Info("Pausing jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", "No jobs found")
-		return s.repository.ErrorTypeIfMismatchCount()
-	}
-
-	s.logger.Info("Pausing jobs", "Progress", "InProgress", "Details", "PausingInRepository", "IDs", getIdsFromJobRawList(jobs))
-	// now, we have the list of jobs to act on.
-	// now update them in the database
-	if err = s.repository.PauseJobs(jobs); err != nil {
-		s.logger.Error(err, "Pausing jobs", "Progress", "Error", "Details", "PausingInRepository", "IDs", getIdsFromJobRawList(jobs))
-		return err
-	}
-	// if here, we have correctly paused them, so we can go on
-	// and safely delete them from the database.
-	s.scheduler.DeleteJobs(jobs)
-
-	// update the metrics
-	s.logger.Info("Pausing jobs", "Progress", "InProgress", "Details", "PausingInScheduler", "IDs", getIdsFromJobRawList(jobs))
-	s.metrics.pauseJobs(len(jobs))
-
-	s.logger.Info("Pausing jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
-	return nil
+	return s.journaled(func(tx *Tx) error { return tx.pauseJobs(options) })
 }
 
 // ResumeTests restarts the RawJob according to options.
@@ -238,67 +361,43 @@ func (s *SmallBen) PauseJobs(options *PauseResumeOptions) error {
 // In case of errors during the last steps of the execution,
 // the jobsToAdd are removed from the scheduler.
 // If no jobs matching options are found, an error of type ErrorTypeIfMismatchCount
-// is returned.
-func (s *SmallBen) ResumeJobs(options *PauseResumeOptions) error {
+// is returned, matching ErrJobNotFound as DeleteJobs does.
+func (s *SmallBen) ResumeJobs(options *PauseResumeOptions) (err error) {
 
 	s.logger.Info("Resume jobs", "Progress", "InProgress")
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	// report which jobs have not been found
+	defer func() { err = notFound(s.repository, options.JobIDs, err) }()
 
-	// grab the jobs
-	jobs, err := s.repository.ListJobs(options)
-	if err != nil {
-		s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", getIdsFromJobRawList(jobs))
-		return err
-	}
-
-	// if no jobs are returned then
-	// throws an error and exit
-	if len(jobs) == 0 {
-		s.logger.Info("Resuming jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", "No jobs found")
-		return s.repository.ErrorTypeIfMismatchCount()
-	}
+	return s.journaled(func(tx *Tx) error { return tx.resumeJobs(options) })
+}
 
+// jobsToResume returns the JobWithSchedule of the jobs of `jobs`
+// that are not in the scheduler yet. The errors of all the jobs
+// are returned as a BatchError.
+func (s *SmallBen) jobsToResume(jobs []RawJob) ([]JobWithSchedule, error) {
 	// now, we have to making sure those jobsToAdd are not already in the scheduler
 	// it's easier, just pick up those whose cron_id = 0
 	// because when a rawJob is being paused, it gets a cron_id of 0.
 	var finalJobs []JobWithSchedule
+	batch := &BatchError{}
 	for _, job := range jobs {
 		if job.CronID == DefaultCronID {
 			jobWithSchedule, err := job.ToJobWithSchedule()
 			if err != nil {
 				s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "BuildingJobWithSchedule", "ID", job.ID)
-				return err
+				batch.add(job.ID, err)
+				continue
 			}
 			finalJobs = append(finalJobs, jobWithSchedule)
 		}
 	}
-
-	// ok, now we mark those jobs as resumed
-	s.logger.Info("Resuming jobs", "Progress", "InProgress", "Details", "ResumingInRepository", "IDs", getIdsFromJobRawList(jobs))
-	if err = s.repository.ResumeJobs(finalJobs); err != nil {
-		return err
-	}
-
-	// and now add them in the scheduler
-	s.logger.Info("Resuming jobs", "Progress", "InProgress", "Details", "ResumingInScheduler", "IDs", getIdsFromJobRawList(jobs))
-	s.scheduler.AddJobs(finalJobs)
-
-	// now, update the database by setting the cron id
-	s.logger.Info("Resuming jobs", "Progress", "InProgress", "Details", "SetCronID", "IDs", getIdsFromJobRawList(jobs))
-	if err = s.repository.SetCronId(finalJobs); err != nil {
-		s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "SetCronID", "IDs", getIdsFromJobRawList(jobs))
-		// in case there have been errors, we clean up the scheduler too
-		// leaving the state unchanged.
-		s.logger.Info("Resuming jobs", "Progress", "Cleaning", "Details", "DeletingFromScheduler", getIdsFromJobRawList(jobs))
-		s.scheduler.DeleteJobsWithSchedule(finalJobs)
-		return err
+	if err := batch.orNil(); err != nil {
+		return nil, err
 	}
-	// update the metrics
-	s.metrics.resumeJobs(len(jobs))
-	s.logger.Info("Resuming jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
-	return nil
+	return finalJobs, nil
 }
 
 // UpdateOption updates the scheduler internal state according to `scheduleInfo`.
@@ -310,27 +409,30 @@ func (s *SmallBen) ResumeJobs(options *PauseResumeOptions) error {
 //
 // In case of errors, it is guaranteed that, in the worst case, jobs will be removed
 // from the scheduler will still being in the database with the old schedule and old JobOtherInputs.
-func (s *SmallBen) UpdateJobs(scheduleInfo []UpdateOption) error {
+// The errors of the jobs, e.g., ErrJobNotFound or ErrInvalidSchedule, are returned as a BatchError.
+func (s *SmallBen) UpdateJobs(scheduleInfo []UpdateOption) (err error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	// report which jobs have not been found
+	defer func() { err = notFound(s.repository, getIdsFromUpdateScheduleList(scheduleInfo), err) }()
 
-	s.logger.Info("Updating jobs", "Progress", "InProgress", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-	// first, we grab all the jobs
-	jobsWithScheduleOld, err := s.repository.GetJobsByIds(getIdsFromUpdateScheduleList(scheduleInfo))
-	if err != nil {
-		s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-		return err
-	}
+	return s.journaled(func(tx *Tx) error { return tx.updateJobs(scheduleInfo) })
+}
 
+// updatedJobs returns the jobs of `jobsWithScheduleOld` updated according
+// to `scheduleInfo`. The errors of all the jobs are returned as a BatchError.
+func (s *SmallBen) updatedJobs(jobsWithScheduleOld []JobWithSchedule, scheduleInfo []UpdateOption) ([]JobWithSchedule, error) {
 	jobsWithScheduleNew := make([]JobWithSchedule, len(scheduleInfo))
+	batch := &BatchError{}
 
 	// compute the new schedule
 	// for the required jobs, making sure the struct is valid.
 	for i, job := range jobsWithScheduleOld {
-		// if it is not valid, just return
+		// if it is not valid, go on with the next one
 		if err := scheduleInfo[i].Valid(); err != nil {
 			s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "Invalid UpdateOption", "ID", scheduleInfo[i].JobOtherInputs)
-			return err
+			batch.add(scheduleInfo[i].JobID, err)
+			continue
 		}
 
 		var newSchedule cron.Schedule
@@ -340,13 +442,20 @@ func (s *SmallBen) UpdateJobs(scheduleInfo []UpdateOption) error {
 		// if a new schedule has been specified
 		// we need to parse it
 		if scheduleInfo[i].CronExpression != nil {
+			// jobs with upstreams have no schedule
+			if job.rawJob.UpstreamIDs != "" {
+				s.logger.Error(ErrWorkflowJobWithSchedule, "Updating jobs", "Progress", "Error", "Details", "BuildingJobWithSchedule", "ID", scheduleInfo[i].JobID)
+				batch.add(scheduleInfo[i].JobID, ErrWorkflowJobWithSchedule)
+				continue
+			}
 			var err error
 			newJobRaw.CronExpression = *scheduleInfo[i].CronExpression
 			// build the cron.Schedule object from
 			newSchedule, err = scheduleInfo[i].schedule()
 			if err != nil {
 				s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "BuildingJobWithSchedule", "ID", scheduleInfo[i].JobID)
-				return err
+				batch.add(scheduleInfo[i].JobID, err)
+				continue
 			}
 		} else {
 			// otherwise, just keep the old one.
@@ -371,59 +480,45 @@ func (s *SmallBen) UpdateJobs(scheduleInfo []UpdateOption) error {
 		// now store the new rawJob into the list
 		jobsWithScheduleNew[i] = newJob
 	}
-
-	// now, remove the jobsToAdd from the scheduler
-	s.logger.Info("Updating jobs", "Progress", "InProgress", "Details", "DeletingFromScheduler", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-	s.scheduler.DeleteJobsWithSchedule(jobsWithScheduleNew)
-
-	// now, we re-add them to the scheduler
-	s.logger.Info("Updating jobs", "Progress", "InProgress", "Details", "AddingToScheduler", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-	s.scheduler.AddJobs(jobsWithScheduleNew)
-
-	// and update the database
-	s.logger.Info("Updating jobs", "Progress", "InProgress", "Details", "UpdatingInRepository", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-	if err = s.repository.SetCronIdAndChangeScheduleAndJobInput(jobsWithScheduleNew); err != nil {
-		s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "UpdatingInRepository", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-
-		// in case of errors, remove from the scheduler
-		s.logger.Info("Updating jobs", "Progress", "Cleaning", "Details", "DeleteFromScheduler", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-		s.scheduler.DeleteJobsWithSchedule(jobsWithScheduleNew)
-
-		// and update the metrics.
-		// Just need to decrement the number of running
-		// and increment the number of paused.
-		// It is the same as pause()
-		s.metrics.pauseJobs(len(jobsWithScheduleNew))
-		return err
+	if err := batch.orNil(); err != nil {
+		return nil, err
 	}
-	// if everything is fine, no need to
-	// update the metrics
-	s.logger.Info("Updating jobs", "Progress", "Done", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
-	return nil
+	return jobsWithScheduleNew, nil
 }
 
 // ListJobs returns the jobs according to `options`.
 // It may fail in case of:
 // - backend error
-// - deserialization error
+// - jobs not found, when listing by IDs only, matching ErrJobNotFound
+// - deserialization error, matching ErrDecodeJob
+// The errors of the jobs are returned as a BatchError.
 func (s *SmallBen) ListJobs(options *ListJobsOptions) ([]Job, error) {
 	// grab the list of raw jobs
 	rawJobs, err := s.repository.ListJobs(options)
 	if err != nil {
-		return nil, err
+		var ids []int64
+		if options != nil {
+			ids = options.JobIDs
+		}
+		return nil, notFound(s.repository, ids, err)
 	}
 	// the array holding the "parsed" jobs
 	jobs := make([]Job, len(rawJobs))
+	batch := &BatchError{}
 	for i, rawJob := range rawJobs {
 		// build the parsed job
 		job, err := rawJob.toJob()
 		// errors in case of deserialization
 		if err != nil {
-			return nil, err
+			batch.add(rawJob.ID, err)
+			continue
 		}
 		// otherwise just add it
 		jobs[i] = job
 	}
+	if err := batch.orNil(); err != nil {
+		return nil, err
+	}
 	return jobs, nil
 }
 
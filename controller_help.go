@@ -12,6 +12,10 @@ func (s *SmallBen) fill() error {
 		if err := s.fillMetrics(); err != nil {
 			return err
 		}
+		// build the dependencies between jobs
+		if err := s.fillWorkflows(); err != nil {
+			return err
+		}
 		// get all the tests
 		jobs, err := s.repository.GetAllJobsToExecute()
 		if err != nil {
@@ -24,12 +28,28 @@ func (s *SmallBen) fill() error {
 		if err != nil {
 			// if there is an error, remove them from the scheduler
 			s.scheduler.DeleteJobsWithSchedule(jobs)
+			return err
 		}
 		s.filled = true
 	}
 	return nil
 }
 
+// fillWorkflows adds to the workflow graph the dependencies
+// of the jobs in the repository, if it supports workflows.
+func (s *SmallBen) fillWorkflows() error {
+	repository, ok := s.repository.(WorkflowRepository)
+	if !ok {
+		return nil
+	}
+	upstreams, err := repository.ListUpstreams()
+	if err != nil {
+		return err
+	}
+	s.workflows.addUpstreams(upstreams)
+	return nil
+}
+
 // ToListOptions is an interface implemented
 // by structs that can be converted to a ListOptions struct.
 type ToListOptions interface {
@@ -44,12 +64,19 @@ type PauseResumeOptions struct {
 	// JobIDs specifies which jobs will be
 	// paused or resumed.
 	JobIDs []int64
+	// JobNames specifies the names of the jobs
+	// that will be paused or resumed. Since names
+	// are unique only within a super group,
+	// it should be combined with SuperGroupIDs.
+	JobNames []string
 	// GroupIDs specifies the group ids
 	// whose jobs will be paused or resumed.
 	GroupIDs []int64
 	// SuperGroupIDs specifies the super group ids
 	// whose jobs will be paused or resumed.
 	SuperGroupIDs []int64
+	// JobFilters are the other filters.
+	JobFilters
 }
 
 // ToListOptions convert to ListJobOptions. Just as in ListJobOptions,
@@ -61,6 +88,8 @@ func (o *PauseResumeOptions) toListOptions() ListJobsOptions {
 		GroupIDs:      o.GroupIDs,
 		SuperGroupIDs: o.SuperGroupIDs,
 		JobIDs:        o.JobIDs,
+		JobNames:      o.JobNames,
+		JobFilters:    o.JobFilters,
 	}
 }
 
@@ -82,5 +111,7 @@ func (o *DeleteOptions) toListOptions() ListJobsOptions {
 		GroupIDs:      o.GroupIDs,
 		SuperGroupIDs: o.SuperGroupIDs,
 		JobIDs:        o.JobIDs,
+		JobNames:      o.JobNames,
+		JobFilters:    o.JobFilters,
 	}
 }
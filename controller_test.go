@@ -7,11 +7,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/robfig/cron/v3"
 	"go.uber.org/zap"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 type SmallBenTestSuite struct {
@@ -439,18 +438,18 @@ func (s *SmallBenTestSuite) TestErrors(t *testing.T) {
 	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{
 		JobIDs: []int64{10000},
 	}})
-	checkErrorIsOf(err, gorm.ErrRecordNotFound, t)
+	checkErrorIsOf(err, s.smallBen.repository.ErrorTypeIfMismatchCount(), t)
 
 	// same for pause
 	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{10000}})
-	checkErrorIsOf(err, gorm.ErrRecordNotFound, t)
+	checkErrorIsOf(err, s.smallBen.repository.ErrorTypeIfMismatchCount(), t)
 
 	// same for update
 	err = s.smallBen.UpdateJobs([]UpdateOption{
 		{JobID: 10000,
 			CronExpression: stringPointer("@every 1s"),
 		}})
-	checkErrorIsOf(err, gorm.ErrRecordNotFound, t)
+	checkErrorIsOf(err, s.smallBen.repository.ErrorTypeIfMismatchCount(), t)
 
 	// new, let's require a non-valid schedule
 	err = s.smallBen.UpdateJobs([]UpdateOption{
@@ -460,21 +459,60 @@ func (s *SmallBenTestSuite) TestErrors(t *testing.T) {
 	if err == nil {
 		t.Errorf("A wrong schedule has been accepted")
 	}
-	if errors.Is(err, gorm.ErrRecordNotFound) {
+	if errors.Is(err, s.smallBen.repository.ErrorTypeIfMismatchCount()) {
 		t.Errorf("The error is of unexpected type: %s\n", err.Error())
 	}
 
-	// now we even test a wrong connection.
-	wrongConfig := RepositoryGormConfig{
-		Dialector: postgres.Open(""),
-		Config:    gorm.Config{},
+	// now we even test a wrong dialect.
+	_, err = NewRepositorySQL(&RepositorySQLConfig{Dialect: "oracle"})
+	checkErrorIsOf(err, ErrUnsupportedDialect, t)
+}
+
+func (s *SmallBenTestSuite) TestPauseFilters(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	// jobs 1 and 4 are failing, but only
+	// 1 is in super group 1.
+	for _, id := range []int64{1, 4} {
+		if err = s.smallBen.repository.SetLastRun(id, time.Now(), JobOutcomeFailed); err != nil {
+			t.Errorf("Fail to set last run: %s\n", err.Error())
+			t.FailNow()
+		}
 	}
 
-	_, err = NewRepositoryGorm(&wrongConfig)
-	if err == nil {
-		t.Errorf("An empty connection has been accepted")
+	// pause all the failing jobs of super group 1
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{
+		SuperGroupIDs: []int64{1},
+		JobFilters:    JobFilters{LastOutcomes: []JobOutcome{JobOutcomeFailed}},
+	})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	paused := true
+	jobs, err := s.smallBen.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 || jobs[0].ID != 1 || jobs[0].LastOutcome() != JobOutcomeFailed {
+		t.Errorf("Wrong paused jobs: %+v\n", jobs)
 	}
 
+	// no job is failing in super group 3
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{
+		SuperGroupIDs: []int64{3},
+		JobFilters:    JobFilters{LastOutcomes: []JobOutcome{JobOutcomeFailed}},
+	}})
+	checkErrorIsOf(err, s.smallBen.repository.ErrorTypeIfMismatchCount(), t)
 }
 
 func (s *SmallBenTestSuite) setup(t *testing.T) {
@@ -494,28 +532,17 @@ func (s *SmallBenTestSuite) teardown(okNotFound bool, t *testing.T) {
 		})
 	// getIdsFromJobList(s.jobs))
 	if err != nil {
-		if !(okNotFound && errors.Is(err, gorm.ErrRecordNotFound)) {
+		if !(okNotFound && errors.Is(err, s.smallBen.repository.ErrorTypeIfMismatchCount())) {
 			t.Errorf("Fail to delete: %s", err.Error())
 		}
 	}
 	s.smallBen.Stop()
 }
 
-func newGormRepository(config *RepositoryGormConfig, t *testing.T) Repository {
-	repository, err := NewRepositoryGorm(config)
-	if err != nil {
-		t.Errorf("Cannot open connection: %s\n", err.Error())
-		t.FailNow()
-	}
-	return repository
-}
-
 // Builds the list of test suites to execute.
 func buildSmallBenTestSuite(t *testing.T) []*SmallBenTestSuite {
 
-	repositories := []Repository{
-		newGormRepository(&RepositoryGormConfig{Dialector: postgres.Open(pgConn)}, t),
-	}
+	repositories := buildSQLRepositories(t)
 	tests := make([]*SmallBenTestSuite, len(repositories))
 
 	config := Config{Logger: zapr.NewLogger(zap.NewExample()), SchedulerConfig: SchedulerConfig{WithSeconds: true}}
@@ -556,6 +583,16 @@ func TestSmallBenUpdate(t *testing.T) {
 	}
 }
 
+func TestSmallBenPauseFilters(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestPauseFilters(t)
+		test.teardown(false, t)
+	}
+}
+
 func TestSmallBenOther(t *testing.T) {
 	tests := buildSmallBenTestSuite(t)
 
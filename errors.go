@@ -0,0 +1,201 @@
+package smallben
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrJobNotFound is returned when some of the jobs to act on
+	// do not exist. It is matched, by errors.Is, by the errors of
+	// the operations of SmallBen whatever the repository is, while
+	// the repositories return their own errors, ErrorTypeIfMismatchCount.
+	ErrJobNotFound = errors.New("job not found")
+	// ErrDuplicateJob is returned when adding a job
+	// whose ID is already taken.
+	ErrDuplicateJob = errors.New("duplicate job")
+	// ErrInvalidSchedule is returned when the
+	// cron expression of a job is not valid.
+	ErrInvalidSchedule = errors.New("invalid schedule")
+	// ErrDecodeJob is returned when a stored job cannot be decoded,
+	// e.g., because the type of its CronJob has not been registered to gob.
+	ErrDecodeJob = errors.New("cannot decode job")
+	// ErrNotApplied is returned, along with its cause, when the changes to
+	// the jobs have been stored, but they have not been applied to the scheduler,
+	// e.g., because storing the cron_id of the jobs failed. The jobs are stored
+	// anyway, and they are scheduled by the next Start.
+	ErrNotApplied = errors.New("changes stored but not applied to the scheduler")
+)
+
+// JobError is the error of a single job.
+type JobError struct {
+	// JobID is the ID of the job.
+	JobID int64
+	// Err is the error of the job.
+	Err error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("job %d: %s", e.JobID, e.Err.Error())
+}
+
+// Unwrap returns Err.
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is the error of an operation on many jobs, listing
+// each of the jobs that made it fail, along with its error.
+// It matches, by errors.Is and errors.As, any of the errors
+// of its jobs, as well as Err.
+type BatchError struct {
+	// Errors are the errors of the jobs.
+	Errors []JobError
+	// Err is the error of the operation as a whole, if any,
+	// e.g., the error returned by the repository.
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		messages[i] = e.Errors[i].Error()
+	}
+	if e.Err == nil {
+		return strings.Join(messages, "; ")
+	}
+	if len(messages) == 0 {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + strings.Join(messages, "; ")
+}
+
+// Unwrap returns Err.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the error of any of the jobs matches `target`.
+func (e *BatchError) Is(target error) bool {
+	for i := range e.Errors {
+		if errors.Is(&e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the jobs matching `target`.
+func (e *BatchError) As(target interface{}) bool {
+	for i := range e.Errors {
+		if errors.As(&e.Errors[i], target) {
+			return true
+		}
+	}
+	return false
+}
+
+// JobIDs returns the IDs of the jobs that made the operation fail.
+func (e *BatchError) JobIDs() []int64 {
+	ids := make([]int64, len(e.Errors))
+	for i := range e.Errors {
+		ids[i] = e.Errors[i].JobID
+	}
+	return ids
+}
+
+// add adds `err` as the error of the job whose ID is `jobID`.
+func (e *BatchError) add(jobID int64, err error) {
+	e.Errors = append(e.Errors, JobError{JobID: jobID, Err: err})
+}
+
+// orNil returns e, or nil if no error has been added.
+func (e *BatchError) orNil() error {
+	if len(e.Errors) == 0 && e.Err == nil {
+		return nil
+	}
+	return e
+}
+
+// kindError is an error matching, by errors.Is,
+// both its cause and the sentinel error of its kind.
+type kindError struct {
+	kind error
+	err  error
+}
+
+// withKind returns `err` as an error matching `kind` too,
+// while keeping `err` in the chain, e.g., to match io.EOF.
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+func (e *kindError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind.Error(), e.err.Error())
+}
+
+// Unwrap returns the cause.
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// Is returns whether `target` is the kind of the error.
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// notFound returns `err`, if it is the error of `repository` about jobs that have
+// not been found, as a BatchError matching ErrJobNotFound, listing which of the jobs
+// of `ids`, if any, are not in `repository`. Other errors are returned as they are.
+func notFound(repository Repository, ids []int64, err error) error {
+	if err == nil || !errors.Is(err, repository.ErrorTypeIfMismatchCount()) {
+		return err
+	}
+	var batch *BatchError
+	if errors.As(err, &batch) {
+		// already converted
+		return err
+	}
+	batch = &BatchError{Err: withKind(ErrJobNotFound, err)}
+	if len(ids) == 0 {
+		return batch
+	}
+	existing, listErr := repository.ListJobs(&ListJobsOptions{JobIDs: ids, AllowMissing: true})
+	if listErr != nil {
+		return batch
+	}
+	found := make(map[int64]bool, len(existing))
+	for _, job := range existing {
+		found[job.ID] = true
+	}
+	for _, id := range ids {
+		if !found[id] {
+			batch.add(id, ErrJobNotFound)
+		}
+	}
+	return batch
+}
+
+// duplicate returns `err`, if it matches ErrDuplicateJob, as a BatchError
+// listing which of the jobs of `ids` are already in `repository`.
+// Other errors are returned as they are.
+func duplicate(repository Repository, ids []int64, err error) error {
+	if err == nil || !errors.Is(err, ErrDuplicateJob) {
+		return err
+	}
+	var batch *BatchError
+	if errors.As(err, &batch) {
+		// already converted
+		return err
+	}
+	batch = &BatchError{Err: err}
+	existing, listErr := repository.ListJobs(&ListJobsOptions{JobIDs: ids, AllowMissing: true})
+	if listErr != nil {
+		return batch
+	}
+	for _, job := range existing {
+		batch.add(job.ID, ErrDuplicateJob)
+	}
+	return batch
+}
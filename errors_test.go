@@ -0,0 +1,136 @@
+package smallben
+
+import (
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestBatchError(t *testing.T) {
+	batch := &BatchError{}
+	if batch.orNil() != nil {
+		t.Errorf("An empty batch should be nil")
+	}
+	batch.add(1, withKind(ErrDecodeJob, io.EOF))
+	batch.add(2, ErrDuplicateJob)
+	err := batch.orNil()
+
+	for _, expected := range []error{ErrDecodeJob, io.EOF, ErrDuplicateJob} {
+		checkErrorIsOf(err, expected, t)
+	}
+	if errors.Is(err, ErrJobNotFound) {
+		t.Errorf("The error should not match ErrJobNotFound")
+	}
+	var jobError *JobError
+	if !errors.As(err, &jobError) || jobError.JobID != 1 {
+		t.Errorf("The error should be a JobError of the job 1. Got: %+v", jobError)
+	}
+	if ids := batch.JobIDs(); !reflect.DeepEqual(ids, []int64{1, 2}) {
+		t.Errorf("Wrong job IDs. Got: %v", ids)
+	}
+	checkErrorMsg(err, "job 1: cannot decode job: EOF; job 2: duplicate job", t)
+
+	// the error of the operation is kept along with the ones of the jobs
+	batch.Err = io.ErrUnexpectedEOF
+	checkErrorMsg(err, "unexpected EOF: job 1: cannot decode job: EOF; job 2: duplicate job", t)
+	batch.Errors = nil
+	checkErrorMsg(err, "unexpected EOF", t)
+}
+
+func (s *SmallBenTestSuite) TestTypedErrors(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+
+	// all the invalid schedules are reported
+	invalid := make([]Job, len(jobs))
+	copy(invalid, jobs)
+	invalid[1].CronExpression = "not a cron expression"
+	invalid[3].CronExpression = "@every"
+	err = s.smallBen.AddJobs(invalid)
+	checkErrorIsOf(err, ErrInvalidSchedule, t)
+	checkBatchJobIDs(err, []int64{jobs[1].ID, jobs[3].ID}, t)
+
+	// as the jobs that already exist
+	if err = s.smallBen.AddJobs(jobs[:2]); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	err = s.smallBen.AddJobs(jobs[1:])
+	checkErrorIsOf(err, ErrDuplicateJob, t)
+	checkBatchJobIDs(err, []int64{jobs[1].ID}, t)
+	if err = s.smallBen.AddJobs(jobs[2:]); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+
+	// the missing jobs are listed, and the error
+	// of the repository is still matched.
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{jobs[0].ID, 10000, 10001}})
+	checkErrorIsOf(err, ErrJobNotFound, t)
+	checkErrorIsOf(err, s.smallBen.ErrorTypeIfMismatchCount(), t)
+	checkBatchJobIDs(err, []int64{10000, 10001}, t)
+
+	cronExpression := "not a cron expression"
+	err = s.smallBen.UpdateJobs([]UpdateOption{
+		{JobID: jobs[0].ID, CronExpression: &cronExpression},
+		{JobID: jobs[2].ID, CronExpression: &cronExpression},
+	})
+	checkErrorIsOf(err, ErrInvalidSchedule, t)
+	checkBatchJobIDs(err, []int64{jobs[0].ID, jobs[2].ID}, t)
+
+	_, err = s.smallBen.ListJobs(&ListJobsOptions{JobIDs: []int64{jobs[0].ID, 10000}})
+	checkErrorIsOf(err, ErrJobNotFound, t)
+	checkBatchJobIDs(err, []int64{10000}, t)
+}
+
+// checkBatchJobIDs checks that `err` is a BatchError listing `expected`.
+func checkBatchJobIDs(err error, expected []int64, t *testing.T) {
+	var batch *BatchError
+	if !errors.As(err, &batch) {
+		t.Errorf("The error should be a BatchError. Got: %v", err)
+		t.FailNow()
+	}
+	if ids := batch.JobIDs(); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Wrong job IDs. Got: %v, expected: %v", ids, expected)
+	}
+}
+
+func TestSmallBenTypedErrors(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestTypedErrors(t)
+		test.teardown(false, t)
+	}
+}
+
+// TestSmallBenTypedErrorsBolt tests that the errors of the other
+// repositories match the same errors.
+func TestSmallBenTypedErrorsBolt(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+	smallBen := New(repository, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+	jobs := make([]Job, len(JobsToUse))
+	copy(jobs, JobsToUse)
+	if err := smallBen.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	err := smallBen.AddJobs(jobs[:2])
+	checkErrorIsOf(err, ErrDuplicateJob, t)
+	checkBatchJobIDs(err, []int64{jobs[0].ID, jobs[1].ID}, t)
+	err = smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{10000}}})
+	checkErrorIsOf(err, ErrJobNotFound, t)
+	checkBatchJobIDs(err, []int64{10000}, t)
+	if err = smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: getIdsFromJobList(jobs)}}); err != nil {
+		t.Errorf("Fail to delete: %s", err.Error())
+	}
+}
@@ -0,0 +1,236 @@
+package smallben
+
+import (
+	"github.com/go-logr/logr"
+	"sync"
+	"time"
+)
+
+// DefaultListenerBufferSize is the number of events buffered
+// for each Listener when Config.ListenerBufferSize is not set.
+const DefaultListenerBufferSize = 256
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventJobAdded is emitted when a job is added by AddJobs.
+	EventJobAdded = EventType("job_added")
+	// EventJobPaused is emitted when a job is paused by PauseJobs.
+	EventJobPaused = EventType("job_paused")
+	// EventJobResumed is emitted when a job is resumed by ResumeJobs.
+	EventJobResumed = EventType("job_resumed")
+	// EventJobUpdated is emitted when a job is updated by UpdateJobs.
+	EventJobUpdated = EventType("job_updated")
+	// EventJobDeleted is emitted when a job is deleted by DeleteJobs.
+	EventJobDeleted = EventType("job_deleted")
+	// EventJobStarted is emitted when an execution of a job starts.
+	EventJobStarted = EventType("job_started")
+	// EventJobFinished is emitted when an execution of a job succeeds.
+	EventJobFinished = EventType("job_finished")
+	// EventJobFailed is emitted when an execution of a job fails.
+	EventJobFailed = EventType("job_failed")
+)
+
+// Event is an event about a job, delivered to the listeners.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+	// Time is when the event happened.
+	Time time.Time
+	// JobID is the ID of the job.
+	JobID int64
+	// GroupID is the GroupID of the job.
+	GroupID int64
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64
+	// JobName is the Name of the job, if any.
+	JobName string
+	// Duration is how long the execution of the job took.
+	// It is only set for EventJobFinished and EventJobFailed.
+	Duration time.Duration
+	// Error is the error the execution failed with.
+	// It is only set for EventJobFailed.
+	Error error
+	// PreviousOutcome is the outcome of the previous execution of the job,
+	// as stored in its last_outcome, empty if it has never been executed.
+	// It is only set for EventJobFinished and EventJobFailed.
+	PreviousOutcome JobOutcome
+}
+
+// Listener is the interface to implement to be notified of events.
+type Listener interface {
+	// OnEvent is called for each event, one at a time, in the same
+	// order they have been emitted.
+	OnEvent(event Event)
+}
+
+// ListenerFunc is an adapter to use functions as Listener.
+type ListenerFunc func(event Event)
+
+// OnEvent calls f(event).
+func (f ListenerFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// newEvent returns an event of type `eventType` about `job`.
+func newEvent(eventType EventType, job *RawJob) Event {
+	return Event{
+		Type:         eventType,
+		Time:         time.Now(),
+		JobID:        job.ID,
+		GroupID:      job.GroupID,
+		SuperGroupID: job.SuperGroupID,
+		JobName:      job.Name,
+	}
+}
+
+// subscription is a Listener together with
+// the buffer of the events to deliver to it.
+type subscription struct {
+	listener Listener
+	events   chan Event
+}
+
+// run delivers the events to the listener, until
+// the channel is closed.
+func (s *subscription) run() {
+	for event := range s.events {
+		s.listener.OnEvent(event)
+	}
+}
+
+// eventDispatcher delivers the events to the listeners, asynchronously.
+// Each listener has its own goroutine and its own buffer: when the buffer is full,
+// new events for that listener are dropped, so a slow listener never blocks
+// the emitter.
+type eventDispatcher struct {
+	lock          sync.RWMutex
+	subscriptions []*subscription
+	// closed is set by close, after which
+	// the listeners are not registered anymore.
+	closed     bool
+	bufferSize int
+	logger     logr.Logger
+}
+
+// newEventDispatcher returns a new eventDispatcher, where each
+// listener buffers up to `bufferSize` events.
+// If bufferSize is not positive, DefaultListenerBufferSize is used.
+func newEventDispatcher(bufferSize int, logger logr.Logger) *eventDispatcher {
+	if bufferSize <= 0 {
+		bufferSize = DefaultListenerBufferSize
+	}
+	return &eventDispatcher{bufferSize: bufferSize, logger: logger}
+}
+
+// subscribe registers `listener`, returning a function
+// to unregister it. Once the dispatcher is closed,
+// `listener` is not registered at all.
+func (d *eventDispatcher) subscribe(listener Listener) func() {
+	sub := &subscription{
+		listener: listener,
+		events:   make(chan Event, d.bufferSize),
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return func() {}
+	}
+	d.subscriptions = append(d.subscriptions, sub)
+	go sub.run()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			// the subscription is closed by close too
+			for i, other := range d.subscriptions {
+				if other == sub {
+					d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+					close(sub.events)
+					break
+				}
+			}
+		})
+	}
+}
+
+// close unregisters all the listeners, whose goroutines end
+// after delivering the events already emitted, without waiting for them.
+func (d *eventDispatcher) close() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, sub := range d.subscriptions {
+		close(sub.events)
+	}
+	d.subscriptions = nil
+	d.closed = true
+}
+
+// emit delivers `events` to all the listeners. It never blocks.
+func (d *eventDispatcher) emit(events ...Event) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	for _, sub := range d.subscriptions {
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			default:
+				d.logger.Info("Dispatching event", "Progress", "Dropped", "Type", event.Type, "ID", event.JobID)
+			}
+		}
+	}
+}
+
+// emitRaw emits an event of type `eventType` for each job in `jobs`.
+func (d *eventDispatcher) emitRaw(eventType EventType, jobs []RawJob) {
+	events := make([]Event, len(jobs))
+	for i := range jobs {
+		events[i] = newEvent(eventType, &jobs[i])
+	}
+	d.emit(events...)
+}
+
+// emitWithSchedule emits an event of type `eventType` for each job in `jobs`.
+func (d *eventDispatcher) emitWithSchedule(eventType EventType, jobs []JobWithSchedule) {
+	events := make([]Event, len(jobs))
+	for i := range jobs {
+		events[i] = newEvent(eventType, &jobs[i].rawJob)
+	}
+	d.emit(events...)
+}
+
+// Subscribe registers `listener`, that will receive all the events
+// emitted from now on, until Stop. It returns a function to unregister it.
+func (s *SmallBen) Subscribe(listener Listener) func() {
+	return s.events.subscribe(listener)
+}
+
+// execute executes `job` within its own span, emitting the events,
+// updating the metrics and recording the run, and returns its outcome.
+func (s *SmallBen) execute(job JobWithSchedule) error {
+	started := newEvent(EventJobStarted, &job.rawJob)
+	s.events.emit(started)
+	s.metrics.executions.startRun(&job.rawJob)
+
+	ctx, span := s.startExecution(&job.rawJob)
+	err := executeJob(ctx, job)
+	endSpan(span, err)
+
+	event := newEvent(EventJobFinished, &job.rawJob)
+	event.Duration = event.Time.Sub(started.Time)
+	outcome := JobOutcomeSucceeded
+	if err != nil {
+		event.Type = EventJobFailed
+		event.Error = err
+		outcome = JobOutcomeFailed
+	}
+	// keep track of the outcome, stored asynchronously
+	event.PreviousOutcome = s.runs.setLastRun(job.rawJob.ID, started.Time, outcome, job.rawJob.LastOutcome)
+	s.metrics.executions.endRun(&job.rawJob, event.Duration, err)
+	s.events.emit(event)
+	return err
+}
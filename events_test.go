@@ -0,0 +1,168 @@
+package smallben
+
+import (
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testListener records the events it receives.
+type testListener struct {
+	events []Event
+	lock   sync.Mutex
+}
+
+func (l *testListener) OnEvent(event Event) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = append(l.events, event)
+}
+
+// count returns the number of events of type `eventType` received so far.
+func (l *testListener) count(eventType EventType) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	count := 0
+	for _, event := range l.events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+// waitFor waits until `expected` events of type `eventType` have been received.
+func (l *testListener) waitFor(eventType EventType, expected int, t *testing.T) {
+	for i := 0; i < 200; i++ {
+		if l.count(eventType) >= expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Events of type %s mismatch. Got: %d, expected: %d\n", eventType, l.count(eventType), expected)
+}
+
+func TestEventDispatcher(t *testing.T) {
+	dispatcher := newEventDispatcher(1, zapr.NewLogger(zap.NewExample()))
+
+	listener := &testListener{}
+	unsubscribe := dispatcher.subscribe(listener)
+
+	// a listener blocking forever
+	blocked := make(chan struct{})
+	unsubscribeBlocked := dispatcher.subscribe(ListenerFunc(func(event Event) {
+		<-blocked
+	}))
+
+	// emitting does not block even if the blocked
+	// listener cannot keep up.
+	for i := 0; i < 10; i++ {
+		dispatcher.emit(Event{Type: EventJobAdded, JobID: int64(i)})
+		// give the listener the time to consume
+		listener.waitFor(EventJobAdded, i+1, t)
+	}
+
+	unsubscribe()
+	// unsubscribing twice is fine
+	unsubscribe()
+	dispatcher.emit(Event{Type: EventJobAdded})
+	time.Sleep(20 * time.Millisecond)
+	if listener.count(EventJobAdded) != 10 {
+		t.Errorf("Events received after unsubscribing. Got: %d\n", listener.count(EventJobAdded))
+	}
+
+	close(blocked)
+	unsubscribeBlocked()
+
+	// closing the dispatcher unregisters the listeners
+	listener = &testListener{}
+	unsubscribe = dispatcher.subscribe(listener)
+	subscription := dispatcher.subscriptions[0]
+	dispatcher.close()
+	if _, ok := <-subscription.events; ok {
+		t.Errorf("The subscription has not been closed\n")
+	}
+	unsubscribe()
+	dispatcher.subscribe(listener)
+	dispatcher.emit(Event{Type: EventJobAdded})
+	time.Sleep(20 * time.Millisecond)
+	if listener.count(EventJobAdded) != 0 {
+		t.Errorf("Events received after closing. Got: %d\n", listener.count(EventJobAdded))
+	}
+}
+
+func (s *SmallBenTestSuite) TestEvents(t *testing.T) {
+	listener := &testListener{}
+	unsubscribe := s.smallBen.Subscribe(listener)
+	defer unsubscribe()
+
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	listener.waitFor(EventJobAdded, len(s.jobs), t)
+
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	listener.waitFor(EventJobPaused, 1, t)
+
+	err = s.smallBen.ResumeJobs(&PauseResumeOptions{JobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to resume jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	listener.waitFor(EventJobResumed, 1, t)
+
+	err = s.smallBen.UpdateJobs([]UpdateOption{{JobID: s.jobs[0].ID, CronExpression: stringPointer("@every 1s")}})
+	if err != nil {
+		t.Errorf("Fail to update jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	listener.waitFor(EventJobUpdated, 1, t)
+
+	// now, the job is executed: it fails since
+	// the json-decoded input is not an int anymore.
+	listener.waitFor(EventJobStarted, 1, t)
+	listener.waitFor(EventJobFailed, 2, t)
+	// the events carry the outcome of the previous execution
+	var outcomes []JobOutcome
+	listener.lock.Lock()
+	for _, event := range listener.events {
+		if event.Type == EventJobFailed {
+			outcomes = append(outcomes, event.PreviousOutcome)
+		}
+	}
+	listener.lock.Unlock()
+	if len(outcomes) < 2 || outcomes[0] != "" || outcomes[1] != JobOutcomeFailed {
+		t.Errorf("Wrong previous outcomes. Got: %v\n", outcomes)
+	}
+
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: getIdsFromJobList(s.jobs)}})
+	if err != nil {
+		t.Errorf("Fail to delete jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	listener.waitFor(EventJobDeleted, len(s.jobs), t)
+}
+
+func TestSmallBenEvents(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestEvents(t)
+		test.teardown(true, t)
+	}
+}
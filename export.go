@@ -0,0 +1,329 @@
+package smallben
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// ExportFormat identifies the exports of SmallBen.
+	ExportFormat = "smallben-export"
+	// ExportVersion is the version of the format of the exports.
+	ExportVersion = 1
+	// exportPageSize is the number of jobs
+	// retrieved at once when exporting.
+	exportPageSize = 500
+)
+
+var (
+	// ErrInvalidExport is returned when importing an export
+	// that is malformed, or whose version is not supported.
+	ErrInvalidExport = errors.New("invalid export")
+	// ErrImportConflict is returned when importing with ImportFail
+	// an export containing jobs that already exist.
+	ErrImportConflict = errors.New("job already exists")
+)
+
+// ImportMode specifies how to import
+// the jobs that already exist.
+type ImportMode string
+
+const (
+	// ImportSkip keeps the existing jobs.
+	ImportSkip = ImportMode("skip")
+	// ImportOverwrite replaces the existing jobs.
+	ImportOverwrite = ImportMode("overwrite")
+	// ImportFail fails the import, without
+	// importing any job.
+	ImportFail = ImportMode("fail")
+)
+
+// exportHeader is the first line of an export.
+type exportHeader struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// ExportedJob is a job, as written in an export,
+// one per line after the header.
+type ExportedJob struct {
+	ID             int64             `json:"id"`
+	GroupID        int64             `json:"group_id"`
+	SuperGroupID   int64             `json:"super_group_id"`
+	Name           string            `json:"name,omitempty"`
+	Description    string            `json:"description,omitempty"`
+	Owner          string            `json:"owner,omitempty"`
+	CronExpression string            `json:"cron_expression"`
+	Paused         bool              `json:"paused"`
+	UpstreamIDs    []int64           `json:"upstream_ids,omitempty"`
+	OnSuccess      []int64           `json:"on_success,omitempty"`
+	OnFailure      []int64           `json:"on_failure,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	// Input is the json-encoded input of the job.
+	Input json.RawMessage `json:"input"`
+	// Job is the serialized CronJob, i.e., base64(gob(job)).
+	// Its type must be registered to gob when importing.
+	Job string `json:"job"`
+}
+
+// ImportResult reports what Import did.
+type ImportResult struct {
+	// Created are the IDs of the jobs that have been added.
+	Created []int64
+	// Overwritten are the IDs of the existing jobs
+	// that have been replaced.
+	Overwritten []int64
+	// Skipped are the IDs of the existing jobs
+	// that have been kept.
+	Skipped []int64
+}
+
+// newExportedJob converts `job` to an ExportedJob.
+func newExportedJob(job *RawJob) (ExportedJob, error) {
+	upstreamIDs, err := job.upstreamIDs()
+	if err != nil {
+		return ExportedJob{}, err
+	}
+	onSuccess, err := decodeIDs(job.OnSuccess)
+	if err != nil {
+		return ExportedJob{}, err
+	}
+	onFailure, err := decodeIDs(job.OnFailure)
+	if err != nil {
+		return ExportedJob{}, err
+	}
+	return ExportedJob{
+		ID:             job.ID,
+		GroupID:        job.GroupID,
+		SuperGroupID:   job.SuperGroupID,
+		Name:           job.Name,
+		Description:    job.Description,
+		Owner:          job.Owner,
+		CronExpression: job.CronExpression,
+		Paused:         job.Paused,
+		UpstreamIDs:    upstreamIDs,
+		OnSuccess:      onSuccess,
+		OnFailure:      onFailure,
+		Labels:         decodeLabels(job.Labels),
+		Input:          json.RawMessage(job.SerializedJobInput),
+		Job:            job.SerializedJob,
+	}, nil
+}
+
+// toJob converts the exported job to a Job, making sure
+// its payload can be decoded and its schedule parsed.
+func (e *ExportedJob) toJob() (Job, error) {
+	labels, err := encodeLabels(e.ID, e.Labels)
+	if err != nil {
+		return Job{}, err
+	}
+	rawJob := RawJob{
+		ID:                 e.ID,
+		GroupID:            e.GroupID,
+		SuperGroupID:       e.SuperGroupID,
+		Name:               e.Name,
+		Description:        e.Description,
+		Owner:              e.Owner,
+		CronExpression:     e.CronExpression,
+		SerializedJob:      e.Job,
+		SerializedJobInput: string(e.Input),
+		UpstreamIDs:        encodeIDs(e.UpstreamIDs),
+		OnSuccess:          encodeIDs(e.OnSuccess),
+		OnFailure:          encodeIDs(e.OnFailure),
+		Labels:             labels,
+	}
+	job, err := rawJob.toJob()
+	if err != nil {
+		return Job{}, err
+	}
+	// the chains are checked when upserting
+	job.triggered = true
+	if _, err = job.toJobWithSchedule(); err != nil {
+		return Job{}, err
+	}
+	job.triggered = false
+	return job, nil
+}
+
+// Export writes the jobs matching `options` to `w`, as JSON lines: the first line is a
+// header with the version of the format, followed by one ExportedJob per line.
+// If options is nil, all the jobs are exported. Pagination options are ignored,
+// since the jobs are retrieved one page at a time.
+func (s *SmallBen) Export(w io.Writer, options *ListJobsOptions) (err error) {
+	_, span := s.startOperation("Export", nil)
+	defer func() { endSpan(span, err) }()
+
+	s.logger.Info("Exporting jobs", "Progress", "InProgress")
+	encoder := json.NewEncoder(w)
+	if err = encoder.Encode(exportHeader{Format: ExportFormat, Version: ExportVersion, ExportedAt: time.Now()}); err != nil {
+		return err
+	}
+	pageOptions := ListJobsOptions{}
+	if options != nil {
+		pageOptions = *options
+	}
+	pageOptions.Limit, pageOptions.Offset, pageOptions.PageToken = exportPageSize, 0, ""
+	exported := 0
+	for {
+		rawJobs, err := s.repository.ListJobs(&pageOptions)
+		if err != nil {
+			s.logger.Error(err, "Exporting jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+			return err
+		}
+		for i := range rawJobs {
+			job, err := newExportedJob(&rawJobs[i])
+			if err != nil {
+				s.logger.Error(err, "Exporting jobs", "Progress", "Error", "Details", "Encoding", "ID", rawJobs[i].ID)
+				return err
+			}
+			if err = encoder.Encode(job); err != nil {
+				return err
+			}
+		}
+		exported += len(rawJobs)
+		if pageOptions.PageToken, err = pageOptions.nextPageToken(rawJobs); err != nil {
+			return err
+		}
+		if pageOptions.PageToken == "" {
+			break
+		}
+	}
+	s.logger.Info("Exporting jobs", "Progress", "Done", "Jobs", exported)
+	return nil
+}
+
+// Import restores the jobs exported by Export from `r`. The jobs that already exist
+// are handled according to `mode`. The whole export is validated before anything
+// is written: the types of the jobs must be registered to gob.
+//
+// The jobs are written as done by UpsertJobs, and then paused or resumed according
+// to the export, all within a single transaction if the repository implements
+// OutboxRepository.
+func (s *SmallBen) Import(r io.Reader, mode ImportMode) (result ImportResult, err error) {
+	ctx, span := s.startOperation("Import", nil)
+	defer func() { endSpan(span, err) }()
+
+	s.logger.Info("Importing jobs", "Progress", "InProgress")
+	if mode != ImportSkip && mode != ImportOverwrite && mode != ImportFail {
+		return result, fmt.Errorf("%w: unknown import mode %s", ErrInvalidExport, mode)
+	}
+	jobs, paused, err := readExport(r)
+	if err != nil {
+		s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "Reading")
+		return result, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err = s.journaled(func(tx *Tx) error {
+		result, err = tx.importJobs(ctx, jobs, paused, mode)
+		return err
+	}); err != nil {
+		return ImportResult{}, err
+	}
+	s.logger.Info("Importing jobs", "Progress", "Done",
+		"Created", len(result.Created), "Overwritten", len(result.Overwritten), "Skipped", len(result.Skipped))
+	return result, nil
+}
+
+// importJobs writes the exported `jobs`, paused according to `paused`,
+// within the transaction, for callers holding the lock of SmallBen.
+func (t *Tx) importJobs(ctx context.Context, jobs []Job, paused []bool, mode ImportMode) (result ImportResult, err error) {
+	s := t.smallBen
+
+	existing, err := s.existingJobs(ctx, t.repository, jobs)
+	if err != nil {
+		s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+		return result, err
+	}
+
+	var toImport []Job
+	var toPause, toResume []int64
+	for i, job := range jobs {
+		old, ok := existing[job.ID]
+		if ok {
+			switch mode {
+			case ImportSkip:
+				result.Skipped = append(result.Skipped, job.ID)
+				continue
+			case ImportOverwrite:
+				result.Overwritten = append(result.Overwritten, job.ID)
+			default:
+				err = fmt.Errorf("%w: %d", ErrImportConflict, job.ID)
+				s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "Conflict", "ID", job.ID)
+				return ImportResult{}, err
+			}
+		} else {
+			result.Created = append(result.Created, job.ID)
+		}
+		toImport = append(toImport, job)
+		if paused[i] && !old.Paused {
+			toPause = append(toPause, job.ID)
+		} else if !paused[i] && old.Paused {
+			toResume = append(toResume, job.ID)
+		}
+	}
+
+	if len(toImport) > 0 {
+		if _, err = t.upsertJobs(toImport); err != nil {
+			s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "Upserting")
+			return ImportResult{}, err
+		}
+	}
+	if len(toPause) > 0 {
+		if err = notFound(t.repository, toPause, t.pauseJobs(&PauseResumeOptions{JobIDs: toPause})); err != nil {
+			s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "Pausing", "IDs", toPause)
+			return ImportResult{}, err
+		}
+	}
+	if len(toResume) > 0 {
+		if err = notFound(t.repository, toResume, t.resumeJobs(&PauseResumeOptions{JobIDs: toResume})); err != nil {
+			s.logger.Error(err, "Importing jobs", "Progress", "Error", "Details", "Resuming", "IDs", toResume)
+			return ImportResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// readExport reads and validates the export in `r`, returning
+// its jobs together with their paused state.
+func readExport(r io.Reader) ([]Job, []bool, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	var header exportHeader
+	if err := decoder.Decode(&header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrInvalidExport, err.Error())
+	}
+	if header.Format != ExportFormat || header.Version != ExportVersion {
+		return nil, nil, fmt.Errorf("%w: unsupported format %s, version %d", ErrInvalidExport, header.Format, header.Version)
+	}
+	var jobs []Job
+	var paused []bool
+	ids := make(map[int64]bool)
+	for line := 2; ; line++ {
+		var exported ExportedJob
+		if err := decoder.Decode(&exported); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: %s", ErrInvalidExport, line, err.Error())
+		}
+		if exported.ID == 0 || ids[exported.ID] {
+			return nil, nil, fmt.Errorf("%w: line %d: missing or duplicate ID %d", ErrInvalidExport, line, exported.ID)
+		}
+		ids[exported.ID] = true
+		job, err := exported.toJob()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: line %d: %s", ErrInvalidExport, line, err.Error())
+		}
+		jobs = append(jobs, job)
+		paused = append(paused, exported.Paused)
+	}
+	return jobs, paused, nil
+}
+
@@ -0,0 +1,201 @@
+package smallben
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadExport(t *testing.T) {
+	valid := testExport(t)
+	jobs, paused, err := readExport(strings.NewReader(valid))
+	if err != nil {
+		t.Errorf("Cannot read the export: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 || jobs[0].ID != 1 || jobs[0].JobInput["test_id"] != float64(1) || !paused[0] {
+		t.Errorf("Wrong jobs: %+v, paused: %v\n", jobs, paused)
+	}
+
+	lines := strings.SplitN(valid, "\n", 2)
+	invalid := []string{
+		"",
+		`{"format":"smallben-export","version":2}` + "\n" + lines[1],
+		lines[0] + "\n" + strings.Replace(lines[1], `"job":"`, `"job":"x`, 1),
+		lines[0] + "\n" + strings.Replace(lines[1], `"@every 70s"`, `"@every"`, 1),
+		lines[0] + "\n" + lines[1] + lines[1],
+		lines[0] + "\n" + strings.Replace(lines[1], `"id":1`, `"id":1,"unknown":1`, 1),
+	}
+	for _, export := range invalid {
+		_, _, err = readExport(strings.NewReader(export))
+		checkErrorIsOf(err, ErrInvalidExport, t)
+	}
+}
+
+// testExport returns an export containing a single paused job.
+func testExport(t *testing.T) string {
+	job, err := JobsToUse[0].toJobWithSchedule()
+	if err != nil {
+		t.Errorf("Cannot build the job: %s\n", err.Error())
+		t.FailNow()
+	}
+	rawJob, err := job.BuildJob()
+	if err != nil {
+		t.Errorf("Cannot build the job: %s\n", err.Error())
+		t.FailNow()
+	}
+	rawJob.Paused = true
+	exported, err := newExportedJob(&rawJob)
+	if err != nil {
+		t.Errorf("Cannot export the job: %s\n", err.Error())
+		t.FailNow()
+	}
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	if err = encoder.Encode(exportHeader{Format: ExportFormat, Version: ExportVersion}); err != nil {
+		t.Errorf("Cannot write the export: %s\n", err.Error())
+		t.FailNow()
+	}
+	if err = encoder.Encode(exported); err != nil {
+		t.Errorf("Cannot write the export: %s\n", err.Error())
+		t.FailNow()
+	}
+	return buffer.String()
+}
+
+func (s *SmallBenTestSuite) TestExportImport(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	ids := getIdsFromJobList(s.jobs)
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: ids[1:2]})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	before, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: ids})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	var buffer bytes.Buffer
+	if err = s.smallBen.Export(&buffer, &ListJobsOptions{JobIDs: ids}); err != nil {
+		t.Errorf("Fail to export jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	export := buffer.String()
+	if lines := strings.Count(export, "\n"); lines != len(ids)+1 {
+		t.Errorf("Wrong number of lines. Got: %d, expected: %d\n", lines, len(ids)+1)
+	}
+
+	// change a job and delete another one
+	newExpression := "@every 75s"
+	err = s.smallBen.UpdateJobs([]UpdateOption{{JobID: ids[0], CronExpression: &newExpression}})
+	if err != nil {
+		t.Errorf("Fail to update jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: ids[3:]}})
+	if err != nil {
+		t.Errorf("Fail to delete jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// nothing is imported on conflicts
+	_, err = s.smallBen.Import(strings.NewReader(export), ImportFail)
+	checkErrorIsOf(err, ErrImportConflict, t)
+	if count, _ := s.smallBen.repository.CountJobs(&ListJobsOptions{}); count != int64(len(ids)-1) {
+		t.Errorf("No job should have been imported. Got: %d jobs\n", count)
+	}
+
+	result, err := s.smallBen.Import(strings.NewReader(export), ImportSkip)
+	if err != nil {
+		t.Errorf("Fail to import jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(result, ImportResult{Created: ids[3:], Skipped: ids[:3]}) {
+		t.Errorf("Wrong import result: %+v\n", result)
+	}
+
+	result, err = s.smallBen.Import(strings.NewReader(export), ImportOverwrite)
+	if err != nil {
+		t.Errorf("Fail to import jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(result, ImportResult{Overwritten: ids}) {
+		t.Errorf("Wrong import result: %+v\n", result)
+	}
+	after, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: ids})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	for i := range after {
+		if after[i].CronExpression != before[i].CronExpression || after[i].Paused() != before[i].Paused() ||
+			!reflect.DeepEqual(after[i].JobInput, before[i].JobInput) || reflect.TypeOf(after[i].Job) != reflect.TypeOf(before[i].Job) {
+			t.Errorf("The job has not been restored. Got: %+v, expected: %+v\n", after[i], before[i])
+		}
+	}
+}
+
+func TestSmallBenExportImport(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestExportImport(t)
+		test.teardown(false, t)
+	}
+}
+
+// failingPauseRepository is a RepositorySQL failing to pause the jobs,
+// also within its transactions.
+type failingPauseRepository struct {
+	*RepositorySQL
+}
+
+func (f *failingPauseRepository) PauseJobs(_ []RawJob) error {
+	return errors.New("cannot pause the jobs")
+}
+
+func (f *failingPauseRepository) Transaction(fn func(repository Repository) error) error {
+	return f.RepositorySQL.Transaction(func(repository Repository) error {
+		return fn(&failingPauseRepository{RepositorySQL: repository.(*RepositorySQL)})
+	})
+}
+
+// TestImportRollback tests that the jobs are not imported
+// if they cannot be paused as required by the export.
+func TestImportRollback(t *testing.T) {
+	db, _ := openTestSQLite(t)
+	repository, err := NewRepositorySQL(&RepositorySQLConfig{DB: db, Dialect: SQLDialectSQLite})
+	if err != nil {
+		t.Errorf("Cannot create the repository: %s\n", err.Error())
+		t.FailNow()
+	}
+	smallBen := New(&failingPauseRepository{RepositorySQL: repository}, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+
+	if _, err = smallBen.Import(strings.NewReader(testExport(t)), ImportFail); err == nil {
+		t.Errorf("The import should have failed\n")
+	}
+	if count, err := repository.CountJobs(nil); err != nil || count != 0 {
+		t.Errorf("No job should have been imported. Got: %d, %v\n", count, err)
+	}
+	checkOutboxEmpty(repository, t)
+}
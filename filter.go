@@ -0,0 +1,255 @@
+package smallben
+
+import (
+	"sort"
+	"time"
+)
+
+// The functions of this file list the jobs in memory, with the same semantics
+// as the queries of RepositorySQL, for the backends that cannot query them,
+// i.e., RepositoryBolt and RepositoryRedis.
+
+// byIDsOnly returns whether the jobs are listed by their IDs only,
+// in which case a missing job is reported as a mismatch, unless AllowMissing is set.
+func (o *ListJobsOptions) byIDsOnly() bool {
+	return o.JobIDs != nil && !o.AllowMissing && o.SuperGroupIDs == nil && o.GroupIDs == nil && o.JobNames == nil &&
+		o.Paused == nil && !o.paginated() && o.JobFilters.isZero()
+}
+
+// matches returns whether `job` matches the filters of the options,
+// ignoring the sorting and the pagination.
+func (o *ListJobsOptions) matches(job *RawJob) bool {
+	if o.Paused != nil && job.Paused != *o.Paused {
+		return false
+	}
+	if len(o.JobIDs) > 0 && !containsID(o.JobIDs, job.ID) {
+		return false
+	}
+	if len(o.JobNames) > 0 && !containsString(o.JobNames, job.Name) {
+		return false
+	}
+	if len(o.GroupIDs) > 0 && !containsID(o.GroupIDs, job.GroupID) {
+		return false
+	}
+	if len(o.SuperGroupIDs) > 0 && !containsID(o.SuperGroupIDs, job.SuperGroupID) {
+		return false
+	}
+	return o.JobFilters.matches(job)
+}
+
+// matches returns whether `job` matches the filters.
+func (f *JobFilters) matches(job *RawJob) bool {
+	if !f.CreatedAt.contains(&job.CreatedAt) || !f.UpdatedAt.contains(&job.UpdatedAt) ||
+		!f.NextRunAt.contains(job.NextRunAt) {
+		return false
+	}
+	if containsID(f.ExcludeJobIDs, job.ID) || containsID(f.ExcludeGroupIDs, job.GroupID) ||
+		containsID(f.ExcludeSuperGroupIDs, job.SuperGroupID) {
+		return false
+	}
+	if len(f.CronExpressions) > 0 && !containsString(f.CronExpressions, job.CronExpression) {
+		return false
+	}
+	if len(f.LastOutcomes) > 0 {
+		found := false
+		for _, outcome := range f.LastOutcomes {
+			found = found || outcome == job.LastOutcome
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.TriggersJobIDs) > 0 && !triggersAny(job, f.TriggersJobIDs) {
+		return false
+	}
+	return len(f.LabelSelector) == 0 || f.LabelSelector.Matches(decodeLabels(job.Labels))
+}
+
+// triggersAny returns whether `job` triggers, on success
+// or on failure, any of the jobs whose ID is in `ids`.
+func triggersAny(job *RawJob, ids []int64) bool {
+	// invalid lists trigger nothing
+	onSuccess, _ := decodeIDs(job.OnSuccess)
+	onFailure, _ := decodeIDs(job.OnFailure)
+	for _, id := range append(onSuccess, onFailure...) {
+		if containsID(ids, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains returns whether `t` is in the range. A nil range contains
+// everything, while a nil time is never contained in a range.
+func (r *TimeRange) contains(t *time.Time) bool {
+	if r == nil {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return (r.After.IsZero() || !t.Before(r.After)) && (r.Before.IsZero() || t.Before(r.Before))
+}
+
+// containsID returns whether `ids` contains `id`.
+func containsID(ids []int64, id int64) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
+
+// filterJobsInMemory returns the jobs of `jobs` matching `options`,
+// ignoring the sorting and the pagination. If options is nil,
+// all the jobs are returned.
+func filterJobsInMemory(jobs []RawJob, options ToListOptions) []RawJob {
+	if options == nil {
+		return jobs
+	}
+	convertedOptions := options.toListOptions()
+	filtered := make([]RawJob, 0, len(jobs))
+	for i := range jobs {
+		if convertedOptions.matches(&jobs[i]) {
+			filtered = append(filtered, jobs[i])
+		}
+	}
+	return filtered
+}
+
+// listJobsInMemory filters, sorts and paginates `jobs` according to `options`.
+// If options is nil, all the jobs are returned, sorted by ID.
+func listJobsInMemory(jobs []RawJob, options ToListOptions) ([]RawJob, error) {
+	jobs = filterJobsInMemory(jobs, options)
+	if options == nil {
+		sort.Slice(jobs, func(i, j int) bool {
+			return jobs[i].ID < jobs[j].ID
+		})
+		return jobs, nil
+	}
+	convertedOptions := options.toListOptions()
+	return paginateJobsInMemory(jobs, &convertedOptions)
+}
+
+// sortValue returns the value of `field` of `job`,
+// nil if the job has not been scheduled.
+func sortValue(job *RawJob, field JobSortField) *time.Time {
+	switch field {
+	case SortByCreatedAt:
+		return &job.CreatedAt
+	case SortByUpdatedAt:
+		return &job.UpdatedAt
+	default:
+		return job.NextRunAt
+	}
+}
+
+// compareJobs compares `a` and `b` by `field` and then by ID, in ascending order.
+// Jobs that are not scheduled come after the scheduled ones.
+func compareJobs(a, b *RawJob, field JobSortField) int {
+	if field != SortByID {
+		if c := compareTimes(sortValue(a, field), sortValue(b, field)); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a.ID < b.ID:
+		return -1
+	case a.ID > b.ID:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareTimes compares `a` and `b`, nil being the greatest time.
+func compareTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	case a.Before(*b):
+		return -1
+	case a.After(*b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// paginateJobsInMemory sorts and paginates `jobs` according to `options`.
+// Pages following the first one start after the job in the page token.
+func paginateJobsInMemory(jobs []RawJob, options *ListJobsOptions) ([]RawJob, error) {
+	field, err := options.sortField()
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := options.cursor()
+	if err != nil {
+		return nil, err
+	}
+	direction := 1
+	if options.Descending {
+		direction = -1
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return direction*compareJobs(&jobs[i], &jobs[j], field) < 0
+	})
+
+	if cursor != nil {
+		last := RawJob{ID: cursor.ID}
+		if cursor.Value == nil && field != SortByID && field != SortByNextRunAt {
+			return nil, ErrInvalidPageToken
+		}
+		switch field {
+		case SortByCreatedAt:
+			last.CreatedAt = *cursor.Value
+		case SortByUpdatedAt:
+			last.UpdatedAt = *cursor.Value
+		case SortByNextRunAt:
+			last.NextRunAt = cursor.Value
+		}
+		start := sort.Search(len(jobs), func(i int) bool {
+			return direction*compareJobs(&jobs[i], &last, field) > 0
+		})
+		jobs = jobs[start:]
+	} else if options.Offset > 0 {
+		if options.Offset >= len(jobs) {
+			return []RawJob{}, nil
+		}
+		jobs = jobs[options.Offset:]
+	}
+	if options.Limit > 0 && len(jobs) > options.Limit {
+		jobs = jobs[:options.Limit]
+	}
+	return jobs, nil
+}
+
+// setCreationTimes sets the created_at and updated_at fields
+// of a new job to the current time, if not set.
+func setCreationTimes(job *RawJob) {
+	now := time.Now()
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = now
+	}
+	if job.UpdatedAt.IsZero() {
+		job.UpdatedAt = now
+	}
+}
+
+// toJobsWithSchedule converts `rawJobs` to instances of JobWithSchedule.
+func toJobsWithSchedule(rawJobs []RawJob) ([]JobWithSchedule, error) {
+	jobs := make([]JobWithSchedule, len(rawJobs))
+	for i, rawJob := range rawJobs {
+		job, err := rawJob.ToJobWithSchedule()
+		if err != nil {
+			return nil, err
+		}
+		jobs[i] = job
+	}
+	return jobs, nil
+}
@@ -3,11 +3,23 @@ module github.com/nbena/smallben
 go 1.14
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.3
 	github.com/go-logr/logr v0.2.0
 	github.com/go-logr/zapr v0.3.0
+	github.com/go-redis/redis/v8 v8.11.0
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/jackc/pgx/v4 v4.8.1
+	github.com/mattn/go-sqlite3 v1.14.3
 	github.com/prometheus/client_golang v1.8.0
 	github.com/robfig/cron/v3 v3.0.1
+	go.etcd.io/bbolt v1.3.5
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	go.uber.org/zap v1.13.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+	gorm.io/driver/mysql v1.0.1
 	gorm.io/driver/postgres v1.0.1
+	gorm.io/driver/sqlite v1.1.3
 	gorm.io/gorm v1.20.1
 )
@@ -0,0 +1,18 @@
+module github.com/nbena/smallben/gormrepo
+
+go 1.14
+
+require (
+	github.com/go-logr/zapr v0.3.0
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/nbena/smallben v0.0.0-20261019073151-e4819ea4f31e
+	go.uber.org/zap v1.13.0
+	gorm.io/driver/mysql v1.0.1
+	gorm.io/driver/postgres v1.0.1
+	gorm.io/driver/sqlite v1.1.3
+	gorm.io/gorm v1.20.1
+)
+
+// The replace directive only applies when developing within this repository:
+// the importers of gormrepo use the version of smallben required above.
+replace github.com/nbena/smallben => ../
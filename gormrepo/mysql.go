@@ -0,0 +1,57 @@
+package gormrepo
+
+import (
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"time"
+)
+
+// MySQLConfig is the configuration of
+// a Repository backed by MySQL or MariaDB.
+type MySQLConfig struct {
+	// DSN is the data source name of the database,
+	// e.g., "user:password@tcp(localhost:3306)/smallben".
+	DSN string
+	// Config is the configuration to use to connect to the database.
+	Config gorm.Config
+	// SkipMigrations disables the migrations on creation.
+	SkipMigrations bool
+}
+
+// dsn returns config.DSN with the parameters required by smallben.RepositorySQL:
+//   - parseTime, to scan datetime columns into time.Time, in UTC;
+//   - clientFoundRows, so that updates count the matched rows rather than the changed ones,
+//     as done by Postgres and SQLite, otherwise updating a job with the same values
+//     would be reported as a missing job.
+//
+// The isolation level is not set on the connection, since MariaDB, before 11.1, has
+// no transaction_isolation variable: smallben.RepositorySQL sets it per transaction.
+func (c *MySQLConfig) dsn() (string, error) {
+	config, err := mysqldriver.ParseDSN(c.DSN)
+	if err != nil {
+		return "", err
+	}
+	config.ParseTime = true
+	config.Loc = time.UTC
+	config.ClientFoundRows = true
+	return config.FormatDSN(), nil
+}
+
+// NewMySQL returns an instance of Repository backed by the MySQL or MariaDB
+// database in config.DSN, whose parameters are adjusted as required. Like New,
+// it applies the migrations of the schema, unless config.SkipMigrations is set.
+//
+// Since MySQL commits DDL statements implicitly, a migration failing
+// halfway is not rolled back, and must be completed by hand.
+func NewMySQL(config *MySQLConfig) (*Repository, error) {
+	dsn, err := config.dsn()
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &config.Config)
+	if err != nil {
+		return nil, err
+	}
+	return newRepository(db, config.SkipMigrations)
+}
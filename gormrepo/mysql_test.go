@@ -0,0 +1,74 @@
+package gormrepo
+
+import (
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/nbena/smallben"
+	"os"
+	"testing"
+	"time"
+)
+
+// KeyTestMySQLDbName is the environment variable with
+// the MySQL database to run the tests against.
+const KeyTestMySQLDbName = "TEST_DATABASE_MYSQL"
+
+// TestMySQLDSN tests that the parameters required
+// by smallben.RepositorySQL are added to the DSN, keeping the other ones.
+func TestMySQLDSN(t *testing.T) {
+	config := MySQLConfig{DSN: "user:password@tcp(localhost:3306)/smallben?timeout=5s&clientFoundRows=false"}
+	dsn, err := config.dsn()
+	if err != nil {
+		t.Fatalf("Cannot build the DSN: %s", err.Error())
+	}
+	parsed, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		t.Fatalf("Cannot parse the DSN: %s", err.Error())
+	}
+	if !parsed.ParseTime || !parsed.ClientFoundRows || parsed.Loc != time.UTC {
+		t.Errorf("The required parameters are missing: %s", dsn)
+	}
+	if _, ok := parsed.Params["transaction_isolation"]; ok {
+		t.Errorf("The isolation level must not be set on the connection: %s", dsn)
+	}
+	if parsed.User != "user" || parsed.DBName != "smallben" || parsed.Timeout != 5*time.Second {
+		t.Errorf("The DSN has been altered: %s", dsn)
+	}
+
+	config.DSN = "not a dsn"
+	if _, err = config.dsn(); err == nil {
+		t.Errorf("A malformed DSN has been accepted")
+	}
+}
+
+// TestMySQL tests that the jobs are stored by a repository
+// backed by MySQL, if KeyTestMySQLDbName is set.
+func TestMySQL(t *testing.T) {
+	mysqlConn := os.Getenv(KeyTestMySQLDbName)
+	if mysqlConn == "" {
+		t.Skip("MySQL is not configured")
+	}
+	repository, err := NewMySQL(&MySQLConfig{DSN: mysqlConn})
+	if err != nil {
+		t.Fatalf("Cannot open connection: %s", err.Error())
+	}
+	smallBen := newTestSmallBen(repository, 0, t)
+	if err = smallBen.Start(); err != nil {
+		t.Fatalf("Cannot start: %s", err.Error())
+	}
+	defer smallBen.Stop()
+	jobs := newTestJobs(1, 3)
+	if err = smallBen.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	options := smallben.PauseResumeOptions{JobIDs: []int64{1, 2, 3}}
+	listed, err := smallBen.ListJobs(&smallben.ListJobsOptions{JobIDs: options.JobIDs})
+	if err != nil {
+		t.Fatalf("Cannot list jobs: %s", err.Error())
+	}
+	if len(listed) != len(jobs) {
+		t.Errorf("Wrong number of jobs. Got: %d, expected: %d", len(listed), len(jobs))
+	}
+	if err = smallBen.DeleteJobs(&smallben.DeleteOptions{PauseResumeOptions: options}); err != nil {
+		t.Errorf("Fail to delete: %s", err.Error())
+	}
+}
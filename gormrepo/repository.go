@@ -0,0 +1,91 @@
+// Package gormrepo provides the repositories of smallben backed by GORM,
+// for the applications already using it. They are smallben.RepositorySQL
+// using the connections of a *gorm.DB, so that they share its schema and
+// its migrations, and so that the jobs can be managed within the
+// transactions of GORM.
+//
+// It is a module on its own, so that the users of
+// smallben.RepositorySQL do not depend on GORM.
+package gormrepo
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/nbena/smallben"
+	"gorm.io/gorm"
+)
+
+// Repository implements the smallben.Repository interface by the means
+// of GORM. It supports workflows, the log of the webhook deliveries, the
+// outbox and the transactions of the caller, as smallben.RepositorySQL does.
+type Repository struct {
+	*smallben.RepositorySQL
+	db *gorm.DB
+}
+
+// Config regulates the internal working of the repository.
+type Config struct {
+	// Dialector is the dialector of the database,
+	// e.g., postgres.Open(dsn). Postgres, SQLite,
+	// MySQL and MariaDB are supported.
+	Dialector gorm.Dialector
+	// Config is the configuration to use to connect to the database.
+	Config gorm.Config
+	// SkipMigrations disables the migrations on creation.
+	// The version of the schema is checked anyway.
+	SkipMigrations bool
+}
+
+// New returns an instance of the repository connecting to the given database,
+// after applying the migrations of the schema, unless config.SkipMigrations is set.
+// It returns smallben.ErrSchemaTooNew if the schema has been migrated by a newer
+// version of the library, and smallben.ErrUnsupportedDialect if the database
+// is not supported.
+func New(config *Config) (*Repository, error) {
+	db, err := gorm.Open(config.Dialector, &config.Config)
+	if err != nil {
+		return nil, err
+	}
+	return newRepository(db, config.SkipMigrations)
+}
+
+// newRepository returns an instance of the repository
+// using `db`, after migrating or checking its schema.
+func newRepository(db *gorm.DB, skipMigrations bool) (*Repository, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	repository, err := smallben.NewRepositorySQL(&smallben.RepositorySQLConfig{
+		DB:             sqlDB,
+		Dialect:        db.Dialector.Name(),
+		SkipMigrations: skipMigrations,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{RepositorySQL: repository, db: db}, nil
+}
+
+// DB returns the *gorm.DB of the repository,
+// e.g., to begin the transactions to pass to WithTx.
+func (r *Repository) DB() *gorm.DB {
+	return r.db
+}
+
+// WithTx returns a repository executing its operations within `tx`,
+// which must be a *gorm.DB within a transaction, e.g., returned by Begin,
+// on the database of the repository. Its own transactions are then
+// executed as savepoints of `tx`.
+// It implements the smallben.TxRepository interface.
+func (r *Repository) WithTx(tx interface{}) (smallben.Repository, error) {
+	gormTx, ok := tx.(*gorm.DB)
+	if !ok || gormTx == nil {
+		return nil, fmt.Errorf("%w: %T", smallben.ErrInvalidTx, tx)
+	}
+	sqlTx, ok := gormTx.Statement.ConnPool.(*sql.Tx)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T is not within a transaction", smallben.ErrInvalidTx, tx)
+	}
+	return r.RepositorySQL.WithTx(sqlTx)
+}
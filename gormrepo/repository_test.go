@@ -0,0 +1,216 @@
+package gormrepo
+
+import (
+	"encoding/gob"
+	"errors"
+	"github.com/go-logr/zapr"
+	"github.com/nbena/smallben"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// KeyTestPgDbName is the environment variable with
+// the Postgres database to run the tests against.
+const KeyTestPgDbName = "TEST_DATABASE_PG"
+
+func init() {
+	gob.Register(&testCronJob{})
+}
+
+// testCronJob is a CronJob doing nothing.
+type testCronJob struct{}
+
+func (t *testCronJob) Run(input smallben.CronJobInput) {}
+
+// tempDir returns a directory removed once the test completes.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "smallben")
+	if err != nil {
+		t.Fatalf("Cannot create the directory: %s", err.Error())
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return dir
+}
+
+// newTestSmallBen returns a SmallBen using `repository`,
+// whose IDs are generated for `node`.
+func newTestSmallBen(repository smallben.Repository, node int64, t *testing.T) *smallben.SmallBen {
+	idGenerator, err := smallben.NewSnowflakeGenerator(node)
+	if err != nil {
+		t.Fatalf("Cannot create the generator: %s", err.Error())
+	}
+	return smallben.New(repository, &smallben.Config{
+		Logger:          zapr.NewLogger(zap.NewNop()),
+		SchedulerConfig: smallben.SchedulerConfig{WithSeconds: true},
+		IDGenerator:     idGenerator,
+	})
+}
+
+// newTestJobs returns `count` jobs, whose ids start from `first`.
+func newTestJobs(first int64, count int) []smallben.Job {
+	jobs := make([]smallben.Job, count)
+	for i := range jobs {
+		jobs[i] = smallben.Job{
+			ID:             first + int64(i),
+			GroupID:        1,
+			SuperGroupID:   1,
+			CronExpression: "@every 1m",
+			Job:            &testCronJob{},
+		}
+	}
+	return jobs
+}
+
+// TestRepositoryTx tests that the jobs are managed
+// within the transactions of GORM.
+func TestRepositoryTx(t *testing.T) {
+	repository, err := NewSQLite(&SQLiteConfig{Path: filepath.Join(tempDir(t), "tx.db")})
+	if err != nil {
+		t.Fatalf("Cannot open connection: %s", err.Error())
+	}
+	smallBen := newTestSmallBen(repository, 0, t)
+	if err = smallBen.Start(); err != nil {
+		t.Fatalf("Cannot start: %s", err.Error())
+	}
+	defer smallBen.Stop()
+
+	if _, err = smallBen.WithTx(repository.DB()); !errors.Is(err, smallben.ErrInvalidTx) {
+		t.Errorf("A database not within a transaction has been accepted: %v", err)
+	}
+
+	jobs := newTestJobs(1, 3)
+	for _, commit := range []bool{false, true} {
+		tx := repository.DB().Begin()
+		smallBenTx, err := smallBen.WithTx(tx)
+		if err != nil {
+			t.Fatalf("Cannot use the transaction: %s", err.Error())
+		}
+		if err = smallBenTx.AddJobs(jobs); err != nil {
+			t.Fatalf("Fail to add jobs: %s", err.Error())
+		}
+		if !commit {
+			if err = tx.Rollback().Error; err != nil {
+				t.Fatalf("Cannot roll back: %s", err.Error())
+			}
+			smallBenTx.RolledBack()
+			continue
+		}
+		if err = tx.Commit().Error; err != nil {
+			t.Fatalf("Cannot commit: %s", err.Error())
+		}
+		if err = smallBenTx.Committed(); err != nil {
+			t.Fatalf("Cannot apply the changes: %s", err.Error())
+		}
+	}
+	count, err := repository.CountJobs(nil)
+	if err != nil {
+		t.Fatalf("Cannot count jobs: %s", err.Error())
+	}
+	if count != int64(len(jobs)) {
+		t.Errorf("Only the committed jobs should have been added. Got: %d", count)
+	}
+}
+
+// TestNew tests that the databases that cannot
+// be connected to are refused.
+func TestNew(t *testing.T) {
+	if _, err := New(&Config{Dialector: postgres.Open("")}); err == nil {
+		t.Errorf("An empty connection has been accepted")
+	}
+	pgConn := os.Getenv(KeyTestPgDbName)
+	if pgConn == "" {
+		return
+	}
+	repository, err := New(&Config{Dialector: postgres.Open(pgConn)})
+	if err != nil {
+		t.Fatalf("Cannot open connection: %s", err.Error())
+	}
+	if version, err := repository.SchemaVersion(); err != nil || version != smallben.SchemaVersion {
+		t.Errorf("The schema has not been migrated. Got: %d, %v", version, err)
+	}
+}
+
+// TestSQLiteConcurrent tests that concurrent writes and reads
+// on the same SQLite database, from two repositories, wait
+// for each other rather than failing because the database is locked.
+func TestSQLiteConcurrent(t *testing.T) {
+	path := filepath.Join(tempDir(t), "concurrent.db")
+	smallBens := make([]*smallben.SmallBen, 2)
+	for i := range smallBens {
+		repository, err := NewSQLite(&SQLiteConfig{Path: path})
+		if err != nil {
+			t.Fatalf("Cannot open connection: %s", err.Error())
+		}
+		// the instances sharing the database use different nodes.
+		smallBens[i] = newTestSmallBen(repository, int64(i), t)
+		if err = smallBens[i].Start(); err != nil {
+			t.Fatalf("Cannot start: %s", err.Error())
+		}
+		defer smallBens[i].Stop()
+	}
+
+	const jobsPerWorker = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(smallBens)*jobsPerWorker)
+	for worker, smallBen := range smallBens {
+		wg.Add(1)
+		go func(worker int, smallBen *smallben.SmallBen) {
+			defer wg.Done()
+			for _, job := range newTestJobs(int64(worker*jobsPerWorker+1), jobsPerWorker) {
+				if err := smallBen.AddJobs([]smallben.Job{job}); err != nil {
+					errs <- err
+				}
+				if _, err := smallBen.ListJobs(&smallben.ListJobsOptions{}); err != nil {
+					errs <- err
+				}
+			}
+		}(worker, smallBen)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent access failed: %s", err.Error())
+	}
+
+	jobs, err := smallBens[0].ListJobs(&smallben.ListJobsOptions{})
+	if err != nil {
+		t.Fatalf("Cannot list jobs: %s", err.Error())
+	}
+	if len(jobs) != len(smallBens)*jobsPerWorker {
+		t.Errorf("The number of jobs is wrong. Got %d, expected: %d", len(jobs), len(smallBens)*jobsPerWorker)
+	}
+}
+
+// TestSQLiteMemory tests that an in-memory
+// database keeps its content across operations.
+func TestSQLiteMemory(t *testing.T) {
+	repository, err := NewSQLite(&SQLiteConfig{Path: SQLiteMemory})
+	if err != nil {
+		t.Fatalf("Cannot open connection: %s", err.Error())
+	}
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Cannot get the schema version: %s", err.Error())
+	}
+	if version != smallben.SchemaVersion {
+		t.Errorf("The schema version is wrong. Got %d, expected: %d", version, smallben.SchemaVersion)
+	}
+	smallBen := newTestSmallBen(repository, 0, t)
+	if err = smallBen.Start(); err != nil {
+		t.Fatalf("Cannot start: %s", err.Error())
+	}
+	defer smallBen.Stop()
+	if err = smallBen.AddJobs(newTestJobs(1, 1)); err != nil {
+		t.Fatalf("Cannot add job: %s", err.Error())
+	}
+	if _, err = repository.GetJob(1); err != nil {
+		t.Errorf("Cannot retrieve job: %s", err.Error())
+	}
+}
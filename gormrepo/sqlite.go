@@ -0,0 +1,75 @@
+package gormrepo
+
+import (
+	"fmt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
+)
+
+// DefaultSQLiteBusyTimeout is the default time to wait
+// for a locked SQLite database to be released.
+const DefaultSQLiteBusyTimeout = 5 * time.Second
+
+// SQLiteMemory is the Path of an in-memory SQLite database.
+const SQLiteMemory = ":memory:"
+
+// SQLiteConfig is the configuration of
+// a Repository backed by SQLite.
+type SQLiteConfig struct {
+	// Path is the path of the database file,
+	// or SQLiteMemory for an in-memory database.
+	Path string
+	// BusyTimeout is how long to wait for a locked database
+	// to be released, e.g., by another process, before failing.
+	// If zero, DefaultSQLiteBusyTimeout is used.
+	BusyTimeout time.Duration
+	// Config is the configuration to use to connect to the database.
+	Config gorm.Config
+	// SkipMigrations disables the migrations on creation.
+	SkipMigrations bool
+}
+
+// dsn returns the data source name of the database. The journal is in WAL mode, so that
+// reads do not block writes, and transactions take the write lock as soon as they begin,
+// so that concurrent transactions wait for the busy timeout rather than failing
+// when upgrading from a read to a write lock.
+func (c *SQLiteConfig) dsn() string {
+	busyTimeout := c.BusyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = DefaultSQLiteBusyTimeout
+	}
+	params := url.Values{}
+	params.Set("_busy_timeout", fmt.Sprint(busyTimeout.Milliseconds()))
+	params.Set("_foreign_keys", "on")
+	params.Set("_txlock", "immediate")
+	if c.Path == SQLiteMemory {
+		return "file::memory:?" + params.Encode()
+	}
+	params.Set("_journal_mode", "WAL")
+	return "file:" + c.Path + "?" + params.Encode()
+}
+
+// NewSQLite returns an instance of Repository backed by
+// the SQLite database in config.Path, created if it does not exist.
+// Like New, it applies the migrations of the schema,
+// unless config.SkipMigrations is set.
+func NewSQLite(config *SQLiteConfig) (*Repository, error) {
+	db, err := gorm.Open(sqlite.Open(config.dsn()), &config.Config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Path == SQLiteMemory {
+		// each connection to an in-memory database is
+		// a different database, so just one is used.
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+	}
+	return newRepository(db, config.SkipMigrations)
+}
@@ -0,0 +1,120 @@
+package smallben
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// HealthCheckRepository is the interface storage backends
+// can implement to report whether they are reachable.
+type HealthCheckRepository interface {
+	// Ping returns an error if the backend is not reachable.
+	Ping(ctx context.Context) error
+}
+
+// HealthStatus reports the health of SmallBen.
+type HealthStatus struct {
+	// Running is whether the cron loop is running.
+	Running bool `json:"running"`
+	// RepositoryReachable is whether the repository is reachable.
+	RepositoryReachable bool `json:"repository_reachable"`
+	// RepositoryError is the error returned by the repository, if any.
+	RepositoryError string `json:"repository_error,omitempty"`
+	// Filled is whether the jobs to execute have been
+	// loaded from the repository on Start.
+	Filled bool `json:"filled"`
+	// FillError is the error the loading failed with, if any.
+	FillError string `json:"fill_error,omitempty"`
+	// LoadedJobs is the number of jobs in the scheduler.
+	LoadedJobs int `json:"loaded_jobs"`
+	// ExpectedJobs is the number of jobs not paused in the repository.
+	// Jobs without a schedule, such as the downstream jobs of a workflow,
+	// are expected but never loaded.
+	ExpectedJobs int64 `json:"expected_jobs"`
+}
+
+// Live returns whether SmallBen is alive, i.e.,
+// whether the cron loop is running.
+func (h *HealthStatus) Live() bool {
+	return h.Running
+}
+
+// Ready returns whether SmallBen is ready, i.e., whether
+// the cron loop is running, the repository is reachable and
+// the jobs have been loaded.
+func (h *HealthStatus) Ready() bool {
+	return h.Running && h.RepositoryReachable && h.Filled
+}
+
+// Health returns the health of SmallBen. The repository is reached
+// by using `ctx`, if it implements HealthCheckRepository.
+func (s *SmallBen) Health(ctx context.Context) HealthStatus {
+	status := s.processHealth()
+
+	var err error
+	if repository, ok := s.repository.(HealthCheckRepository); ok {
+		err = repository.Ping(ctx)
+	}
+	if err == nil {
+		paused := false
+		status.ExpectedJobs, err = s.repository.CountJobs(&ListJobsOptions{Paused: &paused})
+	}
+	status.RepositoryReachable = err == nil
+	if err != nil {
+		status.RepositoryError = err.Error()
+	}
+	return status
+}
+
+// processHealth returns the health of SmallBen
+// without reaching the repository.
+func (s *SmallBen) processHealth() HealthStatus {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	status := HealthStatus{
+		Running:    s.running,
+		Filled:     s.filled,
+		LoadedJobs: len(s.scheduler.cron.Entries()),
+	}
+	if s.fillErr != nil {
+		status.FillError = s.fillErr.Error()
+	}
+	return status
+}
+
+// LivenessHandler returns an http.Handler reporting the health of SmallBen
+// as JSON, with the status code 503 if it is not alive.
+// It is meant to be used as a liveness probe: the repository
+// is not reached, so that its failures never restart SmallBen,
+// and the repository fields of the status are left empty.
+func (s *SmallBen) LivenessHandler() http.Handler {
+	return &healthHandler{
+		health: func(_ context.Context) HealthStatus { return s.processHealth() },
+		check:  (*HealthStatus).Live,
+	}
+}
+
+// ReadinessHandler returns an http.Handler reporting the health of SmallBen
+// as JSON, with the status code 503 if it is not ready.
+// It is meant to be used as a readiness probe.
+func (s *SmallBen) ReadinessHandler() http.Handler {
+	return &healthHandler{health: s.Health, check: (*HealthStatus).Ready}
+}
+
+// healthHandler serves the health of SmallBen
+// returned by `health`, according to `check`.
+type healthHandler struct {
+	health func(ctx context.Context) HealthStatus
+	check  func(status *HealthStatus) bool
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := h.health(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !h.check(&status) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	// nothing to do if the client went away
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,131 @@
+package smallben
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthStatus(t *testing.T) {
+	pairs := []struct {
+		status HealthStatus
+		live   bool
+		ready  bool
+	}{
+		{HealthStatus{}, false, false},
+		{HealthStatus{Running: true}, true, false},
+		{HealthStatus{Running: true, Filled: true}, true, false},
+		{HealthStatus{Running: true, Filled: true, RepositoryReachable: true}, true, true},
+	}
+	for _, pair := range pairs {
+		if pair.status.Live() != pair.live {
+			t.Errorf("Wrong liveness for %+v. Got: %v, expected: %v\n", pair.status, !pair.live, pair.live)
+		}
+		if pair.status.Ready() != pair.ready {
+			t.Errorf("Wrong readiness for %+v. Got: %v, expected: %v\n", pair.status, !pair.ready, pair.ready)
+		}
+	}
+}
+
+// checkHealthHandler checks that `handler` responds
+// with `expectedCode`, returning the decoded status.
+func checkHealthHandler(handler http.Handler, expectedCode int, t *testing.T) HealthStatus {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != expectedCode {
+		t.Errorf("Wrong status code. Got: %d, expected: %d\n", recorder.Code, expectedCode)
+	}
+	var status HealthStatus
+	if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+		t.Errorf("Fail to decode the status: %s\n", err.Error())
+	}
+	return status
+}
+
+func (s *SmallBenTestSuite) TestHealth(t *testing.T) {
+	// not started yet
+	checkHealthHandler(s.smallBen.LivenessHandler(), http.StatusServiceUnavailable, t)
+	checkHealthHandler(s.smallBen.ReadinessHandler(), http.StatusServiceUnavailable, t)
+
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	checkHealthHandler(s.smallBen.LivenessHandler(), http.StatusOK, t)
+	status := checkHealthHandler(s.smallBen.ReadinessHandler(), http.StatusOK, t)
+	if !status.RepositoryReachable || !status.Filled || status.FillError != "" {
+		t.Errorf("Wrong status: %+v\n", status)
+	}
+	if status.LoadedJobs != len(s.jobs) || status.ExpectedJobs != int64(len(s.jobs)) {
+		t.Errorf("Wrong number of jobs. Got: %d loaded, %d expected, expected: %d\n",
+			status.LoadedJobs, status.ExpectedJobs, len(s.jobs))
+	}
+
+	s.smallBen.Stop()
+	status = s.smallBen.Health(context.Background())
+	if status.Live() {
+		t.Errorf("SmallBen should not be alive once stopped\n")
+	}
+}
+
+func TestSmallBenHealth(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestHealth(t)
+		test.teardown(false, t)
+	}
+}
+
+// unreachableRepository is a Repository failing
+// to count the jobs once `unreachable` is set.
+type unreachableRepository struct {
+	Repository
+	unreachable bool
+}
+
+func (u *unreachableRepository) CountJobs(options ToListOptions) (int64, error) {
+	if u.unreachable {
+		return 0, errors.New("unreachable")
+	}
+	return u.Repository.CountJobs(options)
+}
+
+// TestLivenessRepositoryUnreachable tests that SmallBen is alive,
+// but not ready, while its repository is unreachable.
+func TestLivenessRepositoryUnreachable(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+	unreachable := &unreachableRepository{Repository: repository}
+	smallBen := New(unreachable, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+	if err := smallBen.Start(); err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	defer smallBen.Stop()
+	unreachable.unreachable = true
+
+	status := checkHealthHandler(smallBen.LivenessHandler(), http.StatusOK, t)
+	if !status.Running || status.RepositoryError != "" {
+		t.Errorf("Wrong status: %+v\n", status)
+	}
+	status = checkHealthHandler(smallBen.ReadinessHandler(), http.StatusServiceUnavailable, t)
+	if status.RepositoryReachable || status.RepositoryError == "" {
+		t.Errorf("Wrong status: %+v\n", status)
+	}
+}
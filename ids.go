@@ -0,0 +1,151 @@
+package smallben
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// snowflakeNodeBits is the number of bits
+	// of the node in the generated IDs.
+	snowflakeNodeBits = 10
+	// snowflakeSequenceBits is the number of bits
+	// of the sequence in the generated IDs.
+	snowflakeSequenceBits = 12
+	// SnowflakeMaxNode is the maximum node of a SnowflakeGenerator.
+	SnowflakeMaxNode = 1<<snowflakeNodeBits - 1
+	// snowflakeMaxSequence is the maximum sequence
+	// within the same millisecond.
+	snowflakeMaxSequence = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch is the epoch of the IDs generated by SnowflakeGenerator.
+var SnowflakeEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	// ErrInvalidNode is returned when creating a SnowflakeGenerator
+	// with a node that is negative or greater than SnowflakeMaxNode.
+	ErrInvalidNode = errors.New("invalid node")
+	// ErrNodeRequired is returned when journaling the changes in the outbox
+	// of a repository implementing OutboxRepository, if the IDGenerator has
+	// no node: the changes of the instances sharing the repository could
+	// not be told apart.
+	ErrNodeRequired = errors.New("the IDGenerator has no node")
+)
+
+// IDGenerator generates the IDs of the jobs
+// added with a zero ID.
+//
+// If it also has a `Node() int64` method, as SnowflakeGenerator does,
+// the node identifies the instance of SmallBen among the ones sharing the
+// repository, so that each applies only its own changes left in the outbox.
+// The instances sharing a repository implementing OutboxRepository must
+// then use different nodes, while the IDGenerator without a node cannot be
+// used with it: the operations fail with ErrNodeRequired.
+type IDGenerator interface {
+	// NextID returns a new, unique, positive ID.
+	NextID() (int64, error)
+}
+
+// nodeGenerator is an IDGenerator bound to a node.
+type nodeGenerator interface {
+	Node() int64
+}
+
+// SnowflakeGenerator is an IDGenerator generating Snowflake-style IDs, i.e.,
+// made of the milliseconds since SnowflakeEpoch, the node and a sequence.
+// The IDs are unique as long as each instance of SmallBen sharing
+// the same repository uses a different node, and they are sorted by
+// creation time. The zero value is a generator for node 0.
+type SnowflakeGenerator struct {
+	// lock protects the fields below.
+	lock sync.Mutex
+	// node is the node of this generator.
+	node int64
+	// lastMillis is the last millisecond
+	// an ID has been generated at.
+	lastMillis int64
+	// sequence is the sequence of the last ID
+	// generated at lastMillis.
+	sequence int64
+	// now returns the current time.
+	// If nil, time.Now is used.
+	now func() time.Time
+}
+
+// NewSnowflakeGenerator returns a SnowflakeGenerator for `node`, that must be
+// between 0 and SnowflakeMaxNode, otherwise ErrInvalidNode is returned.
+func NewSnowflakeGenerator(node int64) (*SnowflakeGenerator, error) {
+	if node < 0 || node > SnowflakeMaxNode {
+		return nil, ErrInvalidNode
+	}
+	return &SnowflakeGenerator{node: node}, nil
+}
+
+// Node returns the node of this generator.
+func (g *SnowflakeGenerator) Node() int64 {
+	return g.node
+}
+
+// NextID returns a new ID. If more than 4096 IDs are requested
+// within the same millisecond, it waits for the next one.
+func (g *SnowflakeGenerator) NextID() (int64, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	millis := g.millis()
+	// never go back in time, even if the clock does.
+	if millis < g.lastMillis {
+		millis = g.lastMillis
+	}
+	if millis == g.lastMillis {
+		g.sequence++
+		if g.sequence > snowflakeMaxSequence {
+			// the sequence is exhausted,
+			// wait for the next millisecond.
+			for millis <= g.lastMillis {
+				time.Sleep(time.Millisecond)
+				millis = g.millis()
+			}
+			g.sequence = 0
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMillis = millis
+	return millis<<(snowflakeNodeBits+snowflakeSequenceBits) | g.node<<snowflakeSequenceBits | g.sequence, nil
+}
+
+// millis returns the milliseconds since SnowflakeEpoch.
+func (g *SnowflakeGenerator) millis() int64 {
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	return now().Sub(SnowflakeEpoch).Milliseconds()
+}
+
+// node returns the node of this instance, i.e., the one of its
+// IDGenerator, or ErrNodeRequired if the IDGenerator has none.
+func (s *SmallBen) node() (int64, error) {
+	if generator, ok := s.idGenerator.(nodeGenerator); ok {
+		return generator.Node(), nil
+	}
+	return 0, ErrNodeRequired
+}
+
+// assignIDs assigns a new ID to each job of `jobs` whose ID is zero.
+func (s *SmallBen) assignIDs(jobs []Job) error {
+	for i := range jobs {
+		if jobs[i].ID != 0 {
+			continue
+		}
+		id, err := s.idGenerator.NextID()
+		if err != nil {
+			return err
+		}
+		jobs[i].ID = id
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package smallben
+
+import (
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestSnowflakeGenerator(t *testing.T) {
+	_, err := NewSnowflakeGenerator(SnowflakeMaxNode + 1)
+	checkErrorIsOf(err, ErrInvalidNode, t)
+
+	generator, err := NewSnowflakeGenerator(3)
+	if err != nil {
+		t.Errorf("Cannot create the generator: %s\n", err.Error())
+		t.FailNow()
+	}
+	// the sequence is exhausted within the first
+	// millisecond, then the clock goes back in time.
+	base := SnowflakeEpoch.Add(time.Hour)
+	calls := 0
+	generator.now = func() time.Time {
+		calls++
+		switch {
+		case calls <= snowflakeMaxSequence+2:
+			return base
+		case calls <= snowflakeMaxSequence+10:
+			return base.Add(time.Millisecond)
+		default:
+			return base.Add(-time.Second)
+		}
+	}
+
+	var last int64
+	for i := 0; i < snowflakeMaxSequence+20; i++ {
+		id, err := generator.NextID()
+		if err != nil {
+			t.Errorf("Cannot generate the ID: %s\n", err.Error())
+			t.FailNow()
+		}
+		if id <= last {
+			t.Errorf("IDs are not increasing. Got: %d after %d\n", id, last)
+			t.FailNow()
+		}
+		if node := id >> snowflakeSequenceBits & SnowflakeMaxNode; node != 3 {
+			t.Errorf("Wrong node. Got: %d, expected: 3\n", node)
+		}
+		last = id
+	}
+	if millis := last >> (snowflakeNodeBits + snowflakeSequenceBits); millis != time.Hour.Milliseconds()+1 {
+		t.Errorf("Wrong time of the last ID. Got: %d\n", millis)
+	}
+}
+
+func (s *SmallBenTestSuite) TestGeneratedIDs(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+	jobs[0].ID = 0
+	jobs[1].ID = 0
+
+	err = s.smallBen.AddJobs(jobs)
+	s.jobs = jobs
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if jobs[0].ID <= 0 || jobs[1].ID <= 0 || jobs[0].ID == jobs[1].ID {
+		t.Errorf("Wrong generated IDs: %d, %d\n", jobs[0].ID, jobs[1].ID)
+	}
+	// the IDs of the other jobs are kept
+	if jobs[2].ID != JobsToUse[2].ID {
+		t.Errorf("The ID should have been kept. Got: %d, expected: %d\n", jobs[2].ID, JobsToUse[2].ID)
+	}
+	listed, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: []int64{jobs[0].ID, jobs[1].ID}})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(listed) != 2 {
+		t.Errorf("Wrong number of jobs. Got: %d, expected: 2\n", len(listed))
+	}
+}
+
+func TestSmallBenGeneratedIDs(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestGeneratedIDs(t)
+		test.teardown(false, t)
+	}
+}
+
+// counterGenerator is an IDGenerator without a node.
+type counterGenerator struct {
+	last int64
+}
+
+func (c *counterGenerator) NextID() (int64, error) {
+	c.last++
+	return c.last, nil
+}
+
+// TestNodeRequired tests that an IDGenerator without a node
+// cannot be used with a repository having an outbox.
+func TestNodeRequired(t *testing.T) {
+	db, _ := openTestSQLite(t)
+	repository, err := NewRepositorySQL(&RepositorySQLConfig{DB: db, Dialect: SQLDialectSQLite})
+	if err != nil {
+		t.Fatalf("Cannot create the repository: %s", err.Error())
+	}
+	boltRepository, _ := newBoltTestRepository(t)
+	for _, withOutbox := range []bool{true, false} {
+		var smallBenRepository Repository = boltRepository
+		if withOutbox {
+			smallBenRepository = repository
+		}
+		smallBen := New(smallBenRepository, &Config{
+			Logger:          zapr.NewLogger(zap.NewExample()),
+			SchedulerConfig: SchedulerConfig{WithSeconds: true},
+			IDGenerator:     &counterGenerator{},
+		})
+		jobs := make([]Job, 1)
+		copy(jobs, JobsToUse)
+		err = smallBen.AddJobs(jobs)
+		if withOutbox {
+			checkErrorIsOf(err, ErrNodeRequired, t)
+			checkErrorIsOf(smallBen.Start(), ErrNodeRequired, t)
+		} else if err != nil {
+			t.Errorf("Fail to add jobs: %s", err.Error())
+		}
+	}
+}
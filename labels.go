@@ -0,0 +1,249 @@
+package smallben
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidLabel is returned when adding a job with a label
+	// whose key is empty or whose key or value contain characters
+	// other than letters, digits, '.', '_', '/' and '-'.
+	ErrInvalidLabel = errors.New("invalid label")
+	// ErrInvalidLabelSelector is returned when parsing
+	// a malformed label selector.
+	ErrInvalidLabelSelector = errors.New("invalid label selector")
+)
+
+// labelPattern matches the valid keys and values of the labels.
+var labelPattern = regexp.MustCompile(`^[A-Za-z0-9._/-]*$`)
+
+// setPattern matches a requirement of type `key in (values)` or `key notin (values)`.
+var setPattern = regexp.MustCompile(`^([^\s!=()]+)\s+(in|notin)\s*\((.*)\)$`)
+
+// JobLabel is a label of a job, as stored in the repository.
+type JobLabel struct {
+	// JobID is the ID of the job.
+	JobID int64 `gorm:"primaryKey;column:job_id"`
+	// Key is the key of the label.
+	Key string `gorm:"primaryKey;column:label_key"`
+	// Value is the value of the label.
+	Value string `gorm:"column:label_value"`
+}
+
+func (l *JobLabel) TableName() string {
+	return "job_labels"
+}
+
+// LabelOperator is the operator of a LabelRequirement.
+type LabelOperator string
+
+const (
+	// LabelEquals matches the jobs having the label with the given value.
+	LabelEquals = LabelOperator("=")
+	// LabelNotEquals matches the jobs not having the label with the given value,
+	// including the jobs not having the label at all.
+	LabelNotEquals = LabelOperator("!=")
+	// LabelIn matches the jobs having the label with one of the given values.
+	LabelIn = LabelOperator("in")
+	// LabelNotIn matches the jobs not having the label with one of the given values,
+	// including the jobs not having the label at all.
+	LabelNotIn = LabelOperator("notin")
+	// LabelExists matches the jobs having the label.
+	LabelExists = LabelOperator("exists")
+	// LabelDoesNotExist matches the jobs not having the label.
+	LabelDoesNotExist = LabelOperator("!")
+)
+
+// LabelRequirement is a requirement on a label of the jobs.
+type LabelRequirement struct {
+	// Key is the key of the label.
+	Key string
+	// Operator is the operator to apply.
+	Operator LabelOperator
+	// Values are the values of the label, one for LabelEquals and LabelNotEquals,
+	// at least one for LabelIn and LabelNotIn, none otherwise.
+	Values []string
+}
+
+// LabelSelector selects the jobs matching all of its requirements.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a Kubernetes-style label selector, i.e., a comma-separated
+// list of requirements such as `env=prod`, `env!=prod`, `team in (a,b)`, `team notin (a,b)`,
+// `feature` or `!feature`. It returns ErrInvalidLabelSelector if `selector` is malformed.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var result LabelSelector
+	for _, part := range splitLabelSelector(selector) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		requirement, err := parseLabelRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, requirement)
+	}
+	return result, nil
+}
+
+// splitLabelSelector splits `selector` on the commas
+// not enclosed in parentheses.
+func splitLabelSelector(selector string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range selector {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+// parseLabelRequirement parses a single requirement.
+func parseLabelRequirement(part string) (LabelRequirement, error) {
+	var requirement LabelRequirement
+	if matches := setPattern.FindStringSubmatch(part); matches != nil {
+		requirement = LabelRequirement{Key: matches[1], Operator: LabelOperator(matches[2])}
+		if values := strings.TrimSpace(matches[3]); values != "" {
+			for _, value := range strings.Split(values, ",") {
+				requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+			}
+		}
+	} else if strings.HasPrefix(part, "!") && !strings.Contains(part, "=") {
+		requirement = LabelRequirement{Key: strings.TrimSpace(part[1:]), Operator: LabelDoesNotExist}
+	} else if i := strings.Index(part, "!="); i >= 0 {
+		requirement = LabelRequirement{Key: strings.TrimSpace(part[:i]), Operator: LabelNotEquals,
+			Values: []string{strings.TrimSpace(part[i+2:])}}
+	} else if i := strings.Index(part, "=="); i >= 0 {
+		requirement = LabelRequirement{Key: strings.TrimSpace(part[:i]), Operator: LabelEquals,
+			Values: []string{strings.TrimSpace(part[i+2:])}}
+	} else if i := strings.Index(part, "="); i >= 0 {
+		requirement = LabelRequirement{Key: strings.TrimSpace(part[:i]), Operator: LabelEquals,
+			Values: []string{strings.TrimSpace(part[i+1:])}}
+	} else {
+		requirement = LabelRequirement{Key: part, Operator: LabelExists}
+	}
+	if err := requirement.validate(); err != nil {
+		return LabelRequirement{}, fmt.Errorf("%w: %s", ErrInvalidLabelSelector, part)
+	}
+	return requirement, nil
+}
+
+// validate returns an error if the requirement is not valid.
+func (r *LabelRequirement) validate() error {
+	if validateLabel(r.Key, "") != nil {
+		return ErrInvalidLabelSelector
+	}
+	for _, value := range r.Values {
+		if validateLabel(r.Key, value) != nil {
+			return ErrInvalidLabelSelector
+		}
+	}
+	switch r.Operator {
+	case LabelEquals, LabelNotEquals:
+		if len(r.Values) != 1 {
+			return ErrInvalidLabelSelector
+		}
+	case LabelIn, LabelNotIn:
+		if len(r.Values) == 0 {
+			return ErrInvalidLabelSelector
+		}
+	case LabelExists, LabelDoesNotExist:
+		if len(r.Values) != 0 {
+			return ErrInvalidLabelSelector
+		}
+	default:
+		return ErrInvalidLabelSelector
+	}
+	return nil
+}
+
+// Matches returns whether `labels` match all the requirements.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns whether `labels` match the requirement.
+func (r *LabelRequirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case LabelEquals, LabelIn:
+		return ok && containsString(r.Values, value)
+	case LabelNotEquals, LabelNotIn:
+		return !ok || !containsString(r.Values, value)
+	case LabelExists:
+		return ok
+	case LabelDoesNotExist:
+		return !ok
+	default:
+		return false
+	}
+}
+
+// containsString returns whether `values` contains `value`.
+func containsString(values []string, value string) bool {
+	for _, other := range values {
+		if other == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateLabel returns ErrInvalidLabel if `key` or `value` are not valid.
+func validateLabel(key, value string) error {
+	if key == "" || !labelPattern.MatchString(key) || !labelPattern.MatchString(value) {
+		return ErrInvalidLabel
+	}
+	return nil
+}
+
+// encodeLabels converts `labels` to the labels of the job `jobID`,
+// sorted by key. It returns nil if there are no labels.
+func encodeLabels(jobID int64, labels map[string]string) ([]JobLabel, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	result := make([]JobLabel, 0, len(labels))
+	for key, value := range labels {
+		if err := validateLabel(key, value); err != nil {
+			return nil, fmt.Errorf("%w: %s=%s", err, key, value)
+		}
+		result = append(result, JobLabel{JobID: jobID, Key: key, Value: value})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// decodeLabels converts `labels` to a map.
+// It returns nil if there are no labels.
+func decodeLabels(labels []JobLabel) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(labels))
+	for _, label := range labels {
+		result[label.Key] = label.Value
+	}
+	return result
+}
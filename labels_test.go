@@ -0,0 +1,80 @@
+package smallben
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabelSelector(t *testing.T) {
+	pairs := []struct {
+		selector string
+		expected LabelSelector
+	}{
+		{"", nil},
+		{"env=prod", LabelSelector{{Key: "env", Operator: LabelEquals, Values: []string{"prod"}}}},
+		{"env==prod", LabelSelector{{Key: "env", Operator: LabelEquals, Values: []string{"prod"}}}},
+		{"env != prod", LabelSelector{{Key: "env", Operator: LabelNotEquals, Values: []string{"prod"}}}},
+		{"team in (a, b),env", LabelSelector{
+			{Key: "team", Operator: LabelIn, Values: []string{"a", "b"}},
+			{Key: "env", Operator: LabelExists},
+		}},
+		{"team notin (a), !env", LabelSelector{
+			{Key: "team", Operator: LabelNotIn, Values: []string{"a"}},
+			{Key: "env", Operator: LabelDoesNotExist},
+		}},
+		{"example.com/team=a_b-c", LabelSelector{{Key: "example.com/team", Operator: LabelEquals, Values: []string{"a_b-c"}}}},
+	}
+	for _, pair := range pairs {
+		selector, err := ParseLabelSelector(pair.selector)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s\n", pair.selector, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(selector, pair.expected) {
+			t.Errorf("Selector mismatch for %s. Got: %+v Expected: %+v\n", pair.selector, selector, pair.expected)
+		}
+	}
+
+	for _, selector := range []string{"=prod", "env=pr od", "team in ()", "team in (a", "!", "env=prod=dev", "env in (a,b"} {
+		_, err := ParseLabelSelector(selector)
+		checkErrorIsOf(err, ErrInvalidLabelSelector, t)
+	}
+}
+
+func TestLabelSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "a"}
+	pairs := []struct {
+		selector string
+		expected bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"missing!=dev", true},
+		{"team in (a,b)", true},
+		{"team notin (a,b)", false},
+		{"env,team", true},
+		{"!env", false},
+		{"!missing", true},
+		{"env=prod,team=b", false},
+	}
+	for _, pair := range pairs {
+		selector, err := ParseLabelSelector(pair.selector)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s\n", pair.selector, err.Error())
+			continue
+		}
+		if selector.Matches(labels) != pair.expected {
+			t.Errorf("Wrong match for %s. Expected: %v\n", pair.selector, pair.expected)
+		}
+	}
+}
+
+func TestInvalidLabels(t *testing.T) {
+	for _, labels := range []map[string]string{{"": "a"}, {"env": "pr od"}, {"en v": "prod"}} {
+		job := Job{ID: 1, CronExpression: "@every 1s", Labels: labels}
+		_, err := job.toJobWithSchedule()
+		checkErrorIsOf(err, ErrInvalidLabel, t)
+	}
+}
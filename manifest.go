@@ -0,0 +1,317 @@
+package smallben
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	// ErrInvalidManifest is returned when parsing or applying
+	// a malformed manifest.
+	ErrInvalidManifest = errors.New("invalid manifest")
+	// ErrUnknownJobType is returned when applying a manifest
+	// containing a job whose type is not in Config.JobTypes.
+	ErrUnknownJobType = errors.New("unknown job type")
+)
+
+// Manifest is the declarative definition of a set of jobs,
+// that can be kept in version control and reconciled
+// with the repository by SmallBen.Apply.
+type Manifest struct {
+	// Jobs are the jobs defined by the manifest.
+	Jobs []ManifestJob `json:"jobs" yaml:"jobs"`
+}
+
+// ManifestJob is the definition of a job in a Manifest.
+// It must have an ID or a Name, used to match it against
+// the jobs in the repository.
+type ManifestJob struct {
+	// ID is the ID of the job. If zero, the job is matched
+	// by its Name, and an ID is generated when it is created.
+	ID int64 `json:"id,omitempty" yaml:"id,omitempty"`
+	// Name is the name of the job.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Description is the description of the job.
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	// Owner is the owner of the job.
+	Owner string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	// GroupID is the GroupID of the job.
+	GroupID int64 `json:"group_id" yaml:"group_id"`
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64 `json:"super_group_id" yaml:"super_group_id"`
+	// CronExpression is the schedule of the job.
+	CronExpression string `json:"cron_expression" yaml:"cron_expression"`
+	// Type is the name of the CronJob executing the job,
+	// as registered in Config.JobTypes.
+	Type string `json:"type" yaml:"type"`
+	// Input is the input of the job.
+	Input map[string]interface{} `json:"input,omitempty" yaml:"input,omitempty"`
+	// Paused specifies whether the job is paused.
+	Paused bool `json:"paused,omitempty" yaml:"paused,omitempty"`
+	// Labels are the labels of the job.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// ParseManifest parses a manifest encoded in YAML or JSON.
+// It returns ErrInvalidManifest if `data` is malformed, including
+// when it contains unknown fields.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var manifest Manifest
+	// JSON is valid YAML, so a single decoder is enough.
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&manifest); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidManifest, err.Error())
+	}
+	return &manifest, nil
+}
+
+// LoadManifest reads and parses the manifest in the file at `path`.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// toJobs converts the jobs of the manifest to Job, by looking
+// up their CronJob in `jobTypes`.
+func (m *Manifest) toJobs(jobTypes map[string]CronJob) ([]Job, error) {
+	jobs := make([]Job, len(m.Jobs))
+	ids := make(map[int64]bool, len(m.Jobs))
+	for i, job := range m.Jobs {
+		if job.ID == 0 && job.Name == "" {
+			return nil, fmt.Errorf("%w: job %d has neither an ID nor a name", ErrInvalidManifest, i)
+		}
+		if job.ID != 0 {
+			if ids[job.ID] {
+				return nil, fmt.Errorf("%w: duplicate job %d", ErrInvalidManifest, job.ID)
+			}
+			ids[job.ID] = true
+		}
+		cronJob, ok := jobTypes[job.Type]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, job.Type)
+		}
+		jobs[i] = Job{
+			ID:             job.ID,
+			GroupID:        job.GroupID,
+			SuperGroupID:   job.SuperGroupID,
+			Name:           job.Name,
+			Description:    job.Description,
+			Owner:          job.Owner,
+			CronExpression: job.CronExpression,
+			Job:            cronJob,
+			JobInput:       job.Input,
+			Labels:         job.Labels,
+		}
+	}
+	return jobs, nil
+}
+
+// JobRef identifies a job in an ApplyResult.
+type JobRef struct {
+	// ID is the ID of the job. It is zero for the jobs
+	// to create without an ID when planning.
+	ID int64
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64
+	// Name is the Name of the job, if any.
+	Name string
+}
+
+// ApplyResult reports the differences between
+// a manifest and the repository.
+type ApplyResult struct {
+	// Created are the jobs in the manifest
+	// but not in the repository.
+	Created []JobRef
+	// Updated are the jobs whose definition or paused
+	// state differs from the repository.
+	Updated []JobRef
+	// Deleted are the jobs in the repository, within the
+	// super groups of the manifest, but not in the manifest.
+	// They are deleted only when pruning.
+	Deleted []JobRef
+	// Unchanged are the jobs already up to date.
+	Unchanged []JobRef
+}
+
+// Plan computes the differences between `manifest` and the repository,
+// i.e., what Apply would do, without applying them.
+func (s *SmallBen) Plan(manifest *Manifest, prune bool) (result ApplyResult, err error) {
+	ctx, span := s.startOperation("Plan", nil)
+	defer func() { endSpan(span, err) }()
+
+	plan, err := s.plan(ctx, manifest, prune)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+	return plan.result, nil
+}
+
+// Apply reconciles the repository with `manifest`: the jobs in the manifest
+// but not in the repository are created, the ones that differ are updated,
+// as done by UpsertJobs, and paused or resumed according to the manifest.
+// If `prune` is true, the jobs within the super groups of the manifest that
+// are not in the manifest are deleted. The job types of the manifest are
+// looked up in Config.JobTypes.
+//
+// Each step is atomic, but Apply as a whole is not: in case of errors,
+// it can be called again to complete the reconciliation.
+func (s *SmallBen) Apply(manifest *Manifest, prune bool) (result ApplyResult, err error) {
+	ctx, span := s.startOperation("Apply", nil)
+	defer func() { endSpan(span, err) }()
+
+	s.logger.Info("Applying manifest", "Progress", "InProgress")
+	plan, err := s.plan(ctx, manifest, prune)
+	if err != nil {
+		s.logger.Error(err, "Applying manifest", "Progress", "Error", "Details", "Planning")
+		return ApplyResult{}, err
+	}
+
+	if len(plan.result.Created)+len(plan.result.Updated) > 0 {
+		if _, err = s.UpsertJobs(plan.jobs); err != nil {
+			s.logger.Error(err, "Applying manifest", "Progress", "Error", "Details", "Upserting")
+			return ApplyResult{}, err
+		}
+		// the jobs to create now have an ID.
+		plan.fillIDs()
+	}
+	if toPause := plan.pausedChanges(true); len(toPause) > 0 {
+		if err = s.PauseJobs(&PauseResumeOptions{JobIDs: toPause}); err != nil {
+			s.logger.Error(err, "Applying manifest", "Progress", "Error", "Details", "Pausing", "IDs", toPause)
+			return ApplyResult{}, err
+		}
+	}
+	if toResume := plan.pausedChanges(false); len(toResume) > 0 {
+		if err = s.ResumeJobs(&PauseResumeOptions{JobIDs: toResume}); err != nil {
+			s.logger.Error(err, "Applying manifest", "Progress", "Error", "Details", "Resuming", "IDs", toResume)
+			return ApplyResult{}, err
+		}
+	}
+	if prune && len(plan.result.Deleted) > 0 {
+		toDelete := make([]int64, len(plan.result.Deleted))
+		for i, ref := range plan.result.Deleted {
+			toDelete[i] = ref.ID
+		}
+		if err = s.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: toDelete}}); err != nil {
+			s.logger.Error(err, "Applying manifest", "Progress", "Error", "Details", "Pruning", "IDs", toDelete)
+			return ApplyResult{}, err
+		}
+	}
+	s.logger.Info("Applying manifest", "Progress", "Done",
+		"Created", len(plan.result.Created), "Updated", len(plan.result.Updated),
+		"Deleted", len(plan.result.Deleted), "Unchanged", len(plan.result.Unchanged))
+	return plan.result, nil
+}
+
+// manifestPlan is the plan to apply a manifest.
+type manifestPlan struct {
+	// jobs are the jobs of the manifest, with the
+	// ID of the ones matched by name.
+	jobs []Job
+	// paused is the paused state of the jobs
+	// in the manifest, by index.
+	paused []bool
+	// existingPaused is the paused state of the jobs
+	// in the repository, by index. False for new jobs.
+	existingPaused []bool
+	// created are the indexes of the jobs to create.
+	created []int
+	// result is the result of the plan.
+	result ApplyResult
+}
+
+// plan computes the plan to apply `manifest`.
+func (s *SmallBen) plan(ctx context.Context, manifest *Manifest, prune bool) (*manifestPlan, error) {
+	jobs, err := manifest.toJobs(s.jobTypes)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.existingJobs(ctx, s.repository, jobs)
+	if err != nil {
+		return nil, err
+	}
+	plan := &manifestPlan{
+		jobs:           jobs,
+		paused:         make([]bool, len(jobs)),
+		existingPaused: make([]bool, len(jobs)),
+	}
+	inManifest := make(map[int64]bool, len(jobs))
+	superGroupIDs := make(map[int64]bool)
+	for i, job := range jobs {
+		plan.paused[i] = manifest.Jobs[i].Paused
+		superGroupIDs[job.SuperGroupID] = true
+		ref := JobRef{ID: job.ID, SuperGroupID: job.SuperGroupID, Name: job.Name}
+		old, ok := existing[job.ID]
+		if !ok {
+			plan.created = append(plan.created, i)
+			plan.result.Created = append(plan.result.Created, ref)
+			continue
+		}
+		inManifest[job.ID] = true
+		plan.existingPaused[i] = old.Paused
+		// the chains are checked when upserting
+		job.triggered = true
+		jobWithSchedule, err := job.toJobWithSchedule()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = jobWithSchedule.BuildJob(); err != nil {
+			return nil, err
+		}
+		if jobChanged(&old, &jobWithSchedule.rawJob) || old.Paused != plan.paused[i] {
+			plan.result.Updated = append(plan.result.Updated, ref)
+		} else {
+			plan.result.Unchanged = append(plan.result.Unchanged, ref)
+		}
+	}
+	if prune && len(superGroupIDs) > 0 {
+		options := ListJobsOptions{SuperGroupIDs: make([]int64, 0, len(superGroupIDs))}
+		for id := range superGroupIDs {
+			options.SuperGroupIDs = append(options.SuperGroupIDs, id)
+		}
+		var rawJobs []RawJob
+		if err = s.traceRepository(ctx, "ListJobs", func() error {
+			var err error
+			rawJobs, err = s.repository.ListJobs(&options)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		for _, rawJob := range rawJobs {
+			if !inManifest[rawJob.ID] {
+				plan.result.Deleted = append(plan.result.Deleted,
+					JobRef{ID: rawJob.ID, SuperGroupID: rawJob.SuperGroupID, Name: rawJob.Name})
+			}
+		}
+	}
+	return plan, nil
+}
+
+// fillIDs sets the ID of the created jobs in
+// the result, once they have been assigned.
+func (p *manifestPlan) fillIDs() {
+	for i, index := range p.created {
+		p.result.Created[i].ID = p.jobs[index].ID
+	}
+}
+
+// pausedChanges returns the IDs of the jobs to pause,
+// if `paused` is true, or to resume otherwise.
+func (p *manifestPlan) pausedChanges(paused bool) []int64 {
+	var ids []int64
+	for i, job := range p.jobs {
+		if p.paused[i] == paused && p.existingPaused[i] != paused {
+			ids = append(ids, job.ID)
+		}
+	}
+	return ids
+}
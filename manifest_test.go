@@ -0,0 +1,183 @@
+package smallben
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testManifestYAML = `
+jobs:
+  - id: 1
+    name: report
+    group_id: 1
+    super_group_id: 1
+    cron_expression: "@every 70s"
+    type: noop
+    input:
+      test_id: 1
+    labels:
+      env: prod
+  - id: 2
+    group_id: 1
+    super_group_id: 1
+    cron_expression: "@every 62s"
+    type: noop
+    paused: true
+  - id: 3
+    group_id: 2
+    super_group_id: 1
+    cron_expression: "@every 61s"
+    type: noop
+`
+
+const testManifestJSON = `{"jobs": [
+	{"id": 1, "name": "report", "group_id": 1, "super_group_id": 1, "cron_expression": "@every 70s",
+	 "type": "noop", "input": {"test_id": 1}, "labels": {"env": "prod"}},
+	{"id": 2, "group_id": 1, "super_group_id": 1, "cron_expression": "@every 62s", "type": "noop", "paused": true},
+	{"id": 3, "group_id": 2, "super_group_id": 1, "cron_expression": "@every 61s", "type": "noop"}
+]}`
+
+func TestParseManifest(t *testing.T) {
+	fromYAML, err := ParseManifest([]byte(testManifestYAML))
+	if err != nil {
+		t.Errorf("Cannot parse the YAML manifest: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(fromYAML.Jobs) != 3 || fromYAML.Jobs[0].Name != "report" || !fromYAML.Jobs[1].Paused ||
+		fromYAML.Jobs[0].Labels["env"] != "prod" || fromYAML.Jobs[0].Input["test_id"] != 1 {
+		t.Errorf("Wrong manifest: %+v\n", fromYAML)
+	}
+	fromJSON, err := ParseManifest([]byte(testManifestJSON))
+	if err != nil {
+		t.Errorf("Cannot parse the JSON manifest: %s\n", err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("The manifests should be equal. Got: %+v, expected: %+v\n", fromJSON, fromYAML)
+	}
+
+	_, err = ParseManifest([]byte("jobs:\n  - id: 1\n    cron: \"@every 1s\"\n"))
+	checkErrorIsOf(err, ErrInvalidManifest, t)
+
+	jobTypes := map[string]CronJob{"noop": &SmallBenCronJob{}}
+	_, err = (&Manifest{Jobs: []ManifestJob{{GroupID: 1, Type: "noop"}}}).toJobs(jobTypes)
+	checkErrorIsOf(err, ErrInvalidManifest, t)
+	_, err = (&Manifest{Jobs: []ManifestJob{{ID: 1, Type: "noop"}, {ID: 1, Type: "noop"}}}).toJobs(jobTypes)
+	checkErrorIsOf(err, ErrInvalidManifest, t)
+	_, err = (&Manifest{Jobs: []ManifestJob{{ID: 1, Type: "missing"}}}).toJobs(jobTypes)
+	checkErrorIsOf(err, ErrUnknownJobType, t)
+}
+
+// getIdsFromJobRefList basically does refs.map(ref -> ref.id)
+func getIdsFromJobRefList(refs []JobRef) []int64 {
+	var ids []int64
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+	return ids
+}
+
+// checkApplyResult checks that `result` matches the expected IDs.
+func checkApplyResult(result ApplyResult, created, updated, deleted, unchanged []int64, t *testing.T) {
+	if !reflect.DeepEqual(getIdsFromJobRefList(result.Created), created) ||
+		!reflect.DeepEqual(getIdsFromJobRefList(result.Updated), updated) ||
+		!reflect.DeepEqual(getIdsFromJobRefList(result.Deleted), deleted) ||
+		!reflect.DeepEqual(getIdsFromJobRefList(result.Unchanged), unchanged) {
+		t.Errorf("Wrong apply result. Got: %+v, expected: %v created, %v updated, %v deleted, %v unchanged\n",
+			result, created, updated, deleted, unchanged)
+	}
+}
+
+func (s *SmallBenTestSuite) TestApply(t *testing.T) {
+	s.smallBen.jobTypes = map[string]CronJob{"noop": &SmallBenCronJob{}}
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	manifest, err := ParseManifest([]byte(testManifestYAML))
+	if err != nil {
+		t.Errorf("Cannot parse the manifest: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// planning does not change anything
+	result, err := s.smallBen.Plan(manifest, true)
+	if err != nil {
+		t.Errorf("Fail to plan: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, []int64{1, 2, 3}, nil, nil, nil, t)
+	if count, _ := s.smallBen.repository.CountJobs(nil); count != 0 {
+		t.Errorf("Planning should not create jobs. Got: %d jobs\n", count)
+	}
+
+	result, err = s.smallBen.Apply(manifest, true)
+	if err != nil {
+		t.Errorf("Fail to apply: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, []int64{1, 2, 3}, nil, nil, nil, t)
+	paused := true
+	pausedJobs, err := s.smallBen.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(pausedJobs) != 1 || pausedJobs[0].ID != 2 {
+		t.Errorf("Wrong paused jobs: %+v\n", pausedJobs)
+	}
+
+	// applying again does nothing
+	result, err = s.smallBen.Apply(manifest, true)
+	if err != nil {
+		t.Errorf("Fail to apply: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, nil, nil, nil, []int64{1, 2, 3}, t)
+
+	// change the schedule of the first job, resume the second one
+	// and remove the third one.
+	manifest.Jobs[0].CronExpression = "@every 75s"
+	manifest.Jobs[1].Paused = false
+	manifest.Jobs = manifest.Jobs[:2]
+	result, err = s.smallBen.Plan(manifest, true)
+	if err != nil {
+		t.Errorf("Fail to plan: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, nil, []int64{1, 2}, []int64{3}, nil, t)
+	// without pruning, the third job is kept
+	result, err = s.smallBen.Plan(manifest, false)
+	if err != nil {
+		t.Errorf("Fail to plan: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, nil, []int64{1, 2}, nil, nil, t)
+
+	result, err = s.smallBen.Apply(manifest, true)
+	if err != nil {
+		t.Errorf("Fail to apply: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkApplyResult(result, nil, []int64{1, 2}, []int64{3}, nil, t)
+	jobs, err := s.smallBen.ListJobs(&ListJobsOptions{})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 2 || jobs[0].CronExpression != "@every 75s" || jobs[1].Paused() {
+		t.Errorf("Wrong jobs: %+v\n", jobs)
+	}
+	s.jobs = s.jobs[:2]
+}
+
+func TestSmallBenApply(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestApply(t)
+		test.teardown(false, t)
+	}
+}
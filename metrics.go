@@ -2,20 +2,62 @@ package smallben
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/robfig/cron/v3"
+	"strconv"
+	"sync"
+	"time"
 )
 
+const (
+	// DefaultMetricsMaxJobs is the number of jobs tracked by the
+	// per-job metrics when MetricsConfig.MaxJobs is not set.
+	DefaultMetricsMaxJobs = 1000
+	// DefaultMetricsMaxGroups is the number of distinct groups used as labels
+	// when MetricsConfig.MaxGroups is not set.
+	DefaultMetricsMaxGroups = 100
+	// DefaultMetricsResyncInterval is how often the number of jobs
+	// is resynced when MetricsConfig.ResyncInterval is not set.
+	DefaultMetricsResyncInterval = 5 * time.Minute
+	// MetricsOtherGroup is the value of the group labels
+	// used once MetricsConfig.MaxGroups is reached.
+	MetricsOtherGroup = "other"
+)
+
+// MetricsConfig configures the metrics
+// about the executions of the jobs.
+type MetricsConfig struct {
+	// WithGroupLabels adds the `group_id` and `super_group_id` labels
+	// to the metrics about the executions.
+	WithGroupLabels bool
+	// MaxJobs is the maximum number of jobs tracked by the last success
+	// and last failure gauges: the jobs exceeding it are not tracked.
+	// If not set, DefaultMetricsMaxJobs is used.
+	MaxJobs int
+	// MaxGroups is the maximum number of distinct pairs of `group_id` and `super_group_id`
+	// labels: the pairs exceeding it are reported as MetricsOtherGroup.
+	// If not set, DefaultMetricsMaxGroups is used.
+	MaxGroups int
+	// DurationBuckets are the buckets of the histogram of the duration of the executions,
+	// in seconds. If nil, prometheus.DefBuckets is used.
+	DurationBuckets []float64
+	// ResyncInterval is how often the number of jobs, paused or not,
+	// is recomputed from the repository, so that the gauges never drift.
+	// If not set, DefaultMetricsResyncInterval is used, while if negative
+	// they are never resynced.
+	ResyncInterval time.Duration
+}
+
 // metrics is the struct holding
 // the different Prometheus metrics
 // SmallBen exposes.
 type metrics struct {
-	total     prometheus.Gauge
-	notPaused prometheus.Gauge
-	paused    prometheus.Gauge
+	total      prometheus.Gauge
+	notPaused  prometheus.Gauge
+	paused     prometheus.Gauge
+	executions *executionMetrics
 }
 
-// newMetrics returns a new set of metrics.
-func newMetrics() metrics {
+// newMetrics returns a new set of metrics, configured by `config`.
+func newMetrics(config MetricsConfig) metrics {
 	return metrics{
 		total: prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: "smallben",
@@ -35,31 +77,48 @@ func newMetrics() metrics {
 			Name:      "jobs_paused",
 			Help:      "Number of jobs not scheduled for execution by small ben",
 		}),
+		executions: newExecutionMetrics(config),
 	}
 }
 
-// fillMetrics freshly sets the metrics.
+// fillMetrics freshly sets the metrics, by counting
+// the jobs in the repository.
 func (s *SmallBen) fillMetrics() error {
-	totalJobs, err := s.repository.ListJobs(nil)
-	if err != nil {
-		return err
-	}
 	paused := false
-	notPausedJobs, err := s.repository.ListJobs(&ListJobsOptions{Paused: &paused})
+	notPausedJobs, err := s.repository.CountJobs(&ListJobsOptions{Paused: &paused})
 	if err != nil {
 		return err
 	}
 	paused = true
-	pausedJobs, err := s.repository.ListJobs(&ListJobsOptions{Paused: &paused})
+	pausedJobs, err := s.repository.CountJobs(&ListJobsOptions{Paused: &paused})
 	if err != nil {
 		return err
 	}
-	s.metrics.total.Add(float64(len(totalJobs)))
-	s.metrics.notPaused.Add(float64(len(notPausedJobs)))
-	s.metrics.paused.Add(float64(len(pausedJobs)))
+	s.metrics.total.Set(float64(notPausedJobs + pausedJobs))
+	s.metrics.notPaused.Set(float64(notPausedJobs))
+	s.metrics.paused.Set(float64(pausedJobs))
 	return nil
 }
 
+// resyncMetrics calls fillMetrics every `interval`,
+// until `stop` is closed.
+func (s *SmallBen) resyncMetrics(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			if err := s.fillMetrics(); err != nil {
+				s.logger.Error(err, "Resyncing metrics", "Progress", "Error")
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
 // addJobs updates the metrics
 // by adding `size` jobs, considering them
 // as being scheduled for execution.
@@ -82,34 +141,18 @@ func (m *metrics) resumeJobs(size int) {
 	m.notPaused.Add(float64(size))
 }
 
-// postDelete updates metrics after a delete operation.
-//
-// * beforeJobs is the list of jobs that were on the scheduler before removing them
-//
-// * requestedJobs is the list of jobs users requested to delete
-//
-func (m *metrics) postDelete(beforeJobs []cron.Entry, requestedJobs []RawJob) {
-	// actually, we don't know if the deleted jobs
-	// were running or not, so we to find out
-	for _, oldJob := range beforeJobs {
-		found := false
-		for _, gotJob := range requestedJobs {
-			// if found, then the job was
-			// in execution
-			if int64(oldJob.ID) == gotJob.CronID {
-				found = true
-				break
-			}
-		}
-		// found, then the job was in running
-		if found {
-			m.notPaused.Dec()
-		} else {
-			// otherwise, it was paused
+// postDelete updates metrics after `deletedJobs`
+// have been deleted.
+func (m *metrics) postDelete(deletedJobs []RawJob) {
+	for _, job := range deletedJobs {
+		if job.Paused {
 			m.paused.Dec()
+		} else {
+			m.notPaused.Dec()
 		}
 	}
-	m.total.Sub(float64(len(requestedJobs)))
+	m.total.Sub(float64(len(deletedJobs)))
+	m.executions.deleteJobs(getIdsFromJobRawList(deletedJobs))
 }
 
 // registerTo registers the metrics to registry.
@@ -134,5 +177,198 @@ func (m *metrics) registerTo(registry *prometheus.Registry) error {
 	if err := register.Register(m.notPaused); err != nil {
 		return err
 	}
+	return m.executions.registerTo(register)
+}
+
+// executionMetrics are the metrics about the executions of the jobs.
+type executionMetrics struct {
+	started     *prometheus.CounterVec
+	succeeded   *prometheus.CounterVec
+	failed      *prometheus.CounterVec
+	skipped     *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	inFlight    prometheus.Gauge
+	lastSuccess *prometheus.GaugeVec
+	lastFailure *prometheus.GaugeVec
+
+	withGroupLabels bool
+	maxJobs         int
+	maxGroups       int
+	// jobs keeps the label values of the jobs
+	// tracked by lastSuccess and lastFailure.
+	jobs map[int64][]string
+	// groups keeps the pairs of group labels in use.
+	groups map[[2]int64]bool
+	lock   sync.Mutex
+}
+
+// newExecutionMetrics returns a new set of metrics
+// about the executions, configured by `config`.
+func newExecutionMetrics(config MetricsConfig) *executionMetrics {
+	var groupLabels []string
+	if config.WithGroupLabels {
+		groupLabels = []string{"group_id", "super_group_id"}
+	}
+	jobLabels := append([]string{"job_id"}, groupLabels...)
+	buckets := config.DurationBuckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	m := &executionMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "started_total",
+			Help:      "Number of executions of jobs started",
+		}, groupLabels),
+		succeeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "succeeded_total",
+			Help:      "Number of executions of jobs succeeded",
+		}, groupLabels),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "failed_total",
+			Help:      "Number of executions of jobs failed",
+		}, groupLabels),
+		skipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "skipped_total",
+			Help:      "Number of executions of jobs skipped within chains and workflows",
+		}, groupLabels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "duration_seconds",
+			Help:      "Duration of the executions of jobs",
+			Buckets:   buckets,
+		}, append([]string{"outcome"}, groupLabels...)),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "in_flight",
+			Help:      "Number of executions of jobs in progress",
+		}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "last_success_timestamp_seconds",
+			Help:      "Unix time of the last successful execution of a job",
+		}, jobLabels),
+		lastFailure: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "smallben",
+			Subsystem: "executions",
+			Name:      "last_failure_timestamp_seconds",
+			Help:      "Unix time of the last failed execution of a job",
+		}, jobLabels),
+		withGroupLabels: config.WithGroupLabels,
+		maxJobs:         config.MaxJobs,
+		maxGroups:       config.MaxGroups,
+		jobs:            make(map[int64][]string),
+		groups:          make(map[[2]int64]bool),
+	}
+	if m.maxJobs <= 0 {
+		m.maxJobs = DefaultMetricsMaxJobs
+	}
+	if m.maxGroups <= 0 {
+		m.maxGroups = DefaultMetricsMaxGroups
+	}
+	return m
+}
+
+// groupLabels returns the values of the group labels of `job`,
+// nil if they are disabled. It must be called with the lock held.
+func (m *executionMetrics) groupLabels(job *RawJob) []string {
+	if !m.withGroupLabels {
+		return nil
+	}
+	key := [2]int64{job.GroupID, job.SuperGroupID}
+	if !m.groups[key] {
+		if len(m.groups) >= m.maxGroups {
+			return []string{MetricsOtherGroup, MetricsOtherGroup}
+		}
+		m.groups[key] = true
+	}
+	return []string{strconv.FormatInt(job.GroupID, 10), strconv.FormatInt(job.SuperGroupID, 10)}
+}
+
+// jobLabels returns the values of the labels of `job` in the
+// per-job metrics, and false if the job cannot be tracked
+// since there are already too many jobs.
+// It must be called with the lock held.
+func (m *executionMetrics) jobLabels(job *RawJob) ([]string, bool) {
+	if labels, ok := m.jobs[job.ID]; ok {
+		return labels, true
+	}
+	if len(m.jobs) >= m.maxJobs {
+		return nil, false
+	}
+	labels := append([]string{strconv.FormatInt(job.ID, 10)}, m.groupLabels(job)...)
+	m.jobs[job.ID] = labels
+	return labels, true
+}
+
+// startRun updates the metrics when an execution of `job` starts.
+func (m *executionMetrics) startRun(job *RawJob) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.started.WithLabelValues(m.groupLabels(job)...).Inc()
+	m.inFlight.Inc()
+}
+
+// endRun updates the metrics when an execution of `job`,
+// lasted `duration`, ends with `err`.
+func (m *executionMetrics) endRun(job *RawJob, duration time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.inFlight.Dec()
+
+	groupLabels := m.groupLabels(job)
+	counter, last, outcome := m.succeeded, m.lastSuccess, JobOutcomeSucceeded
+	if err != nil {
+		counter, last, outcome = m.failed, m.lastFailure, JobOutcomeFailed
+	}
+	counter.WithLabelValues(groupLabels...).Inc()
+	m.duration.WithLabelValues(append([]string{string(outcome)}, groupLabels...)...).Observe(duration.Seconds())
+	if labels, ok := m.jobLabels(job); ok {
+		last.WithLabelValues(labels...).Set(float64(time.Now().UnixNano()) / float64(time.Second))
+	}
+}
+
+// skipRun updates the metrics when an execution of `job` is skipped.
+func (m *executionMetrics) skipRun(job *RawJob) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.skipped.WithLabelValues(m.groupLabels(job)...).Inc()
+}
+
+// deleteJobs stops tracking the jobs whose ids are `ids`.
+func (m *executionMetrics) deleteJobs(ids []int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, id := range ids {
+		if labels, ok := m.jobs[id]; ok {
+			m.lastSuccess.DeleteLabelValues(labels...)
+			m.lastFailure.DeleteLabelValues(labels...)
+			delete(m.jobs, id)
+		}
+	}
+}
+
+// registerTo registers the metrics to `register`,
+// stopping at the first error.
+func (m *executionMetrics) registerTo(register prometheus.Registerer) error {
+	collectors := []prometheus.Collector{
+		m.started, m.succeeded, m.failed, m.skipped,
+		m.duration, m.inFlight, m.lastSuccess, m.lastFailure,
+	}
+	for _, collector := range collectors {
+		if err := register.Register(collector); err != nil {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,132 @@
+package smallben
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+func checkMetricValue(collector prometheus.Collector, expected float64, t *testing.T) {
+	if got := testutil.ToFloat64(collector); got != expected {
+		t.Errorf("Wrong metric value. Got: %f, expected: %f\n", got, expected)
+	}
+}
+
+func checkMetricCount(collector prometheus.Collector, expected int, t *testing.T) {
+	if got := testutil.CollectAndCount(collector); got != expected {
+		t.Errorf("Wrong number of series. Got: %d, expected: %d\n", got, expected)
+	}
+}
+
+func TestExecutionMetrics(t *testing.T) {
+	m := newExecutionMetrics(MetricsConfig{})
+	job := RawJob{ID: 1, GroupID: 2, SuperGroupID: 3}
+
+	m.startRun(&job)
+	checkMetricValue(m.inFlight, 1, t)
+	m.endRun(&job, time.Second, nil)
+	m.startRun(&job)
+	m.endRun(&job, time.Second, errors.New("failing on purpose"))
+	m.skipRun(&job)
+
+	checkMetricValue(m.inFlight, 0, t)
+	checkMetricValue(m.started, 2, t)
+	checkMetricValue(m.succeeded, 1, t)
+	checkMetricValue(m.failed, 1, t)
+	checkMetricValue(m.skipped, 1, t)
+	// one series per outcome
+	checkMetricCount(m.duration, 2, t)
+
+	before := float64(time.Now().Add(-time.Minute).Unix())
+	if last := testutil.ToFloat64(m.lastSuccess.WithLabelValues("1")); last < before {
+		t.Errorf("Wrong last success. Got: %f\n", last)
+	}
+	if err := m.registerTo(prometheus.NewRegistry()); err != nil {
+		t.Errorf("Fail to register metrics: %s\n", err.Error())
+	}
+
+	// deleted jobs are not tracked anymore
+	m.deleteJobs([]int64{job.ID})
+	checkMetricCount(m.lastSuccess, 0, t)
+	checkMetricCount(m.lastFailure, 0, t)
+}
+
+func TestExecutionMetricsLimits(t *testing.T) {
+	m := newExecutionMetrics(MetricsConfig{WithGroupLabels: true, MaxJobs: 2, MaxGroups: 1})
+
+	for _, job := range []RawJob{
+		{ID: 1, GroupID: 1, SuperGroupID: 1},
+		{ID: 2, GroupID: 2, SuperGroupID: 1},
+		{ID: 3, GroupID: 2, SuperGroupID: 1},
+	} {
+		m.startRun(&job)
+		m.endRun(&job, time.Second, nil)
+	}
+
+	// only the first two jobs are tracked
+	checkMetricCount(m.lastSuccess, 2, t)
+	checkMetricValue(m.succeeded.WithLabelValues("1", "1"), 1, t)
+	// the second group exceeds the limit
+	checkMetricValue(m.succeeded.WithLabelValues(MetricsOtherGroup, MetricsOtherGroup), 2, t)
+
+	// deleting a job frees its slot
+	m.deleteJobs([]int64{1})
+	job := RawJob{ID: 3, GroupID: 1, SuperGroupID: 1}
+	m.startRun(&job)
+	m.endRun(&job, time.Second, nil)
+	checkMetricCount(m.lastSuccess, 2, t)
+	checkMetricValue(m.inFlight, 0, t)
+}
+
+func (s *SmallBenTestSuite) TestMetrics(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// the gauges drift, and get resynced.
+	s.smallBen.metrics.total.Set(1000)
+	s.smallBen.metrics.paused.Set(1000)
+	if err = s.smallBen.fillMetrics(); err != nil {
+		t.Errorf("Fail to fill metrics: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkMetricValue(s.smallBen.metrics.total, float64(len(s.jobs)), t)
+	checkMetricValue(s.smallBen.metrics.paused, 1, t)
+	checkMetricValue(s.smallBen.metrics.notPaused, float64(len(s.jobs)-1), t)
+
+	// deleting the paused job
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{s.jobs[0].ID}}})
+	if err != nil {
+		t.Errorf("Fail to delete jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkMetricValue(s.smallBen.metrics.total, float64(len(s.jobs)-1), t)
+	checkMetricValue(s.smallBen.metrics.paused, 0, t)
+	checkMetricValue(s.smallBen.metrics.notPaused, float64(len(s.jobs)-1), t)
+	// so that the teardown deletes the others
+	s.jobs = s.jobs[1:]
+}
+
+func TestSmallBenMetrics(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestMetrics(t)
+		test.teardown(false, t)
+	}
+}
@@ -0,0 +1,671 @@
+package smallben
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the version of the database schema
+// required by this version of the library.
+const SchemaVersion = 4
+
+var (
+	// ErrSchemaTooNew is returned when the database schema has been migrated
+	// by a newer version of the library, that this version does not support.
+	ErrSchemaTooNew = errors.New("database schema is newer than the supported one")
+	// ErrUnsupportedDialect is returned when migrating a database
+	// that is neither Postgres, nor SQLite, nor MySQL.
+	ErrUnsupportedDialect = errors.New("unsupported database dialect")
+	// ErrMigrationLocked is returned when MySQL does not acquire in time the
+	// lock serializing the migrations, being held by another instance.
+	ErrMigrationLocked = errors.New("cannot acquire the migration lock")
+)
+
+// The names of the supported dialects,
+// the same as the names of the GORM dialectors.
+// RepositorySQL exports them as SQLDialectPostgres,
+// SQLDialectSQLite and SQLDialectMySQL.
+const (
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+	dialectMySQL    = "mysql"
+)
+
+// migration is a versioned change of the schema,
+// made of statements specific to each dialect.
+type migration struct {
+	version     int
+	description string
+	statements  map[string][]string
+	// guarded are the statements, executed after the other ones,
+	// that cannot be written as re-runnable in their dialect.
+	guarded map[string][]guardedStatement
+}
+
+// guardedStatement is a statement executed only if `unless`,
+// a query returning a count, returns 0, or always if it is empty.
+type guardedStatement struct {
+	statement string
+	unless    string
+}
+
+// createSchemaVersion creates the schema_version table.
+var createSchemaVersion = map[string]string{
+	dialectPostgres: `create table if not exists schema_version (
+    version integer primary key,
+    description text not null,
+    applied_at timestamp with time zone not null
+)`,
+	dialectSQLite: `create table if not exists schema_version (
+    version integer primary key,
+    description text not null,
+    applied_at datetime not null
+)`,
+	dialectMySQL: `create table if not exists schema_version (
+    version integer primary key,
+    description text not null,
+    applied_at datetime(6) not null
+)`,
+}
+
+// migrations are the migrations to apply, sorted by version.
+// The statements must be idempotent, since the first migration
+// is also applied to the databases created by the scripts in the scripts directory,
+// and since those of MySQL are not rolled back when a migration fails.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create the jobs, job_labels, workflow_runs, workflow_run_jobs and webhook_deliveries tables",
+		statements: map[string][]string{
+			dialectPostgres: {
+				`create table if not exists jobs (
+    id bigint primary key,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    name varchar(256) not null default '',
+    description text not null default '',
+    owner varchar(256) not null default '',
+    paused boolean not null default false,
+    cron_expression varchar(256) not null,
+    cron_id bigint not null default 0,
+    serialized_job text not null,
+    serialized_job_input text not null,
+    upstream_ids text not null default '',
+    on_success text not null default '',
+    on_failure text not null default '',
+    created_at timestamp with time zone not null default current_timestamp,
+    updated_at timestamp with time zone not null default current_timestamp,
+    next_run_at timestamp with time zone,
+    last_run_at timestamp with time zone,
+    last_outcome varchar(16) not null default ''
+)`,
+				`create index if not exists paused_idx on jobs(paused)`,
+				`create index if not exists group_idx on jobs(group_id)`,
+				`create index if not exists super_group_idx on jobs(super_group_id)`,
+				`create unique index if not exists super_group_name_idx on jobs(super_group_id, name) where name <> ''`,
+				`create index if not exists created_at_idx on jobs(created_at, id)`,
+				`create index if not exists updated_at_idx on jobs(updated_at, id)`,
+				`create index if not exists next_run_at_idx on jobs(next_run_at, id)`,
+				`create index if not exists last_outcome_idx on jobs(last_outcome)`,
+				`create table if not exists job_labels (
+    job_id bigint not null references jobs(id) on delete cascade,
+    label_key varchar(256) not null,
+    label_value varchar(256) not null default '',
+    primary key (job_id, label_key)
+)`,
+				`create index if not exists job_labels_idx on job_labels(label_key, label_value)`,
+				`create table if not exists workflow_runs (
+    id bigserial primary key,
+    root_job_id bigint not null,
+    scheduled_at timestamp with time zone not null,
+    status varchar(32) not null,
+    created_at timestamp with time zone not null default current_timestamp,
+    updated_at timestamp with time zone not null default current_timestamp
+)`,
+				`create index if not exists workflow_runs_root_job_idx on workflow_runs(root_job_id)`,
+				`create table if not exists workflow_run_jobs (
+    run_id bigint not null references workflow_runs(id) on delete cascade,
+    job_id bigint not null,
+    status varchar(32) not null,
+    started_at timestamp with time zone not null,
+    finished_at timestamp with time zone not null,
+    error_message text not null default '',
+    primary key (run_id, job_id)
+)`,
+				`create table if not exists webhook_deliveries (
+    id bigserial primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    super_group_id bigint not null,
+    url text not null,
+    attempts integer not null,
+    status_code integer not null,
+    delivered boolean not null,
+    error_message text not null default '',
+    created_at timestamp with time zone not null default current_timestamp
+)`,
+				`create index if not exists webhook_deliveries_job_idx on webhook_deliveries(job_id)`,
+			},
+			dialectSQLite: {
+				`create table if not exists jobs (
+    id bigint primary key,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    name varchar(256) not null default '',
+    description text not null default '',
+    owner varchar(256) not null default '',
+    paused boolean not null default false,
+    cron_expression varchar(256) not null,
+    cron_id bigint not null default 0,
+    serialized_job text not null,
+    serialized_job_input text not null,
+    upstream_ids text not null default '',
+    on_success text not null default '',
+    on_failure text not null default '',
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp,
+    next_run_at datetime,
+    last_run_at datetime,
+    last_outcome varchar(16) not null default ''
+)`,
+				`create index if not exists paused_idx on jobs(paused)`,
+				`create index if not exists group_idx on jobs(group_id)`,
+				`create index if not exists super_group_idx on jobs(super_group_id)`,
+				`create unique index if not exists super_group_name_idx on jobs(super_group_id, name) where name <> ''`,
+				`create index if not exists created_at_idx on jobs(created_at, id)`,
+				`create index if not exists updated_at_idx on jobs(updated_at, id)`,
+				`create index if not exists next_run_at_idx on jobs(next_run_at, id)`,
+				`create index if not exists last_outcome_idx on jobs(last_outcome)`,
+				`create table if not exists job_labels (
+    job_id bigint not null references jobs(id) on delete cascade,
+    label_key varchar(256) not null,
+    label_value varchar(256) not null default '',
+    primary key (job_id, label_key)
+)`,
+				`create index if not exists job_labels_idx on job_labels(label_key, label_value)`,
+				`create table if not exists workflow_runs (
+    id integer primary key autoincrement,
+    root_job_id bigint not null,
+    scheduled_at datetime not null,
+    status varchar(32) not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+)`,
+				`create index if not exists workflow_runs_root_job_idx on workflow_runs(root_job_id)`,
+				`create table if not exists workflow_run_jobs (
+    run_id bigint not null references workflow_runs(id) on delete cascade,
+    job_id bigint not null,
+    status varchar(32) not null,
+    started_at datetime not null,
+    finished_at datetime not null,
+    error_message text not null default '',
+    primary key (run_id, job_id)
+)`,
+				`create table if not exists webhook_deliveries (
+    id integer primary key autoincrement,
+    type varchar(32) not null,
+    job_id bigint not null,
+    super_group_id bigint not null,
+    url text not null,
+    attempts integer not null,
+    status_code integer not null,
+    delivered boolean not null,
+    error_message text not null default '',
+    created_at datetime not null default current_timestamp
+)`,
+				`create index if not exists webhook_deliveries_job_idx on webhook_deliveries(job_id)`,
+			},
+			// MySQL supports neither `create index if not exists`, nor partial indexes,
+			// nor defaults on text columns: the indexes are declared within the tables,
+			// and the uniqueness of the names is only checked by SmallBen.
+			dialectMySQL: {
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists jobs (
+    id bigint primary key,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    name varchar(256) not null default '',
+    description text not null,
+    owner varchar(256) not null default '',
+    paused boolean not null default false,
+    cron_expression varchar(256) not null,
+    cron_id bigint not null default 0,
+    serialized_job longtext not null,
+    serialized_job_input longtext not null,
+    upstream_ids text not null,
+    on_success text not null,
+    on_failure text not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    updated_at datetime(6) not null default current_timestamp(6),
+    next_run_at datetime(6) null,
+    last_run_at datetime(6) null,
+    last_outcome varchar(16) not null default '',
+    index paused_idx (paused),
+    index group_idx (group_id),
+    index super_group_name_idx (super_group_id, name),
+    index created_at_idx (created_at, id),
+    index updated_at_idx (updated_at, id),
+    index next_run_at_idx (next_run_at, id),
+    index last_outcome_idx (last_outcome)
+) engine = InnoDB`,
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists job_labels (
+    job_id bigint not null,
+    label_key varchar(191) not null,
+    label_value varchar(191) not null default '',
+    primary key (job_id, label_key),
+    index job_labels_idx (label_key, label_value),
+    foreign key (job_id) references jobs(id) on delete cascade
+) engine = InnoDB`,
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists workflow_runs (
+    id bigint auto_increment primary key,
+    root_job_id bigint not null,
+    scheduled_at datetime(6) not null,
+    status varchar(32) not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    updated_at datetime(6) not null default current_timestamp(6),
+    index workflow_runs_root_job_idx (root_job_id)
+) engine = InnoDB`,
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists workflow_run_jobs (
+    run_id bigint not null,
+    job_id bigint not null,
+    status varchar(32) not null,
+    started_at datetime(6) not null,
+    finished_at datetime(6) not null,
+    error_message text not null,
+    primary key (run_id, job_id),
+    foreign key (run_id) references workflow_runs(id) on delete cascade
+) engine = InnoDB`,
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists webhook_deliveries (
+    id bigint auto_increment primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    super_group_id bigint not null,
+    url text not null,
+    attempts integer not null,
+    status_code integer not null,
+    delivered boolean not null,
+    error_message text not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    index webhook_deliveries_job_idx (job_id)
+) engine = InnoDB`,
+			},
+		},
+	},
+	{
+		version:     2,
+		description: "create the outbox_entries table",
+		statements: map[string][]string{
+			dialectPostgres: {
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists outbox_entries (
+    id bigint primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    job_name varchar(256) not null default '',
+    created_at timestamp with time zone not null
+)`,
+			},
+			dialectSQLite: {
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists outbox_entries (
+    id bigint primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    job_name varchar(256) not null default '',
+    created_at datetime not null
+)`,
+			},
+			dialectMySQL: {
+				// re-runnable: the table is created only if it does not exist.
+				`create table if not exists outbox_entries (
+    id bigint primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    job_name varchar(256) not null default '',
+    created_at datetime(6) not null
+) engine = InnoDB`,
+			},
+		},
+	},
+	{
+		version:     3,
+		description: "widen the labels of MySQL to 256 characters, as the other dialects",
+		statements: map[string][]string{
+			dialectMySQL: {
+				// re-runnable: modifying a column to the type it already has is a no-op.
+				`alter table job_labels modify label_key varchar(256) not null, ` +
+					`modify label_value varchar(256) not null default ''`,
+			},
+		},
+	},
+	{
+		version:     4,
+		description: "scope the entries of the outbox by the node of the instance that made the change",
+		statements: map[string][]string{
+			dialectPostgres: {
+				`alter table outbox_entries add column if not exists node bigint not null default 0`,
+				`create index if not exists outbox_entries_node_idx on outbox_entries(node, created_at, id)`,
+			},
+		},
+		guarded: map[string][]guardedStatement{
+			dialectSQLite: {
+				sqliteAddColumn("outbox_entries", "node", "bigint not null default 0"),
+				{statement: `create index if not exists outbox_entries_node_idx on outbox_entries(node, created_at, id)`},
+			},
+			// each statement is committed on its own, and skipped
+			// when migrating again after a failure, if already applied.
+			dialectMySQL: {
+				mysqlAddColumn("outbox_entries", "node", "bigint not null default 0"),
+				mysqlAddIndex("outbox_entries", "outbox_entries_node_idx", "node, created_at, id"),
+			},
+		},
+	},
+}
+
+// legacyMigration upgrades the jobs table created, before the migrations were
+// introduced, by the scripts in the scripts directory, adding the columns of the
+// later versions. It is recorded as version 0, and applied only if no migration has
+// been applied yet and the jobs table already exists. Every statement is re-runnable:
+// Postgres adds the columns if they do not exist, while SQLite and MySQL skip the
+// statements whose column, or index, exists already. The indexes on the new columns
+// are created by the first migration, which follows, except for MySQL, which declares
+// them within the jobs table, not created again.
+var legacyMigration = migration{
+	version:     0,
+	description: "upgrade the jobs table created by the scripts of the versions without migrations",
+	statements: map[string][]string{
+		dialectPostgres: {
+			`alter table jobs add column if not exists name varchar(256) not null default ''`,
+			`alter table jobs add column if not exists description text not null default ''`,
+			`alter table jobs add column if not exists owner varchar(256) not null default ''`,
+			`alter table jobs add column if not exists upstream_ids text not null default ''`,
+			`alter table jobs add column if not exists on_success text not null default ''`,
+			`alter table jobs add column if not exists on_failure text not null default ''`,
+			`alter table jobs add column if not exists next_run_at timestamp with time zone`,
+			`alter table jobs add column if not exists last_run_at timestamp with time zone`,
+			`alter table jobs add column if not exists last_outcome varchar(16) not null default ''`,
+			// the scripts declared them as integers.
+			`alter table jobs alter column super_group_id type bigint, alter column cron_id type bigint`,
+		},
+	},
+	guarded: map[string][]guardedStatement{
+		dialectSQLite: {
+			sqliteAddColumn("jobs", "name", "varchar(256) not null default ''"),
+			sqliteAddColumn("jobs", "description", "text not null default ''"),
+			sqliteAddColumn("jobs", "owner", "varchar(256) not null default ''"),
+			sqliteAddColumn("jobs", "upstream_ids", "text not null default ''"),
+			sqliteAddColumn("jobs", "on_success", "text not null default ''"),
+			sqliteAddColumn("jobs", "on_failure", "text not null default ''"),
+			sqliteAddColumn("jobs", "next_run_at", "datetime"),
+			sqliteAddColumn("jobs", "last_run_at", "datetime"),
+			sqliteAddColumn("jobs", "last_outcome", "varchar(16) not null default ''"),
+		},
+		// each statement is committed on its own, and skipped
+		// when migrating again after a failure, if already applied.
+		dialectMySQL: {
+			mysqlAddColumn("jobs", "name", "varchar(256) not null default ''"),
+			mysqlAddColumn("jobs", "description", "text not null"),
+			mysqlAddColumn("jobs", "owner", "varchar(256) not null default ''"),
+			mysqlAddColumn("jobs", "upstream_ids", "text not null"),
+			mysqlAddColumn("jobs", "on_success", "text not null"),
+			mysqlAddColumn("jobs", "on_failure", "text not null"),
+			mysqlAddColumn("jobs", "next_run_at", "datetime(6) null"),
+			mysqlAddColumn("jobs", "last_run_at", "datetime(6) null"),
+			mysqlAddColumn("jobs", "last_outcome", "varchar(16) not null default ''"),
+			mysqlAddIndex("jobs", "paused_idx", "paused"),
+			mysqlAddIndex("jobs", "group_idx", "group_id"),
+			mysqlAddIndex("jobs", "super_group_name_idx", "super_group_id, name"),
+			mysqlAddIndex("jobs", "created_at_idx", "created_at, id"),
+			mysqlAddIndex("jobs", "updated_at_idx", "updated_at, id"),
+			mysqlAddIndex("jobs", "next_run_at_idx", "next_run_at, id"),
+			mysqlAddIndex("jobs", "last_outcome_idx", "last_outcome"),
+		},
+	},
+}
+
+// sqliteAddColumn returns the statement adding `column`,
+// defined as `definition`, to `table` of SQLite.
+func sqliteAddColumn(table, column, definition string) guardedStatement {
+	return guardedStatement{
+		statement: fmt.Sprintf("alter table %s add column %s %s", table, column, definition),
+		unless:    fmt.Sprintf("select count(*) from pragma_table_info('%s') where name = '%s'", table, column),
+	}
+}
+
+// mysqlAddColumn returns the statement adding `column`,
+// defined as `definition`, to `table` of MySQL.
+func mysqlAddColumn(table, column, definition string) guardedStatement {
+	return guardedStatement{
+		statement: fmt.Sprintf("alter table %s add column %s %s", table, column, definition),
+		unless: fmt.Sprintf("select count(*) from information_schema.columns "+
+			"where table_schema = database() and table_name = '%s' and column_name = '%s'", table, column),
+	}
+}
+
+// mysqlAddIndex returns the statement adding the
+// index `name` on `columns` to `table` of MySQL.
+func mysqlAddIndex(table, name, columns string) guardedStatement {
+	return guardedStatement{
+		statement: fmt.Sprintf("alter table %s add index %s (%s)", table, name, columns),
+		unless: fmt.Sprintf("select count(*) from information_schema.statistics "+
+			"where table_schema = database() and table_name = '%s' and index_name = '%s'", table, name),
+	}
+}
+
+// hasSchemaVersion returns, for each dialect, the query
+// checking whether the schema_version table exists.
+var hasSchemaVersion = map[string]string{
+	dialectPostgres: `select count(*) from information_schema.tables where table_schema = current_schema() and table_name = 'schema_version'`,
+	dialectSQLite:   `select count(*) from sqlite_master where type = 'table' and name = 'schema_version'`,
+	dialectMySQL:    `select count(*) from information_schema.tables where table_schema = database() and table_name = 'schema_version'`,
+}
+
+// hasJobs returns, for each dialect, the query
+// checking whether the jobs table exists.
+var hasJobs = map[string]string{
+	dialectPostgres: `select count(*) from information_schema.tables where table_schema = current_schema() and table_name = 'jobs'`,
+	dialectSQLite:   `select count(*) from sqlite_master where type = 'table' and name = 'jobs'`,
+	dialectMySQL:    `select count(*) from information_schema.tables where table_schema = database() and table_name = 'jobs'`,
+}
+
+// The locks serializing the migrations of the instances starting together:
+// the key of the advisory lock of Postgres, and the name of the lock of MySQL.
+const (
+	migrationLockKey  = 0x736d616c6c62656e // "smallben"
+	migrationLockName = "smallben_migrations"
+	// migrationLockTimeout is how long MySQL
+	// waits for the lock, in seconds.
+	migrationLockTimeout = 300
+)
+
+// migrationConn is what the migrations are applied on,
+// i.e., a *sql.Conn, a *sql.Tx or a *sql.DB.
+type migrationConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// lockMigrations takes the lock serializing the migrations on `conn`, whose dialect
+// is `dialect`, returning the function releasing it. The migrations are applied on
+// `conn` too, so that they never wait for another connection of the pool, which may
+// be limited to one, as is common for SQLite.
+// SQLite locks the whole database when writing, so that each migration, checking
+// within its transaction whether it has been applied already, is applied once.
+func lockMigrations(ctx context.Context, conn *sql.Conn, dialect string) (func(), error) {
+	var lock, unlock string
+	switch dialect {
+	case dialectPostgres:
+		lock = fmt.Sprintf("select pg_advisory_lock(%d)", migrationLockKey)
+		unlock = fmt.Sprintf("select pg_advisory_unlock(%d)", migrationLockKey)
+	case dialectMySQL:
+		lock = fmt.Sprintf("select get_lock('%s', %d)", migrationLockName, migrationLockTimeout)
+		unlock = fmt.Sprintf("select release_lock('%s')", migrationLockName)
+	default:
+		return func() {}, nil
+	}
+	var err error
+	if dialect == dialectPostgres {
+		// pg_advisory_lock waits for the lock, returning void.
+		_, err = conn.ExecContext(ctx, lock)
+	} else {
+		// get_lock returns 1 if the lock has been acquired.
+		var acquired sql.NullInt64
+		err = conn.QueryRowContext(ctx, lock).Scan(&acquired)
+		if err == nil && acquired.Int64 != 1 {
+			err = ErrMigrationLocked
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_, _ = conn.ExecContext(ctx, unlock)
+	}, nil
+}
+
+// migrate applies the migrations that have not been applied yet to `db`,
+// whose dialect is `dialect`, on a dedicated connection.
+func migrate(db *sql.DB, dialect string) error {
+	create, ok := createSchemaVersion[dialect]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedDialect, dialect)
+	}
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	unlock, err := lockMigrations(ctx, conn, dialect)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err = conn.ExecContext(ctx, create); err != nil {
+		return err
+	}
+	current, err := schemaVersion(conn, dialect)
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: %d, supported: %d", ErrSchemaTooNew, current, SchemaVersion)
+	}
+	if current == 0 {
+		var legacy int
+		if err = conn.QueryRowContext(ctx, hasJobs[dialect]).Scan(&legacy); err != nil {
+			return err
+		}
+		if legacy > 0 {
+			if err = applyMigration(ctx, conn, dialect, &legacyMigration); err != nil {
+				return fmt.Errorf("migration %d: %w", legacyMigration.version, err)
+			}
+		}
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err = applyMigration(ctx, conn, dialect, &m); err != nil {
+			return fmt.Errorf("migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies `m` on `conn` in a single transaction,
+// unless it has been applied already, e.g., by another instance.
+//
+// MySQL commits the DDL statements implicitly, so its statements are executed
+// one at a time, outside of any transaction, while holding the lock of the
+// migrations: since a failed migration is not rolled back, they must be
+// re-runnable, so that migrating again completes it.
+func applyMigration(ctx context.Context, conn *sql.Conn, dialect string, m *migration) error {
+	if dialect == dialectMySQL {
+		return runMigration(ctx, conn, dialect, m)
+	}
+	return sqlTransaction(conn, nil, func(tx *sql.Tx) error {
+		return runMigration(ctx, tx, dialect, m)
+	})
+}
+
+// runMigration applies `m` on `conn`, unless it has been applied already.
+func runMigration(ctx context.Context, conn migrationConn, dialect string, m *migration) error {
+	var applied int
+	if err := conn.QueryRowContext(ctx, rebind(dialect, "select count(*) from schema_version where version = ?"), m.version).Scan(&applied); err != nil {
+		return err
+	}
+	if applied > 0 {
+		return nil
+	}
+	for _, statement := range m.statements[dialect] {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	for _, guarded := range m.guarded[dialect] {
+		if guarded.unless != "" {
+			var count int
+			if err := conn.QueryRowContext(ctx, guarded.unless).Scan(&count); err != nil {
+				return err
+			}
+			if count > 0 {
+				continue
+			}
+		}
+		if _, err := conn.ExecContext(ctx, guarded.statement); err != nil {
+			return err
+		}
+	}
+	_, err := conn.ExecContext(ctx, rebind(dialect, "insert into schema_version (version, description, applied_at) values (?, ?, ?)"),
+		m.version, m.description, time.Now())
+	return err
+}
+
+// schemaVersion returns the version of the schema of `db`,
+// whose dialect is `dialect`, 0 if no migration has been applied yet.
+func schemaVersion(db migrationConn, dialect string) (int, error) {
+	has, ok := hasSchemaVersion[dialect]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnsupportedDialect, dialect)
+	}
+	ctx := context.Background()
+	var count int
+	if err := db.QueryRowContext(ctx, has).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "select max(version) from schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// checkSchemaVersion returns ErrSchemaTooNew if the
+// schema of `db` is newer than SchemaVersion.
+func checkSchemaVersion(db *sql.DB, dialect string) error {
+	current, err := schemaVersion(db, dialect)
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: %d, supported: %d", ErrSchemaTooNew, current, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package smallben
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// legacyJobsSQLite is the jobs table created, before the migrations were
+// introduced, by scripts/postgres_init.sql, in the dialect of SQLite.
+var legacyJobsSQLite = []string{
+	`create table if not exists jobs (
+    id bigint primary key,
+    group_id bigint not null,
+    super_group_id integer not null,
+    paused boolean not null default false,
+    cron_expression varchar(256) not null,
+    cron_id integer not null default 0,
+    serialized_job text not null,
+    serialized_job_input text not null,
+    created_at datetime not null default current_timestamp,
+    updated_at datetime not null default current_timestamp
+)`,
+	`create index if not exists paused_idx on jobs(paused)`,
+	`create index if not exists group_idx on jobs(group_id)`,
+	`create index if not exists super_group_idx on jobs(super_group_id)`,
+}
+
+// openTestSQLite opens a new SQLite database, removed when the test ends.
+func openTestSQLite(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(newTestDir(t), "jobs.db")
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate")
+	if err != nil {
+		t.Fatalf("Cannot open the database: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	return db, path
+}
+
+// TestMigrateLegacy tests that a jobs table created before
+// the migrations were introduced is upgraded, keeping its jobs.
+func TestMigrateLegacy(t *testing.T) {
+	db, _ := openTestSQLite(t)
+	for _, statement := range legacyJobsSQLite {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Cannot create the legacy table: %s", err.Error())
+		}
+	}
+	_, err := db.Exec(`insert into jobs (id, group_id, super_group_id, cron_expression, serialized_job, serialized_job_input)
+values (1, 1, 1, '@every 1s', '', '')`)
+	if err != nil {
+		t.Fatalf("Cannot insert the legacy job: %s", err.Error())
+	}
+
+	repository, err := NewRepositorySQL(&RepositorySQLConfig{DB: db, Dialect: SQLDialectSQLite})
+	if err != nil {
+		t.Fatalf("Cannot migrate the legacy table: %s", err.Error())
+	}
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		t.Fatalf("Cannot get the schema version: %s", err.Error())
+	}
+	if version != SchemaVersion {
+		t.Errorf("The schema version is wrong. Got %d, expected: %d", version, SchemaVersion)
+	}
+	var applied int
+	if err = db.QueryRow("select count(*) from schema_version where version = 0").Scan(&applied); err != nil {
+		t.Fatalf("Cannot get the legacy migration: %s", err.Error())
+	}
+	if applied != 1 {
+		t.Errorf("The legacy migration has not been recorded")
+	}
+
+	jobs := []JobWithSchedule{{rawJob: RawJob{ID: 2, GroupID: 1, SuperGroupID: 1, Name: "second", CronExpression: "@every 1s"},
+		run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 2}}}
+	if err = repository.AddJobs(jobs); err != nil {
+		t.Fatalf("Cannot add a job: %s", err.Error())
+	}
+	rawJobs, err := repository.ListJobs(nil)
+	if err != nil {
+		t.Fatalf("Cannot list the jobs: %s", err.Error())
+	}
+	if !equalIDs(getIdsFromJobRawList(rawJobs), []int64{1, 2}) {
+		t.Errorf("Wrong jobs listed: %v", getIdsFromJobRawList(rawJobs))
+	}
+	if err = repository.Migrate(); err != nil {
+		t.Errorf("Cannot migrate again: %s", err.Error())
+	}
+}
+
+// TestMigrateConcurrent tests that the instances migrating
+// the same database together apply each migration once.
+func TestMigrateConcurrent(t *testing.T) {
+	_, path := openTestSQLite(t)
+	const instances = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate")
+		if err != nil {
+			t.Fatalf("Cannot open the database: %s", err.Error())
+		}
+		defer db.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- migrate(db, dialectSQLite)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Cannot migrate: %s", err.Error())
+		}
+	}
+
+	db, _ := sql.Open("sqlite3", "file:"+path)
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("select count(*) from schema_version").Scan(&count); err != nil {
+		t.Fatalf("Cannot count the migrations: %s", err.Error())
+	}
+	if count != len(migrations) {
+		t.Errorf("Wrong number of migrations applied. Got %d, expected: %d", count, len(migrations))
+	}
+}
+
+// TestMigrateSingleConnection tests that the migrations do not wait
+// for another connection than the one holding their lock.
+func TestMigrateSingleConnection(t *testing.T) {
+	_, path := openTestSQLite(t)
+	databases := []struct {
+		driver, dialect, dsn string
+	}{
+		{driver: "sqlite3", dialect: dialectSQLite, dsn: "file:" + path + "?_busy_timeout=5000&_journal_mode=WAL"},
+	}
+	if pgConn != "" {
+		databases = append(databases, struct{ driver, dialect, dsn string }{"pgx", dialectPostgres, pgConn})
+	}
+	if mysqlConn != "" {
+		databases = append(databases, struct{ driver, dialect, dsn string }{"mysql", dialectMySQL, mysqlDSN(t)})
+	}
+	for _, database := range databases {
+		db, err := sql.Open(database.driver, database.dsn)
+		if err != nil {
+			t.Fatalf("Cannot open the database: %s", err.Error())
+		}
+		db.SetMaxOpenConns(1)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- migrate(db, database.dialect)
+		}()
+		select {
+		case err = <-errs:
+			if err != nil {
+				t.Errorf("Cannot migrate %s: %s", database.dialect, err.Error())
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("The migrations of %s are blocked", database.dialect)
+		}
+		_ = db.Close()
+	}
+}
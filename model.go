@@ -2,10 +2,12 @@ package smallben
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/robfig/cron/v3"
 	"time"
 )
@@ -13,13 +15,21 @@ import (
 // Job is the struct used to interact with SmallBen.
 type Job struct {
 	// ID is a unique ID identifying the rawJob object.
-	// It is chosen by the user.
+	// It is chosen by the user or, if zero, it is generated
+	// when the job is added.
 	ID int64
 	// GroupID is the ID of the group this rawJob is inserted in.
 	GroupID int64
 	// SuperGroupID specifies the ID of the super group
 	// where this group is contained in.
 	SuperGroupID int64
+	// Name is an optional human-readable name of the job.
+	// If not empty, it must be unique within the SuperGroupID.
+	Name string
+	// Description is an optional description of the job.
+	Description string
+	// Owner is an optional owner of the job, e.g., a team or a person.
+	Owner string
 	// cronID is the ID of the cron rawJob as assigned by the scheduler
 	// internally.
 	cronID int64
@@ -33,10 +43,36 @@ type Job struct {
 	// updatedAt specifies the last time this object has been updated,
 	// i.e., paused/resumed/schedule updated.
 	updatedAt time.Time
+	// nextRunAt specifies the next time this job is going to be executed.
+	nextRunAt time.Time
+	// lastRunAt specifies the last time this job has been executed.
+	lastRunAt time.Time
+	// lastOutcome is the outcome of the last execution of this job.
+	lastOutcome JobOutcome
 	// Job is the real unit of work to be executed
 	Job CronJob
 	// JobInput is the additional input to pass to the inner Job.
 	JobInput map[string]interface{}
+	// UpstreamIDs are the IDs of the jobs that must complete
+	// successfully before this Job is executed.
+	// A Job with upstreams is never scheduled on its own,
+	// hence its CronExpression must be empty: it is executed
+	// as part of the workflow run started by its root job.
+	UpstreamIDs []int64
+	// OnSuccess are the IDs of the jobs to execute as soon
+	// as an execution of this Job succeeds.
+	OnSuccess []int64
+	// OnFailure are the IDs of the jobs to execute as soon
+	// as an execution of this Job fails.
+	OnFailure []int64
+	// triggered specifies whether this Job is referenced by the
+	// OnSuccess or OnFailure of another job, in which case its
+	// CronExpression may be empty.
+	triggered bool
+	// Labels are arbitrary key/value pairs tagging the Job,
+	// e.g., by environment or team, that can be selected by
+	// the means of a LabelSelector.
+	Labels map[string]string
 }
 
 // CreatedAt returns the time when this Job has been added to the scheduler.
@@ -50,6 +86,25 @@ func (j *Job) UpdatedAt() time.Time {
 	return j.updatedAt
 }
 
+// NextRunAt returns the next time this Job is going to be executed.
+// It is the zero time if the Job is not scheduled, e.g., because
+// it is paused.
+func (j *Job) NextRunAt() time.Time {
+	return j.nextRunAt
+}
+
+// LastRunAt returns the last time this Job has been executed.
+// It is the zero time if the Job has never been executed.
+func (j *Job) LastRunAt() time.Time {
+	return j.lastRunAt
+}
+
+// LastOutcome returns the outcome of the last execution of this Job.
+// It is empty if the Job has never been executed.
+func (j *Job) LastOutcome() JobOutcome {
+	return j.lastOutcome
+}
+
 // Paused returns whether this Job is currently paused
 // or not.
 func (j *Job) Paused() bool {
@@ -61,7 +116,11 @@ func (j *Job) Paused() bool {
 func (j *Job) toJobWithSchedule() (JobWithSchedule, error) {
 	var result JobWithSchedule
 	// decode the schedule
-	schedule, err := cron.ParseStandard(j.CronExpression)
+	schedule, err := parseSchedule(j.CronExpression, len(j.UpstreamIDs) > 0, j.triggered)
+	if err != nil {
+		return result, err
+	}
+	labels, err := encodeLabels(j.ID, j.Labels)
 	if err != nil {
 		return result, err
 	}
@@ -71,11 +130,18 @@ func (j *Job) toJobWithSchedule() (JobWithSchedule, error) {
 			ID:             j.ID,
 			GroupID:        j.GroupID,
 			SuperGroupID:   j.SuperGroupID,
+			Name:           j.Name,
+			Description:    j.Description,
+			Owner:          j.Owner,
 			CronID:         0,
 			CronExpression: j.CronExpression,
 			Paused:         false,
 			CreatedAt:      time.Now(),
 			UpdatedAt:      time.Now(),
+			UpstreamIDs:    encodeIDs(j.UpstreamIDs),
+			OnSuccess:      encodeIDs(j.OnSuccess),
+			OnFailure:      encodeIDs(j.OnFailure),
+			Labels:         labels,
 		},
 		schedule: schedule,
 		run:      j.Job,
@@ -90,6 +156,10 @@ func (j *Job) toJobWithSchedule() (JobWithSchedule, error) {
 	return result, nil
 }
 
+// DefaultCronID is the CronID of a job
+// that has not been inserted yet.
+const DefaultCronID = int64(0)
+
 // RawJob models a raw rawJob coming from the database.
 type RawJob struct {
 	// ID is a unique ID identifying the rawJob object.
@@ -100,6 +170,13 @@ type RawJob struct {
 	// SuperGroupID specifies the ID of the super group
 	// where this group is contained in.
 	SuperGroupID int64 `gorm:"column:super_group_id"`
+	// Name is the human-readable name of the rawJob,
+	// unique within the SuperGroupID if not empty.
+	Name string `gorm:"column:name"`
+	// Description is the description of the rawJob.
+	Description string `gorm:"column:description"`
+	// Owner is the owner of the rawJob.
+	Owner string `gorm:"column:owner"`
 	// CronID is the ID of the cron rawJob as assigned by the scheduler
 	// internally.
 	CronID int64 `gorm:"column:cron_id"`
@@ -118,6 +195,27 @@ type RawJob struct {
 	// SerializedJobInput is the base64(gob-encoded byte array)
 	// of the map containing the argument for the job.
 	SerializedJobInput string `gorm:"column:serialized_job_input"`
+	// UpstreamIDs is the json-encoded list of the IDs of the jobs
+	// this rawJob depends on. It is empty if the rawJob has no upstreams.
+	UpstreamIDs string `gorm:"column:upstream_ids"`
+	// OnSuccess is the json-encoded list of the IDs of the jobs
+	// to execute when this rawJob succeeds. It is empty if there are none.
+	OnSuccess string `gorm:"column:on_success"`
+	// OnFailure is the json-encoded list of the IDs of the jobs
+	// to execute when this rawJob fails. It is empty if there are none.
+	OnFailure string `gorm:"column:on_failure"`
+	// NextRunAt specifies the next time this rawJob is going to be executed.
+	// It is nil if the rawJob is not scheduled, e.g., because it is paused.
+	NextRunAt *time.Time `gorm:"column:next_run_at"`
+	// LastRunAt specifies the last time this rawJob has been executed.
+	// It is nil if the rawJob has never been executed.
+	LastRunAt *time.Time `gorm:"column:last_run_at"`
+	// LastOutcome is the outcome of the last execution of this rawJob.
+	// It is empty if the rawJob has never been executed.
+	LastOutcome JobOutcome `gorm:"column:last_outcome"`
+	// Labels are the labels of this rawJob,
+	// stored in their own table.
+	Labels []JobLabel `gorm:"foreignKey:JobID"`
 }
 
 func (j *RawJob) TableName() string {
@@ -138,26 +236,16 @@ type JobWithSchedule struct {
 
 // decodeSerializedFields decode j.serializedJob and j.SerializedJobInput.
 func (j *RawJob) decodeSerializedFields() (CronJob, CronJobInput, error) {
-	var decoder *gob.Decoder
-	var err error
-
-	// decode from base64 the serialized job
-	decodedJob, err := base64.StdEncoding.DecodeString(j.SerializedJob)
-	if err != nil {
-		return nil, CronJobInput{}, err
-	}
-
 	// decode the interface executing the rawJob
-	decoder = gob.NewDecoder(bytes.NewBuffer(decodedJob))
-	var runJob CronJob
-	if err = decoder.Decode(&runJob); err != nil {
+	runJob, err := decodeJob(j.SerializedJob)
+	if err != nil {
 		return nil, CronJobInput{}, err
 	}
 
 	// decode the input from json
 	var jobInputMap map[string]interface{}
 	if err := json.Unmarshal([]byte(j.SerializedJobInput), &jobInputMap); err != nil {
-		return nil, CronJobInput{}, err
+		return nil, CronJobInput{}, withKind(ErrDecodeJob, err)
 	}
 
 	// and build the overall object containing all the
@@ -172,16 +260,36 @@ func (j *RawJob) decodeSerializedFields() (CronJob, CronJobInput, error) {
 	return runJob, runJobInput, nil
 }
 
+// upstreamIDs decodes j.UpstreamIDs.
+func (j *RawJob) upstreamIDs() ([]int64, error) {
+	return decodeIDs(j.UpstreamIDs)
+}
+
 // toJob converts j to a Job instance.
 func (j *RawJob) toJob() (Job, error) {
 	job, jobInput, err := j.decodeSerializedFields()
 	if err != nil {
 		return Job{}, err
 	}
+	upstreamIDs, err := j.upstreamIDs()
+	if err != nil {
+		return Job{}, err
+	}
+	onSuccess, err := decodeIDs(j.OnSuccess)
+	if err != nil {
+		return Job{}, err
+	}
+	onFailure, err := decodeIDs(j.OnFailure)
+	if err != nil {
+		return Job{}, err
+	}
 	result := Job{
 		ID:             j.ID,
 		GroupID:        j.GroupID,
 		SuperGroupID:   j.SuperGroupID,
+		Name:           j.Name,
+		Description:    j.Description,
+		Owner:          j.Owner,
 		cronID:         j.CronID,
 		CronExpression: j.CronExpression,
 		paused:         j.Paused,
@@ -189,6 +297,17 @@ func (j *RawJob) toJob() (Job, error) {
 		updatedAt:      j.UpdatedAt,
 		Job:            job,
 		JobInput:       jobInput.OtherInputs,
+		UpstreamIDs:    upstreamIDs,
+		OnSuccess:      onSuccess,
+		OnFailure:      onFailure,
+		Labels:         decodeLabels(j.Labels),
+		lastOutcome:    j.LastOutcome,
+	}
+	if j.NextRunAt != nil {
+		result.nextRunAt = *j.NextRunAt
+	}
+	if j.LastRunAt != nil {
+		result.lastRunAt = *j.LastRunAt
 	}
 	return result, nil
 }
@@ -199,8 +318,9 @@ func (j *RawJob) toJob() (Job, error) {
 // It does NOT copy the byte arrays from j.
 func (j *RawJob) ToJobWithSchedule() (JobWithSchedule, error) {
 	var result JobWithSchedule
-	// decode the schedule
-	schedule, err := cron.ParseStandard(j.CronExpression)
+	// decode the schedule: the chains of the stored jobs
+	// have been checked when they have been added
+	schedule, err := parseSchedule(j.CronExpression, j.UpstreamIDs != "", true)
 	if err != nil {
 		return result, err
 	}
@@ -214,11 +334,21 @@ func (j *RawJob) ToJobWithSchedule() (JobWithSchedule, error) {
 			ID:             j.ID,
 			GroupID:        j.GroupID,
 			SuperGroupID:   j.SuperGroupID,
+			Name:           j.Name,
+			Description:    j.Description,
+			Owner:          j.Owner,
 			CronID:         j.CronID,
 			CronExpression: j.CronExpression,
 			Paused:         j.Paused,
 			CreatedAt:      j.CreatedAt,
 			UpdatedAt:      j.UpdatedAt,
+			UpstreamIDs:    j.UpstreamIDs,
+			OnSuccess:      j.OnSuccess,
+			OnFailure:      j.OnFailure,
+			NextRunAt:      j.NextRunAt,
+			LastRunAt:      j.LastRunAt,
+			LastOutcome:    j.LastOutcome,
+			Labels:         j.Labels,
 		},
 		schedule: schedule,
 		run:      runJob,
@@ -227,6 +357,64 @@ func (j *RawJob) ToJobWithSchedule() (JobWithSchedule, error) {
 	return result, nil
 }
 
+// parseSchedule parses `cronExpression`. An empty expression
+// means the job has no schedule of its own, i.e., it is executed only
+// when triggered by other jobs: in that case a nil schedule is returned.
+// It is allowed only for jobs with upstreams, which must have an empty
+// cron expression, and for the `triggered` ones, i.e., those executed
+// on success or on failure of other jobs.
+func parseSchedule(cronExpression string, hasUpstreams bool, triggered bool) (cron.Schedule, error) {
+	if hasUpstreams && cronExpression != "" {
+		return nil, ErrWorkflowJobWithSchedule
+	}
+	if cronExpression == "" && (hasUpstreams || triggered) {
+		return nil, nil
+	}
+	schedule, err := cron.ParseStandard(cronExpression)
+	if err != nil {
+		return nil, withKind(ErrInvalidSchedule, err)
+	}
+	return schedule, nil
+}
+
+// encodeIDs json-encodes `ids`, e.g., the upstreams of a job, returning
+// an empty string if there are no ids.
+func encodeIDs(ids []int64) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	// encoding a slice of int64 never fails
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+// decodeIDs is the inverse of encodeIDs.
+func decodeIDs(encoded string) ([]int64, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var ids []int64
+	if err := json.Unmarshal([]byte(encoded), &ids); err != nil {
+		return nil, withKind(ErrDecodeJob, err)
+	}
+	return ids, nil
+}
+
+// decodeJob decodes the CronJob serialized in `serialized`,
+// i.e., encoded in Gob and then in base64.
+func decodeJob(serialized string) (CronJob, error) {
+	decodedJob, err := base64.StdEncoding.DecodeString(serialized)
+	if err != nil {
+		return nil, withKind(ErrDecodeJob, err)
+	}
+	decoder := gob.NewDecoder(bytes.NewBuffer(decodedJob))
+	var runJob CronJob
+	if err = decoder.Decode(&runJob); err != nil {
+		return nil, withKind(ErrDecodeJob, err)
+	}
+	return runJob, nil
+}
+
 // encodeJob encodes `job`. A separate function is needed because we need to pass
 // a POINTER to interface.
 func encodeJob(encoder *gob.Encoder, job CronJob) error {
@@ -286,6 +474,15 @@ func getIdsFromJobsWithScheduleList(jobs []JobWithSchedule) []int64 {
 	return ids
 }
 
+// getRawJobsFromJobsWithScheduleList basically does jobs.map(job -> job.rawJob)
+func getRawJobsFromJobsWithScheduleList(jobs []JobWithSchedule) []RawJob {
+	rawJobs := make([]RawJob, len(jobs))
+	for i, job := range jobs {
+		rawJobs[i] = job.rawJob
+	}
+	return rawJobs
+}
+
 // getIdsFromJobList basically does jobs.map(rawJob -> rawJob.id)
 func getIdsFromJobList(jobs []Job) []int64 {
 	ids := make([]int64, len(jobs))
@@ -318,7 +515,11 @@ type UpdateOption struct {
 }
 
 func (u *UpdateOption) schedule() (cron.Schedule, error) {
-	return cron.ParseStandard(*u.CronExpression)
+	schedule, err := cron.ParseStandard(*u.CronExpression)
+	if err != nil {
+		return nil, withKind(ErrInvalidSchedule, err)
+	}
+	return schedule, nil
 }
 
 var (
@@ -327,7 +528,7 @@ var (
 	// This error is returned when the combination
 	// of the fields is not valid (i.e., both nil).
 	// For error in the CronExpression field,
-	// the specific error set by the library is returned,
+	// ErrInvalidSchedule is returned.
 	ErrUpdateOptionInvalid = errors.New("invalid option")
 )
 
@@ -371,10 +572,60 @@ type CronJobInput struct {
 	CronExpression string
 	// OtherInputs contains the other inputs of the job.
 	OtherInputs map[string]interface{}
+	// WorkflowRunID is the ID of the workflow run this execution
+	// belongs to. It is 0 if the job is not part of a workflow.
+	WorkflowRunID int64
+	// ParentJobID is the ID of the job whose execution triggered
+	// this one, by the means of OnSuccess or OnFailure.
+	// It is 0 if the job has not been triggered by another job.
+	ParentJobID int64
+	// ParentOutcome is the outcome of the execution of the parent job.
+	// It is empty if the job has not been triggered by another job.
+	ParentOutcome JobOutcome
+	// ParentError is the error returned by the parent job, if it failed.
+	ParentError string
 }
 
+// JobOutcome is the outcome of the execution of a job.
+type JobOutcome string
+
+const (
+	// JobOutcomeSucceeded means the execution succeeded.
+	JobOutcomeSucceeded = JobOutcome("succeeded")
+	// JobOutcomeFailed means the execution returned an error, or panicked.
+	JobOutcomeFailed = JobOutcome("failed")
+)
+
 // CronJob is the interface jobs have to implement.
 // It contains only one single method, `Run`.
 type CronJob interface {
 	Run(input CronJobInput)
 }
+
+// CronJobWithResult is the interface jobs can implement
+// to report whether their execution succeeded or not.
+// When implemented, RunWithResult is called instead of Run,
+// and a non-nil error means the execution failed.
+type CronJobWithResult interface {
+	CronJob
+	RunWithResult(input CronJobInput) error
+}
+
+// executeJob executes `job`, returning the error reported
+// by the job if it implements CronJobWithContext or CronJobWithResult.
+// Panics are recovered and returned as errors.
+func executeJob(ctx context.Context, job JobWithSchedule) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	if withContext, ok := job.run.(CronJobWithContext); ok {
+		return withContext.RunWithContext(ctx, job.runInput)
+	}
+	if withResult, ok := job.run.(CronJobWithResult); ok {
+		return withResult.RunWithResult(job.runInput)
+	}
+	job.run.Run(job.runInput)
+	return nil
+}
@@ -0,0 +1,61 @@
+package smallben
+
+import (
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RepositoryMySQLConfig is the configuration of
+// a RepositoryGorm backed by MySQL or MariaDB.
+//
+// Deprecated: use the MySQLConfig of the gormrepo module.
+type RepositoryMySQLConfig struct {
+	// DSN is the data source name of the database,
+	// e.g., "user:password@tcp(localhost:3306)/smallben".
+	DSN string
+	// Config is the configuration to use to connect to the database.
+	Config gorm.Config
+	// SkipMigrations disables the migrations on creation.
+	SkipMigrations bool
+}
+
+// dsn returns config.DSN with the parameters required by RepositorySQL:
+//   - parseTime, to scan datetime columns into time.Time, in UTC;
+//   - clientFoundRows, so that updates count the matched rows rather than the changed ones,
+//     as done by Postgres and SQLite, otherwise updating a job with the same values
+//     would be reported as a missing job.
+//
+// The isolation level is not set on the connection, since MariaDB, before 11.1, has
+// no transaction_isolation variable: RepositorySQL sets it per transaction.
+func (c *RepositoryMySQLConfig) dsn() (string, error) {
+	config, err := mysqldriver.ParseDSN(c.DSN)
+	if err != nil {
+		return "", err
+	}
+	config.ParseTime = true
+	config.Loc = time.UTC
+	config.ClientFoundRows = true
+	return config.FormatDSN(), nil
+}
+
+// NewRepositoryMySQL returns an instance of RepositoryGorm backed by the MySQL or MariaDB
+// database in config.DSN, whose parameters are adjusted as required. Like NewRepositoryGorm,
+// it applies the migrations of the schema, unless config.SkipMigrations is set.
+//
+// Since MySQL commits DDL statements implicitly, a migration failing
+// halfway is not rolled back, and must be completed by hand.
+//
+// Deprecated: use NewMySQL of the gormrepo module.
+func NewRepositoryMySQL(config *RepositoryMySQLConfig) (*RepositoryGorm, error) {
+	dsn, err := config.dsn()
+	if err != nil {
+		return nil, err
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &config.Config)
+	if err != nil {
+		return nil, err
+	}
+	return newRepositoryGorm(db, config.SkipMigrations)
+}
@@ -0,0 +1,74 @@
+package smallben
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrDuplicateJobName is returned when adding a job whose Name
+// is already used by another job of the same SuperGroupID.
+// It is an ErrDuplicateJob.
+var ErrDuplicateJobName = fmt.Errorf("%w name", ErrDuplicateJob)
+
+// jobName is the name of a job within its super group.
+type jobName struct {
+	superGroupID int64
+	name         string
+}
+
+// checkNames makes sure the names of `jobs` are unique within
+// their super group, both among `jobs` and among the other jobs
+// already in `repository`, returning a BatchError listing the
+// jobs whose name is already taken.
+func (s *SmallBen) checkNames(ctx context.Context, repository Repository, jobs []Job) error {
+	// maps each name to the ID of the job having it
+	names := make(map[jobName]int64)
+	var superGroupIDs []int64
+	var jobNames []string
+	batch := &BatchError{}
+	for _, job := range jobs {
+		if job.Name == "" {
+			continue
+		}
+		key := jobName{superGroupID: job.SuperGroupID, name: job.Name}
+		if _, ok := names[key]; ok {
+			batch.add(job.ID, fmt.Errorf("%w: %s", ErrDuplicateJobName, job.Name))
+			continue
+		}
+		names[key] = job.ID
+		superGroupIDs = append(superGroupIDs, job.SuperGroupID)
+		jobNames = append(jobNames, job.Name)
+	}
+	if len(names) == 0 {
+		return batch.orNil()
+	}
+	var existing []RawJob
+	if err := s.traceRepository(ctx, "ListJobs", func() error {
+		var err error
+		existing, err = repository.ListJobs(&ListJobsOptions{SuperGroupIDs: superGroupIDs, JobNames: jobNames})
+		return err
+	}); err != nil {
+		return err
+	}
+	for _, job := range existing {
+		if id, ok := names[jobName{superGroupID: job.SuperGroupID, name: job.Name}]; ok && id != job.ID {
+			batch.add(id, fmt.Errorf("%w: %s", ErrDuplicateJobName, job.Name))
+		}
+	}
+	return batch.orNil()
+}
+
+// GetJobByName returns the job of the super group `superGroupID`
+// whose Name is `name`. In case the job is not found, an error
+// matching ErrJobNotFound, and ErrorTypeIfMismatchCount(), is returned.
+func (s *SmallBen) GetJobByName(superGroupID int64, name string) (Job, error) {
+	job, err := s.repository.GetJobByName(superGroupID, name)
+	if err != nil {
+		return Job{}, notFound(s.repository, nil, err)
+	}
+	rawJob, err := job.BuildJob()
+	if err != nil {
+		return Job{}, err
+	}
+	return rawJob.toJob()
+}
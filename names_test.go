@@ -0,0 +1,92 @@
+package smallben
+
+import (
+	"testing"
+)
+
+func (s *SmallBenTestSuite) TestNames(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	// the same name can be used in different super groups
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+	jobs[0].Name, jobs[0].Description, jobs[0].Owner = "report", "Daily report", "team-a"
+	jobs[1].Name = "cleanup"
+	jobs[3].Name = "report"
+	s.jobs = jobs
+
+	// duplicate names within the same super group
+	duplicated := make([]Job, len(jobs))
+	copy(duplicated, jobs)
+	duplicated[2].Name = "report"
+	err = s.smallBen.AddJobs(duplicated)
+	checkErrorIsOf(err, ErrDuplicateJobName, t)
+
+	err = s.smallBen.AddJobs(jobs[:3])
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	// the name is already used in the repository
+	err = s.smallBen.AddJobs([]Job{duplicated[2]})
+	checkErrorIsOf(err, ErrDuplicateJobName, t)
+	err = s.smallBen.AddJobs(jobs[3:])
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	job, err := s.smallBen.GetJobByName(jobs[0].SuperGroupID, "report")
+	if err != nil {
+		t.Errorf("Fail to get job: %s\n", err.Error())
+		t.FailNow()
+	}
+	if job.ID != jobs[0].ID || job.Description != jobs[0].Description || job.Owner != jobs[0].Owner {
+		t.Errorf("Wrong job: %+v\n", job)
+	}
+	_, err = s.smallBen.GetJobByName(jobs[0].SuperGroupID, "missing")
+	checkErrorIsOf(err, s.smallBen.ErrorTypeIfMismatchCount(), t)
+
+	// pause the jobs by name
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{SuperGroupIDs: []int64{jobs[0].SuperGroupID}, JobNames: []string{"report"}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	paused := true
+	pausedJobs, err := s.smallBen.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(pausedJobs) != 1 || pausedJobs[0].ID != jobs[0].ID || pausedJobs[0].Name != "report" {
+		t.Errorf("Wrong paused jobs: %+v\n", pausedJobs)
+	}
+	// without the super group, the name matches all of them
+	err = s.smallBen.ResumeJobs(&PauseResumeOptions{JobNames: []string{"report"}})
+	if err != nil {
+		t.Errorf("Fail to resume jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	named, err := s.smallBen.ListJobs(&ListJobsOptions{JobNames: []string{"report"}})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(named) != 2 || named[0].ID != jobs[0].ID || named[1].ID != jobs[3].ID {
+		t.Errorf("Wrong named jobs: %+v\n", named)
+	}
+}
+
+func TestSmallBenNames(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestNames(t)
+		test.teardown(false, t)
+	}
+}
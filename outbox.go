@@ -0,0 +1,153 @@
+package smallben
+
+import (
+	"time"
+)
+
+// OutboxEntry is a change made to a job whose effects on the scheduler,
+// i.e., its scheduling and its event, may have not been applied yet.
+// It is stored atomically with the change itself, and deleted once
+// its effects have been applied.
+type OutboxEntry struct {
+	// ID is the ID of the entry, assigned by the IDGenerator.
+	ID int64 `gorm:"primaryKey;column:id"`
+	// Type is the type of the event of the change.
+	Type EventType `gorm:"column:type"`
+	// JobID is the ID of the changed job.
+	JobID int64 `gorm:"column:job_id"`
+	// GroupID is the GroupID of the job.
+	GroupID int64 `gorm:"column:group_id"`
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64 `gorm:"column:super_group_id"`
+	// JobName is the Name of the job, if any.
+	JobName string `gorm:"column:job_name"`
+	// Node is the node of the instance of SmallBen that made the change,
+	// the only one applying the entry. See IDGenerator.
+	Node int64 `gorm:"column:node"`
+	// CreatedAt specifies when the change has been made.
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (o *OutboxEntry) TableName() string {
+	return "outbox_entries"
+}
+
+// event returns the event of the change.
+func (o *OutboxEntry) event() Event {
+	return Event{
+		Type:         o.Type,
+		Time:         o.CreatedAt,
+		JobID:        o.JobID,
+		GroupID:      o.GroupID,
+		SuperGroupID: o.SuperGroupID,
+		JobName:      o.JobName,
+	}
+}
+
+// OutboxRepository is the interface implemented by the storage backends
+// that can journal the changes to the jobs, so that SmallBen is consistent
+// with them even if it crashes before applying them to the scheduler.
+type OutboxRepository interface {
+	// Transaction executes `fn` within a transaction, passing it a Repository,
+	// implementing OutboxRepository too, bound to the transaction. The transaction
+	// is committed if `fn` returns nil, and rolled back otherwise.
+	Transaction(fn func(repository Repository) error) error
+	// AddOutboxEntries stores `entries`.
+	AddOutboxEntries(entries []OutboxEntry) error
+	// ListOutboxEntries returns the entries of `node`,
+	// sorted by creation time.
+	ListOutboxEntries(node int64) ([]OutboxEntry, error)
+	// DeleteOutboxEntries deletes the entries whose id is in `ids`.
+	DeleteOutboxEntries(ids []int64) error
+}
+
+// journaled executes `operation` within a transaction of the repository of
+// SmallBen, and then applies its changes to the scheduler. If the repository
+// implements OutboxRepository, the changes are journaled within the same transaction,
+// and the journal is cleared once they have been applied: in case of crashes, Start
+// applies the changes left. Otherwise, the transaction is a no-op, and the changes
+// are stored by the operations of the repository one at a time.
+//
+// Once the changes have been stored, the errors applying them are returned
+// as errors matching ErrNotApplied.
+func (s *SmallBen) journaled(operation func(tx *Tx) error) error {
+	tx := &Tx{smallBen: s, repository: s.repository}
+	var err error
+	if repository, ok := s.repository.(OutboxRepository); ok {
+		err = repository.Transaction(func(txRepository Repository) error {
+			tx.repository = txRepository
+			return operation(tx)
+		})
+	} else {
+		err = operation(tx)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.apply()
+}
+
+// journal stores the entries of the changes of type `eventType` to `jobs`
+// within the transaction, if the repository supports it, returning their IDs.
+func (t *Tx) journal(eventType EventType, jobs []RawJob) ([]int64, error) {
+	repository, ok := t.repository.(OutboxRepository)
+	if !ok || len(jobs) == 0 {
+		return nil, nil
+	}
+	node, err := t.smallBen.node()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries := make([]OutboxEntry, len(jobs))
+	ids := make([]int64, len(jobs))
+	for i := range jobs {
+		id, err := t.smallBen.idGenerator.NextID()
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = OutboxEntry{
+			ID:           id,
+			Type:         eventType,
+			JobID:        jobs[i].ID,
+			GroupID:      jobs[i].GroupID,
+			SuperGroupID: jobs[i].SuperGroupID,
+			JobName:      jobs[i].Name,
+			Node:         node,
+			CreatedAt:    now,
+		}
+		ids[i] = id
+	}
+	if err := repository.AddOutboxEntries(entries); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// replayOutbox applies the changes left in the outbox by this instance, e.g., because
+// of a crash. Since the scheduler is filled from the repository, the jobs are already
+// scheduled as they should, so only their events are left to emit. The entries of
+// the other instances are left to them, since they may be applying them.
+func (s *SmallBen) replayOutbox() error {
+	repository, ok := s.repository.(OutboxRepository)
+	if !ok {
+		return nil
+	}
+	node, err := s.node()
+	if err != nil {
+		return err
+	}
+	entries, err := repository.ListOutboxEntries(node)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	s.logger.Info("Replaying outbox", "Progress", "InProgress", "Entries", len(entries))
+	events := make([]Event, len(entries))
+	ids := make([]int64, len(entries))
+	for i := range entries {
+		events[i] = entries[i].event()
+		ids[i] = entries[i].ID
+	}
+	s.events.emit(events...)
+	return repository.DeleteOutboxEntries(ids)
+}
@@ -0,0 +1,149 @@
+package smallben
+
+import (
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func (s *SmallBenTestSuite) TestOutbox(t *testing.T) {
+	repository := s.smallBen.repository.(*RepositorySQL)
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+
+	// the transaction is committed, but SmallBen
+	// crashes before applying the changes.
+	tx, err := repository.db.Begin()
+	if err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	smallBenTx, err := s.smallBen.WithTx(tx)
+	if err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+	if err = smallBenTx.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Cannot commit: %s", err.Error())
+	}
+	entries, err := repository.ListOutboxEntries(0)
+	if err != nil {
+		t.Fatalf("Cannot list the outbox: %s", err.Error())
+	}
+	if len(entries) != len(jobs) {
+		t.Fatalf("Wrong number of entries. Got: %d, expected: %d", len(entries), len(jobs))
+	}
+	for _, entry := range entries {
+		if entry.Type != EventJobAdded {
+			t.Errorf("Wrong type of entry. Got: %s, expected: %s", entry.Type, EventJobAdded)
+		}
+	}
+
+	// the changes are left to the instance that made them
+	generator, err := NewSnowflakeGenerator(1)
+	if err != nil {
+		t.Fatalf("Cannot create the generator: %s", err.Error())
+	}
+	other := New(repository, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+		IDGenerator:     generator,
+	})
+	if err = other.Start(); err != nil {
+		t.Fatalf("Cannot even start: %s", err.Error())
+	}
+	other.Stop()
+	if entries, err = repository.ListOutboxEntries(0); err != nil || len(entries) != len(jobs) {
+		t.Fatalf("The entries should have been left. Got: %d, %v", len(entries), err)
+	}
+
+	// the changes are applied on restart
+	listener := &testListener{}
+	restarted := New(repository, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+		Listeners:       []Listener{listener},
+	})
+	if err = restarted.Start(); err != nil {
+		t.Fatalf("Cannot even start: %s", err.Error())
+	}
+	defer restarted.Stop()
+	listener.waitFor(EventJobAdded, len(jobs), t)
+	if len(restarted.scheduler.cron.Entries()) != len(jobs) {
+		t.Errorf("The jobs should have been scheduled. Got: %d", len(restarted.scheduler.cron.Entries()))
+	}
+	checkOutboxEmpty(repository, t)
+
+	// the outbox is cleared once the changes have been applied
+	if err = restarted.PauseJobs(&PauseResumeOptions{JobIDs: []int64{jobs[0].ID}}); err != nil {
+		t.Fatalf("Fail to pause jobs: %s", err.Error())
+	}
+	listener.waitFor(EventJobPaused, 1, t)
+	checkOutboxEmpty(repository, t)
+	if len(restarted.scheduler.cron.Entries()) != len(jobs)-1 {
+		t.Errorf("The job should have been paused. Got: %d", len(restarted.scheduler.cron.Entries()))
+	}
+
+	// and nothing is changed in case of errors
+	checkErrorIsOf(restarted.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{-1}}}),
+		repository.ErrorTypeIfMismatchCount(), t)
+	checkOutboxEmpty(repository, t)
+	time.Sleep(50 * time.Millisecond)
+	if count := listener.count(EventJobDeleted); count != 0 {
+		t.Errorf("No job should have been deleted. Got: %d", count)
+	}
+}
+
+// checkOutboxEmpty checks that the outbox of `repository`
+// is empty for node 0, the one of the default IDGenerator.
+func checkOutboxEmpty(repository OutboxRepository, t *testing.T) {
+	entries, err := repository.ListOutboxEntries(0)
+	if err != nil {
+		t.Fatalf("Cannot list the outbox: %s", err.Error())
+	}
+	if len(entries) != 0 {
+		t.Errorf("The outbox should be empty. Got: %d entries", len(entries))
+	}
+}
+
+func TestSmallBenOutbox(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestOutbox(t)
+		test.teardown(false, t)
+	}
+}
+
+// failingCronIDRepository is a Repository failing to store the cron_id of the jobs.
+type failingCronIDRepository struct {
+	Repository
+}
+
+func (f *failingCronIDRepository) SetCronId(_ []JobWithSchedule) error {
+	return errors.New("cannot store the cron_id")
+}
+
+// TestSmallBenNotApplied tests that the changes stored but not applied to
+// the scheduler are reported by ErrNotApplied, and kept in the repository.
+func TestSmallBenNotApplied(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+	smallBen := New(&failingCronIDRepository{Repository: repository}, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+	jobs := make([]Job, len(JobsToUse))
+	copy(jobs, JobsToUse)
+
+	checkErrorIsOf(smallBen.AddJobs(jobs), ErrNotApplied, t)
+	if count, err := repository.CountJobs(nil); err != nil || count != int64(len(jobs)) {
+		t.Errorf("The jobs should have been stored. Got: %d, %v", count, err)
+	}
+	if len(smallBen.scheduler.cron.Entries()) != 0 {
+		t.Errorf("The jobs should not have been scheduled. Got: %d", len(smallBen.scheduler.cron.Entries()))
+	}
+}
@@ -0,0 +1,145 @@
+package smallben
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// JobSortField is a field jobs can be sorted by.
+type JobSortField string
+
+const (
+	// SortByID sorts the jobs by ID.
+	SortByID = JobSortField("id")
+	// SortByCreatedAt sorts the jobs by creation time.
+	SortByCreatedAt = JobSortField("created_at")
+	// SortByUpdatedAt sorts the jobs by the last update time.
+	SortByUpdatedAt = JobSortField("updated_at")
+	// SortByNextRunAt sorts the jobs by the time of their next execution.
+	// Jobs that are not scheduled come last in ascending order.
+	SortByNextRunAt = JobSortField("next_run_at")
+)
+
+var (
+	// ErrInvalidSortField is returned when listing the jobs
+	// by a field that is not a JobSortField.
+	ErrInvalidSortField = errors.New("invalid sort field")
+	// ErrInvalidPageToken is returned when listing the jobs with a page
+	// token that is malformed, or that has been returned by a listing
+	// with a different sorting.
+	ErrInvalidPageToken = errors.New("invalid page token")
+)
+
+// JobsPage is a page of jobs.
+type JobsPage struct {
+	// Jobs are the jobs in the page.
+	Jobs []Job
+	// NextPageToken is the token to use in ListJobsOptions.PageToken
+	// to retrieve the next page. It is empty if this is the last page.
+	NextPageToken string
+}
+
+// pageCursor is the position after which
+// the next page starts, i.e., the sort value
+// and the id of the last job of a page.
+type pageCursor struct {
+	SortBy     JobSortField `json:"s"`
+	Descending bool         `json:"d"`
+	Value      *time.Time   `json:"v,omitempty"`
+	ID         int64        `json:"i"`
+}
+
+// sortField returns the field to sort by,
+// defaulting to SortByID.
+func (o *ListJobsOptions) sortField() (JobSortField, error) {
+	switch o.SortBy {
+	case "":
+		return SortByID, nil
+	case SortByID, SortByCreatedAt, SortByUpdatedAt, SortByNextRunAt:
+		return o.SortBy, nil
+	default:
+		return "", ErrInvalidSortField
+	}
+}
+
+// paginated returns whether any of the pagination options is set.
+func (o *ListJobsOptions) paginated() bool {
+	return o.Limit > 0 || o.Offset > 0 || o.PageToken != ""
+}
+
+// cursor returns the cursor encoded in the page token,
+// nil if there is no page token.
+func (o *ListJobsOptions) cursor() (*pageCursor, error) {
+	if o.PageToken == "" {
+		return nil, nil
+	}
+	field, err := o.sortField()
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(o.PageToken)
+	if err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	var cursor pageCursor
+	if err = json.Unmarshal(decoded, &cursor); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+	if cursor.SortBy != field || cursor.Descending != o.Descending {
+		return nil, ErrInvalidPageToken
+	}
+	return &cursor, nil
+}
+
+// nextPageToken returns the token of the page following `jobs`,
+// listed according to `options`. It is empty if `jobs` is the last page.
+func (o *ListJobsOptions) nextPageToken(jobs []RawJob) (string, error) {
+	if o.Limit <= 0 || len(jobs) < o.Limit {
+		return "", nil
+	}
+	field, err := o.sortField()
+	if err != nil {
+		return "", err
+	}
+	last := &jobs[len(jobs)-1]
+	cursor := pageCursor{SortBy: field, Descending: o.Descending, ID: last.ID}
+	switch field {
+	case SortByCreatedAt:
+		cursor.Value = &last.CreatedAt
+	case SortByUpdatedAt:
+		cursor.Value = &last.UpdatedAt
+	case SortByNextRunAt:
+		cursor.Value = last.NextRunAt
+	}
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// ListJobsPage lists a page of the jobs according to `options`, that should
+// set a Limit. The returned NextPageToken, if not empty, is used to retrieve the
+// next page, by setting it in the PageToken of the same options.
+func (s *SmallBen) ListJobsPage(options *ListJobsOptions) (JobsPage, error) {
+	if options == nil {
+		options = &ListJobsOptions{}
+	}
+	rawJobs, err := s.repository.ListJobs(options)
+	if err != nil {
+		return JobsPage{}, err
+	}
+	var page JobsPage
+	if page.NextPageToken, err = options.nextPageToken(rawJobs); err != nil {
+		return JobsPage{}, err
+	}
+	page.Jobs = make([]Job, len(rawJobs))
+	for i := range rawJobs {
+		if page.Jobs[i], err = rawJobs[i].toJob(); err != nil {
+			return JobsPage{}, err
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,90 @@
+package smallben
+
+import (
+	"testing"
+)
+
+func TestPageToken(t *testing.T) {
+	options := ListJobsOptions{Limit: 2, SortBy: SortByNextRunAt}
+	token, err := options.nextPageToken([]RawJob{{ID: 1}, {ID: 2}})
+	if err != nil {
+		t.Errorf("Cannot build the page token: %s\n", err.Error())
+		t.FailNow()
+	}
+	if token == "" {
+		t.Errorf("A full page should have a next page\n")
+	}
+	options.PageToken = token
+	cursor, err := options.cursor()
+	if err != nil {
+		t.Errorf("Cannot decode the page token: %s\n", err.Error())
+		t.FailNow()
+	}
+	if cursor.ID != 2 || cursor.Value != nil {
+		t.Errorf("Wrong cursor: %+v\n", cursor)
+	}
+
+	// the last page has no next page
+	token, err = options.nextPageToken([]RawJob{{ID: 3}})
+	if err != nil || token != "" {
+		t.Errorf("The last page should have no next page\n")
+	}
+}
+
+func (s *SmallBenTestSuite) TestListJobsPage(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	options := ListJobsOptions{Limit: len(s.jobs) - 1, SortBy: SortByNextRunAt}
+	page, err := s.smallBen.ListJobsPage(&options)
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(page.Jobs) != len(s.jobs)-1 || page.NextPageToken == "" {
+		t.Errorf("Wrong first page. Got: %d jobs, token: %s\n", len(page.Jobs), page.NextPageToken)
+		t.FailNow()
+	}
+	for _, job := range page.Jobs {
+		if job.NextRunAt().IsZero() {
+			t.Errorf("Job %d should have a next run\n", job.ID)
+		}
+	}
+
+	// the paused job comes last
+	options.PageToken = page.NextPageToken
+	page, err = s.smallBen.ListJobsPage(&options)
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(page.Jobs) != 1 || page.Jobs[0].ID != s.jobs[0].ID || !page.Jobs[0].NextRunAt().IsZero() {
+		t.Errorf("Wrong last page: %+v\n", page.Jobs)
+	}
+	if page.NextPageToken != "" {
+		t.Errorf("The last page should have no next page\n")
+	}
+}
+
+func TestSmallBenListJobsPage(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestListJobsPage(t)
+		test.teardown(false, t)
+	}
+}
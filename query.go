@@ -0,0 +1,274 @@
+package smallben
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jobQuery is a query on the jobs table that does not depend on how it is executed:
+// RepositorySQL renders it to SQL, while the other backends list the jobs in memory.
+// The conditions use `?` as placeholder, and slice arguments are expanded to lists.
+type jobQuery struct {
+	conditions []queryCondition
+	orders     []string
+	limit      int
+	offset     int
+}
+
+// queryCondition is a condition of a jobQuery, and its arguments.
+type queryCondition struct {
+	sql  string
+	args []interface{}
+}
+
+// where adds the condition `sql` to the query.
+func (q *jobQuery) where(sql string, args ...interface{}) {
+	q.conditions = append(q.conditions, queryCondition{sql: sql, args: args})
+}
+
+// newJobQuery returns the query selecting the jobs according to `options`,
+// ignoring the sorting and the pagination. If options is nil, all the jobs are selected.
+func newJobQuery(options ToListOptions) *jobQuery {
+	query := &jobQuery{}
+	if options == nil {
+		return query
+	}
+	convertedOptions := options.toListOptions()
+	if convertedOptions.Paused != nil {
+		query.where("paused = ?", *convertedOptions.Paused)
+	}
+	if len(convertedOptions.JobIDs) > 0 {
+		query.where("id in (?)", convertedOptions.JobIDs)
+	}
+	if len(convertedOptions.JobNames) > 0 {
+		query.where("name in (?)", convertedOptions.JobNames)
+	}
+	if len(convertedOptions.GroupIDs) > 0 {
+		query.where("group_id in (?)", convertedOptions.GroupIDs)
+	}
+	if len(convertedOptions.SuperGroupIDs) > 0 {
+		query.where("super_group_id in (?)", convertedOptions.SuperGroupIDs)
+	}
+	query.filterBy(&convertedOptions.JobFilters)
+	return query
+}
+
+// filterBy applies `filters` to the query.
+func (q *jobQuery) filterBy(filters *JobFilters) {
+	q.filterTimeRange("created_at", filters.CreatedAt)
+	q.filterTimeRange("updated_at", filters.UpdatedAt)
+	q.filterTimeRange("next_run_at", filters.NextRunAt)
+	if len(filters.ExcludeJobIDs) > 0 {
+		q.where("id not in (?)", filters.ExcludeJobIDs)
+	}
+	if len(filters.ExcludeGroupIDs) > 0 {
+		q.where("group_id not in (?)", filters.ExcludeGroupIDs)
+	}
+	if len(filters.ExcludeSuperGroupIDs) > 0 {
+		q.where("super_group_id not in (?)", filters.ExcludeSuperGroupIDs)
+	}
+	if len(filters.CronExpressions) > 0 {
+		q.where("cron_expression in (?)", filters.CronExpressions)
+	}
+	if len(filters.LastOutcomes) > 0 {
+		q.where("last_outcome in (?)", filters.LastOutcomes)
+	}
+	if len(filters.TriggersJobIDs) > 0 {
+		q.filterTriggers(filters.TriggersJobIDs)
+	}
+	for i := range filters.LabelSelector {
+		q.filterLabel(&filters.LabelSelector[i])
+	}
+}
+
+// filterLabel filters the query by the jobs matching `requirement`.
+func (q *jobQuery) filterLabel(requirement *LabelRequirement) {
+	const byKey = "select job_id from job_labels where label_key = ?"
+	const byValue = byKey + " and label_value in (?)"
+	switch requirement.Operator {
+	case LabelEquals, LabelIn:
+		q.where("id in ("+byValue+")", requirement.Key, requirement.Values)
+	case LabelNotEquals, LabelNotIn:
+		q.where("id not in ("+byValue+")", requirement.Key, requirement.Values)
+	case LabelExists:
+		q.where("id in ("+byKey+")", requirement.Key)
+	case LabelDoesNotExist:
+		q.where("id not in ("+byKey+")", requirement.Key)
+	default:
+		// invalid requirements match nothing
+		q.where("1 = 0")
+	}
+}
+
+// filterTriggers filters the query by the jobs triggering any of `ids`. The IDs of
+// the chained jobs are json-encoded, e.g., [1,2,3]: replacing the brackets with
+// commas, each ID is matched as ,id, whatever its position in the list.
+func (q *jobQuery) filterTriggers(ids []int64) {
+	const onSuccess = "replace(replace(on_success, '[', ','), ']', ',') like ?"
+	const onFailure = "replace(replace(on_failure, '[', ','), ']', ',') like ?"
+	conditions := make([]string, 0, 2*len(ids))
+	args := make([]interface{}, 0, 2*len(ids))
+	for _, id := range ids {
+		pattern := "%," + strconv.FormatInt(id, 10) + ",%"
+		conditions = append(conditions, onSuccess, onFailure)
+		args = append(args, pattern, pattern)
+	}
+	q.where("("+strings.Join(conditions, " or ")+")", args...)
+}
+
+// filterTimeRange filters the query by the `column` being in `timeRange`.
+// Null values never match.
+func (q *jobQuery) filterTimeRange(column string, timeRange *TimeRange) {
+	if timeRange == nil {
+		return
+	}
+	q.where(column + " is not null")
+	if !timeRange.After.IsZero() {
+		q.where(column+" >= ?", timeRange.After)
+	}
+	if !timeRange.Before.IsZero() {
+		q.where(column+" < ?", timeRange.Before)
+	}
+}
+
+// paginate sorts and paginates the query according to `options`.
+// Pages following the first one are retrieved by the keyset in the page token,
+// i.e., by starting after the sort value and the id of the last job.
+func (q *jobQuery) paginate(options *ListJobsOptions) error {
+	field, err := options.sortField()
+	if err != nil {
+		return err
+	}
+	cursor, err := options.cursor()
+	if err != nil {
+		return err
+	}
+	direction, comparison := "asc", ">"
+	if options.Descending {
+		direction, comparison = "desc", "<"
+	}
+
+	if cursor != nil {
+		switch {
+		case field == SortByID:
+			q.where("id "+comparison+" ?", cursor.ID)
+		case cursor.Value != nil:
+			condition := "(" + string(field) + " " + comparison + " ? or (" + string(field) + " = ? and id " + comparison + " ?))"
+			// not scheduled jobs come last
+			if field == SortByNextRunAt && !options.Descending {
+				condition = "(" + condition + " or next_run_at is null)"
+			}
+			q.where(condition, *cursor.Value, *cursor.Value, cursor.ID)
+		case !options.Descending:
+			// the last job was not scheduled, and
+			// neither are the following ones.
+			q.where("(next_run_at is null and id > ?)", cursor.ID)
+		default:
+			q.where("((next_run_at is null and id < ?) or next_run_at is not null)", cursor.ID)
+		}
+	} else if options.Offset > 0 {
+		q.offset = options.Offset
+	}
+
+	if field == SortByNextRunAt {
+		q.orders = append(q.orders, "case when next_run_at is null then 1 else 0 end "+direction)
+	}
+	if field != SortByID {
+		q.orders = append(q.orders, string(field)+" "+direction)
+	}
+	q.orders = append(q.orders, "id "+direction)
+	q.limit = options.Limit
+	return nil
+}
+
+// whereSQL returns the where clause of the query, empty if there
+// are no conditions, and its arguments, with the slices expanded.
+func (q *jobQuery) whereSQL() (string, []interface{}) {
+	if len(q.conditions) == 0 {
+		return "", nil
+	}
+	var builder strings.Builder
+	var args []interface{}
+	builder.WriteString(" where ")
+	for i, condition := range q.conditions {
+		if i > 0 {
+			builder.WriteString(" and ")
+		}
+		builder.WriteString("(")
+		args = expandArgs(&builder, condition.sql, condition.args, args)
+		builder.WriteString(")")
+	}
+	return builder.String(), args
+}
+
+// tailSQL returns the order, limit and offset clauses of the query.
+func (q *jobQuery) tailSQL() string {
+	var builder strings.Builder
+	if len(q.orders) > 0 {
+		builder.WriteString(" order by ")
+		builder.WriteString(strings.Join(q.orders, ", "))
+	}
+	if q.limit > 0 {
+		builder.WriteString(" limit " + strconv.Itoa(q.limit))
+	}
+	if q.offset > 0 {
+		if q.limit <= 0 {
+			// MySQL and SQLite do not support an offset without a limit
+			builder.WriteString(" limit " + strconv.FormatInt(int64(^uint32(0)>>1), 10))
+		}
+		builder.WriteString(" offset " + strconv.Itoa(q.offset))
+	}
+	return builder.String()
+}
+
+// expandArgs writes `sql` to `builder`, replacing each `?` whose argument
+// is a slice with as many placeholders as its elements, and appends
+// the arguments to `args`. An empty slice is written as null.
+func expandArgs(builder *strings.Builder, sql string, values []interface{}, args []interface{}) []interface{} {
+	next := 0
+	for i := 0; i < len(sql); i++ {
+		if sql[i] != '?' || next >= len(values) {
+			builder.WriteByte(sql[i])
+			continue
+		}
+		value := reflect.ValueOf(values[next])
+		next++
+		if value.Kind() != reflect.Slice {
+			builder.WriteByte('?')
+			args = append(args, values[next-1])
+			continue
+		}
+		if value.Len() == 0 {
+			builder.WriteString("null")
+			continue
+		}
+		for j := 0; j < value.Len(); j++ {
+			if j > 0 {
+				builder.WriteString(", ")
+			}
+			builder.WriteByte('?')
+			args = append(args, value.Index(j).Interface())
+		}
+	}
+	return args
+}
+
+// rebind replaces the `?` placeholders of `query` with
+// the numbered ones of Postgres, if `dialect` requires them.
+func rebind(dialect string, query string) string {
+	if dialect != dialectPostgres {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			builder.WriteByte(query[i])
+			continue
+		}
+		n++
+		builder.WriteString("$" + strconv.Itoa(n))
+	}
+	return builder.String()
+}
@@ -0,0 +1,705 @@
+package smallben
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRedisPrefix is the default prefix of the keys used by RepositoryRedis.
+// Its hash tag, i.e., `{smallben}`, stores all the keys in the same slot
+// of Redis Cluster, so that they can be used by the same transaction.
+const DefaultRedisPrefix = "{smallben}:"
+
+// redisMaxRetries is the number of times a transaction is retried
+// when the jobs it involves are modified concurrently.
+const redisMaxRetries = 16
+
+var (
+	// ErrRedisJobNotFound is the error returned by RepositoryRedis
+	// when some of the involved jobs are not found.
+	// It is ErrJobNotFound.
+	ErrRedisJobNotFound = ErrJobNotFound
+	// ErrRedisDuplicateJob is returned by RepositoryRedis
+	// when adding a job that already exists.
+	// It is ErrDuplicateJob.
+	ErrRedisDuplicateJob = ErrDuplicateJob
+	// ErrRedisConflict is returned by RepositoryRedis when a transaction
+	// keeps failing because its jobs are modified concurrently.
+	ErrRedisConflict = errors.New("too many concurrent modifications")
+	// ErrRedisPrefixHashTag is returned by NewRepositoryRedis when the
+	// client is a Redis Cluster one, but the prefix has no hash tag.
+	ErrRedisPrefixHashTag = errors.New("the prefix of the keys must have a hash tag on Redis Cluster")
+)
+
+// RepositoryRedis implements the Repository interface on top of Redis.
+// Each job is stored in a hash, while sets index the jobs by group,
+// super group and paused state, and a hash per super group maps
+// the names to the IDs.
+//
+// Writes are made within MULTI transactions, watching the involved jobs,
+// so they are atomic, and retried if the jobs are modified concurrently.
+type RepositoryRedis struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// RepositoryRedisConfig is the configuration of RepositoryRedis.
+type RepositoryRedisConfig struct {
+	// Client is the client to use to connect to Redis.
+	Client redis.UniversalClient
+	// Prefix is the prefix of the keys, to share the database.
+	// If empty, DefaultRedisPrefix is used. On Redis Cluster, it must
+	// have a hash tag, e.g., `{jobs}:`, since the transactions involve
+	// many keys, which must be in the same slot.
+	Prefix string
+}
+
+// NewRepositoryRedis returns an instance of RepositoryRedis,
+// after checking that Redis is reachable.
+func NewRepositoryRedis(config *RepositoryRedisConfig) (*RepositoryRedis, error) {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = DefaultRedisPrefix
+	}
+	if _, ok := config.Client.(*redis.ClusterClient); ok && !redisHasHashTag(prefix) {
+		return nil, ErrRedisPrefixHashTag
+	}
+	repository := &RepositoryRedis{client: config.Client, prefix: prefix}
+	if err := repository.Ping(context.Background()); err != nil {
+		return nil, err
+	}
+	return repository, nil
+}
+
+// Ping checks whether Redis is reachable.
+// It implements the HealthCheckRepository interface.
+func (r *RepositoryRedis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// ErrorTypeIfMismatchCount returns ErrRedisJobNotFound.
+func (r *RepositoryRedis) ErrorTypeIfMismatchCount() error {
+	return ErrRedisJobNotFound
+}
+
+// AddJobs adds `jobs`, failing with ErrRedisDuplicateJob if any of
+// them already exists, or it is repeated in `jobs`. None of the jobs is added in case of errors.
+func (r *RepositoryRedis) AddJobs(jobs []JobWithSchedule) error {
+	rawJobs, err := redisBuildJobs(jobs)
+	if err != nil {
+		return err
+	}
+	return r.transaction(getIdsFromJobRawList(rawJobs), r.namesKeys(rawJobs),
+		func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error {
+			names := make(map[jobName]int64, len(rawJobs))
+			added := make(map[int64]bool, len(rawJobs))
+			for i := range rawJobs {
+				if existing[rawJobs[i].ID] != nil || added[rawJobs[i].ID] {
+					return fmt.Errorf("%w: %d", ErrRedisDuplicateJob, rawJobs[i].ID)
+				}
+				added[rawJobs[i].ID] = true
+				if err := r.checkName(ctx, tx, &rawJobs[i], names); err != nil {
+					return err
+				}
+				setCreationTimes(&rawJobs[i])
+				if err := r.putJob(ctx, pipe, &rawJobs[i], nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+// UpsertJobs adds the jobs of `jobs` that do not exist, and updates the other ones,
+// in a single transaction. The `paused`, `created_at`, `last_run_at` and `last_outcome`
+// fields of the updated jobs are left untouched, while their labels are replaced.
+func (r *RepositoryRedis) UpsertJobs(jobs []JobWithSchedule) error {
+	rawJobs, err := redisBuildJobs(jobs)
+	if err != nil {
+		return err
+	}
+	return r.transaction(getIdsFromJobRawList(rawJobs), r.namesKeys(rawJobs),
+		func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error {
+			names := make(map[jobName]int64, len(rawJobs))
+			for i := range rawJobs {
+				rawJob := &rawJobs[i]
+				if err := r.checkName(ctx, tx, rawJob, names); err != nil {
+					return err
+				}
+				old := existing[rawJob.ID]
+				if old != nil {
+					rawJob.Paused = old.Paused
+					rawJob.CreatedAt = old.CreatedAt
+					rawJob.UpdatedAt = time.Now()
+					rawJob.LastRunAt = old.LastRunAt
+					rawJob.LastOutcome = old.LastOutcome
+				} else {
+					setCreationTimes(rawJob)
+				}
+				if err := r.putJob(ctx, pipe, rawJob, old); err != nil {
+					return err
+				}
+				// the following jobs see this version
+				existing[rawJob.ID] = rawJob
+			}
+			return nil
+		})
+}
+
+// GetJob returns the JobWithSchedule whose id is `jobID`.
+// In case the job is not found, ErrRedisJobNotFound is returned.
+func (r *RepositoryRedis) GetJob(jobID int64) (JobWithSchedule, error) {
+	rawJobs, err := r.readJobs(context.Background(), r.client, []int64{jobID})
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	if len(rawJobs) == 0 {
+		return JobWithSchedule{}, ErrRedisJobNotFound
+	}
+	return rawJobs[0].ToJobWithSchedule()
+}
+
+// GetJobByName returns the JobWithSchedule of the super group `superGroupID`
+// whose name is `name`. In case the job is not found, ErrRedisJobNotFound is returned.
+func (r *RepositoryRedis) GetJobByName(superGroupID int64, name string) (JobWithSchedule, error) {
+	id, err := r.client.HGet(context.Background(), r.namesKey(superGroupID), name).Int64()
+	if err == redis.Nil {
+		return JobWithSchedule{}, ErrRedisJobNotFound
+	}
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	return r.GetJob(id)
+}
+
+// PauseJobs pauses the jobs whose id are in `jobs`, resetting their
+// cron_id and next_run_at fields. The jobs found are paused anyway, but
+// ErrRedisJobNotFound is returned if some of the jobs are not found.
+func (r *RepositoryRedis) PauseJobs(jobs []RawJob) error {
+	return r.updateFoundJobs(getIdsFromJobRawList(jobs), func(job *RawJob) {
+		job.Paused = true
+		job.CronID = 0
+		job.NextRunAt = nil
+	})
+}
+
+// ResumeJobs resumes the jobs whose id are in `jobs`. The jobs found are resumed
+// anyway, but ErrRedisJobNotFound is returned if some of the jobs are not found.
+func (r *RepositoryRedis) ResumeJobs(jobs []JobWithSchedule) error {
+	return r.updateFoundJobs(getIdsFromJobsWithScheduleList(jobs), func(job *RawJob) {
+		job.Paused = false
+	})
+}
+
+// updateFoundJobs updates the jobs whose id are in `ids` by `update`,
+// returning ErrRedisJobNotFound if some of them are not found,
+// after updating the other ones.
+func (r *RepositoryRedis) updateFoundJobs(ids []int64, update func(job *RawJob)) error {
+	updated := 0
+	err := r.transaction(ids, nil,
+		func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error {
+			updated = len(existing)
+			for _, old := range existing {
+				job := *old
+				update(&job)
+				if err := r.putJob(ctx, pipe, &job, old); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	if updated != len(ids) {
+		return ErrRedisJobNotFound
+	}
+	return nil
+}
+
+// updateAllJobs updates the jobs whose id are in `ids` by `update`, returning
+// ErrRedisJobNotFound, without updating any job, if some of them are not found.
+func (r *RepositoryRedis) updateAllJobs(ids []int64, update func(job *RawJob) error) error {
+	return r.transaction(ids, nil,
+		func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error {
+			if len(existing) != len(ids) {
+				return ErrRedisJobNotFound
+			}
+			for _, id := range ids {
+				old := existing[id]
+				job := *old
+				if err := update(&job); err != nil {
+					return err
+				}
+				if err := r.putJob(ctx, pipe, &job, old); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+// GetAllJobsToExecute returns all the jobs whose `paused` field is set to `false`.
+func (r *RepositoryRedis) GetAllJobsToExecute() ([]JobWithSchedule, error) {
+	paused := false
+	rawJobs, err := r.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// GetJobsByIds returns all the jobs whose ids are in `jobsID`.
+// Returns ErrRedisJobNotFound in case there are less jobs than the requested ones.
+func (r *RepositoryRedis) GetJobsByIds(jobsID []int64) ([]JobWithSchedule, error) {
+	rawJobs, err := r.ListJobs(&ListJobsOptions{JobIDs: jobsID})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// DeleteJobsByIds deletes the jobs whose ids are in `jobsID`, returning
+// ErrRedisJobNotFound, without deleting any job, if some of them are not found.
+func (r *RepositoryRedis) DeleteJobsByIds(jobsID []int64) error {
+	return r.transaction(jobsID, nil,
+		func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error {
+			if len(existing) != len(jobsID) {
+				return ErrRedisJobNotFound
+			}
+			for _, job := range existing {
+				r.deleteIndexes(ctx, pipe, job)
+				pipe.SRem(ctx, r.jobsKey(), job.ID)
+				pipe.Del(ctx, r.jobKey(job.ID))
+			}
+			return nil
+		})
+}
+
+// SetCronId updates the cron_id and next_run_at fields of `jobs`, returning
+// ErrRedisJobNotFound, without updating any job, if some of them are not found.
+func (r *RepositoryRedis) SetCronId(jobs []JobWithSchedule) error {
+	byID := make(map[int64]*RawJob, len(jobs))
+	for i := range jobs {
+		byID[jobs[i].rawJob.ID] = &jobs[i].rawJob
+	}
+	return r.updateAllJobs(getIdsFromJobsWithScheduleList(jobs), func(job *RawJob) error {
+		job.CronID = byID[job.ID].CronID
+		job.NextRunAt = byID[job.ID].NextRunAt
+		job.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// SetCronIdAndChangeScheduleAndJobInput updates the fields `cron_id`, `cron_expression`,
+// `next_run_at` and `serialized_job_input` of jobs, returning ErrRedisJobNotFound,
+// without updating any job, if some of them are not found.
+//
+// In particular, the job input must have been set internally, since
+// this call will encode the job input.
+func (r *RepositoryRedis) SetCronIdAndChangeScheduleAndJobInput(jobs []JobWithSchedule) error {
+	byID := make(map[int64]*RawJob, len(jobs))
+	for i := range jobs {
+		if err := jobs[i].encodeJobInput(); err != nil {
+			return err
+		}
+		byID[jobs[i].rawJob.ID] = &jobs[i].rawJob
+	}
+	return r.updateAllJobs(getIdsFromJobsWithScheduleList(jobs), func(job *RawJob) error {
+		updated := byID[job.ID]
+		job.CronID = updated.CronID
+		job.CronExpression = updated.CronExpression
+		job.NextRunAt = updated.NextRunAt
+		job.SerializedJobInput = updated.SerializedJobInput
+		job.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// SetNextRunAt updates the next_run_at field of the job whose id is `jobID`.
+func (r *RepositoryRedis) SetNextRunAt(jobID int64, nextRunAt *time.Time) error {
+	return r.updateAllJobs([]int64{jobID}, func(job *RawJob) error {
+		job.NextRunAt = nextRunAt
+		return nil
+	})
+}
+
+// SetLastRun updates the last_run_at and last_outcome fields
+// of the job whose id is `jobID`.
+func (r *RepositoryRedis) SetLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome) error {
+	return r.updateAllJobs([]int64{jobID}, func(job *RawJob) error {
+		job.LastRunAt = &lastRunAt
+		job.LastOutcome = outcome
+		return nil
+	})
+}
+
+// ListJobs list all jobs using options. If nil, no options will
+// be used, thus returning all the jobs.
+func (r *RepositoryRedis) ListJobs(options ToListOptions) ([]RawJob, error) {
+	jobs, err := r.candidates(context.Background(), options)
+	if err != nil {
+		return nil, err
+	}
+	if jobs, err = listJobsInMemory(jobs, options); err != nil {
+		return nil, err
+	}
+	if options != nil {
+		convertedOptions := options.toListOptions()
+		if convertedOptions.byIDsOnly() && len(jobs) != len(convertedOptions.JobIDs) {
+			return jobs, ErrRedisJobNotFound
+		}
+	}
+	return jobs, nil
+}
+
+// CountJobs counts the jobs using options. If nil, no options will
+// be used, thus counting all the jobs.
+func (r *RepositoryRedis) CountJobs(options ToListOptions) (int64, error) {
+	jobs, err := r.candidates(context.Background(), options)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(filterJobsInMemory(jobs, options))), nil
+}
+
+// transaction reads the jobs whose id are in `ids`, and calls `update` with the
+// ones found, by ID, to queue the writes in `pipe`, executed atomically unless
+// `update` fails. The jobs and the `watch` keys are watched, and the transaction
+// is retried if any of them is modified concurrently.
+func (r *RepositoryRedis) transaction(ids []int64, watch []string,
+	update func(ctx context.Context, tx *redis.Tx, existing map[int64]*RawJob, pipe redis.Pipeliner) error) error {
+	ctx := context.Background()
+	keys := make([]string, 0, len(ids)+len(watch))
+	for _, id := range ids {
+		keys = append(keys, r.jobKey(id))
+	}
+	keys = append(keys, watch...)
+	for attempt := 0; attempt < redisMaxRetries; attempt++ {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			rawJobs, err := r.readJobs(ctx, tx, ids)
+			if err != nil {
+				return err
+			}
+			existing := make(map[int64]*RawJob, len(rawJobs))
+			for i := range rawJobs {
+				existing[rawJobs[i].ID] = &rawJobs[i]
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return update(ctx, tx, existing, pipe)
+			})
+			return err
+		}, keys...)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return ErrRedisConflict
+}
+
+// candidates returns the jobs that may match `options`, by using the most
+// selective index available, to be filtered by filterJobsInMemory.
+func (r *RepositoryRedis) candidates(ctx context.Context, options ToListOptions) ([]RawJob, error) {
+	var ids []string
+	var err error
+	convertedOptions := ListJobsOptions{}
+	if options != nil {
+		convertedOptions = options.toListOptions()
+	}
+	switch {
+	case len(convertedOptions.JobIDs) > 0:
+		return r.readJobs(ctx, r.client, convertedOptions.JobIDs)
+	case len(convertedOptions.SuperGroupIDs) > 0:
+		keys := make([]string, len(convertedOptions.SuperGroupIDs))
+		for i, id := range convertedOptions.SuperGroupIDs {
+			keys[i] = r.superGroupKey(id)
+		}
+		ids, err = r.client.SUnion(ctx, keys...).Result()
+	case len(convertedOptions.GroupIDs) > 0:
+		keys := make([]string, len(convertedOptions.GroupIDs))
+		for i, id := range convertedOptions.GroupIDs {
+			keys[i] = r.groupKey(id)
+		}
+		ids, err = r.client.SUnion(ctx, keys...).Result()
+	case convertedOptions.Paused != nil:
+		ids, err = r.client.SMembers(ctx, r.pausedKey(*convertedOptions.Paused)).Result()
+	default:
+		ids, err = r.client.SMembers(ctx, r.jobsKey()).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	jobIDs := make([]int64, len(ids))
+	for i, id := range ids {
+		if jobIDs[i], err = strconv.ParseInt(id, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return r.readJobs(ctx, r.client, jobIDs)
+}
+
+// readJobs returns the jobs whose id are in `ids`, skipping the missing ones.
+func (r *RepositoryRedis) readJobs(ctx context.Context, client redis.Cmdable, ids []int64) ([]RawJob, error) {
+	seen := make(map[int64]bool, len(ids))
+	var cmds []*redis.StringStringMapCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			cmds = append(cmds, pipe.HGetAll(ctx, r.jobKey(id)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]RawJob, 0, len(cmds))
+	for _, cmd := range cmds {
+		if len(cmd.Val()) == 0 {
+			continue
+		}
+		job, err := redisDecodeJob(cmd.Val())
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// checkName returns ErrDuplicateJobName if another job of the super group
+// of `job` has its name, either in Redis or in `names`, which keeps track
+// of the names of the jobs written by the transaction.
+func (r *RepositoryRedis) checkName(ctx context.Context, tx *redis.Tx, job *RawJob, names map[jobName]int64) error {
+	if job.Name == "" {
+		return nil
+	}
+	key := jobName{superGroupID: job.SuperGroupID, name: job.Name}
+	if id, ok := names[key]; ok && id != job.ID {
+		return fmt.Errorf("%w: %s", ErrDuplicateJobName, job.Name)
+	}
+	names[key] = job.ID
+	id, err := tx.HGet(ctx, r.namesKey(job.SuperGroupID), job.Name).Int64()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if id != job.ID {
+		return fmt.Errorf("%w: %s", ErrDuplicateJobName, job.Name)
+	}
+	return nil
+}
+
+// putJob queues the writes storing `job` and its index entries,
+// replacing the ones of `old`, if not nil.
+func (r *RepositoryRedis) putJob(ctx context.Context, pipe redis.Pipeliner, job *RawJob, old *RawJob) error {
+	fields, err := redisEncodeJob(job)
+	if err != nil {
+		return err
+	}
+	if old != nil {
+		r.deleteIndexes(ctx, pipe, old)
+	}
+	pipe.HSet(ctx, r.jobKey(job.ID), fields)
+	pipe.SAdd(ctx, r.jobsKey(), job.ID)
+	pipe.SAdd(ctx, r.groupKey(job.GroupID), job.ID)
+	pipe.SAdd(ctx, r.superGroupKey(job.SuperGroupID), job.ID)
+	pipe.SAdd(ctx, r.pausedKey(job.Paused), job.ID)
+	if job.Name != "" {
+		pipe.HSet(ctx, r.namesKey(job.SuperGroupID), job.Name, job.ID)
+	}
+	return nil
+}
+
+// deleteIndexes queues the writes deleting the index entries of `job`.
+func (r *RepositoryRedis) deleteIndexes(ctx context.Context, pipe redis.Pipeliner, job *RawJob) {
+	pipe.SRem(ctx, r.groupKey(job.GroupID), job.ID)
+	pipe.SRem(ctx, r.superGroupKey(job.SuperGroupID), job.ID)
+	pipe.SRem(ctx, r.pausedKey(job.Paused), job.ID)
+	if job.Name != "" {
+		pipe.HDel(ctx, r.namesKey(job.SuperGroupID), job.Name)
+	}
+}
+
+// namesKeys returns the keys of the names
+// of the super groups of `jobs`.
+func (r *RepositoryRedis) namesKeys(jobs []RawJob) []string {
+	var keys []string
+	for _, job := range jobs {
+		if job.Name != "" {
+			keys = append(keys, r.namesKey(job.SuperGroupID))
+		}
+	}
+	return keys
+}
+
+// redisHasHashTag returns whether `prefix` has a hash tag, i.e., a non-empty
+// substring between the first `{` and the following `}`, deciding the slot of the keys.
+func redisHasHashTag(prefix string) bool {
+	start := strings.IndexByte(prefix, '{')
+	if start < 0 {
+		return false
+	}
+	end := strings.IndexByte(prefix[start+1:], '}')
+	return end > 0
+}
+
+// jobKey returns the key of the hash of the job `id`.
+func (r *RepositoryRedis) jobKey(id int64) string {
+	return r.prefix + "job:" + strconv.FormatInt(id, 10)
+}
+
+// jobsKey returns the key of the set of all the jobs.
+func (r *RepositoryRedis) jobsKey() string {
+	return r.prefix + "jobs"
+}
+
+// groupKey returns the key of the set of the jobs of the group `id`.
+func (r *RepositoryRedis) groupKey(id int64) string {
+	return r.prefix + "group:" + strconv.FormatInt(id, 10)
+}
+
+// superGroupKey returns the key of the set of the jobs of the super group `id`.
+func (r *RepositoryRedis) superGroupKey(id int64) string {
+	return r.prefix + "super_group:" + strconv.FormatInt(id, 10)
+}
+
+// pausedKey returns the key of the set of the jobs
+// whose paused field is `paused`.
+func (r *RepositoryRedis) pausedKey(paused bool) string {
+	return r.prefix + "paused:" + strconv.FormatBool(paused)
+}
+
+// namesKey returns the key of the hash mapping the names
+// of the jobs of the super group `id` to their IDs.
+func (r *RepositoryRedis) namesKey(id int64) string {
+	return r.prefix + "names:" + strconv.FormatInt(id, 10)
+}
+
+// redisEncodeJob converts `job` to the fields of its hash.
+func redisEncodeJob(job *RawJob) (map[string]interface{}, error) {
+	labels, err := json.Marshal(decodeLabels(job.Labels))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"id":                   job.ID,
+		"group_id":             job.GroupID,
+		"super_group_id":       job.SuperGroupID,
+		"name":                 job.Name,
+		"description":          job.Description,
+		"owner":                job.Owner,
+		"paused":               strconv.FormatBool(job.Paused),
+		"cron_expression":      job.CronExpression,
+		"cron_id":              job.CronID,
+		"serialized_job":       job.SerializedJob,
+		"serialized_job_input": job.SerializedJobInput,
+		"upstream_ids":         job.UpstreamIDs,
+		"on_success":           job.OnSuccess,
+		"on_failure":           job.OnFailure,
+		"labels":               string(labels),
+		"created_at":           redisEncodeTime(&job.CreatedAt),
+		"updated_at":           redisEncodeTime(&job.UpdatedAt),
+		"next_run_at":          redisEncodeTime(job.NextRunAt),
+		"last_run_at":          redisEncodeTime(job.LastRunAt),
+		"last_outcome":         string(job.LastOutcome),
+	}, nil
+}
+
+// redisDecodeJob converts the fields of the hash of a job to the job.
+func redisDecodeJob(fields map[string]string) (RawJob, error) {
+	job := RawJob{
+		Name:               fields["name"],
+		Description:        fields["description"],
+		Owner:              fields["owner"],
+		CronExpression:     fields["cron_expression"],
+		SerializedJob:      fields["serialized_job"],
+		SerializedJobInput: fields["serialized_job_input"],
+		UpstreamIDs:        fields["upstream_ids"],
+		OnSuccess:          fields["on_success"],
+		OnFailure:          fields["on_failure"],
+		LastOutcome:        JobOutcome(fields["last_outcome"]),
+	}
+	var err error
+	for field, value := range map[string]*int64{
+		"id": &job.ID, "group_id": &job.GroupID, "super_group_id": &job.SuperGroupID, "cron_id": &job.CronID,
+	} {
+		if *value, err = strconv.ParseInt(fields[field], 10, 64); err != nil {
+			return RawJob{}, err
+		}
+	}
+	if job.Paused, err = strconv.ParseBool(fields["paused"]); err != nil {
+		return RawJob{}, err
+	}
+	var labels map[string]string
+	if err = json.Unmarshal([]byte(fields["labels"]), &labels); err != nil {
+		return RawJob{}, err
+	}
+	if job.Labels, err = encodeLabels(job.ID, labels); err != nil {
+		return RawJob{}, err
+	}
+	createdAt, err := redisDecodeTime(fields["created_at"])
+	if err != nil {
+		return RawJob{}, err
+	}
+	updatedAt, err := redisDecodeTime(fields["updated_at"])
+	if err != nil {
+		return RawJob{}, err
+	}
+	if createdAt != nil {
+		job.CreatedAt = *createdAt
+	}
+	if updatedAt != nil {
+		job.UpdatedAt = *updatedAt
+	}
+	if job.NextRunAt, err = redisDecodeTime(fields["next_run_at"]); err != nil {
+		return RawJob{}, err
+	}
+	if job.LastRunAt, err = redisDecodeTime(fields["last_run_at"]); err != nil {
+		return RawJob{}, err
+	}
+	return job, nil
+}
+
+// redisEncodeTime encodes `t`, as an empty string if nil.
+func redisEncodeTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// redisDecodeTime decodes a time encoded by redisEncodeTime.
+func redisDecodeTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// redisBuildJobs serializes `jobs`.
+func redisBuildJobs(jobs []JobWithSchedule) ([]RawJob, error) {
+	rawJobs := make([]RawJob, len(jobs))
+	for i := range jobs {
+		rawJob, err := jobs[i].BuildJob()
+		if err != nil {
+			return nil, err
+		}
+		rawJobs[i] = rawJob
+	}
+	return rawJobs, nil
+}
@@ -0,0 +1,155 @@
+package smallben
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"testing"
+)
+
+// KeyTestRedisAddr is the environment variable holding the address of the
+// Redis server to run the tests against. If empty, miniredis is used.
+const KeyTestRedisAddr = "TEST_REDIS_ADDR"
+
+// newRedisTestRepository returns a RepositoryRedis on the Redis server at `addr`
+// or, if empty, on a new miniredis server, returned as well. The client, and the
+// miniredis server, are closed when the test ends.
+func newRedisTestRepository(t *testing.T, addr string) (*RepositoryRedis, *miniredis.Miniredis) {
+	var server *miniredis.Miniredis
+	if addr == "" {
+		var err error
+		if server, err = miniredis.Run(); err != nil {
+			t.Fatalf("Cannot start miniredis: %s", err.Error())
+		}
+		t.Cleanup(server.Close)
+		addr = server.Addr()
+	}
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { _ = client.Close() })
+	repository, err := NewRepositoryRedis(&RepositoryRedisConfig{Client: client, Prefix: "{smallben_test}:"})
+	if err != nil {
+		t.Fatalf("Cannot connect to Redis: %s", err.Error())
+	}
+	return repository, server
+}
+
+// TestRepositoryRedisAtomic tests that a batch containing an existing
+// job is not added at all, and that the indexes follow the jobs.
+func TestRepositoryRedisAtomic(t *testing.T) {
+	repository, server := newRedisTestRepository(t, "")
+
+	jobs := []JobWithSchedule{
+		{rawJob: RawJob{ID: 1, GroupID: 1, SuperGroupID: 1, Name: "first", CronExpression: "@every 1s"},
+			run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 1}},
+		{rawJob: RawJob{ID: 2, GroupID: 2, SuperGroupID: 1, CronExpression: "@every 1s"},
+			run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 2}},
+	}
+	if err := repository.AddJobs(jobs[:1]); err != nil {
+		t.Fatalf("Cannot add job: %s", err.Error())
+	}
+	checkErrorIsOf(repository.AddJobs(jobs), ErrRedisDuplicateJob, t)
+	if server.Exists(repository.jobKey(2)) {
+		t.Errorf("The job 2 should not have been added")
+	}
+	// as well as a batch containing the same job twice
+	checkErrorIsOf(repository.AddJobs([]JobWithSchedule{jobs[1], jobs[1]}), ErrRedisDuplicateJob, t)
+	if server.Exists(repository.jobKey(2)) {
+		t.Errorf("The job 2 should not have been added")
+	}
+
+	// another job with the same name is refused
+	duplicate := jobs[1]
+	duplicate.rawJob.Name = "first"
+	checkErrorIsOf(repository.UpsertJobs([]JobWithSchedule{duplicate}), ErrDuplicateJobName, t)
+
+	// move the first job to another group, and pause it
+	jobs[0].rawJob.GroupID = 2
+	if err := repository.UpsertJobs(jobs[:1]); err != nil {
+		t.Fatalf("Cannot upsert job: %s", err.Error())
+	}
+	if err := repository.PauseJobs([]RawJob{jobs[0].rawJob}); err != nil {
+		t.Fatalf("Cannot pause job: %s", err.Error())
+	}
+	if members, _ := server.Members(repository.groupKey(1)); len(members) != 0 {
+		t.Errorf("The job should have left the group 1: %v", members)
+	}
+	if members, _ := server.Members(repository.groupKey(2)); len(members) != 1 || members[0] != "1" {
+		t.Errorf("The job should have joined the group 2: %v", members)
+	}
+	if members, _ := server.Members(repository.pausedKey(true)); len(members) != 1 || members[0] != "1" {
+		t.Errorf("The job should be paused: %v", members)
+	}
+
+	if err := repository.DeleteJobsByIds([]int64{1}); err != nil {
+		t.Fatalf("Cannot delete job: %s", err.Error())
+	}
+	for _, key := range server.Keys() {
+		if members, _ := server.Members(key); len(members) > 0 {
+			t.Errorf("The key %s should be empty: %v", key, members)
+		}
+		if fields, _ := server.HKeys(key); len(fields) > 0 {
+			t.Errorf("The key %s should be empty: %v", key, fields)
+		}
+	}
+}
+
+// TestRepositoryRedisConcurrent tests that concurrent
+// transactions on the same job are all applied.
+func TestRepositoryRedisConcurrent(t *testing.T) {
+	repository, server := newRedisTestRepository(t, "")
+
+	job := JobWithSchedule{rawJob: RawJob{ID: 1, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 1s"},
+		run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 1}}
+	if err := repository.AddJobs([]JobWithSchedule{job}); err != nil {
+		t.Fatalf("Cannot add job: %s", err.Error())
+	}
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := repository.PauseJobs([]RawJob{job.rawJob}); err != nil {
+				errs <- err
+			}
+			if err := repository.ResumeJobs([]JobWithSchedule{job}); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent update failed: %s", err.Error())
+	}
+	// the job is in exactly one of the paused sets
+	paused, _ := server.Members(repository.pausedKey(true))
+	notPaused, _ := server.Members(repository.pausedKey(false))
+	if len(paused)+len(notPaused) != 1 {
+		t.Errorf("The paused index is inconsistent: %v, %v", paused, notPaused)
+	}
+}
+
+// TestRepositoryRedisHashTag tests that the keys share the same
+// hash tag, as required by the transactions on Redis Cluster.
+func TestRepositoryRedisHashTag(t *testing.T) {
+	for prefix, expected := range map[string]bool{
+		DefaultRedisPrefix: true,
+		"{jobs}:":          true,
+		"app:{jobs}:":      true,
+		"smallben:":        false,
+		"{}:smallben":      false,
+		"smallben:{":       false,
+	} {
+		if got := redisHasHashTag(prefix); got != expected {
+			t.Errorf("Wrong hash tag detection of %s. Got: %v", prefix, got)
+		}
+	}
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{"localhost:0"}})
+	defer client.Close()
+	_, err := NewRepositoryRedis(&RepositoryRedisConfig{Client: client, Prefix: "smallben:"})
+	checkErrorIsOf(err, ErrRedisPrefixHashTag, t)
+}
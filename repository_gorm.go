@@ -1,244 +1,87 @@
 package smallben
 
 import (
+	"database/sql"
+	"fmt"
 	"gorm.io/gorm"
 )
 
-// DefaultCronID is the CronID of a job
-// that has not been inserted yet.
-const DefaultCronID = int64(0)
-
-// RepositoryGorm implements the Repository
-// interface by the means of GORM.
+// RepositoryGorm implements the Repository interface by the means of GORM.
+// It is a RepositorySQL using the connections of a *gorm.DB, except that
+// its missing jobs are reported with gorm.ErrRecordNotFound.
+//
+// Deprecated: use RepositorySQL, or the Repository of the gormrepo module,
+// so that smallben does not depend on GORM.
 type RepositoryGorm struct {
+	*RepositorySQL
 	db *gorm.DB
 }
 
-// ErrorTypeIfMismatchCount returns the error returned
-// during operations where the number of involved jobs
-// is different than the number of expected jobs that
-// should have been involved.
-func (r *RepositoryGorm) ErrorTypeIfMismatchCount() error {
-	return gorm.ErrRecordNotFound
-}
-
 // RepositoryGormConfig regulates the internal working of the scheduler.
+//
+// Deprecated: use the Config of the gormrepo module.
 type RepositoryGormConfig struct {
 	// Dialector is the dialector to use to connect to the database
 	Dialector gorm.Dialector
 	// Config is the configuration to use to connect to the database.
 	Config gorm.Config
+	// SkipMigrations disables the migrations on creation,
+	// e.g., when the schema is managed by other tools or when
+	// RepositoryGorm.Migrate is called explicitly.
+	// The version of the schema is checked anyway.
+	SkipMigrations bool
 }
 
-// NewRepositoryGorm returns an instance of the repository connecting to the given database.
+// NewRepositoryGorm returns an instance of the repository connecting to the given database,
+// after applying the migrations of the schema, unless config.SkipMigrations is set.
+// It returns ErrSchemaTooNew if the schema has been migrated by a newer version of the library.
+//
+// Deprecated: use New of the gormrepo module.
 func NewRepositoryGorm(config *RepositoryGormConfig) (*RepositoryGorm, error) {
 	db, err := gorm.Open(config.Dialector, &config.Config)
 	if err != nil {
 		return nil, err
 	}
-	return &RepositoryGorm{db: db}, nil
-}
-
-// AddJobs adds `jobs` to the database. This operation can fail
-// if the job serialized fails, or for database errors.
-func (r *RepositoryGorm) AddJobs(jobs []JobWithSchedule) error {
-	rawJobs := make([]RawJob, len(jobs))
-	for i, job := range jobs {
-		rawJob, err := job.BuildJob()
-		if err != nil {
-			return err
-		}
-		rawJobs[i] = rawJob
-	}
-	return r.db.Create(&rawJobs).Error
-}
-
-// GetJob returns the JobWithSchedule whose id is `jobID`.
-// In case the job is not found, an error of type gorm.ErrRecordNotFound is returned.
-func (r *RepositoryGorm) GetJob(jobID int64) (JobWithSchedule, error) {
-	var rawJob RawJob
-	if err := r.db.First(&rawJob, "id = ?", jobID).Error; err != nil {
-		return JobWithSchedule{}, err
-	}
-	// now, convert it to a JobWithSchedule
-	job, err := rawJob.ToJobWithSchedule()
-	return job, err
-}
-
-// PauseJobs pause jobs whose id are in `jobs`.
-// It returns an error `gorm.ErrRecordNotFound` in case
-// the number of updated rows is different than the length of jobsToAdd.
-func (r *RepositoryGorm) PauseJobs(jobs []RawJob) error {
-	return r.updatePausedField(jobs, true)
-}
-
-// PauseJobs resume jobs whose id are in `jobs`.
-// It returns an error `gorm.ErrRecordNotFound` in case
-// the number of updated rows is different then the length of jobsToAdd.
-func (r *RepositoryGorm) ResumeJobs(jobs []JobWithSchedule) error {
-	result := r.db.Table("jobs").Where("id in ?", getIdsFromJobsWithScheduleList(jobs)).Updates(map[string]interface{}{"paused": false})
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected != int64(len(jobs)) {
-		return gorm.ErrRecordNotFound
-	}
-	return nil
+	return newRepositoryGorm(db, config.SkipMigrations)
 }
 
-// GetAllJobsToExecute returns all the jobs whose `paused` field is set to `false`.
-func (r *RepositoryGorm) GetAllJobsToExecute() ([]JobWithSchedule, error) {
-	paused := false
-	// build the struct to make the list query
-	// and make the query
-	rawJobs, err := r.ListJobs(&ListJobsOptions{
-		Paused: &paused,
-	})
+// newRepositoryGorm returns an instance of the repository using `db`,
+// after migrating or checking its schema.
+func newRepositoryGorm(db *gorm.DB, skipMigrations bool) (*RepositoryGorm, error) {
+	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
 	}
-	// now, convert the raw jobs to instances of JobWithSchedule.
-	jobs := make([]JobWithSchedule, len(rawJobs))
-	for i, rawJob := range rawJobs {
-		job, err := rawJob.ToJobWithSchedule()
-		if err != nil {
-			return nil, err
-		}
-		jobs[i] = job
-	}
-	return jobs, nil
-}
-
-// GetJobsByIds returns all the jobsToAdd whose ids are in `jobsID`.
-// Returns an error of type `gorm.ErrRecordNotFound` in case
-// there are less jobs than the requested ones.
-func (r *RepositoryGorm) GetJobsByIds(jobsID []int64) ([]JobWithSchedule, error) {
-	// built the struct to do the list query
-	// and execute it
-	rawJobs, err := r.ListJobs(&ListJobsOptions{
-		JobIDs: jobsID,
+	repository, err := NewRepositorySQL(&RepositorySQLConfig{
+		DB:             sqlDB,
+		Dialect:        db.Dialector.Name(),
+		SkipMigrations: skipMigrations,
 	})
 	if err != nil {
 		return nil, err
 	}
-	jobs := make([]JobWithSchedule, len(rawJobs))
-	for i, rawJob := range rawJobs {
-		job, err := rawJob.ToJobWithSchedule()
-		if err != nil {
-			return nil, err
-		}
-		jobs[i] = job
-	}
-	return jobs, nil
-}
-
-// DeleteJobsByIds delete jobs whose ids are 'jobsID`, returning an error
-// of type gorm.ErrRecordNotFound if the number of deleted jobs is less
-// than the length of `jobsID`.
-func (r *RepositoryGorm) DeleteJobsByIds(jobsID []int64) error {
-	result := r.db.Delete(&RawJob{}, jobsID)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected != int64(len(jobsID)) {
-		return gorm.ErrRecordNotFound
-	}
-	return nil
+	repository.mismatch = gorm.ErrRecordNotFound
+	return &RepositoryGorm{RepositorySQL: repository, db: db}, nil
 }
 
-// SetCronId updates the cron_id field of `jobs`.
-func (r *RepositoryGorm) SetCronId(jobs []JobWithSchedule) error {
-	err := r.db.Transaction(func(tx *gorm.DB) error {
-		for _, job := range jobs {
-			result := tx.Model(&job.rawJob).Update("cron_id", job.rawJob.CronID)
-			if result.Error != nil {
-				return result.Error
-			}
-			if result.RowsAffected != int64(1) {
-				return gorm.ErrRecordNotFound
-			}
-		}
-		return nil
-	})
-	return err
-}
-
-// SetCronIdAndChangeScheduleAndJobInput updates the fields `cron_id`, `cron_expression`
-// and `serialized_job_input` of jobs.
-//
-// In particular, the job input must have been set internally, since
-// this call will encode the job input.
-func (r *RepositoryGorm) SetCronIdAndChangeScheduleAndJobInput(jobs []JobWithSchedule) error {
-	err := r.db.Transaction(func(tx *gorm.DB) error {
-		for _, job := range jobs {
-			// build the input of the job.
-			if err := job.encodeJobInput(); err != nil {
-				// if it fails, the transaction is aborted.
-				return err
-			}
-			// now, do the update.
-			result := tx.Model(&job.rawJob).Updates(map[string]interface{}{
-				"cron_id":              job.rawJob.CronID,
-				"cron_expression":      job.rawJob.CronExpression,
-				"serialized_job_input": job.rawJob.SerializedJobInput,
-			})
-			if result.Error != nil {
-				return result.Error
-			}
-			if result.RowsAffected != int64(1) {
-				return gorm.ErrRecordNotFound
-			}
-		}
-		return nil
-	})
-	return err
-}
-
-// ListJobs list all jobs using options. If nil, no options will
-// be used, thus returning all the jobs.
-func (r *RepositoryGorm) ListJobs(options ToListOptions) ([]RawJob, error) {
-	var jobs []RawJob
-	var query = r.db.Session(&gorm.Session{WithConditions: true})
-	if options != nil {
-		convertedOptions := options.toListOptions()
-		if convertedOptions.Paused != nil {
-			if *convertedOptions.Paused == true {
-				query = query.Where("paused = ?", true)
-			} else if *convertedOptions.Paused == false {
-				query = query.Where("paused = ?", false)
-			}
-		}
-		if convertedOptions.JobIDs != nil && len(convertedOptions.JobIDs) > 0 {
-			query = query.Where("id in (?)", convertedOptions.JobIDs)
-		}
-		if convertedOptions.GroupIDs != nil && len(convertedOptions.GroupIDs) > 0 {
-			query = query.Where("group_id in (?)", convertedOptions.GroupIDs)
-		}
-		if convertedOptions.SuperGroupIDs != nil && len(convertedOptions.SuperGroupIDs) > 0 {
-			query = query.Where("super_group_id in (?)", convertedOptions.SuperGroupIDs)
-		}
-	}
-	err := query.Find(&jobs).Error
-	// a check for gorm.ErrRecordNotFound if we require only the job id
-	if options != nil {
-		convertedOptions := options.toListOptions()
-		if convertedOptions.JobIDs != nil && convertedOptions.SuperGroupIDs == nil &&
-			convertedOptions.GroupIDs == nil && convertedOptions.Paused == nil {
-			if len(jobs) != len(convertedOptions.JobIDs) {
-				err = gorm.ErrRecordNotFound
-			}
-		}
-	}
-	return jobs, err
+// DB returns the *gorm.DB of the repository,
+// e.g., to begin the transactions to pass to WithTx.
+func (r *RepositoryGorm) DB() *gorm.DB {
+	return r.db
 }
 
-func (r *RepositoryGorm) updatePausedField(jobs []RawJob, paused bool) error {
-	result := r.db.Table("jobs").Where("id in ?", getIdsFromJobRawList(jobs)).Updates(map[string]interface{}{"paused": paused, "cron_id": 0})
-	if result.Error != nil {
-		return result.Error
+// WithTx returns a repository executing its operations within `tx`,
+// which must be a *gorm.DB within a transaction, e.g., returned by Begin.
+// Its own transactions are then executed as savepoints of `tx`.
+// It implements the TxRepository interface.
+func (r *RepositoryGorm) WithTx(tx interface{}) (Repository, error) {
+	db, ok := tx.(*gorm.DB)
+	if !ok || db == nil {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidTx, tx)
 	}
-	if result.RowsAffected != int64(len(jobs)) {
-		return gorm.ErrRecordNotFound
+	sqlTx, ok := db.Statement.ConnPool.(*sql.Tx)
+	if !ok {
+		return nil, fmt.Errorf("%w: not within a transaction", ErrInvalidTx)
 	}
-	return nil
+	return r.RepositorySQL.WithTx(sqlTx)
 }
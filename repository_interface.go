@@ -1,14 +1,28 @@
 package smallben
 
+import (
+	"time"
+)
+
 // Repository is the interface whose storage backends should implement.
 type Repository interface {
 	// AddJobs adds `jobs` to the backend. This operation
 	// must be atomic.
 	AddJobs(jobs []JobWithSchedule) error
+	// UpsertJobs adds the jobs of `jobs` that are not in the backend,
+	// and updates the other ones, except for their `paused`,
+	// `created_at`, `last_run_at` and `last_outcome` fields.
+	// This operation must be atomic.
+	UpsertJobs(jobs []JobWithSchedule) error
 	// GetJob returns the job whose ID is `jobID`, returning
 	// an error in case the required job has not been found.
 	// That error must be of the type returned by ErrorTypeIfMismatchCount().
 	GetJob(jobID int64) (JobWithSchedule, error)
+	// GetJobByName returns the job of the super group `superGroupID`
+	// whose name is `name`, returning an error in case the required job
+	// has not been found.
+	// That error must be of the type returned by ErrorTypeIfMismatchCount().
+	GetJobByName(superGroupID int64, name string) (JobWithSchedule, error)
 	// PauseJobs pause `jobs`, i.e., marks them as paused.
 	// This operation must be atomic.
 	//
@@ -57,10 +71,28 @@ type Repository interface {
 	// of required jobs, i.e., `len(jobs)`.
 	SetCronIdAndChangeScheduleAndJobInput(jobs []JobWithSchedule) error
 	// ListJobs list all the jobs present in the job storage backend,
-	// according to `options`.
+	// according to `options`, including their sorting and pagination.
 	//
 	// If options is `nil`, no filtering is applied.
 	ListJobs(options ToListOptions) ([]RawJob, error)
+	// CountJobs counts the jobs present in the job storage backend,
+	// according to `options`, without retrieving them.
+	//
+	// Sorting and pagination are ignored.
+	// If options is `nil`, no filtering is applied.
+	CountJobs(options ToListOptions) (int64, error)
+	// SetNextRunAt sets the `next_run_at` field of the job
+	// whose id is `jobID`.
+	//
+	// It must return an error of type ErrorTypeIfMismatchCount() in case
+	// the job is not found.
+	SetNextRunAt(jobID int64, nextRunAt *time.Time) error
+	// SetLastRun sets the `last_run_at` and `last_outcome` fields
+	// of the job whose id is `jobID`.
+	//
+	// It must return an error of type ErrorTypeIfMismatchCount() in case
+	// the job is not found.
+	SetLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome) error
 	// ErrorTypeIfMismatchCount specifies the error type
 	// to return in case there is a mismatch count
 	// between the number of jobs involved in a backend operation
@@ -92,6 +124,84 @@ type ListJobsOptions struct {
 	// This option logically overrides other options
 	// since it is the most specific.
 	JobIDs []int64
+	// AllowMissing makes the listing by JobIDs succeed even if some of
+	// the jobs do not exist, listing only the existing ones, instead of
+	// returning ErrorTypeIfMismatchCount.
+	AllowMissing bool
+	// JobNames filters the jobs by the given name.
+	// Since names are unique only within a super group,
+	// it should be combined with SuperGroupIDs.
+	// if nil, it is ignored.
+	JobNames []string
+	// JobFilters are the other filters.
+	JobFilters
+	// SortBy is the field to sort the jobs by.
+	// If empty, jobs are sorted by ID.
+	SortBy JobSortField
+	// Descending sorts the jobs in descending order.
+	Descending bool
+	// Limit is the maximum number of jobs to list.
+	// If 0, all the jobs are listed.
+	Limit int
+	// Offset is the number of jobs to skip.
+	// It is ignored when PageToken is set.
+	Offset int
+	// PageToken lists the jobs following the page it has been
+	// returned with, by SmallBen.ListJobsPage. The sorting must not change
+	// between pages.
+	PageToken string
+}
+
+// TimeRange is a range of time. A zero bound means
+// the range is unbounded on that side.
+type TimeRange struct {
+	// After matches the times equal to or after it.
+	After time.Time
+	// Before matches the times strictly before it.
+	Before time.Time
+}
+
+// JobFilters are the filters on the jobs shared by
+// ListJobsOptions, PauseResumeOptions and DeleteOptions.
+// All filters are *combined*, i.e., with an `AND`.
+type JobFilters struct {
+	// CreatedAt filters the jobs by creation time.
+	// If nil, it is ignored.
+	CreatedAt *TimeRange
+	// UpdatedAt filters the jobs by the last update time.
+	// If nil, it is ignored.
+	UpdatedAt *TimeRange
+	// NextRunAt filters the jobs by the time of their next execution.
+	// Jobs that are not scheduled never match it.
+	// If nil, it is ignored.
+	NextRunAt *TimeRange
+	// ExcludeJobIDs excludes the jobs whose ID is in it.
+	ExcludeJobIDs []int64
+	// ExcludeGroupIDs excludes the jobs whose GroupID is in it.
+	ExcludeGroupIDs []int64
+	// ExcludeSuperGroupIDs excludes the jobs whose SuperGroupID is in it.
+	ExcludeSuperGroupIDs []int64
+	// CronExpressions filters the jobs whose CronExpression
+	// is exactly one of them.
+	CronExpressions []string
+	// LastOutcomes filters the jobs whose last execution
+	// has one of the given outcomes. Jobs never executed
+	// never match it.
+	LastOutcomes []JobOutcome
+	// TriggersJobIDs filters the jobs triggering, on success
+	// or on failure, at least one of the jobs whose ID is in it.
+	TriggersJobIDs []int64
+	// LabelSelector filters the jobs whose labels match it.
+	// If nil, it is ignored.
+	LabelSelector LabelSelector
+}
+
+// isZero returns whether no filter is set.
+func (f *JobFilters) isZero() bool {
+	return f.CreatedAt == nil && f.UpdatedAt == nil && f.NextRunAt == nil &&
+		len(f.ExcludeJobIDs) == 0 && len(f.ExcludeGroupIDs) == 0 && len(f.ExcludeSuperGroupIDs) == 0 &&
+		len(f.CronExpressions) == 0 && len(f.LastOutcomes) == 0 && len(f.TriggersJobIDs) == 0 &&
+		len(f.LabelSelector) == 0
 }
 
 // Need to implement the ToListOptions interface.
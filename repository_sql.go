@@ -0,0 +1,1068 @@
+package smallben
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The dialects supported by RepositorySQL.
+const (
+	// SQLDialectPostgres is the dialect of Postgres.
+	SQLDialectPostgres = dialectPostgres
+	// SQLDialectSQLite is the dialect of SQLite.
+	SQLDialectSQLite = dialectSQLite
+	// SQLDialectMySQL is the dialect of MySQL and MariaDB.
+	SQLDialectMySQL = dialectMySQL
+)
+
+// sqlBatchSize is the maximum number of rows inserted by a single statement,
+// so that the number of arguments stays below the limit of SQLite, i.e., 999.
+const sqlBatchSize = 50
+
+// sqlJobColumns are the columns of the jobs table, in the order they are scanned.
+const sqlJobColumns = "id, group_id, super_group_id, name, description, owner, cron_id, cron_expression, " +
+	"paused, created_at, updated_at, serialized_job, serialized_job_input, upstream_ids, on_success, " +
+	"on_failure, next_run_at, last_run_at, last_outcome"
+
+var (
+	// ErrSQLJobNotFound is the error returned by RepositorySQL
+	// when some of the involved jobs, or workflow runs, are not found.
+	// It is ErrJobNotFound, and not sql.ErrNoRows, so that the errors
+	// of the other queries are not mistaken for a missing job.
+	ErrSQLJobNotFound = ErrJobNotFound
+	// ErrSQLDuplicateJob is matched by the error returned by RepositorySQL when
+	// adding a job whose ID, or whose name, is already taken. It is ErrDuplicateJob.
+	ErrSQLDuplicateJob = ErrDuplicateJob
+)
+
+// RepositorySQL implements the Repository interface directly on database/sql,
+// by the means of hand-written queries. It supports workflows, the log of the
+// webhook deliveries and the outbox, and it is the base of the GORM repositories
+// of the gormrepo module, which share its schema and its migrations.
+type RepositorySQL struct {
+	db *sql.DB
+	// tx is the transaction the operations are executed
+	// in, if any, either of the caller or of Transaction.
+	tx *sql.Tx
+	// callerTx specifies whether tx is a transaction of the caller,
+	// in which a failed operation is rolled back to a savepoint,
+	// so that the caller can go on with it.
+	callerTx   bool
+	dialect    string
+	statements *sqlStatements
+	// mismatch is the error returned when some of the involved jobs
+	// are not found, gorm.ErrRecordNotFound for RepositoryGorm,
+	// ErrSQLJobNotFound if nil.
+	mismatch error
+}
+
+// sqlStatements are the statements prepared by RepositorySQL.
+type sqlStatements struct {
+	getJob               *sql.Stmt
+	getJobByName         *sql.Stmt
+	getLabels            *sql.Stmt
+	countJob             *sql.Stmt
+	updateJob            *sql.Stmt
+	deleteLabels         *sql.Stmt
+	setCronID            *sql.Stmt
+	setCronIDAndSchedule *sql.Stmt
+	setNextRunAt         *sql.Stmt
+	setLastRun           *sql.Stmt
+}
+
+// RepositorySQLConfig is the configuration of a RepositorySQL.
+type RepositorySQLConfig struct {
+	// DB is the database to use. It is not closed by RepositorySQL.Close.
+	//
+	// A MySQL or MariaDB database must be opened with the `parseTime`
+	// and `clientFoundRows` parameters set to true.
+	DB *sql.DB
+	// Dialect is the dialect of DB, i.e., one of SQLDialectPostgres,
+	// SQLDialectSQLite and SQLDialectMySQL.
+	Dialect string
+	// SkipMigrations disables the migrations on creation.
+	// The version of the schema is checked anyway.
+	SkipMigrations bool
+}
+
+// NewRepositorySQL returns an instance of RepositorySQL using config.DB, after
+// applying the migrations of the schema, unless config.SkipMigrations is set,
+// and preparing its statements. It returns ErrUnsupportedDialect if config.Dialect
+// is not supported, and ErrSchemaTooNew if the schema has been migrated by
+// a newer version of the library.
+func NewRepositorySQL(config *RepositorySQLConfig) (*RepositorySQL, error) {
+	if _, ok := createSchemaVersion[config.Dialect]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedDialect, config.Dialect)
+	}
+	var err error
+	if config.SkipMigrations {
+		err = checkSchemaVersion(config.DB, config.Dialect)
+	} else {
+		err = migrate(config.DB, config.Dialect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	repository := &RepositorySQL{db: config.DB, dialect: config.Dialect, statements: &sqlStatements{}}
+	if err = repository.prepare(); err != nil {
+		_ = repository.Close()
+		return nil, err
+	}
+	return repository, nil
+}
+
+// prepare prepares the statements of the repository.
+func (r *RepositorySQL) prepare() error {
+	statements := []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&r.statements.getJob, "select " + sqlJobColumns + " from jobs where id = ?"},
+		{&r.statements.getJobByName, "select " + sqlJobColumns + " from jobs where super_group_id = ? and name = ?"},
+		{&r.statements.getLabels, "select job_id, label_key, label_value from job_labels where job_id = ?"},
+		{&r.statements.countJob, "select count(*) from jobs where id = ?"},
+		{&r.statements.updateJob, "update jobs set group_id = ?, super_group_id = ?, name = ?, description = ?, " +
+			"owner = ?, cron_id = ?, cron_expression = ?, serialized_job = ?, serialized_job_input = ?, " +
+			"upstream_ids = ?, on_success = ?, on_failure = ?, next_run_at = ?, updated_at = ? where id = ?"},
+		{&r.statements.deleteLabels, "delete from job_labels where job_id = ?"},
+		{&r.statements.setCronID, "update jobs set cron_id = ?, next_run_at = ?, updated_at = ? where id = ?"},
+		{&r.statements.setCronIDAndSchedule, "update jobs set cron_id = ?, cron_expression = ?, next_run_at = ?, " +
+			"serialized_job_input = ?, updated_at = ? where id = ?"},
+		{&r.statements.setNextRunAt, "update jobs set next_run_at = ? where id = ?"},
+		{&r.statements.setLastRun, "update jobs set last_run_at = ?, last_outcome = ? where id = ?"},
+	}
+	for _, statement := range statements {
+		stmt, err := r.db.Prepare(rebind(r.dialect, statement.query))
+		if err != nil {
+			return err
+		}
+		*statement.stmt = stmt
+	}
+	return nil
+}
+
+// Close closes the prepared statements. The database is left open.
+// It must not be called on the repositories returned by WithTx,
+// which share the statements of this one.
+func (r *RepositorySQL) Close() error {
+	var firstErr error
+	for _, stmt := range []*sql.Stmt{r.statements.getJob, r.statements.getJobByName, r.statements.getLabels,
+		r.statements.countJob, r.statements.updateJob, r.statements.deleteLabels, r.statements.setCronID,
+		r.statements.setCronIDAndSchedule, r.statements.setNextRunAt, r.statements.setLastRun} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Migrate brings the database schema to SchemaVersion, by applying
+// the migrations that have not been applied yet, each one in its own
+// transaction, and keeping track of them in the schema_version table.
+// It returns ErrSchemaTooNew if the schema has been migrated by
+// a newer version of the library.
+func (r *RepositorySQL) Migrate() error {
+	return migrate(r.db, r.dialect)
+}
+
+// SchemaVersion returns the version of the database schema,
+// i.e., the last migration applied. It is 0 if no migration
+// has been applied yet.
+func (r *RepositorySQL) SchemaVersion() (int, error) {
+	return schemaVersion(r.db, r.dialect)
+}
+
+// Ping checks whether the database is reachable.
+// It implements the HealthCheckRepository interface.
+func (r *RepositorySQL) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// ErrorTypeIfMismatchCount returns ErrSQLJobNotFound,
+// unless the repository is a RepositoryGorm.
+func (r *RepositorySQL) ErrorTypeIfMismatchCount() error {
+	if r.mismatch != nil {
+		return r.mismatch
+	}
+	return ErrSQLJobNotFound
+}
+
+// AddJobs adds `jobs` to the database, in batches, in a single transaction.
+// This operation can fail if the job serialized fails, or for database errors.
+func (r *RepositorySQL) AddJobs(jobs []JobWithSchedule) error {
+	rawJobs := make([]RawJob, len(jobs))
+	for i := range jobs {
+		rawJob, err := jobs[i].BuildJob()
+		if err != nil {
+			return err
+		}
+		setCreationTimes(&rawJob)
+		rawJobs[i] = rawJob
+	}
+	return r.transaction(func(tx *sql.Tx) error {
+		if err := r.insertJobs(tx, rawJobs); err != nil {
+			return err
+		}
+		return r.insertLabels(tx, rawJobs)
+	})
+}
+
+// UpsertJobs adds the jobs of `jobs` that are not in the database,
+// and updates the other ones, in a single transaction.
+// The `paused`, `created_at`, `last_run_at` and `last_outcome` fields
+// of the updated jobs are left untouched, while their labels are replaced.
+func (r *RepositorySQL) UpsertJobs(jobs []JobWithSchedule) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		var toInsert, toLabel []RawJob
+		for i := range jobs {
+			rawJob, err := jobs[i].BuildJob()
+			if err != nil {
+				return err
+			}
+			var count int64
+			if err = tx.Stmt(r.statements.countJob).QueryRow(rawJob.ID).Scan(&count); err != nil {
+				return err
+			}
+			toLabel = append(toLabel, rawJob)
+			if count == 0 {
+				setCreationTimes(&rawJob)
+				toInsert = append(toInsert, rawJob)
+				continue
+			}
+			_, err = tx.Stmt(r.statements.updateJob).Exec(rawJob.GroupID, rawJob.SuperGroupID, rawJob.Name,
+				rawJob.Description, rawJob.Owner, rawJob.CronID, rawJob.CronExpression, rawJob.SerializedJob,
+				rawJob.SerializedJobInput, rawJob.UpstreamIDs, rawJob.OnSuccess, rawJob.OnFailure,
+				nullTime(rawJob.NextRunAt), time.Now(), rawJob.ID)
+			if err != nil {
+				return err
+			}
+			if _, err = tx.Stmt(r.statements.deleteLabels).Exec(rawJob.ID); err != nil {
+				return err
+			}
+		}
+		if err := r.insertJobs(tx, toInsert); err != nil {
+			return err
+		}
+		return r.insertLabels(tx, toLabel)
+	})
+}
+
+// GetJob returns the JobWithSchedule whose id is `jobID`.
+// In case the job is not found, ErrSQLJobNotFound is returned.
+func (r *RepositorySQL) GetJob(jobID int64) (JobWithSchedule, error) {
+	return r.getJob(r.stmt(r.statements.getJob).QueryRow(jobID))
+}
+
+// GetJobByName returns the JobWithSchedule of the super group `superGroupID`
+// whose name is `name`.
+// In case the job is not found, ErrSQLJobNotFound is returned.
+func (r *RepositorySQL) GetJobByName(superGroupID int64, name string) (JobWithSchedule, error) {
+	return r.getJob(r.stmt(r.statements.getJobByName).QueryRow(superGroupID, name))
+}
+
+// getJob returns the JobWithSchedule in `row`, along with its labels.
+func (r *RepositorySQL) getJob(row *sql.Row) (JobWithSchedule, error) {
+	rawJob, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return JobWithSchedule{}, r.ErrorTypeIfMismatchCount()
+	}
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	rows, err := r.stmt(r.statements.getLabels).Query(rawJob.ID)
+	if err != nil {
+		return JobWithSchedule{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label JobLabel
+		if err = rows.Scan(&label.JobID, &label.Key, &label.Value); err != nil {
+			return JobWithSchedule{}, err
+		}
+		rawJob.Labels = append(rawJob.Labels, label)
+	}
+	if err = rows.Err(); err != nil {
+		return JobWithSchedule{}, err
+	}
+	return rawJob.ToJobWithSchedule()
+}
+
+// PauseJobs pauses the jobs whose id are in `jobs`, resetting their
+// cron_id and next_run_at fields. The jobs found are paused anyway, but
+// ErrSQLJobNotFound is returned if some of the jobs are not found.
+func (r *RepositorySQL) PauseJobs(jobs []RawJob) error {
+	return r.updateJobs("update jobs set paused = ?, cron_id = 0, next_run_at = null where id in (?)",
+		true, getIdsFromJobRawList(jobs))
+}
+
+// ResumeJobs resumes the jobs whose id are in `jobs`. The jobs found are resumed
+// anyway, but ErrSQLJobNotFound is returned if some of the jobs are not found.
+func (r *RepositorySQL) ResumeJobs(jobs []JobWithSchedule) error {
+	return r.updateJobs("update jobs set paused = ? where id in (?)",
+		false, getIdsFromJobsWithScheduleList(jobs))
+}
+
+// updateJobs executes `update`, whose arguments are `value` and `ids`,
+// returning ErrSQLJobNotFound if it does not update as many jobs as `ids`.
+func (r *RepositorySQL) updateJobs(update string, value bool, ids []int64) error {
+	query, args := r.expand(update, value, ids)
+	result, err := r.conn().Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	return r.checkRowsAffected(result, int64(len(ids)))
+}
+
+// GetAllJobsToExecute returns all the jobs whose `paused` field is set to `false`.
+func (r *RepositorySQL) GetAllJobsToExecute() ([]JobWithSchedule, error) {
+	paused := false
+	rawJobs, err := r.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// GetJobsByIds returns all the jobs whose ids are in `jobsID`.
+// Returns ErrSQLJobNotFound in case there are less jobs than the requested ones.
+func (r *RepositorySQL) GetJobsByIds(jobsID []int64) ([]JobWithSchedule, error) {
+	rawJobs, err := r.ListJobs(&ListJobsOptions{JobIDs: jobsID})
+	if err != nil {
+		return nil, err
+	}
+	return toJobsWithSchedule(rawJobs)
+}
+
+// DeleteJobsByIds deletes the jobs whose ids are `jobsID`, along with their labels,
+// returning ErrSQLJobNotFound, without deleting any job, if some of them are not found.
+func (r *RepositorySQL) DeleteJobsByIds(jobsID []int64) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		query, args := r.expand("delete from job_labels where job_id in (?)", jobsID)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+		query, args = r.expand("delete from jobs where id in (?)", jobsID)
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		return r.checkRowsAffected(result, int64(len(jobsID)))
+	})
+}
+
+// SetCronId updates the cron_id and next_run_at fields of `jobs`, returning
+// ErrSQLJobNotFound, without updating any job, if some of them are not found.
+func (r *RepositorySQL) SetCronId(jobs []JobWithSchedule) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		stmt := tx.Stmt(r.statements.setCronID)
+		for _, job := range jobs {
+			result, err := stmt.Exec(job.rawJob.CronID, nullTime(job.rawJob.NextRunAt), time.Now(), job.rawJob.ID)
+			if err != nil {
+				return err
+			}
+			if err = r.checkRowsAffected(result, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetCronIdAndChangeScheduleAndJobInput updates the fields `cron_id`, `cron_expression`,
+// `next_run_at` and `serialized_job_input` of jobs, returning ErrSQLJobNotFound,
+// without updating any job, if some of them are not found.
+//
+// In particular, the job input must have been set internally, since
+// this call will encode the job input.
+func (r *RepositorySQL) SetCronIdAndChangeScheduleAndJobInput(jobs []JobWithSchedule) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		stmt := tx.Stmt(r.statements.setCronIDAndSchedule)
+		for _, job := range jobs {
+			if err := job.encodeJobInput(); err != nil {
+				return err
+			}
+			result, err := stmt.Exec(job.rawJob.CronID, job.rawJob.CronExpression, nullTime(job.rawJob.NextRunAt),
+				job.rawJob.SerializedJobInput, time.Now(), job.rawJob.ID)
+			if err != nil {
+				return err
+			}
+			if err = r.checkRowsAffected(result, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetNextRunAt updates the next_run_at field of the job whose id is `jobID`.
+func (r *RepositorySQL) SetNextRunAt(jobID int64, nextRunAt *time.Time) error {
+	result, err := r.stmt(r.statements.setNextRunAt).Exec(nullTime(nextRunAt), jobID)
+	if err != nil {
+		return err
+	}
+	return r.checkRowsAffected(result, 1)
+}
+
+// SetLastRun updates the last_run_at and last_outcome fields
+// of the job whose id is `jobID`.
+func (r *RepositorySQL) SetLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome) error {
+	result, err := r.stmt(r.statements.setLastRun).Exec(lastRunAt, string(outcome), jobID)
+	if err != nil {
+		return err
+	}
+	return r.checkRowsAffected(result, 1)
+}
+
+// ListJobs list all jobs using options. If nil, no options will
+// be used, thus returning all the jobs.
+func (r *RepositorySQL) ListJobs(options ToListOptions) ([]RawJob, error) {
+	query := newJobQuery(options)
+	if options != nil {
+		convertedOptions := options.toListOptions()
+		if err := query.paginate(&convertedOptions); err != nil {
+			return nil, err
+		}
+	} else {
+		query.orders = []string{"id"}
+	}
+	where, args := query.whereSQL()
+	rows, err := r.conn().Query(rebind(r.dialect, "select "+sqlJobColumns+" from jobs"+where+query.tailSQL()), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []RawJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = r.loadLabels(jobs); err != nil {
+		return nil, err
+	}
+	if options != nil {
+		convertedOptions := options.toListOptions()
+		if convertedOptions.byIDsOnly() && len(jobs) != len(convertedOptions.JobIDs) {
+			return jobs, r.ErrorTypeIfMismatchCount()
+		}
+	}
+	return jobs, nil
+}
+
+// CountJobs counts the jobs using options. If nil, no options will
+// be used, thus counting all the jobs.
+func (r *RepositorySQL) CountJobs(options ToListOptions) (int64, error) {
+	where, args := newJobQuery(options).whereSQL()
+	var count int64
+	err := r.conn().QueryRow(rebind(r.dialect, "select count(*) from jobs"+where), args...).Scan(&count)
+	return count, err
+}
+
+// loadLabels sets the labels of `jobs`, loading them in batches.
+func (r *RepositorySQL) loadLabels(jobs []RawJob) error {
+	byID := make(map[int64]*RawJob, len(jobs))
+	ids := make([]int64, len(jobs))
+	for i := range jobs {
+		byID[jobs[i].ID] = &jobs[i]
+		ids[i] = jobs[i].ID
+	}
+	// the ids are passed as arguments, so
+	// the batches are larger than the inserted ones.
+	const batchSize = 10 * sqlBatchSize
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		query, args := r.expand("select job_id, label_key, label_value from job_labels where job_id in (?)", ids[start:end])
+		if err := r.scanLabels(byID, query, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanLabels executes `query`, appending the labels
+// it returns to the corresponding job of `byID`.
+func (r *RepositorySQL) scanLabels(byID map[int64]*RawJob, query string, args []interface{}) error {
+	rows, err := r.conn().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label JobLabel
+		if err = rows.Scan(&label.JobID, &label.Key, &label.Value); err != nil {
+			return err
+		}
+		if job, ok := byID[label.JobID]; ok {
+			job.Labels = append(job.Labels, label)
+		}
+	}
+	return rows.Err()
+}
+
+// insertJobs inserts `jobs` by multi-row statements of at most sqlBatchSize jobs.
+func (r *RepositorySQL) insertJobs(tx *sql.Tx, jobs []RawJob) error {
+	const placeholders = "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	for start := 0; start < len(jobs); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(jobs) {
+			end = len(jobs)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 19*(end-start))
+		for _, job := range jobs[start:end] {
+			values = append(values, placeholders)
+			args = append(args, job.ID, job.GroupID, job.SuperGroupID, job.Name, job.Description, job.Owner,
+				job.CronID, job.CronExpression, job.Paused, job.CreatedAt, job.UpdatedAt, job.SerializedJob,
+				job.SerializedJobInput, job.UpstreamIDs, job.OnSuccess, job.OnFailure, nullTime(job.NextRunAt),
+				nullTime(job.LastRunAt), string(job.LastOutcome))
+		}
+		query := "insert into jobs (" + sqlJobColumns + ") values " + strings.Join(values, ", ")
+		if _, err := tx.Exec(rebind(r.dialect, query), args...); err != nil {
+			if isUniqueViolation(r.dialect, err) {
+				return withKind(ErrSQLDuplicateJob, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// insertLabels inserts the labels of `jobs` by multi-row
+// statements of at most sqlBatchSize labels.
+func (r *RepositorySQL) insertLabels(tx *sql.Tx, jobs []RawJob) error {
+	var labels []JobLabel
+	for _, job := range jobs {
+		labels = append(labels, job.Labels...)
+	}
+	for start := 0; start < len(labels); start += sqlBatchSize {
+		end := start + sqlBatchSize
+		if end > len(labels) {
+			end = len(labels)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]interface{}, 0, 3*(end-start))
+		for _, label := range labels[start:end] {
+			values = append(values, "(?, ?, ?)")
+			args = append(args, label.JobID, label.Key, label.Value)
+		}
+		query := "insert into job_labels (job_id, label_key, label_value) values " + strings.Join(values, ", ")
+		if _, err := tx.Exec(rebind(r.dialect, query), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expand returns `query`, whose slice arguments are
+// expanded, in the dialect of the repository.
+func (r *RepositorySQL) expand(query string, args ...interface{}) (string, []interface{}) {
+	var builder strings.Builder
+	expanded := expandArgs(&builder, query, args, nil)
+	return rebind(r.dialect, builder.String()), expanded
+}
+
+// WithTx returns a RepositorySQL executing its operations within `tx`,
+// which must be a *sql.Tx on the database of the repository.
+// It implements the TxRepository interface.
+func (r *RepositorySQL) WithTx(tx interface{}) (Repository, error) {
+	sqlTx, ok := tx.(*sql.Tx)
+	if !ok || sqlTx == nil {
+		return nil, fmt.Errorf("%w: %T", ErrInvalidTx, tx)
+	}
+	return &RepositorySQL{db: r.db, tx: sqlTx, callerTx: true, dialect: r.dialect, statements: r.statements,
+		mismatch: r.mismatch}, nil
+}
+
+// Transaction executes `fn` within a transaction, passing it a RepositorySQL
+// bound to it. It implements the OutboxRepository interface.
+func (r *RepositorySQL) Transaction(fn func(repository Repository) error) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		return fn(&RepositorySQL{db: r.db, tx: tx, dialect: r.dialect, statements: r.statements, mismatch: r.mismatch})
+	})
+}
+
+// AddOutboxEntries stores `entries`, by multi-row statements
+// of at most sqlBatchSize entries, in a single transaction.
+func (r *RepositorySQL) AddOutboxEntries(entries []OutboxEntry) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		for start := 0; start < len(entries); start += sqlBatchSize {
+			end := start + sqlBatchSize
+			if end > len(entries) {
+				end = len(entries)
+			}
+			values := make([]string, 0, end-start)
+			args := make([]interface{}, 0, 8*(end-start))
+			for _, entry := range entries[start:end] {
+				values = append(values, "(?, ?, ?, ?, ?, ?, ?, ?)")
+				args = append(args, entry.ID, string(entry.Type), entry.JobID, entry.GroupID,
+					entry.SuperGroupID, entry.JobName, entry.Node, entry.CreatedAt)
+			}
+			query := "insert into outbox_entries (id, type, job_id, group_id, super_group_id, job_name, node, created_at) values " +
+				strings.Join(values, ", ")
+			if _, err := tx.Exec(rebind(r.dialect, query), args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListOutboxEntries returns the entries of `node`, sorted by creation time.
+func (r *RepositorySQL) ListOutboxEntries(node int64) ([]OutboxEntry, error) {
+	rows, err := r.conn().Query(rebind(r.dialect, "select id, type, job_id, group_id, super_group_id, job_name, node, created_at "+
+		"from outbox_entries where node = ? order by created_at, id"), node)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		if err = rows.Scan(&entry.ID, &entry.Type, &entry.JobID, &entry.GroupID, &entry.SuperGroupID,
+			&entry.JobName, &entry.Node, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteOutboxEntries deletes the entries whose id is in `ids`, in batches.
+func (r *RepositorySQL) DeleteOutboxEntries(ids []int64) error {
+	// the ids are passed as arguments, so
+	// the batches are larger than the inserted ones.
+	const batchSize = 10 * sqlBatchSize
+	return r.transaction(func(tx *sql.Tx) error {
+		for start := 0; start < len(ids); start += batchSize {
+			end := start + batchSize
+			if end > len(ids) {
+				end = len(ids)
+			}
+			query, args := r.expand("delete from outbox_entries where id in (?)", ids[start:end])
+			if _, err := tx.Exec(query, args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ListUpstreams returns the upstreams of the jobs having
+// any, indexed by the ID of the job.
+func (r *RepositorySQL) ListUpstreams() (map[int64][]int64, error) {
+	rows, err := r.conn().Query("select id, upstream_ids from jobs where upstream_ids <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	upstreams := make(map[int64][]int64)
+	for rows.Next() {
+		var id int64
+		var encoded string
+		if err = rows.Scan(&id, &encoded); err != nil {
+			return nil, err
+		}
+		if upstreams[id], err = decodeIDs(encoded); err != nil {
+			return nil, &JobError{JobID: id, Err: err}
+		}
+	}
+	return upstreams, rows.Err()
+}
+
+// AddWorkflowRun stores `run` and its jobs within a transaction,
+// setting `run.ID`.
+func (r *RepositorySQL) AddWorkflowRun(run *WorkflowRun) error {
+	now := time.Now()
+	if run.CreatedAt.IsZero() {
+		run.CreatedAt = now
+	}
+	if run.UpdatedAt.IsZero() {
+		run.UpdatedAt = now
+	}
+	return r.transaction(func(tx *sql.Tx) error {
+		id, err := r.insertReturningID(tx, "insert into workflow_runs (root_job_id, scheduled_at, status, created_at, updated_at) "+
+			"values (?, ?, ?, ?, ?)", run.RootJobID, run.ScheduledAt, string(run.Status), run.CreatedAt, run.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		run.ID = id
+		for i := range run.Jobs {
+			run.Jobs[i].RunID = id
+		}
+		for start := 0; start < len(run.Jobs); start += sqlBatchSize {
+			end := start + sqlBatchSize
+			if end > len(run.Jobs) {
+				end = len(run.Jobs)
+			}
+			values := make([]string, 0, end-start)
+			args := make([]interface{}, 0, 6*(end-start))
+			for _, job := range run.Jobs[start:end] {
+				values = append(values, "(?, ?, ?, ?, ?, ?)")
+				args = append(args, job.RunID, job.JobID, string(job.Status), job.StartedAt, job.FinishedAt, job.Error)
+			}
+			query := "insert into workflow_run_jobs (run_id, job_id, status, started_at, finished_at, error_message) values " +
+				strings.Join(values, ", ")
+			if _, err = tx.Exec(rebind(r.dialect, query), args...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateWorkflowRun updates the `status` of `run`.
+// It returns ErrSQLJobNotFound in case the run does not exist.
+func (r *RepositorySQL) UpdateWorkflowRun(run *WorkflowRun) error {
+	result, err := r.conn().Exec(rebind(r.dialect, "update workflow_runs set status = ?, updated_at = ? where id = ?"),
+		string(run.Status), time.Now(), run.ID)
+	if err != nil {
+		return err
+	}
+	return r.checkRowsAffected(result, 1)
+}
+
+// UpdateWorkflowRunJob updates the fields `status`, `started_at`,
+// `finished_at` and `error_message` of `job`.
+// It returns ErrSQLJobNotFound in case the job is not part of the run.
+func (r *RepositorySQL) UpdateWorkflowRunJob(job *WorkflowRunJob) error {
+	result, err := r.conn().Exec(rebind(r.dialect, "update workflow_run_jobs set status = ?, started_at = ?, "+
+		"finished_at = ?, error_message = ? where run_id = ? and job_id = ?"),
+		string(job.Status), job.StartedAt, job.FinishedAt, job.Error, job.RunID, job.JobID)
+	if err != nil {
+		return err
+	}
+	return r.checkRowsAffected(result, 1)
+}
+
+// GetWorkflowRun returns the run whose id is `runID`, together with its jobs.
+// In case the run is not found, ErrSQLJobNotFound is returned.
+func (r *RepositorySQL) GetWorkflowRun(runID int64) (WorkflowRun, error) {
+	runs, err := r.listWorkflowRuns(" where id = ?", []interface{}{runID})
+	if err != nil {
+		return WorkflowRun{}, err
+	}
+	if len(runs) == 0 {
+		return WorkflowRun{}, r.ErrorTypeIfMismatchCount()
+	}
+	return runs[0], nil
+}
+
+// ListWorkflowRuns lists the runs according to options, from the most
+// recent one. If nil, no options will be used, thus returning all the runs.
+func (r *RepositorySQL) ListWorkflowRuns(options *ListWorkflowRunsOptions) ([]WorkflowRun, error) {
+	var conditions []string
+	var args []interface{}
+	if options != nil {
+		if len(options.RootJobIDs) > 0 {
+			conditions = append(conditions, "root_job_id in (?)")
+			args = append(args, options.RootJobIDs)
+		}
+		if len(options.Statuses) > 0 {
+			statuses := make([]string, len(options.Statuses))
+			for i, status := range options.Statuses {
+				statuses[i] = string(status)
+			}
+			conditions = append(conditions, "status in (?)")
+			args = append(args, statuses)
+		}
+	}
+	var where string
+	if len(conditions) > 0 {
+		where = " where " + strings.Join(conditions, " and ")
+	}
+	return r.listWorkflowRuns(where, args)
+}
+
+// listWorkflowRuns returns the runs matching `where`, whose
+// arguments are `args`, from the most recent one, with their jobs.
+func (r *RepositorySQL) listWorkflowRuns(where string, args []interface{}) ([]WorkflowRun, error) {
+	query, args := r.expand("select id, root_job_id, scheduled_at, status, created_at, updated_at "+
+		"from workflow_runs"+where+" order by id desc", args...)
+	rows, err := r.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []WorkflowRun
+	for rows.Next() {
+		var run WorkflowRun
+		if err = rows.Scan(&run.ID, &run.RootJobID, &run.ScheduledAt, &run.Status, &run.CreatedAt,
+			&run.UpdatedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return runs, r.loadWorkflowRunJobs(runs)
+}
+
+// loadWorkflowRunJobs sets the jobs of `runs`, loading them in batches.
+func (r *RepositorySQL) loadWorkflowRunJobs(runs []WorkflowRun) error {
+	byID := make(map[int64]*WorkflowRun, len(runs))
+	ids := make([]int64, len(runs))
+	for i := range runs {
+		byID[runs[i].ID] = &runs[i]
+		ids[i] = runs[i].ID
+	}
+	const batchSize = 10 * sqlBatchSize
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		query, args := r.expand("select run_id, job_id, status, started_at, finished_at, error_message "+
+			"from workflow_run_jobs where run_id in (?) order by run_id, job_id", ids[start:end])
+		if err := r.scanWorkflowRunJobs(byID, query, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanWorkflowRunJobs executes `query`, appending the jobs
+// it returns to the corresponding run of `byID`.
+func (r *RepositorySQL) scanWorkflowRunJobs(byID map[int64]*WorkflowRun, query string, args []interface{}) error {
+	rows, err := r.conn().Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var job WorkflowRunJob
+		if err = rows.Scan(&job.RunID, &job.JobID, &job.Status, &job.StartedAt, &job.FinishedAt, &job.Error); err != nil {
+			return err
+		}
+		if run, ok := byID[job.RunID]; ok {
+			run.Jobs = append(run.Jobs, job)
+		}
+	}
+	return rows.Err()
+}
+
+// AddWebhookDelivery stores `delivery`, setting `delivery.ID`.
+func (r *RepositorySQL) AddWebhookDelivery(delivery *WebhookDelivery) error {
+	if delivery.CreatedAt.IsZero() {
+		delivery.CreatedAt = time.Now()
+	}
+	id, err := r.insertReturningID(r.conn(), "insert into webhook_deliveries (type, job_id, super_group_id, url, "+
+		"attempts, status_code, delivered, error_message, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		string(delivery.Type), delivery.JobID, delivery.SuperGroupID, delivery.URL, delivery.Attempts,
+		delivery.StatusCode, delivery.Delivered, delivery.Error, delivery.CreatedAt)
+	if err != nil {
+		return err
+	}
+	delivery.ID = id
+	return nil
+}
+
+// ListWebhookDeliveries lists the deliveries according to options, from the most
+// recent one. If nil, no options will be used, thus returning all the deliveries.
+func (r *RepositorySQL) ListWebhookDeliveries(options *ListWebhookDeliveriesOptions) ([]WebhookDelivery, error) {
+	var conditions []string
+	var args []interface{}
+	if options != nil {
+		if len(options.JobIDs) > 0 {
+			conditions = append(conditions, "job_id in (?)")
+			args = append(args, options.JobIDs)
+		}
+		if options.Delivered != nil {
+			conditions = append(conditions, "delivered = ?")
+			args = append(args, *options.Delivered)
+		}
+	}
+	var where string
+	if len(conditions) > 0 {
+		where = " where " + strings.Join(conditions, " and ")
+	}
+	query, args := r.expand("select id, type, job_id, super_group_id, url, attempts, status_code, delivered, "+
+		"error_message, created_at from webhook_deliveries"+where+" order by id desc", args...)
+	rows, err := r.conn().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		if err = rows.Scan(&delivery.ID, &delivery.Type, &delivery.JobID, &delivery.SuperGroupID, &delivery.URL,
+			&delivery.Attempts, &delivery.StatusCode, &delivery.Delivered, &delivery.Error,
+			&delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+// insertReturningID executes `insert` on `conn`, whose arguments are `args`,
+// returning the id generated for the inserted row.
+func (r *RepositorySQL) insertReturningID(conn sqlConn, insert string, args ...interface{}) (int64, error) {
+	if r.dialect == dialectPostgres {
+		var id int64
+		err := conn.QueryRow(rebind(r.dialect, insert+" returning id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := conn.Exec(rebind(r.dialect, insert), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// sqlConn is either a *sql.DB or a *sql.Tx.
+type sqlConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the transaction of the caller, if any, or the database.
+func (r *RepositorySQL) conn() sqlConn {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// stmt returns `stmt` within the transaction of the caller, if any.
+func (r *RepositorySQL) stmt(stmt *sql.Stmt) *sql.Stmt {
+	if r.tx != nil {
+		return r.tx.Stmt(stmt)
+	}
+	return stmt
+}
+
+// transaction executes `fn` in a transaction which is rolled back if fn returns an error.
+// Within the transaction of the caller, a savepoint is rolled back instead, while within
+// the one of Transaction fn is just executed, since the whole transaction is rolled back.
+func (r *RepositorySQL) transaction(fn func(tx *sql.Tx) error) error {
+	if r.tx == nil {
+		return sqlTransaction(r.db, r.txOptions(), fn)
+	}
+	if !r.callerTx {
+		return fn(r.tx)
+	}
+	if _, err := r.tx.Exec("savepoint smallben"); err != nil {
+		return err
+	}
+	if err := fn(r.tx); err != nil {
+		if _, rollbackErr := r.tx.Exec("rollback to savepoint smallben"); rollbackErr != nil {
+			return fmt.Errorf("%w, rollback: %s", err, rollbackErr.Error())
+		}
+		return err
+	}
+	_, err := r.tx.Exec("release savepoint smallben")
+	return err
+}
+
+// txOptions returns the options of the transactions of the repository. On MySQL,
+// they are READ COMMITTED, the default level of Postgres, so that the transactions
+// updating many jobs, e.g., SetCronId, do not lock the gaps between them. The level
+// is set per transaction since MariaDB, before 11.1, has no transaction_isolation
+// variable to set it on the connection.
+func (r *RepositorySQL) txOptions() *sql.TxOptions {
+	if r.dialect == dialectMySQL {
+		return &sql.TxOptions{Isolation: sql.LevelReadCommitted}
+	}
+	return nil
+}
+
+// sqlScanner is either a *sql.Row or *sql.Rows.
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob scans a job, made of sqlJobColumns, without its labels.
+func scanJob(row sqlScanner) (RawJob, error) {
+	var job RawJob
+	var nextRunAt, lastRunAt sql.NullTime
+	err := row.Scan(&job.ID, &job.GroupID, &job.SuperGroupID, &job.Name, &job.Description, &job.Owner,
+		&job.CronID, &job.CronExpression, &job.Paused, &job.CreatedAt, &job.UpdatedAt, &job.SerializedJob,
+		&job.SerializedJobInput, &job.UpstreamIDs, &job.OnSuccess, &job.OnFailure, &nextRunAt, &lastRunAt,
+		&job.LastOutcome)
+	if err != nil {
+		return job, err
+	}
+	if nextRunAt.Valid {
+		job.NextRunAt = &nextRunAt.Time
+	}
+	if lastRunAt.Valid {
+		job.LastRunAt = &lastRunAt.Time
+	}
+	return job, nil
+}
+
+// nullTime converts `t` to a nullable time.
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
+
+// checkRowsAffected returns the error of ErrorTypeIfMismatchCount
+// if `result` did not affect exactly `expected` rows.
+func (r *RepositorySQL) checkRowsAffected(result sql.Result, expected int64) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected != expected {
+		return r.ErrorTypeIfMismatchCount()
+	}
+	return nil
+}
+
+// sqlBeginner begins the transactions, i.e., a *sql.DB or a *sql.Conn.
+type sqlBeginner interface {
+	BeginTx(ctx context.Context, options *sql.TxOptions) (*sql.Tx, error)
+}
+
+// sqlTransaction executes `fn` in a transaction on `db`, whose options
+// are `options`, which is rolled back if fn returns an error.
+func sqlTransaction(db sqlBeginner, options *sql.TxOptions, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), options)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w, rollback: %s", err, rollbackErr.Error())
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUniqueViolation returns whether `err` is the violation of a unique constraint,
+// e.g., of the primary key of the jobs. The drivers are not imported by RepositorySQL,
+// hence their errors are recognized by their SQLSTATE, or by their message.
+func isUniqueViolation(dialect string, err error) bool {
+	switch dialect {
+	case dialectPostgres:
+		var state interface{ SQLState() string }
+		return errors.As(err, &state) && state.SQLState() == "23505"
+	case dialectMySQL:
+		// ER_DUP_ENTRY
+		return strings.HasPrefix(err.Error(), "Error 1062")
+	default:
+		return strings.Contains(err.Error(), "UNIQUE constraint failed")
+	}
+}
@@ -0,0 +1,235 @@
+package smallben
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sqliteDSN is the data source name of the SQLite database used by the
+// tests. As advised for RepositorySQL, the journal is in WAL mode and the
+// transactions take the write lock as soon as they begin.
+var sqliteDSN = "file:" + sqlitePath + "?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_txlock=immediate"
+
+// mysqlDSN returns the data source name of the MySQL database used by
+// the tests, with the parameters required by RepositorySQL.
+func mysqlDSN(t *testing.T) string {
+	config, err := mysql.ParseDSN(mysqlConn)
+	if err != nil {
+		t.Fatalf("Cannot parse the DSN: %s", err.Error())
+	}
+	config.ParseTime = true
+	config.Loc = time.UTC
+	config.ClientFoundRows = true
+	return config.FormatDSN()
+}
+
+// buildSQLRepositories returns the repositories to run the tests against:
+// SQLite, always, Postgres, if KeyTestPgDbName is set,
+// and MySQL, if KeyTestMySQLDbName is set.
+func buildSQLRepositories(t *testing.T) []Repository {
+	databases := []struct {
+		driver, dialect, dsn string
+	}{
+		{driver: "sqlite3", dialect: SQLDialectSQLite, dsn: sqliteDSN},
+	}
+	if pgConn != "" {
+		databases = append(databases, struct{ driver, dialect, dsn string }{"pgx", SQLDialectPostgres, pgConn})
+	}
+	if mysqlConn != "" {
+		databases = append(databases, struct{ driver, dialect, dsn string }{"mysql", SQLDialectMySQL, mysqlDSN(t)})
+	}
+	repositories := make([]Repository, len(databases))
+	for i, database := range databases {
+		db, err := sql.Open(database.driver, database.dsn)
+		if err != nil {
+			t.Fatalf("Cannot open connection: %s", err.Error())
+		}
+		repository, err := NewRepositorySQL(&RepositorySQLConfig{DB: db, Dialect: database.dialect})
+		if err != nil {
+			t.Fatalf("Cannot open connection: %s", err.Error())
+		}
+		repositories[i] = repository
+	}
+	return repositories
+}
+
+// TestRepositorySQLBatches tests that jobs and labels exceeding
+// a batch are all inserted, listed and deleted.
+func TestRepositorySQLBatches(t *testing.T) {
+	for _, repository := range buildSQLRepositories(t) {
+		const count = 3*sqlBatchSize + 7
+		jobs := make([]JobWithSchedule, count)
+		ids := make([]int64, count)
+		for i := range jobs {
+			id := int64(i + 1)
+			jobs[i] = JobWithSchedule{rawJob: RawJob{ID: id, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 1s",
+				Labels: []JobLabel{{JobID: id, Key: "env", Value: "test"}, {JobID: id, Key: "index", Value: fmt.Sprint(i)}}},
+				run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: id}}
+			ids[i] = id
+		}
+		if err := repository.AddJobs(jobs); err != nil {
+			t.Fatalf("Cannot add jobs: %s", err.Error())
+		}
+		rawJobs, err := repository.ListJobs(&ListJobsOptions{JobFilters: JobFilters{LabelSelector: LabelSelector{
+			{Key: "env", Operator: LabelEquals, Values: []string{"test"}},
+		}}})
+		if err != nil {
+			t.Fatalf("Cannot list jobs: %s", err.Error())
+		}
+		if !equalIDs(getIdsFromJobRawList(rawJobs), ids) {
+			t.Errorf("Wrong jobs listed: %v", getIdsFromJobRawList(rawJobs))
+		}
+		for _, rawJob := range rawJobs {
+			if len(rawJob.Labels) != 2 {
+				t.Errorf("Wrong labels of job %d: %v", rawJob.ID, rawJob.Labels)
+			}
+		}
+		if err = repository.DeleteJobsByIds(ids); err != nil {
+			t.Fatalf("Cannot delete jobs: %s", err.Error())
+		}
+	}
+}
+
+// TestJobQuerySQL tests the rendering of a jobQuery to SQL.
+func TestJobQuerySQL(t *testing.T) {
+	paused := false
+	query := newJobQuery(&ListJobsOptions{
+		Paused:     &paused,
+		JobIDs:     []int64{1, 2, 3},
+		JobFilters: JobFilters{LabelSelector: LabelSelector{{Key: "env", Operator: LabelNotIn, Values: []string{}}}},
+	})
+	if err := query.paginate(&ListJobsOptions{SortBy: SortByCreatedAt, Descending: true, Limit: 10}); err != nil {
+		t.Fatalf("Cannot paginate: %s", err.Error())
+	}
+	where, args := query.whereSQL()
+	expected := " where (paused = ?) and (id in (?, ?, ?)) and " +
+		"(id not in (select job_id from job_labels where label_key = ? and label_value in (null)))"
+	if where != expected {
+		t.Errorf("Wrong where clause. Got: %s, expected: %s", where, expected)
+	}
+	if expectedArgs := []interface{}{false, int64(1), int64(2), int64(3), "env"}; !reflect.DeepEqual(args, expectedArgs) {
+		t.Errorf("Wrong arguments. Got: %v, expected: %v", args, expectedArgs)
+	}
+	if tail := query.tailSQL(); tail != " order by created_at desc, id desc limit 10" {
+		t.Errorf("Wrong tail: %s", tail)
+	}
+	if rebound := rebind(SQLDialectPostgres, where); strings.Contains(rebound, "?") || !strings.Contains(rebound, "$5") {
+		t.Errorf("Wrong rebound query: %s", rebound)
+	}
+}
+
+// TestRepositorySQLTx tests that the operations are executed within
+// the transaction of the caller, and that a failed operation is rolled
+// back to its savepoint without aborting the transaction.
+func TestRepositorySQLTx(t *testing.T) {
+	for _, repository := range buildSQLRepositories(t) {
+		repositorySQL := repository.(*RepositorySQL)
+		_, err := repositorySQL.WithTx(repositorySQL.db)
+		checkErrorIsOf(err, ErrInvalidTx, t)
+
+		job := JobWithSchedule{rawJob: RawJob{ID: 1, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 1s"},
+			run: &TestCronJobNoop{}, runInput: CronJobInput{JobID: 1}}
+		tx, err := repositorySQL.db.Begin()
+		if err != nil {
+			t.Fatalf("Cannot begin: %s", err.Error())
+		}
+		txRepository, err := repositorySQL.WithTx(tx)
+		if err != nil {
+			t.Fatalf("Cannot use the transaction: %s", err.Error())
+		}
+		if err = txRepository.AddJobs([]JobWithSchedule{job}); err != nil {
+			t.Fatalf("Cannot add job: %s", err.Error())
+		}
+		if err = tx.Rollback(); err != nil {
+			t.Fatalf("Cannot roll back: %s", err.Error())
+		}
+		_, err = repository.GetJob(1)
+		checkErrorIsOf(err, ErrSQLJobNotFound, t)
+		if errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("A missing job should not be reported as sql.ErrNoRows")
+		}
+
+		if tx, err = repositorySQL.db.Begin(); err != nil {
+			t.Fatalf("Cannot begin: %s", err.Error())
+		}
+		if txRepository, err = repositorySQL.WithTx(tx); err != nil {
+			t.Fatalf("Cannot use the transaction: %s", err.Error())
+		}
+		if err = txRepository.AddJobs([]JobWithSchedule{job}); err != nil {
+			t.Fatalf("Cannot add job: %s", err.Error())
+		}
+		checkErrorIsOf(txRepository.DeleteJobsByIds([]int64{1, 2}), ErrSQLJobNotFound, t)
+		if err = tx.Commit(); err != nil {
+			t.Fatalf("Cannot commit: %s", err.Error())
+		}
+		if _, err = repository.GetJob(1); err != nil {
+			t.Errorf("The job should have been added: %s", err.Error())
+		}
+		if err = repository.DeleteJobsByIds([]int64{1}); err != nil {
+			t.Fatalf("Cannot delete job: %s", err.Error())
+		}
+	}
+}
+
+// TestRepositorySQLOutbox tests that the entries of the outbox are stored
+// within the transactions, and listed by creation time.
+func TestRepositorySQLOutbox(t *testing.T) {
+	for _, repository := range buildSQLRepositories(t) {
+		repositorySQL := repository.(*RepositorySQL)
+		now := time.Now().Truncate(time.Millisecond)
+		entries := make([]OutboxEntry, sqlBatchSize+3)
+		for i := range entries {
+			entries[i] = OutboxEntry{ID: int64(len(entries) - i), Type: EventJobAdded, JobID: int64(i),
+				CreatedAt: now.Add(time.Duration(i) * time.Second)}
+		}
+		// the entries of a transaction rolled back are discarded
+		err := repositorySQL.Transaction(func(repository Repository) error {
+			if err := repository.(OutboxRepository).AddOutboxEntries(entries); err != nil {
+				return err
+			}
+			return repository.DeleteJobsByIds([]int64{-1})
+		})
+		checkErrorIsOf(err, ErrSQLJobNotFound, t)
+		checkOutboxEmpty(repositorySQL, t)
+
+		if err = repositorySQL.Transaction(func(repository Repository) error {
+			return repository.(OutboxRepository).AddOutboxEntries(entries)
+		}); err != nil {
+			t.Fatalf("Cannot add the entries: %s", err.Error())
+		}
+		listed, err := repositorySQL.ListOutboxEntries(1)
+		if err != nil {
+			t.Fatalf("Cannot list the entries: %s", err.Error())
+		}
+		if len(listed) != 0 {
+			t.Errorf("The entries of node 0 should not be listed for node 1. Got: %d", len(listed))
+		}
+		if listed, err = repositorySQL.ListOutboxEntries(0); err != nil {
+			t.Fatalf("Cannot list the entries: %s", err.Error())
+		}
+		if len(listed) != len(entries) {
+			t.Fatalf("Wrong number of entries. Got: %d, expected: %d", len(listed), len(entries))
+		}
+		for i := range listed {
+			if listed[i].ID != entries[i].ID || listed[i].JobID != entries[i].JobID || listed[i].Type != EventJobAdded {
+				t.Errorf("Wrong entry. Got: %+v, expected: %+v", listed[i], entries[i])
+			}
+		}
+		ids := make([]int64, len(entries))
+		for i := range entries {
+			ids[i] = entries[i].ID
+		}
+		if err = repositorySQL.DeleteOutboxEntries(ids); err != nil {
+			t.Fatalf("Cannot delete the entries: %s", err.Error())
+		}
+		checkOutboxEmpty(repositorySQL, t)
+	}
+}
@@ -0,0 +1,1184 @@
+package smallben
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	KeyTestPgDbName    = "TEST_DATABASE_PG"
+	KeyTestMySQLDbName = "TEST_DATABASE_MYSQL"
+)
+
+var (
+	// accessed by the TestCronJobNoop
+	// indexed by the id of the rawJob
+	accessed = make(map[int64]CronJobInput)
+
+	pgConn    = os.Getenv(KeyTestPgDbName)
+	mysqlConn = os.Getenv(KeyTestMySQLDbName)
+
+	// sqlitePath is the SQLite database used by the tests,
+	// which always run against it.
+	sqlitePath = filepath.Join(os.TempDir(), "smallben_test.db")
+)
+
+func init() {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		_ = os.Remove(sqlitePath + suffix)
+	}
+	gob.Register(&TestCronJobNoop{})
+	gob.Register(&TestCronJobModifyMap{})
+}
+
+// fake struct implementing the CronJob interface
+// it just writes its input into the accessed map
+type TestCronJobNoop struct{}
+
+func (t *TestCronJobNoop) Run(input CronJobInput) {
+	accessed[input.JobID] = input
+}
+
+// fake struct implementing the CronJob interface
+// it writes its input into the accessed map
+// but the value at input.JobId is the sum
+// of JobID, GroupID and SuperGroupID
+type TestCronJobModifyMap struct{}
+
+func (t *TestCronJobModifyMap) Run(input CronJobInput) {
+	input.JobID = input.JobID + input.GroupID + input.SuperGroupID
+	accessed[input.JobID] = input
+}
+
+type RepositoryTestSuite struct {
+	repository Repository
+	jobsToAdd  []JobWithSchedule
+}
+
+func NewRepositoryTestSuite(repository Repository) *RepositoryTestSuite {
+	return &RepositoryTestSuite{repository: repository}
+}
+
+// TestAddNoError tests that adding a series of jobsToAdd works.
+// Checks various way of retrieve the rawJob, including
+// the execution of a retrieved rawJob.
+func (r *RepositoryTestSuite) TestAddNoError(t *testing.T) {
+
+	// add them
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Fail to add jobsToAdd: %s\n", err.Error())
+	}
+
+	// retrieve them using GetRawByIds
+	rawJobs, err := r.repository.ListJobs(&ListJobsOptions{JobIDs: getIdsFromJobsWithScheduleList(r.jobsToAdd)})
+	if err != nil {
+		t.Errorf("Cannot get raw jobs from id: %s\n", err.Error())
+	}
+	if len(rawJobs) != len(r.jobsToAdd) {
+		t.Errorf("The number of retrieved test is wrong. Got %d, expected: %d\n", len(rawJobs), len(r.jobsToAdd))
+	}
+	// and also using GetJobsByIds
+	jobsWithSchedule, err := r.repository.GetJobsByIds(getIdsFromJobsWithScheduleList(r.jobsToAdd))
+	if err != nil {
+		t.Errorf("Cannot get jobs from id: %s\n", err.Error())
+	}
+	if len(jobsWithSchedule) != len(r.jobsToAdd) {
+		t.Errorf("The number of retrieved test is wrong. Got %d, expected: %d\n", len(jobsWithSchedule), len(r.jobsToAdd))
+	}
+
+	// build the ids making sure they match
+	gotIds := getIdsFromJobRawList(rawJobs)
+	expectedIds := getIdsFromJobsWithScheduleList(r.jobsToAdd)
+	if !reflect.DeepEqual(gotIds, expectedIds) {
+		t.Errorf("The retrieved jobs id is wrong. Got\n%+v\nExpected\n%+v\n", gotIds, expectedIds)
+	}
+
+	// retrieve them using GetAllJobsToExecute
+	jobs, err := r.repository.GetAllJobsToExecute()
+	if err != nil {
+		t.Errorf("Cannot get the list of jobs to execute: %s\n", err.Error())
+	}
+	if len(jobs) != len(r.jobsToAdd) {
+		t.Errorf("The number of retrieved jobs to execute is wrong. Got %d, expected: %d", len(jobs), len(r.jobsToAdd))
+	}
+
+	gotIds = getIdsFromJobsWithScheduleList(jobs)
+	if !reflect.DeepEqual(gotIds, expectedIds) {
+		t.Errorf("The expected ids are wrong. Got\n%+v\nExpected\n%+v\n", gotIds, expectedIds)
+	}
+
+	// the missing jobs are skipped on request
+	rawJobs, err = r.repository.ListJobs(&ListJobsOptions{JobIDs: []int64{expectedIds[0], 10000}, AllowMissing: true})
+	if err != nil {
+		t.Errorf("Cannot list the jobs allowing missing ones: %s\n", err.Error())
+	}
+	if gotIds = getIdsFromJobRawList(rawJobs); !reflect.DeepEqual(gotIds, expectedIds[:1]) {
+		t.Errorf("Wrong jobs listed allowing missing ones. Got %v\n", gotIds)
+	}
+
+	// the jobs cannot be added twice
+	checkErrorIsOf(r.repository.AddJobs(r.jobsToAdd[:1]), ErrDuplicateJob, t)
+
+	// retrieve one of them
+	job, err := r.repository.GetJob(r.jobsToAdd[0].rawJob.ID)
+	if err != nil {
+		t.Errorf("Fail to retrieve single rawJob: %s\n", err.Error())
+		t.FailNow()
+	}
+	// this check won't work because of time difference
+	// r.Equal(rawJob.rawJob, r.jobsToAdd[0].rawJob)
+
+	// also, checking that the input has been correctly
+	// recovered
+	if !reflect.DeepEqual(job.runInput, r.jobsToAdd[0].runInput) {
+		t.Errorf("The retrieved rawJob is wrong. Got\n%+v\nExpected\n%+v\n", job.runInput, r.jobsToAdd[0].runInput)
+	}
+	// and now execute it
+	job.run.Run(job.runInput)
+	// making sure it has been executed
+	// we have to do some type assertions to make the proper check
+	// since TestCronJobNoop just places its values
+	// but TestCronJobModifyMap sets another value for job id
+	var expectedInput CronJobInput
+	if reflect.TypeOf(job.run) == reflect.TypeOf(&TestCronJobNoop{}) {
+		expectedInput = r.jobsToAdd[0].runInput
+	} else if reflect.TypeOf(job.run) == reflect.TypeOf(&TestCronJobModifyMap{}) {
+		expectedInput = r.jobsToAdd[0].runInput
+		expectedInput.JobID = expectedInput.JobID + expectedInput.GroupID + expectedInput.SuperGroupID
+	} else {
+		t.Errorf("Cannot do type interference. Type is: %s\n", reflect.TypeOf(job.run).String())
+		t.FailNow()
+	}
+	// now, let's compare
+	if !reflect.DeepEqual(accessed[job.rawJob.ID], expectedInput) {
+		t.Errorf("The rawJob has not been executed correctly. Got\n%+v\nExpected\n%+v\n", accessed[job.rawJob.ID], expectedInput)
+	}
+}
+
+func (r *RepositoryTestSuite) TestPauseJobs(t *testing.T) {
+	// add the jobs, this way we can pause them
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// get the length of jobs to execute.
+	// we will use it for later comparison
+	jobsToExecuteBefore, err := r.repository.GetAllJobsToExecute()
+	if err != nil {
+		t.Errorf("Fail to get all jobs to execute before pause: %s\n", err.Error())
+		t.FailNow()
+	}
+	lenOfJobsToExecuteBefore := len(jobsToExecuteBefore)
+
+	// and now we can pause them
+	// we need to convert to the raw format
+	rawJobs := make([]RawJob, len(r.jobsToAdd))
+	for i, job := range r.jobsToAdd {
+		rawJobs[i] = job.rawJob
+	}
+
+	// effectively pause them
+	err = r.repository.PauseJobs(rawJobs)
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+	}
+
+	// now, compute the number of jobs to execute now
+	jobsToExecuteAfterPause, err := r.repository.GetAllJobsToExecute()
+	if err != nil {
+		t.Errorf("Fail to get all jobs to execute after pause: %s\n", err.Error())
+		t.FailNow()
+	}
+	lenOfJobsToExecuteAfterPause := len(jobsToExecuteAfterPause)
+
+	if lenOfJobsToExecuteBefore != lenOfJobsToExecuteAfterPause+len(r.jobsToAdd) {
+		t.Errorf("Something went wrong during the pause. Got\n%d\nExpected\n%d\n",
+			lenOfJobsToExecuteBefore, lenOfJobsToExecuteAfterPause-len(r.jobsToAdd))
+	}
+
+	// now, resume them
+	err = r.repository.ResumeJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Fail to resume jobs: %s\n", err.Error())
+	}
+	// re-grab the number of jobs
+	jobsToExecuteAfterResume, err := r.repository.GetAllJobsToExecute()
+	if err != nil {
+		t.Errorf("Fail to get all jobs to execute after resume: %s\n", err.Error())
+		t.FailNow()
+	}
+	lenOfJobsToExecuteAfterResume := len(jobsToExecuteAfterResume)
+
+	if lenOfJobsToExecuteAfterResume != len(r.jobsToAdd) {
+		t.Errorf("Something went wrong during resume. Got\n%d\nExpected\n%d\n",
+			lenOfJobsToExecuteAfterResume, len(r.jobsToAdd))
+	}
+}
+
+func (r *RepositoryTestSuite) TestCronId(t *testing.T) {
+	// first, add the jobs
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	counter := int64(10)
+	// now, change the cron id
+	for i := range r.jobsToAdd {
+		r.jobsToAdd[i].rawJob.CronID = counter
+		counter += 10
+	}
+
+	// now, save
+	err = r.repository.SetCronId(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot change cron id: %s", err.Error())
+		t.FailNow()
+	}
+
+	// and retrieve
+	jobs, err := r.repository.GetJobsByIds(getIdsFromJobsWithScheduleList(r.jobsToAdd))
+	counter = 0
+	for i, job := range jobs {
+		if job.rawJob.CronID != counter+10 {
+			t.Errorf("Cron id not set. Got: %d Expected: %d\n", job.rawJob.CronID, int64(i+10))
+		}
+		counter += 10
+	}
+
+	newCronID := int64(100)
+	newSchedule := "@every 100s"
+	newJobInput := map[string]interface{}{
+		"never free": "never me",
+	}
+
+	// now we do the same, but we also change the schedule
+	// and the input
+	for i := range r.jobsToAdd {
+		r.jobsToAdd[i].rawJob.CronID = newCronID
+		r.jobsToAdd[i].rawJob.CronExpression = newSchedule
+		r.jobsToAdd[i].runInput.OtherInputs = newJobInput
+	}
+
+	err = r.repository.SetCronIdAndChangeScheduleAndJobInput(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Fail to set cron id and change schedule: %s", err.Error())
+	}
+
+	// retrieve and check
+	jobs, err = r.repository.GetJobsByIds(getIdsFromJobsWithScheduleList(r.jobsToAdd))
+	for _, job := range jobs {
+		if job.rawJob.CronID != newCronID {
+			t.Errorf("Cron id not set. Got: %d Expected: %d\n", job.rawJob.CronID, newCronID)
+		}
+		if job.rawJob.CronExpression != newSchedule {
+			t.Errorf("Schedule not changed. Got: %s Expected: %s\n", job.rawJob.CronExpression, newSchedule)
+		}
+		if !reflect.DeepEqual(job.runInput.OtherInputs, newJobInput) {
+			t.Errorf("OtherInputs not changed. Got:\n%v\nExpected:\n%v\n", job.runInput.OtherInputs, newJobInput)
+		}
+	}
+}
+
+func (r *RepositoryTestSuite) TestList(t *testing.T) {
+	// first, let's add all jobs
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// and retrieve them
+	jobs, err := r.repository.ListJobs(nil)
+	if err != nil {
+		t.Errorf("Cannot list jobs: %s", err.Error())
+	}
+	if len(jobs) != len(r.jobsToAdd) {
+		t.Errorf("Count mismatch. Got: %d Expected: %d\n", len(jobs), len(r.jobsToAdd))
+	}
+
+	// now, pause one of them.
+	err = r.repository.PauseJobs([]RawJob{r.jobsToAdd[0].rawJob})
+	if err != nil {
+		t.Errorf("Cannot pause job: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// re-retrieve using paused = true
+	paused := true
+	options := ListJobsOptions{
+		Paused:        &paused,
+		GroupIDs:      nil,
+		SuperGroupIDs: nil,
+	}
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Cannot get paused jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 {
+		t.Errorf("Paused = true count mismatch: Got %d Expected: %d\n", len(jobs), 1)
+		t.FailNow()
+	}
+
+	// now do the same using paused = false
+	paused = false
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Cannot get unpaused jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(r.jobsToAdd)-1 {
+		t.Errorf("Paused = false count mismatch: Got %d Expected: %d\n",
+			len(jobs), len(r.jobsToAdd)-1)
+		t.FailNow()
+	}
+
+	// resume the job that have been paused
+	err = r.repository.ResumeJobs([]JobWithSchedule{r.jobsToAdd[0]})
+	if err != nil {
+		t.Errorf("Cannot resume job: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// now, we grab the list of unique groups
+	// and super groups
+	groups := getUniqueGroupID(r.jobsToAdd)
+	superGroups := getUniqueSuperGroupID(r.jobsToAdd)
+
+	// query using all the available group ids
+	// should return all jobs
+	options.Paused = nil
+	options.GroupIDs = groups
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by group ids: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(r.jobsToAdd) {
+		t.Errorf("GroupIDs = all count mismatch. Got: %d Expected: %d\n",
+			len(jobs), len(r.jobsToAdd))
+		t.FailNow()
+	}
+
+	// now, let's pause a job within the group
+	// to use the paused field
+	err = r.repository.PauseJobs([]RawJob{{
+		ID: jobs[0].ID,
+	}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	paused = true
+	options.Paused = &paused
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by group and paused: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 {
+		t.Errorf(`GroupIDs = all & paused = true count mismatch
+Got: %d Expected: %d\n`, len(jobs), 1)
+		t.FailNow()
+	}
+
+	// now test with pause = false
+	paused = false
+	options.Paused = &paused
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by group and paused: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(r.jobsToAdd)-1 {
+		t.Errorf(`GroupIDs = all & paused = false count mismatch
+Got: %d Expected: %d\n`, len(jobs), len(r.jobsToAdd)-1)
+		t.FailNow()
+	}
+
+	// do the same, also for super groups
+	options.Paused = nil
+	options.GroupIDs = nil
+	options.SuperGroupIDs = superGroups
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by super group ids: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(r.jobsToAdd) {
+		t.Errorf("GroupIDs = all count mismatch. Got: %d Expected: %d\n",
+			len(jobs), len(r.jobsToAdd))
+		t.FailNow()
+	}
+
+	// now, select just a group
+	group := groups[0]
+	// compute all the jobs it should return
+	var jobsByGroup []int64
+	for _, job := range r.jobsToAdd {
+		if job.rawJob.GroupID == group {
+			jobsByGroup = append(jobsByGroup, job.rawJob.ID)
+		}
+	}
+	options.GroupIDs = []int64{group}
+	options.SuperGroupIDs = nil
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by subset of group ids: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(jobsByGroup) {
+		t.Errorf("GroupIDs = groups[0] count mismatch. Got: %d Expected: %d\n",
+			len(jobs), len(jobsByGroup))
+		t.FailNow()
+	}
+
+	// do the same, also for the super-group
+	// this time, using an array of more than one item.
+	superGroupsToFilter := superGroups[len(superGroups)-2:]
+	options.SuperGroupIDs = superGroupsToFilter
+	options.GroupIDs = nil
+	var jobsBySuperGroup []int64
+	for _, job := range r.jobsToAdd {
+		for _, superGroupId := range superGroupsToFilter {
+			if job.rawJob.SuperGroupID == superGroupId {
+				jobsBySuperGroup = append(jobsBySuperGroup, job.rawJob.ID)
+				break
+			}
+		}
+	}
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by subset of super group ids: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(jobsBySuperGroup) {
+		t.Errorf("SuperGroupIDs = superGroups[-2:] count mismatch. Got: %d Expected: %d\n",
+			len(jobs), len(jobsBySuperGroup))
+		t.FailNow()
+	}
+
+	// now, we pause of such jobs
+	err = r.repository.PauseJobs([]RawJob{{
+		ID: jobs[0].ID,
+	}})
+	if err != nil {
+		t.Errorf("Cannot pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	paused = true
+	options.Paused = &paused
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by paused and super group id: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 {
+		t.Errorf("SuperGroupIDs = superGroups[-2:] & paused = true count mismatch. Got: %d Expected: %d\n`",
+			len(jobs), 1)
+		t.FailNow()
+	}
+
+	// now, we do the same by with paused = false
+	paused = false
+	options.Paused = &paused
+	jobs, err = r.repository.ListJobs(&options)
+	if err != nil {
+		t.Errorf("Fail to get jobs by paused and super group id: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != len(jobsBySuperGroup)-1 {
+		t.Errorf(`SuperGroupIDs = superGroups[-2:] & paused = false count mismatch
+Got: %d Expected: %d\n`, len(jobs), len(jobsBySuperGroup)-1)
+		t.FailNow()
+	}
+}
+
+func (r *RepositoryTestSuite) TestCount(t *testing.T) {
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = r.repository.PauseJobs([]RawJob{r.jobsToAdd[0].rawJob})
+	if err != nil {
+		t.Errorf("Cannot pause job: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	paused := true
+	pairs := []struct {
+		options  ToListOptions
+		expected int64
+	}{
+		{nil, int64(len(r.jobsToAdd))},
+		{&ListJobsOptions{Paused: &paused}, 1},
+		{&ListJobsOptions{GroupIDs: []int64{1}, SuperGroupIDs: []int64{1}}, 3},
+		{&PauseResumeOptions{JobIDs: []int64{1, 2, 10000}}, 2},
+	}
+	for _, pair := range pairs {
+		count, err := r.repository.CountJobs(pair.options)
+		if err != nil {
+			t.Errorf("Cannot count jobs: %s\n", err.Error())
+			t.FailNow()
+		}
+		if count != pair.expected {
+			t.Errorf("Count mismatch. Got: %d Expected: %d\n", count, pair.expected)
+		}
+	}
+}
+
+// checkErrorIsOf checks that `err` is of type `expected`. If `err`
+// is nil, fails showing `msg`.
+// newTestDir returns a new temporary directory,
+// removed along with its files when the test ends.
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "smallben")
+	if err != nil {
+		t.Fatalf("Cannot create the directory: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func checkErrorIsOf(err, expected error, t *testing.T) {
+	if err == nil {
+		t.Errorf("%s error expected. Should have been not nil.\n", expected.Error())
+		t.FailNow()
+	} else if !errors.Is(err, expected) {
+		t.Errorf("Error is of wrong type: %s\n", err.Error())
+		t.FailNow()
+	}
+}
+
+// checkErrorMsg checks that `err` contains `msg`, failing otherwise.
+// if `err` is nil it fails.
+func checkErrorMsg(err error, msg string, t *testing.T) {
+	if err == nil {
+		t.Errorf("%s error expected. Should have been not nil.\n", msg)
+		t.FailNow()
+	}
+	if !strings.Contains(err.Error(), msg) {
+		t.Errorf("Error '%s' does not contain '%s'\n", err.Error(), msg)
+		t.FailNow()
+	}
+}
+
+func (r *RepositoryTestSuite) TestPauseJobNotExisting(t *testing.T) {
+	notExisting := RawJob{
+		ID:     1000,
+		CronID: 10000,
+	}
+
+	// get with just one
+	_, err := r.repository.GetJob(1000)
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// get with many
+	_, err = r.repository.GetJobsByIds([]int64{10000})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// get with many -- raw
+	_, err = r.repository.ListJobs(&ListJobsOptions{JobIDs: []int64{10000}})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// pause
+	err = r.repository.PauseJobs([]RawJob{notExisting})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// resume
+	err = r.repository.ResumeJobs([]JobWithSchedule{{rawJob: notExisting}})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// set cron id
+	err = r.repository.SetCronId([]JobWithSchedule{{rawJob: notExisting}})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+	// set cron id and change schedule
+	err = r.repository.SetCronIdAndChangeScheduleAndJobInput([]JobWithSchedule{{rawJob: notExisting}})
+	checkErrorIsOf(err, r.repository.ErrorTypeIfMismatchCount(), t)
+
+}
+
+func scheduleNeverFail(t *testing.T, seconds int) cron.Schedule {
+	res, err := cron.ParseStandard(fmt.Sprintf("@every %ds", seconds))
+	if err != nil {
+		t.Error("Schedule conversions should not fail")
+		t.FailNow()
+	}
+	return res
+}
+
+func (r *RepositoryTestSuite) setup(t *testing.T) {
+	// 3 jobs in (group 1, super group 1)
+	// 1 job in (group 2, super group 1)
+	// 1 job in (group 1, super group 2)
+	// 1 job in (group 3, super group 3)
+	r.jobsToAdd = []JobWithSchedule{
+		{
+			rawJob: RawJob{
+				ID:             1,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 60s",
+			},
+			run: &TestCronJobNoop{},
+			runInput: CronJobInput{
+				JobID:          1,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 60s",
+				OtherInputs: map[string]interface{}{
+					"hello": "is there anybody in there?",
+				},
+			},
+			schedule: scheduleNeverFail(t, 30),
+		},
+		{
+			rawJob: RawJob{
+				ID:             2,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+			},
+			run: &TestCronJobNoop{},
+			runInput: CronJobInput{
+				JobID:          2,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+				OtherInputs: map[string]interface{}{
+					"when I was a child": "I had fever",
+				},
+			},
+			schedule: scheduleNeverFail(t, 60),
+		},
+		{
+			rawJob: RawJob{
+				ID:             3,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+			},
+			run: &TestCronJobModifyMap{},
+			runInput: CronJobInput{
+				JobID:          3,
+				GroupID:        1,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+				OtherInputs: map[string]interface{}{
+					"when I was a child": "I had fever",
+				},
+			},
+			schedule: scheduleNeverFail(t, 60),
+		},
+		{
+			rawJob: RawJob{
+				ID:             4,
+				GroupID:        2,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+			},
+			run: &TestCronJobNoop{},
+			runInput: CronJobInput{
+				JobID:          4,
+				GroupID:        2,
+				SuperGroupID:   1,
+				CronExpression: "@every 120s",
+				OtherInputs: map[string]interface{}{
+					"when I was a child": "I had fever",
+				},
+			},
+			schedule: scheduleNeverFail(t, 60),
+		},
+		{
+			rawJob: RawJob{
+				ID:             5,
+				GroupID:        1,
+				SuperGroupID:   2,
+				CronExpression: "@every 120s",
+			},
+			run: &TestCronJobNoop{},
+			runInput: CronJobInput{
+				JobID:          5,
+				GroupID:        1,
+				SuperGroupID:   2,
+				CronExpression: "@every 120s",
+				OtherInputs: map[string]interface{}{
+					"when I was a child": "I had fever",
+				},
+			},
+			schedule: scheduleNeverFail(t, 60),
+		},
+		{
+			rawJob: RawJob{
+				ID:             6,
+				GroupID:        3,
+				SuperGroupID:   3,
+				CronExpression: "@every 120s",
+			},
+			run: &TestCronJobNoop{},
+			runInput: CronJobInput{
+				JobID:          6,
+				GroupID:        3,
+				SuperGroupID:   3,
+				CronExpression: "@every 120s",
+				OtherInputs: map[string]interface{}{
+					"when I was a child": "I had fever",
+				},
+			},
+			schedule: scheduleNeverFail(t, 60),
+		},
+	}
+}
+
+func (r *RepositoryTestSuite) teardown(okNotFound bool, t *testing.T) {
+	err := r.repository.DeleteJobsByIds(getIdsFromJobsWithScheduleList(r.jobsToAdd))
+	if err != nil {
+		if !(okNotFound && errors.Is(err, r.repository.ErrorTypeIfMismatchCount())) {
+			t.Errorf("Cannot delete jobs on teardown: %s\n", err.Error())
+		}
+	}
+}
+
+func buildRepositoryTestSuite(t *testing.T) []*RepositoryTestSuite {
+	repositories := buildSQLRepositories(t)
+	bolt, _ := newBoltTestRepository(t)
+	redis, _ := newRedisTestRepository(t, os.Getenv(KeyTestRedisAddr))
+	repositories = append(repositories, bolt, redis)
+	tests := make([]*RepositoryTestSuite, len(repositories))
+	for i, repository := range repositories {
+		tests[i] = NewRepositoryTestSuite(repository)
+	}
+	return tests
+}
+
+func TestRepositoryAddTestSuite(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestAddNoError(t)
+		test.teardown(false, t)
+	}
+}
+
+func TestRepositoryPauseResume(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestPauseJobs(t)
+		test.teardown(false, t)
+	}
+}
+
+func TestRepositoryCronId(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestCronId(t)
+		test.teardown(false, t)
+	}
+}
+
+func TestRepositoryList(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestList(t)
+		test.teardown(true, t)
+	}
+}
+
+func TestRepositoryCount(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestCount(t)
+		test.teardown(false, t)
+	}
+}
+
+func TestRepositoryError(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestPauseJobNotExisting(t)
+		test.teardown(true, t)
+	}
+}
+
+func getUniqueGroupID(jobs []JobWithSchedule) []int64 {
+	var ids []int64
+	for _, job := range jobs {
+		found := false
+		for _, id := range ids {
+			if id == job.rawJob.GroupID {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			ids = append(ids, job.rawJob.GroupID)
+		}
+	}
+	return ids
+}
+
+func getUniqueSuperGroupID(jobs []JobWithSchedule) []int64 {
+	var ids []int64
+	for _, job := range jobs {
+		found := false
+		for _, id := range ids {
+			if id == job.rawJob.SuperGroupID {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			ids = append(ids, job.rawJob.SuperGroupID)
+		}
+	}
+	return ids
+}
+
+// listPages lists all the pages of jobs according to
+// `options`, returning the IDs of the jobs in each page.
+func (r *RepositoryTestSuite) listPages(options ListJobsOptions, t *testing.T) [][]int64 {
+	var pages [][]int64
+	for {
+		jobs, err := r.repository.ListJobs(&options)
+		if err != nil {
+			t.Errorf("Cannot list jobs: %s\n", err.Error())
+			t.FailNow()
+		}
+		pages = append(pages, getIdsFromJobRawList(jobs))
+		token, err := options.nextPageToken(jobs)
+		if err != nil {
+			t.Errorf("Cannot build the page token: %s\n", err.Error())
+			t.FailNow()
+		}
+		if token == "" || len(pages) > len(r.jobsToAdd) {
+			return pages
+		}
+		options.PageToken = token
+	}
+}
+
+func (r *RepositoryTestSuite) TestListPage(t *testing.T) {
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	now := time.Now()
+	for id, nextRunAt := range map[int64]time.Time{3: now.Add(2 * time.Hour), 5: now.Add(time.Hour)} {
+		nextRunAt := nextRunAt
+		if err = r.repository.SetNextRunAt(id, &nextRunAt); err != nil {
+			t.Errorf("Cannot set next run: %s\n", err.Error())
+			t.FailNow()
+		}
+	}
+	checkErrorIsOf(r.repository.SetNextRunAt(10000, &now), r.repository.ErrorTypeIfMismatchCount(), t)
+
+	pairs := []struct {
+		options  ListJobsOptions
+		expected [][]int64
+	}{
+		{ListJobsOptions{Limit: 4}, [][]int64{{1, 2, 3, 4}, {5, 6}}},
+		{ListJobsOptions{Limit: 3}, [][]int64{{1, 2, 3}, {4, 5, 6}, {}}},
+		{ListJobsOptions{Limit: 4, Descending: true}, [][]int64{{6, 5, 4, 3}, {2, 1}}},
+		{ListJobsOptions{Limit: 2, Offset: 2}, [][]int64{{3, 4}, {5, 6}, {}}},
+		{ListJobsOptions{Limit: 2, JobIDs: []int64{1, 2, 3}}, [][]int64{{1, 2}, {3}}},
+		{ListJobsOptions{Limit: 2, SortBy: SortByNextRunAt}, [][]int64{{5, 3}, {1, 2}, {4, 6}, {}}},
+		{ListJobsOptions{Limit: 2, SortBy: SortByNextRunAt, Descending: true}, [][]int64{{6, 4}, {2, 1}, {3, 5}, {}}},
+	}
+	for _, pair := range pairs {
+		pages := r.listPages(pair.options, t)
+		if len(pages) != len(pair.expected) {
+			t.Errorf("Pages mismatch for %+v. Got: %v Expected: %v\n", pair.options, pages, pair.expected)
+			continue
+		}
+		for i := range pages {
+			if !reflect.DeepEqual(pages[i], pair.expected[i]) && len(pages[i])+len(pair.expected[i]) > 0 {
+				t.Errorf("Pages mismatch for %+v. Got: %v Expected: %v\n", pair.options, pages, pair.expected)
+				break
+			}
+		}
+	}
+
+	// jobs are created at about the same time,
+	// but no one is skipped nor repeated.
+	for _, field := range []JobSortField{SortByCreatedAt, SortByUpdatedAt} {
+		seen := make(map[int64]bool)
+		for _, page := range r.listPages(ListJobsOptions{Limit: 4, SortBy: field}, t) {
+			for _, id := range page {
+				if seen[id] {
+					t.Errorf("Job %d listed twice sorting by %s\n", id, field)
+				}
+				seen[id] = true
+			}
+		}
+		if len(seen) != len(r.jobsToAdd) {
+			t.Errorf("Count mismatch sorting by %s. Got: %d Expected: %d\n", field, len(seen), len(r.jobsToAdd))
+		}
+	}
+
+	// the token depends on the sorting
+	jobs, err := r.repository.ListJobs(&ListJobsOptions{Limit: 2})
+	if err != nil {
+		t.Errorf("Cannot list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	options := ListJobsOptions{Limit: 2}
+	token, err := options.nextPageToken(jobs)
+	if err != nil {
+		t.Errorf("Cannot build the page token: %s\n", err.Error())
+		t.FailNow()
+	}
+	_, err = r.repository.ListJobs(&ListJobsOptions{Limit: 2, PageToken: token, Descending: true})
+	checkErrorIsOf(err, ErrInvalidPageToken, t)
+	_, err = r.repository.ListJobs(&ListJobsOptions{Limit: 2, PageToken: "not a token"})
+	checkErrorIsOf(err, ErrInvalidPageToken, t)
+	_, err = r.repository.ListJobs(&ListJobsOptions{SortBy: "serialized_job"})
+	checkErrorIsOf(err, ErrInvalidSortField, t)
+}
+
+func TestRepositoryListPage(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestListPage(t)
+		test.teardown(false, t)
+	}
+}
+
+func (r *RepositoryTestSuite) TestListFilters(t *testing.T) {
+	// 12 makes sure the IDs are not matched by their prefix
+	r.jobsToAdd[0].rawJob.OnSuccess = encodeIDs([]int64{12, 3})
+	r.jobsToAdd[3].rawJob.OnFailure = encodeIDs([]int64{2})
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	now := time.Now()
+	nextRunAt := now.Add(time.Hour)
+	if err = r.repository.SetNextRunAt(2, &nextRunAt); err != nil {
+		t.Errorf("Cannot set next run: %s\n", err.Error())
+		t.FailNow()
+	}
+	for id, outcome := range map[int64]JobOutcome{1: JobOutcomeFailed, 4: JobOutcomeFailed, 5: JobOutcomeSucceeded} {
+		if err = r.repository.SetLastRun(id, now, outcome); err != nil {
+			t.Errorf("Cannot set last run: %s\n", err.Error())
+			t.FailNow()
+		}
+	}
+	checkErrorIsOf(r.repository.SetLastRun(10000, now, JobOutcomeFailed), r.repository.ErrorTypeIfMismatchCount(), t)
+
+	pairs := []struct {
+		filters  JobFilters
+		expected []int64
+	}{
+		{JobFilters{CreatedAt: &TimeRange{After: now.Add(-time.Hour)}}, []int64{1, 2, 3, 4, 5, 6}},
+		{JobFilters{CreatedAt: &TimeRange{Before: now.Add(-time.Hour)}}, nil},
+		{JobFilters{UpdatedAt: &TimeRange{After: now.Add(-time.Hour), Before: now.Add(time.Hour)}}, []int64{1, 2, 3, 4, 5, 6}},
+		{JobFilters{NextRunAt: &TimeRange{After: now}}, []int64{2}},
+		{JobFilters{NextRunAt: &TimeRange{Before: now}}, nil},
+		{JobFilters{ExcludeJobIDs: []int64{1, 2}}, []int64{3, 4, 5, 6}},
+		{JobFilters{ExcludeGroupIDs: []int64{1}}, []int64{4, 6}},
+		{JobFilters{ExcludeSuperGroupIDs: []int64{1, 2}}, []int64{6}},
+		{JobFilters{CronExpressions: []string{"@every 60s"}}, []int64{1}},
+		{JobFilters{LastOutcomes: []JobOutcome{JobOutcomeFailed}}, []int64{1, 4}},
+		{JobFilters{LastOutcomes: []JobOutcome{JobOutcomeFailed}, ExcludeGroupIDs: []int64{2}}, []int64{1}},
+		{JobFilters{TriggersJobIDs: []int64{3}}, []int64{1}},
+		{JobFilters{TriggersJobIDs: []int64{2}}, []int64{4}},
+		{JobFilters{TriggersJobIDs: []int64{1}}, nil},
+		{JobFilters{TriggersJobIDs: []int64{2, 12}}, []int64{1, 4}},
+	}
+	for _, pair := range pairs {
+		jobs, err := r.repository.ListJobs(&ListJobsOptions{JobFilters: pair.filters})
+		if err != nil {
+			t.Errorf("Cannot list jobs: %s\n", err.Error())
+			t.FailNow()
+		}
+		if ids := getIdsFromJobRawList(jobs); len(ids)+len(pair.expected) > 0 && !reflect.DeepEqual(ids, pair.expected) {
+			t.Errorf("Filter mismatch for %+v. Got: %v Expected: %v\n", pair.filters, ids, pair.expected)
+		}
+		count, err := r.repository.CountJobs(&ListJobsOptions{JobFilters: pair.filters})
+		if err != nil {
+			t.Errorf("Cannot count jobs: %s\n", err.Error())
+			t.FailNow()
+		}
+		if count != int64(len(pair.expected)) {
+			t.Errorf("Count mismatch for %+v. Got: %d Expected: %d\n", pair.filters, count, len(pair.expected))
+		}
+	}
+
+	// filters on the job ids do not fail if some jobs are filtered out
+	jobs, err := r.repository.ListJobs(&ListJobsOptions{JobIDs: []int64{1, 2},
+		JobFilters: JobFilters{LastOutcomes: []JobOutcome{JobOutcomeFailed}}})
+	if err != nil {
+		t.Errorf("Cannot list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(jobs) != 1 || jobs[0].LastOutcome != JobOutcomeFailed || jobs[0].LastRunAt == nil {
+		t.Errorf("Wrong jobs: %+v\n", jobs)
+	}
+}
+
+func TestRepositoryListFilters(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestListFilters(t)
+		test.teardown(false, t)
+	}
+}
+
+func (r *RepositoryTestSuite) TestListLabels(t *testing.T) {
+	labels := map[int64]map[string]string{
+		1: {"env": "prod", "team": "a"},
+		2: {"env": "prod", "team": "b"},
+		3: {"env": "dev"},
+		4: {"team": "a"},
+	}
+	for i := range r.jobsToAdd {
+		encoded, err := encodeLabels(r.jobsToAdd[i].rawJob.ID, labels[r.jobsToAdd[i].rawJob.ID])
+		if err != nil {
+			t.Errorf("Cannot encode labels: %s\n", err.Error())
+			t.FailNow()
+		}
+		r.jobsToAdd[i].rawJob.Labels = encoded
+	}
+	err := r.repository.AddJobs(r.jobsToAdd)
+	if err != nil {
+		t.Errorf("Cannot add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	job, err := r.repository.GetJob(1)
+	if err != nil {
+		t.Errorf("Cannot get job: %s\n", err.Error())
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(decodeLabels(job.rawJob.Labels), labels[1]) {
+		t.Errorf("Labels mismatch. Got: %v Expected: %v\n", decodeLabels(job.rawJob.Labels), labels[1])
+	}
+
+	pairs := []struct {
+		selector string
+		expected []int64
+	}{
+		{"env=prod", []int64{1, 2}},
+		{"env==prod,team=a", []int64{1}},
+		{"env!=prod", []int64{3, 4, 5, 6}},
+		{"team in (a, b)", []int64{1, 2, 4}},
+		{"team notin (a)", []int64{2, 3, 5, 6}},
+		{"env", []int64{1, 2, 3}},
+		{"!env", []int64{4, 5, 6}},
+		{"env=staging", nil},
+	}
+	for _, pair := range pairs {
+		selector, err := ParseLabelSelector(pair.selector)
+		if err != nil {
+			t.Errorf("Cannot parse selector %s: %s\n", pair.selector, err.Error())
+			t.FailNow()
+		}
+		jobs, err := r.repository.ListJobs(&ListJobsOptions{JobFilters: JobFilters{LabelSelector: selector}})
+		if err != nil {
+			t.Errorf("Cannot list jobs: %s\n", err.Error())
+			t.FailNow()
+		}
+		if ids := getIdsFromJobRawList(jobs); len(ids)+len(pair.expected) > 0 && !reflect.DeepEqual(ids, pair.expected) {
+			t.Errorf("Selector mismatch for %s. Got: %v Expected: %v\n", pair.selector, ids, pair.expected)
+		}
+		for _, job := range jobs {
+			if !selector.Matches(decodeLabels(job.Labels)) {
+				t.Errorf("Job %d does not match %s: %v\n", job.ID, pair.selector, decodeLabels(job.Labels))
+			}
+		}
+	}
+}
+
+func TestRepositoryListLabels(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestListLabels(t)
+		test.teardown(false, t)
+	}
+}
+
+// TestMigrate tests that the schema is at SchemaVersion, that migrating
+// again is a no-op, and that a newer schema is refused.
+func (r *RepositoryTestSuite) TestMigrate(t *testing.T) {
+	var repository interface {
+		Migrate() error
+		SchemaVersion() (int, error)
+	}
+	var db *sql.DB
+	var dialect string
+	switch backend := r.repository.(type) {
+	case *RepositorySQL:
+		repository, db, dialect = backend, backend.db, backend.dialect
+	default:
+		return
+	}
+	version, err := repository.SchemaVersion()
+	if err != nil {
+		t.Errorf("Cannot get the schema version: %s", err.Error())
+	}
+	if version != SchemaVersion {
+		t.Errorf("The schema version is wrong. Got %d, expected: %d", version, SchemaVersion)
+	}
+	if err = repository.Migrate(); err != nil {
+		t.Errorf("Cannot migrate again: %s", err.Error())
+	}
+
+	_, err = db.Exec(rebind(dialect, "insert into schema_version (version, description, applied_at) values (?, ?, ?)"),
+		SchemaVersion+1, "newer", time.Now())
+	if err != nil {
+		t.Fatalf("Cannot insert the newer version: %s", err.Error())
+	}
+	defer func() {
+		if _, err := db.Exec(rebind(dialect, "delete from schema_version where version = ?"), SchemaVersion+1); err != nil {
+			t.Errorf("Cannot delete the newer version: %s", err.Error())
+		}
+	}()
+	checkErrorIsOf(repository.Migrate(), ErrSchemaTooNew, t)
+	checkErrorIsOf(checkSchemaVersion(db, dialect), ErrSchemaTooNew, t)
+}
+
+func TestRepositoryMigrate(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestMigrate(t)
+		test.teardown(true, t)
+	}
+}
@@ -0,0 +1,190 @@
+package smallben
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultRunsFlushInterval is how often the runs of the executed jobs
+// are stored when Config.RunsFlushInterval is not set.
+const DefaultRunsFlushInterval = time.Second
+
+// jobRuns are the runs of a job waiting to be stored.
+type jobRuns struct {
+	// nextRunAt is the next execution
+	// of the job, nil if not to store.
+	nextRunAt *time.Time
+	// lastRunAt and lastOutcome are the last execution
+	// of the job, lastRunAt is zero if not to store.
+	lastRunAt   time.Time
+	lastOutcome JobOutcome
+}
+
+// runRecorder stores the next_run_at, last_run_at and last_outcome
+// fields of the executed jobs asynchronously, so that the executions
+// never wait for the repository: the runs are coalesced by job,
+// and stored in batches by flush.
+type runRecorder struct {
+	lock    sync.Mutex
+	pending map[int64]*jobRuns
+	// outcomes are the outcomes of the last executions
+	// of the jobs, stored or not yet.
+	outcomes map[int64]JobOutcome
+	// disabled is set when the runs are not stored at all.
+	disabled bool
+}
+
+// newRunRecorder returns a new runRecorder, storing
+// nothing if `disabled` is true.
+func newRunRecorder(disabled bool) *runRecorder {
+	return &runRecorder{
+		pending:  make(map[int64]*jobRuns),
+		outcomes: make(map[int64]JobOutcome),
+		disabled: disabled,
+	}
+}
+
+// runs returns the pending runs of the job whose id is `jobID`,
+// for callers holding the lock.
+func (r *runRecorder) runs(jobID int64) *jobRuns {
+	runs, ok := r.pending[jobID]
+	if !ok {
+		runs = &jobRuns{}
+		r.pending[jobID] = runs
+	}
+	return runs
+}
+
+// setNextRun records `nextRunAt` as the next execution of the job whose id is `jobID`.
+func (r *runRecorder) setNextRun(jobID int64, nextRunAt time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.disabled {
+		r.runs(jobID).nextRunAt = &nextRunAt
+	}
+}
+
+// setLastRun records the last execution of the job whose id is `jobID`,
+// returning the outcome of the previous one, if known, otherwise `stored`.
+func (r *runRecorder) setLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome, stored JobOutcome) JobOutcome {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previous, ok := r.outcomes[jobID]
+	if !ok {
+		previous = stored
+	}
+	r.outcomes[jobID] = outcome
+	if !r.disabled {
+		runs := r.runs(jobID)
+		runs.lastRunAt, runs.lastOutcome = lastRunAt, outcome
+	}
+	return previous
+}
+
+// forgetNextRuns drops the pending next runs of the jobs
+// whose id is in `jobsID`, e.g., because they have been paused.
+func (r *runRecorder) forgetNextRuns(jobsID []int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, id := range jobsID {
+		if runs, ok := r.pending[id]; ok {
+			runs.nextRunAt = nil
+		}
+	}
+}
+
+// forget drops all the runs of the jobs whose id is in `jobsID`,
+// e.g., because they have been deleted.
+func (r *runRecorder) forget(jobsID []int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, id := range jobsID {
+		delete(r.pending, id)
+		delete(r.outcomes, id)
+	}
+}
+
+// take returns the pending runs, leaving none.
+func (r *runRecorder) take() map[int64]*jobRuns {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	pending := r.pending
+	r.pending = make(map[int64]*jobRuns)
+	return pending
+}
+
+// requeue puts back `runs`, which could not be stored,
+// unless newer runs of the same jobs are pending.
+func (r *runRecorder) requeue(runs map[int64]*jobRuns) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, old := range runs {
+		pending, ok := r.pending[id]
+		if !ok {
+			r.pending[id] = old
+			continue
+		}
+		if pending.nextRunAt == nil {
+			pending.nextRunAt = old.nextRunAt
+		}
+		if pending.lastRunAt.IsZero() {
+			pending.lastRunAt, pending.lastOutcome = old.lastRunAt, old.lastOutcome
+		}
+	}
+}
+
+// flushRuns stores the pending runs in the repository, within a single
+// transaction if it implements OutboxRepository. The runs of the jobs
+// that do not exist anymore are skipped. If the transaction fails,
+// e.g., because a statement aborted it, the runs are stored again
+// by the next flush.
+func (s *SmallBen) flushRuns() {
+	pending := s.runs.take()
+	if len(pending) == 0 {
+		return
+	}
+	store := func(repository Repository, stopOnError bool) error {
+		for id, runs := range pending {
+			var err error
+			if runs.nextRunAt != nil {
+				err = repository.SetNextRunAt(id, runs.nextRunAt)
+			}
+			if err == nil && !runs.lastRunAt.IsZero() {
+				err = repository.SetLastRun(id, runs.lastRunAt, runs.lastOutcome)
+			}
+			if err != nil && !errors.Is(err, repository.ErrorTypeIfMismatchCount()) {
+				s.logger.Error(err, "Storing runs", "Progress", "Error", "ID", id)
+				if stopOnError {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if repository, ok := s.repository.(OutboxRepository); ok {
+		if err := repository.Transaction(func(txRepository Repository) error {
+			return store(txRepository, true)
+		}); err != nil {
+			s.logger.Error(err, "Storing runs", "Progress", "Error", "Details", "Committing")
+			s.runs.requeue(pending)
+		}
+		return
+	}
+	_ = store(s.repository, false)
+}
+
+// recordRuns flushes the runs every `interval`, until `stop` is closed.
+func (s *SmallBen) recordRuns(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.flushRuns()
+		}
+	}
+}
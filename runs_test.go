@@ -0,0 +1,110 @@
+package smallben
+
+import (
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+// TestSmallBenRuns tests that the runs of the jobs are stored
+// periodically, and that they are not stored when disabled.
+func TestSmallBenRuns(t *testing.T) {
+	for _, interval := range []time.Duration{50 * time.Millisecond, -1} {
+		repository, _ := newBoltTestRepository(t)
+		smallBen := New(repository, &Config{
+			Logger:            zapr.NewLogger(zap.NewExample()),
+			SchedulerConfig:   SchedulerConfig{WithSeconds: true},
+			RunsFlushInterval: interval,
+		})
+		if err := smallBen.Start(); err != nil {
+			t.Fatalf("Cannot even start: %s", err.Error())
+		}
+		job := ChainJobsToUse[0]
+		job.OnSuccess, job.OnFailure = nil, nil
+		if err := smallBen.AddJobs([]Job{job}); err != nil {
+			t.Fatalf("Fail to add jobs: %s", err.Error())
+		}
+
+		// wait for the job to fire, and the runs to be flushed
+		time.Sleep(1200 * time.Millisecond)
+		stored, err := repository.GetJob(job.ID)
+		if err != nil {
+			t.Fatalf("Cannot get the job: %s", err.Error())
+		}
+		if interval > 0 && (stored.rawJob.LastOutcome != JobOutcomeFailed || stored.rawJob.NextRunAt == nil) {
+			t.Errorf("The runs have not been stored. Got: %+v", stored.rawJob)
+		}
+		smallBen.Stop()
+		if interval < 0 {
+			if stored, err = repository.GetJob(job.ID); err != nil {
+				t.Fatalf("Cannot get the job: %s", err.Error())
+			}
+			if stored.rawJob.LastOutcome != "" || stored.rawJob.LastRunAt != nil {
+				t.Errorf("The runs have been stored. Got: %+v", stored.rawJob)
+			}
+		}
+	}
+}
+
+// failingRunsRepository is a RepositorySQL failing
+// to store the last runs `failures` times.
+type failingRunsRepository struct {
+	*RepositorySQL
+	failures *int
+}
+
+func (f *failingRunsRepository) SetLastRun(jobID int64, lastRunAt time.Time, outcome JobOutcome) error {
+	if *f.failures > 0 {
+		*f.failures--
+		return errors.New("cannot store the last run")
+	}
+	return f.RepositorySQL.SetLastRun(jobID, lastRunAt, outcome)
+}
+
+func (f *failingRunsRepository) Transaction(fn func(repository Repository) error) error {
+	return f.RepositorySQL.Transaction(func(repository Repository) error {
+		return fn(&failingRunsRepository{RepositorySQL: repository.(*RepositorySQL), failures: f.failures})
+	})
+}
+
+// TestFlushRunsRequeue tests that the runs are stored
+// again by the next flush if their transaction fails.
+func TestFlushRunsRequeue(t *testing.T) {
+	db, _ := openTestSQLite(t)
+	repository, err := NewRepositorySQL(&RepositorySQLConfig{DB: db, Dialect: SQLDialectSQLite})
+	if err != nil {
+		t.Fatalf("Cannot create the repository: %s", err.Error())
+	}
+	failures := 1
+	smallBen := New(&failingRunsRepository{RepositorySQL: repository, failures: &failures}, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+	jobs := make([]Job, 2)
+	copy(jobs, JobsToUse)
+	if err = smallBen.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+
+	nextRunAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	smallBen.runs.setNextRun(jobs[0].ID, nextRunAt)
+	smallBen.runs.setLastRun(jobs[1].ID, time.Now(), JobOutcomeSucceeded, "")
+	for i, stored := range []bool{false, true} {
+		smallBen.flushRuns()
+		first, err := repository.GetJob(jobs[0].ID)
+		if err != nil {
+			t.Fatalf("Cannot get the job: %s", err.Error())
+		}
+		second, err := repository.GetJob(jobs[1].ID)
+		if err != nil {
+			t.Fatalf("Cannot get the job: %s", err.Error())
+		}
+		gotNext := first.rawJob.NextRunAt != nil && first.rawJob.NextRunAt.Equal(nextRunAt)
+		gotLast := second.rawJob.LastOutcome == JobOutcomeSucceeded
+		if gotNext != stored || gotLast != stored {
+			t.Errorf("Flush %d: the runs should have been stored: %v. Got: %+v, %+v", i, stored, first.rawJob, second.rawJob)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 type scheduler struct {
 	cron   *cron.Cron
 	logger cron.Logger
+	// execute is called each time a job fires.
+	execute func(job JobWithSchedule)
 }
 
 // SchedulerConfig contains the configuration
@@ -21,11 +23,14 @@ type scheduler struct {
 // option https://pkg.go.dev/github.com/robfig/cron/v3#WithParser.
 type SchedulerConfig struct {
 	// DelayIfStillRunning delays a job starting
-	// if that job has not finished yet.
+	// if that job has not finished yet. The execution of a job
+	// includes the jobs chained to it and, for the roots,
+	// the rest of their workflow.
 	// Equivalent to attaching: https://pkg.go.dev/github.com/robfig/cron/v3#DelayIfStillRunning
 	DelayIfStillRunning bool
 	// SkipIfStillRunning skips a job starting
-	// if that job has not finished yet.
+	// if that job has not finished yet. As for DelayIfStillRunning,
+	// the execution of a job includes its chained jobs and workflow.
 	// Equivalent to attaching: https://pkg.go.dev/github.com/robfig/cron/v3#SkipIfStillRunning
 	SkipIfStillRunning bool
 	// WithSeconds enable seconds-grained scheduling.
@@ -88,26 +93,40 @@ func newScheduler(config *SchedulerConfig) scheduler {
 	// create the scheduler struct...
 	scheduler := scheduler{
 		// by passing it the options.
-		cron:   cron.New(options...),
-		logger: logger,
+		cron:    cron.New(options...),
+		logger:  logger,
+		execute: runJobWithSchedule,
 	}
 	return scheduler
 }
 
+// runJobWithSchedule simply executes `job`.
+func runJobWithSchedule(job JobWithSchedule) {
+	job.run.Run(job.runInput)
+}
+
 // AddJobs adds `jobs` to the scheduler.
 // This function never fails and updates
-// the input array with the `CronID`.
+// the input array with the `CronID` and the `NextRunAt`.
+// Jobs without a schedule, i.e., jobs with upstreams,
+// are skipped and keep a `CronID` of DefaultCronID.
 func (s *scheduler) AddJobs(jobs []JobWithSchedule) {
 
 	for i := range jobs {
-		runFunc := jobs[i].run
-		runFuncInput := jobs[i].runInput
+		if jobs[i].schedule == nil {
+			jobs[i].rawJob.CronID = DefaultCronID
+			continue
+		}
+		job := jobs[i]
+		execute := s.execute
 
 		entryID := s.cron.Schedule(jobs[i].schedule, cron.FuncJob(func() {
-			runFunc.Run(runFuncInput)
+			execute(job)
 		}))
 
 		jobs[i].rawJob.CronID = int64(entryID)
+		nextRunAt := jobs[i].schedule.Next(time.Now().In(s.cron.Location()))
+		jobs[i].rawJob.NextRunAt = &nextRunAt
 
 		s.logger.Info("Added job",
 			"ID", jobs[i].rawJob.ID,
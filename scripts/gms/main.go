@@ -0,0 +1,31 @@
+// Command gms serves an in-memory MySQL database, by the means of
+// go-mysql-server, to run the tests against MySQL without a server.
+// It is a module on its own, since go-mysql-server requires a recent Go.
+package main
+
+import (
+	"flag"
+	"log"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	"github.com/dolthub/go-mysql-server/sql"
+)
+
+func main() {
+	address := flag.String("address", "127.0.0.1:3307", "the address to listen on")
+	database := flag.String("database", "smallben", "the name of the database")
+	flag.Parse()
+
+	provider := memory.NewDBProvider(memory.NewDatabase(*database))
+	engine := sqle.NewDefault(provider)
+	config := server.Config{Protocol: "tcp", Address: *address}
+	s, err := server.NewServer(config, engine, sql.NewContext, memory.NewSessionBuilder(provider), nil)
+	if err != nil {
+		log.Fatalf("Cannot create the server: %s", err.Error())
+	}
+	if err = s.Start(); err != nil {
+		log.Fatalf("Cannot start the server: %s", err.Error())
+	}
+}
@@ -0,0 +1,88 @@
+-- This script mirrors the schema created by the migrations applied by NewRepositorySQL,
+-- which is still needed to keep track of the schema version.
+-- The uniqueness of the names within a super group is checked by SmallBen.
+
+create table if not exists jobs (
+    id bigint primary key,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    name varchar(256) not null default '',
+    description text not null,
+    owner varchar(256) not null default '',
+    paused boolean not null default false,
+    cron_expression varchar(256) not null,
+    cron_id bigint not null default 0,
+    serialized_job longtext not null,
+    serialized_job_input longtext not null,
+    upstream_ids text not null,
+    on_success text not null,
+    on_failure text not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    updated_at datetime(6) not null default current_timestamp(6),
+    next_run_at datetime(6) null,
+    last_run_at datetime(6) null,
+    last_outcome varchar(16) not null default '',
+    index paused_idx (paused),
+    index group_idx (group_id),
+    index super_group_name_idx (super_group_id, name),
+    index created_at_idx (created_at, id),
+    index updated_at_idx (updated_at, id),
+    index next_run_at_idx (next_run_at, id),
+    index last_outcome_idx (last_outcome)
+) engine = InnoDB;
+
+create table if not exists job_labels (
+    job_id bigint not null,
+    label_key varchar(256) not null,
+    label_value varchar(256) not null default '',
+    primary key (job_id, label_key),
+    index job_labels_idx (label_key, label_value),
+    foreign key (job_id) references jobs(id) on delete cascade
+) engine = InnoDB;
+
+create table if not exists workflow_runs (
+    id bigint auto_increment primary key,
+    root_job_id bigint not null,
+    scheduled_at datetime(6) not null,
+    status varchar(32) not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    updated_at datetime(6) not null default current_timestamp(6),
+    index workflow_runs_root_job_idx (root_job_id)
+) engine = InnoDB;
+
+create table if not exists workflow_run_jobs (
+    run_id bigint not null,
+    job_id bigint not null,
+    status varchar(32) not null,
+    started_at datetime(6) not null,
+    finished_at datetime(6) not null,
+    error_message text not null,
+    primary key (run_id, job_id),
+    foreign key (run_id) references workflow_runs(id) on delete cascade
+) engine = InnoDB;
+
+create table if not exists webhook_deliveries (
+    id bigint auto_increment primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    super_group_id bigint not null,
+    url text not null,
+    attempts integer not null,
+    status_code integer not null,
+    delivered boolean not null,
+    error_message text not null,
+    created_at datetime(6) not null default current_timestamp(6),
+    index webhook_deliveries_job_idx (job_id)
+) engine = InnoDB;
+
+create table if not exists outbox_entries (
+    id bigint primary key,
+    type varchar(32) not null,
+    job_id bigint not null,
+    group_id bigint not null,
+    super_group_id bigint not null,
+    job_name varchar(256) not null default '',
+    node bigint not null default 0,
+    created_at datetime(6) not null,
+    index outbox_entries_node_idx (node, created_at, id)
+) engine = InnoDB;
@@ -1,3 +1,6 @@
+-- This script mirrors the first migration applied by NewRepositorySQL,
+-- which is still needed to keep track of the schema version.
+
 create table if not exists jobs
 (
     -- the test rule id
@@ -15,6 +18,13 @@ create table if not exists jobs
     integer
     not
     null,
+    -- the optional human-readable name of the job,
+    -- unique within the supergroup if not empty
+    name varchar(256) not null default '',
+    -- the optional description of the job
+    description text not null default '',
+    -- the optional owner of the job
+    owner varchar(256) not null default '',
     -- set to true if you want to pause this test
     paused
     boolean
@@ -39,10 +49,28 @@ create table if not exists jobs
     -- it uses a `text` type instead of a binary,
     -- to make the Go struct more transparent to the underlying db.
     serialized_job_input text not null,
+    -- json-encoded list of the ids of the upstream jobs,
+    -- empty if the job has no upstreams
+    upstream_ids text not null default '',
+    -- json-encoded list of the ids of the jobs to execute
+    -- when this job succeeds, empty if there are none
+    on_success text not null default '',
+    -- json-encoded list of the ids of the jobs to execute
+    -- when this job fails, empty if there are none
+    on_failure text not null default '',
     -- when the item has been created
     created_at timestamp with time zone not null default current_timestamp,
     -- when the item has been updated last time
     updated_at timestamp  with time zone not null default current_timestamp,
+    -- when the job is going to be executed next time,
+    -- null if it is not scheduled
+    next_run_at timestamp with time zone,
+    -- when the job has been executed last time,
+    -- null if it has never been executed
+    last_run_at timestamp with time zone,
+    -- outcome of the last execution, empty if
+    -- the job has never been executed
+    last_outcome varchar(16) not null default ''
 );
 
 -- index on the paused field
@@ -50,4 +78,112 @@ create index if not exists paused_idx on jobs(paused);
 -- index on the group id
 create index if not exists group_idx on jobs(group_id);
 -- index on the super group id
-create index if not exists super_group_idx on jobs(super_group_id);
\ No newline at end of file
+create index if not exists super_group_idx on jobs(super_group_id);
+-- names are unique within a super group
+create unique index if not exists super_group_name_idx on jobs(super_group_id, name) where name <> '';
+-- indexes on the fields jobs are sorted by
+create index if not exists created_at_idx on jobs(created_at, id);
+create index if not exists updated_at_idx on jobs(updated_at, id);
+create index if not exists next_run_at_idx on jobs(next_run_at, id);
+-- index on the outcome of the last execution
+create index if not exists last_outcome_idx on jobs(last_outcome);
+
+create table if not exists job_labels
+(
+    -- the id of the job
+    job_id bigint not null references jobs(id) on delete cascade,
+    -- the key of the label
+    label_key varchar(256) not null,
+    -- the value of the label
+    label_value varchar(256) not null default '',
+    primary key (job_id, label_key)
+);
+
+-- index on the labels, used by the label selectors
+create index if not exists job_labels_idx on job_labels(label_key, label_value);
+
+create table if not exists workflow_runs
+(
+    -- the id of the run
+    id bigserial primary key,
+    -- the id of the job that started the run
+    root_job_id bigint not null,
+    -- when the root job fired
+    scheduled_at timestamp with time zone not null,
+    -- status of the run
+    status varchar(32) not null,
+    -- when the run has been created
+    created_at timestamp with time zone not null default current_timestamp,
+    -- when the run has been updated last time
+    updated_at timestamp with time zone not null default current_timestamp
+);
+
+-- index on the root job id
+create index if not exists workflow_runs_root_job_idx on workflow_runs(root_job_id);
+
+create table if not exists workflow_run_jobs
+(
+    -- the id of the run
+    run_id bigint not null references workflow_runs(id) on delete cascade,
+    -- the id of the job
+    job_id bigint not null,
+    -- status of the job within the run
+    status varchar(32) not null,
+    -- when the job started
+    started_at timestamp with time zone not null,
+    -- when the job finished
+    finished_at timestamp with time zone not null,
+    -- why the job failed or has been skipped
+    error_message text not null default '',
+    primary key (run_id, job_id)
+);
+
+create table if not exists webhook_deliveries
+(
+    -- the id of the delivery
+    id bigserial primary key,
+    -- the type of the webhook
+    type varchar(32) not null,
+    -- the id of the job the webhook is about
+    job_id bigint not null,
+    -- the id of the supergroup of the job
+    super_group_id bigint not null,
+    -- the destination of the webhook
+    url text not null,
+    -- the number of attempts done
+    attempts integer not null,
+    -- the status code of the last response, 0 if none
+    status_code integer not null,
+    -- whether the webhook has been delivered
+    delivered boolean not null,
+    -- the error of the last attempt
+    error_message text not null default '',
+    -- when the delivery has been completed
+    created_at timestamp with time zone not null default current_timestamp
+);
+
+-- index on the job id
+create index if not exists webhook_deliveries_job_idx on webhook_deliveries(job_id);
+
+create table if not exists outbox_entries
+(
+    -- the id of the entry
+    id bigint primary key,
+    -- the type of the event of the change
+    type varchar(32) not null,
+    -- the id of the changed job
+    job_id bigint not null,
+    -- the id of the group of the job
+    group_id bigint not null,
+    -- the id of the supergroup of the job
+    super_group_id bigint not null,
+    -- the name of the job
+    job_name varchar(256) not null default '',
+    -- the node of the instance that made the change
+    node bigint not null default 0,
+    -- when the change has been made
+    created_at timestamp with time zone not null
+);
+
+-- index on the node, to list the entries of an instance
+create index if not exists outbox_entries_node_idx on outbox_entries(node, created_at, id);
@@ -0,0 +1,22 @@
+#!/bin/sh
+# Runs the tests against an in-memory MySQL database served by
+# go-mysql-server, see the gms directory, when no MySQL server is at hand.
+# The tests using the transactions of the caller are skipped, since
+# go-mysql-server does not support savepoints, and the foreign keys are
+# not checked, since it refuses the ones referencing a primary key.
+set -e
+
+root="$(cd "$(dirname "$0")/.." && pwd)"
+address="127.0.0.1:${GMS_PORT:-3307}"
+server="$(mktemp -d)/gms"
+
+(cd "$root/scripts/gms" && go build -o "$server" .)
+"$server" -address "$address" &
+pid=$!
+trap 'kill $pid' EXIT
+sleep 1
+
+export TEST_DATABASE_MYSQL="root@tcp($address)/smallben?foreign_key_checks=0"
+skip='^(TestRepositorySQLTx|TestSmallBenTx|TestSmallBenTxConcurrent|TestSmallBenOutbox)$'
+(cd "$root" && go test -count=1 -skip "$skip" ./...)
+(cd "$root/gormrepo" && go test -count=1 ./...)
@@ -0,0 +1,100 @@
+package smallben
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the tracer used by SmallBen.
+const tracerName = "github.com/nbena/smallben"
+
+const (
+	// AttributeJobID is the attribute keeping the ID of a job.
+	AttributeJobID = attribute.Key("smallben.job.id")
+	// AttributeJobGroupID is the attribute keeping the GroupID of a job.
+	AttributeJobGroupID = attribute.Key("smallben.job.group_id")
+	// AttributeJobSuperGroupID is the attribute keeping the SuperGroupID of a job.
+	AttributeJobSuperGroupID = attribute.Key("smallben.job.super_group_id")
+	// AttributeJobName is the attribute keeping the Name of a job, if any.
+	AttributeJobName = attribute.Key("smallben.job.name")
+	// AttributeJobIDs is the attribute keeping the IDs of the jobs
+	// involved in an operation.
+	AttributeJobIDs = attribute.Key("smallben.job.ids")
+)
+
+// CronJobWithContext is the interface jobs can implement
+// to receive a context, carrying the span of the execution.
+// When implemented, RunWithContext is called instead of Run and RunWithResult,
+// and a non-nil error means the execution failed.
+type CronJobWithContext interface {
+	CronJob
+	RunWithContext(ctx context.Context, input CronJobInput) error
+}
+
+// newTracer returns the tracer from `provider`. If nil,
+// the global provider is used.
+func newTracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// startOperation starts the span of the operation `name`,
+// e.g., AddJobs, involving the jobs `ids`.
+func (s *SmallBen) startOperation(name string, ids []int64) (context.Context, trace.Span) {
+	ctx, span := s.tracer.Start(context.Background(), "smallben."+name)
+	if ids != nil {
+		span.SetAttributes(AttributeJobIDs.Int64Slice(ids))
+	}
+	return ctx, span
+}
+
+// traceRepository executes `call`, i.e., the call to the method
+// `name` of the repository, within a span child of the one in `ctx`.
+func (s *SmallBen) traceRepository(ctx context.Context, name string, call func() error) error {
+	_, span := s.tracer.Start(ctx, "smallben.repository."+name, trace.WithSpanKind(trace.SpanKindClient))
+	err := call()
+	endSpan(span, err)
+	return err
+}
+
+// listJobs lists the jobs of `repository` according to `options` within a span
+// child of the one in `ctx`, that keeps track of the IDs of the jobs.
+func (s *SmallBen) listJobs(ctx context.Context, repository Repository, options ToListOptions) ([]RawJob, error) {
+	var jobs []RawJob
+	err := s.traceRepository(ctx, "ListJobs", func() error {
+		var err error
+		jobs, err = repository.ListJobs(options)
+		return err
+	})
+	trace.SpanFromContext(ctx).SetAttributes(AttributeJobIDs.Int64Slice(getIdsFromJobRawList(jobs)))
+	return jobs, err
+}
+
+// startExecution starts the root span of the execution of `job`.
+func (s *SmallBen) startExecution(job *RawJob) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		AttributeJobID.Int64(job.ID),
+		AttributeJobGroupID.Int64(job.GroupID),
+		AttributeJobSuperGroupID.Int64(job.SuperGroupID),
+	}
+	if job.Name != "" {
+		attributes = append(attributes, AttributeJobName.String(job.Name))
+	}
+	return s.tracer.Start(context.Background(), "smallben.Run",
+		trace.WithNewRoot(),
+		trace.WithAttributes(attributes...))
+}
+
+// endSpan ends `span`, recording `err`, if any.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,179 @@
+package smallben
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"github.com/go-logr/zapr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+)
+
+// tracingReceived keeps the span contexts
+// received by TracingTestCronJob.
+var tracingReceived = struct {
+	data []trace.SpanContext
+	lock sync.Mutex
+}{}
+
+// TracingTestCronJob records the span context it
+// is executed within, and fails if asked to.
+type TracingTestCronJob struct{}
+
+func (t *TracingTestCronJob) Run(input CronJobInput) {}
+
+func (t *TracingTestCronJob) RunWithContext(ctx context.Context, input CronJobInput) error {
+	tracingReceived.lock.Lock()
+	tracingReceived.data = append(tracingReceived.data, trace.SpanContextFromContext(ctx))
+	tracingReceived.lock.Unlock()
+	if fail, ok := input.OtherInputs["fail"].(bool); ok && fail {
+		return errors.New("failing on purpose")
+	}
+	return nil
+}
+
+func init() {
+	gob.Register(&TracingTestCronJob{})
+}
+
+// newTestTracerProvider returns a tracer provider
+// exporting synchronously to the returned exporter.
+func newTestTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+// findAttribute returns the value of the attribute `key`,
+// and whether it has been found.
+func findAttribute(attributes []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingExecution(t *testing.T) {
+	provider, exporter := newTestTracerProvider()
+	smallBen := &SmallBen{
+		logger:  zapr.NewLogger(zap.NewNop()),
+		events:  newEventDispatcher(0, zapr.NewLogger(zap.NewNop())),
+		metrics: newMetrics(MetricsConfig{}),
+		tracer:  newTracer(provider),
+		runs:    newRunRecorder(true),
+	}
+
+	job := JobWithSchedule{
+		rawJob:   RawJob{ID: 1, GroupID: 2, SuperGroupID: 3},
+		run:      &TracingTestCronJob{},
+		runInput: CronJobInput{JobID: 1, OtherInputs: map[string]interface{}{}},
+	}
+	if err := smallBen.execute(job); err != nil {
+		t.Errorf("The execution should succeed: %s\n", err.Error())
+	}
+	job.runInput.OtherInputs["fail"] = true
+	checkErrorMsg(smallBen.execute(job), "failing on purpose", t)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Errorf("Wrong number of spans. Got: %d, expected: %d\n", len(spans), 2)
+		t.FailNow()
+	}
+
+	tracingReceived.lock.Lock()
+	defer tracingReceived.lock.Unlock()
+	for i, span := range spans {
+		if span.Name != "smallben.Run" {
+			t.Errorf("Wrong span name. Got: %s\n", span.Name)
+		}
+		// each execution is a trace on its own
+		if span.Parent.IsValid() {
+			t.Errorf("The span of an execution should be a root\n")
+		}
+		// the job received the span
+		if !tracingReceived.data[i].Equal(span.SpanContext) {
+			t.Errorf("The job did not receive the span of its execution\n")
+		}
+		expected := map[attribute.Key]int64{
+			AttributeJobID:           1,
+			AttributeJobGroupID:      2,
+			AttributeJobSuperGroupID: 3,
+		}
+		for key, value := range expected {
+			if got, ok := findAttribute(span.Attributes, key); !ok || got.AsInt64() != value {
+				t.Errorf("Wrong attribute %s. Got: %v, expected: %d\n", key, got.AsInt64(), value)
+			}
+		}
+	}
+	if spans[0].Status.Code == codes.Error {
+		t.Errorf("The first execution should not be an error\n")
+	}
+	if spans[1].Status.Code != codes.Error {
+		t.Errorf("The second execution should be an error\n")
+	}
+}
+
+func (s *SmallBenTestSuite) TestTracing(t *testing.T) {
+	provider, exporter := newTestTracerProvider()
+	s.smallBen.tracer = newTracer(provider)
+
+	err := s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: getIdsFromJobList(s.jobs)})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	spans := exporter.GetSpans()
+	operations := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		if !span.Parent.IsValid() {
+			operations[span.Name] = span
+		}
+	}
+	for _, name := range []string{"smallben.AddJobs", "smallben.PauseJobs"} {
+		operation, ok := operations[name]
+		if !ok {
+			t.Errorf("Missing span %s\n", name)
+			continue
+		}
+		ids, ok := findAttribute(operation.Attributes, AttributeJobIDs)
+		if !ok || len(ids.AsInt64Slice()) != len(s.jobs) {
+			t.Errorf("Wrong job IDs for span %s. Got: %v\n", name, ids.AsInt64Slice())
+		}
+		// the repository calls are children of the operation
+		children := 0
+		for _, span := range spans {
+			if span.Parent.SpanID() == operation.SpanContext.SpanID() {
+				children++
+				if span.SpanKind != trace.SpanKindClient {
+					t.Errorf("Wrong span kind for %s. Got: %s\n", span.Name, span.SpanKind)
+				}
+			}
+		}
+		if children == 0 {
+			t.Errorf("No repository span for %s\n", name)
+		}
+	}
+}
+
+func TestSmallBenTracing(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestTracing(t)
+		test.teardown(true, t)
+	}
+}
@@ -0,0 +1,430 @@
+package smallben
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrTxNotSupported is returned by SmallBen.WithTx when the
+	// repository does not implement the TxRepository interface.
+	ErrTxNotSupported = errors.New("the repository does not support transactions of the caller")
+	// ErrInvalidTx is returned by TxRepository.WithTx when
+	// the transaction is not of the type it supports.
+	ErrInvalidTx = errors.New("invalid transaction")
+	// ErrTxDone is returned when using a Tx after
+	// Committed or RolledBack have been called.
+	ErrTxDone = errors.New("transaction already committed or rolled back")
+)
+
+// TxRepository is the interface implemented by the storage
+// backends that can execute their operations within a
+// transaction of the caller.
+type TxRepository interface {
+	// WithTx returns a Repository executing its operations within `tx`.
+	// The type of `tx` depends on the backend, e.g., *sql.Tx for RepositorySQL
+	// and *gorm.DB for the repositories of the gormrepo module:
+	// other types return ErrInvalidTx.
+	WithTx(tx interface{}) (Repository, error)
+}
+
+// Tx executes the operations of SmallBen within a transaction of the caller, so that
+// the jobs are stored along with the other data of the caller, or not at all.
+// The changes to the repository are made right away within the transaction, while
+// the ones to the scheduler, i.e., scheduling the jobs, and the events and the metrics,
+// are deferred: they are applied by Committed, to be called once the caller has committed
+// the transaction, or discarded by RolledBack.
+//
+// In case of errors, the caller should roll back the transaction, since some of
+// the changes to the repository may have been made.
+type Tx struct {
+	smallBen *SmallBen
+	// repository executes the operations
+	// within the transaction of the caller.
+	repository Repository
+	// lock protects pending and done.
+	lock sync.Mutex
+	// pending are the changes to apply
+	// once the transaction has been committed.
+	pending []pendingChange
+	// done specifies whether Committed or
+	// RolledBack have been called.
+	done bool
+}
+
+// WithTx returns a Tx executing the operations within `tx`, a transaction of
+// the caller whose type depends on the repository, e.g., *sql.Tx for RepositorySQL
+// and *gorm.DB for the repositories of the gormrepo module. It returns ErrTxNotSupported if the repository
+// does not implement TxRepository, and ErrInvalidTx if `tx` is not supported.
+func (s *SmallBen) WithTx(tx interface{}) (*Tx, error) {
+	repository, ok := s.repository.(TxRepository)
+	if !ok {
+		return nil, ErrTxNotSupported
+	}
+	txRepository, err := repository.WithTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{smallBen: s, repository: txRepository}, nil
+}
+
+// pendingChange is a change to apply once
+// the transaction has been committed.
+type pendingChange struct {
+	// apply applies the change.
+	apply func() error
+	// entries are the IDs of the entries of the
+	// outbox to delete once the change has been applied.
+	entries []int64
+}
+
+// deferChange journals the changes of type `eventType` to `jobs`, and adds `apply`
+// to the changes to apply once the transaction has been committed.
+func (t *Tx) deferChange(eventType EventType, jobs []RawJob, apply func() error) error {
+	entries, err := t.journal(eventType, jobs)
+	if err != nil {
+		return err
+	}
+	t.pending = append(t.pending, pendingChange{apply: apply, entries: entries})
+	return nil
+}
+
+// begin locks the Tx and SmallBen for an operation, returning ErrTxDone
+// if the Tx is done. The returned function unlocks them.
+//
+// The lock of SmallBen is held as by the operations of SmallBen, so that the
+// checks made in memory, e.g., of the names of the jobs and of the cycles of
+// the workflows, and the writes they guard are not interleaved with theirs.
+// Since the operations of SmallBen hold it while waiting for the locks of
+// the repository, which may be held by the transaction of the caller until
+// it ends, the transactions should be short, or have a lock timeout.
+func (t *Tx) begin() (func(), error) {
+	t.lock.Lock()
+	if t.done {
+		t.lock.Unlock()
+		return nil, ErrTxDone
+	}
+	t.smallBen.lock.Lock()
+	return func() {
+		t.smallBen.lock.Unlock()
+		t.lock.Unlock()
+	}, nil
+}
+
+// AddJobs adds `jobs` to the repository within the transaction, just as
+// SmallBen.AddJobs does. They are scheduled by Committed.
+func (t *Tx) AddJobs(jobs []Job) error {
+	end, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+	return duplicate(t.repository, getIdsFromJobList(jobs), t.addJobs(jobs))
+}
+
+// addJobs is Tx.AddJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) addJobs(jobs []Job) (err error) {
+	s := t.smallBen
+
+	if err := s.assignIDs(jobs); err != nil {
+		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "AssigningIDs")
+		return err
+	}
+	s.logger.Info("Adding jobs", "Progress", "InProgress", "Details", "WithinTransaction", "IDs", getIdsFromJobList(jobs))
+
+	ctx, span := s.startOperation("AddJobs", getIdsFromJobList(jobs))
+	defer func() { endSpan(span, err) }()
+
+	jobsWithSchedule, err := s.prepareJobs(ctx, t.repository, jobs)
+	if err != nil {
+		return err
+	}
+	if err = s.traceRepository(ctx, "AddJobs", func() error {
+		return t.repository.AddJobs(jobsWithSchedule)
+	}); err != nil {
+		s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "AddingToRepository", "IDs", getIdsFromJobList(jobs))
+		return err
+	}
+
+	return t.deferChange(EventJobAdded, getRawJobsFromJobsWithScheduleList(jobsWithSchedule), func() error {
+		// the jobs exist anyway, even if they cannot be scheduled.
+		s.workflows.add(jobs)
+		if err := s.schedule(jobsWithSchedule); err != nil {
+			s.logger.Error(err, "Adding jobs", "Progress", "Error", "Details", "SetCronID", "IDs", getIdsFromJobList(jobs))
+			return err
+		}
+		s.events.emitWithSchedule(EventJobAdded, jobsWithSchedule)
+		s.metrics.addJobs(len(jobs))
+		s.logger.Info("Adding jobs", "Progress", "Done", "IDs", getIdsFromJobList(jobs))
+		return nil
+	})
+}
+
+// DeleteJobs deletes the jobs according to `options` from the repository within
+// the transaction, just as SmallBen.DeleteJobs does. They are unscheduled by Committed.
+func (t *Tx) DeleteJobs(options *DeleteOptions) error {
+	end, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+	return notFound(t.repository, options.JobIDs, t.deleteJobs(options))
+}
+
+// deleteJobs is Tx.DeleteJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) deleteJobs(options *DeleteOptions) (err error) {
+	s := t.smallBen
+
+	ctx, span := s.startOperation("DeleteJobs", nil)
+	defer func() { endSpan(span, err) }()
+
+	jobs, err := s.listJobs(ctx, t.repository, options)
+	if err != nil {
+		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+		return err
+	}
+	if len(jobs) == 0 {
+		return t.repository.ErrorTypeIfMismatchCount()
+	}
+	if err = s.workflows.checkDelete(getIdsFromJobRawList(jobs)); err != nil {
+		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "CheckingDownstreams", "IDs", getIdsFromJobRawList(jobs))
+		return err
+	}
+	if err = s.checkChainedDelete(ctx, t.repository, getIdsFromJobRawList(jobs)); err != nil {
+		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "CheckingChains", "IDs", getIdsFromJobRawList(jobs))
+		return err
+	}
+	if err = s.traceRepository(ctx, "DeleteJobsByIds", func() error {
+		return t.repository.DeleteJobsByIds(getIdsFromJobRawList(jobs))
+	}); err != nil {
+		s.logger.Error(err, "Deleting jobs", "Progress", "Error", "Details", "DeletingFromRepository", "IDs", getIdsFromJobRawList(jobs))
+		return err
+	}
+
+	return t.deferChange(EventJobDeleted, jobs, func() error {
+		s.scheduler.DeleteJobs(jobs)
+		s.runs.forget(getIdsFromJobRawList(jobs))
+		s.workflows.remove(getIdsFromJobRawList(jobs))
+		s.events.emitRaw(EventJobDeleted, jobs)
+		s.metrics.postDelete(jobs)
+		s.logger.Info("Deleting jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
+		return nil
+	})
+}
+
+// PauseJobs pauses the jobs according to `options` in the repository within
+// the transaction, just as SmallBen.PauseJobs does. They are unscheduled by Committed.
+func (t *Tx) PauseJobs(options *PauseResumeOptions) error {
+	end, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+	return notFound(t.repository, options.JobIDs, t.pauseJobs(options))
+}
+
+// pauseJobs is Tx.PauseJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) pauseJobs(options *PauseResumeOptions) (err error) {
+	s := t.smallBen
+
+	ctx, span := s.startOperation("PauseJobs", nil)
+	defer func() { endSpan(span, err) }()
+
+	jobs, err := s.listJobs(ctx, t.repository, options)
+	if err != nil {
+		s.logger.Error(err, "Pausing jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+		return err
+	}
+	if len(jobs) == 0 {
+		return t.repository.ErrorTypeIfMismatchCount()
+	}
+	if err = s.traceRepository(ctx, "PauseJobs", func() error {
+		return t.repository.PauseJobs(jobs)
+	}); err != nil {
+		s.logger.Error(err, "Pausing jobs", "Progress", "Error", "Details", "PausingInRepository", "IDs", getIdsFromJobRawList(jobs))
+		return err
+	}
+
+	return t.deferChange(EventJobPaused, jobs, func() error {
+		s.scheduler.DeleteJobs(jobs)
+		s.runs.forgetNextRuns(getIdsFromJobRawList(jobs))
+		s.events.emitRaw(EventJobPaused, jobs)
+		s.metrics.pauseJobs(len(jobs))
+		s.logger.Info("Pausing jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
+		return nil
+	})
+}
+
+// ResumeJobs resumes the jobs according to `options` in the repository within
+// the transaction, just as SmallBen.ResumeJobs does. They are scheduled by Committed.
+func (t *Tx) ResumeJobs(options *PauseResumeOptions) error {
+	end, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+	return notFound(t.repository, options.JobIDs, t.resumeJobs(options))
+}
+
+// resumeJobs is Tx.ResumeJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) resumeJobs(options *PauseResumeOptions) (err error) {
+	s := t.smallBen
+
+	ctx, span := s.startOperation("ResumeJobs", nil)
+	defer func() { endSpan(span, err) }()
+
+	jobs, err := s.listJobs(ctx, t.repository, options)
+	if err != nil {
+		s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+		return err
+	}
+	if len(jobs) == 0 {
+		return t.repository.ErrorTypeIfMismatchCount()
+	}
+	finalJobs, err := s.jobsToResume(jobs)
+	if err != nil {
+		return err
+	}
+	if err = s.traceRepository(ctx, "ResumeJobs", func() error {
+		return t.repository.ResumeJobs(finalJobs)
+	}); err != nil {
+		s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "ResumingInRepository", "IDs", getIdsFromJobRawList(jobs))
+		return err
+	}
+
+	return t.deferChange(EventJobResumed, getRawJobsFromJobsWithScheduleList(finalJobs), func() error {
+		if err := s.schedule(finalJobs); err != nil {
+			s.logger.Error(err, "Resuming jobs", "Progress", "Error", "Details", "SetCronID", "IDs", getIdsFromJobRawList(jobs))
+			return err
+		}
+		s.events.emitWithSchedule(EventJobResumed, finalJobs)
+		s.metrics.resumeJobs(len(jobs))
+		s.logger.Info("Resuming jobs", "Progress", "Done", "IDs", getIdsFromJobRawList(jobs))
+		return nil
+	})
+}
+
+// UpdateJobs updates the jobs according to `scheduleInfo` in the repository within
+// the transaction, just as SmallBen.UpdateJobs does. They are rescheduled by Committed.
+func (t *Tx) UpdateJobs(scheduleInfo []UpdateOption) error {
+	end, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer end()
+	return notFound(t.repository, getIdsFromUpdateScheduleList(scheduleInfo), t.updateJobs(scheduleInfo))
+}
+
+// updateJobs is Tx.UpdateJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) updateJobs(scheduleInfo []UpdateOption) (err error) {
+	s := t.smallBen
+
+	ctx, span := s.startOperation("UpdateJobs", getIdsFromUpdateScheduleList(scheduleInfo))
+	defer func() { endSpan(span, err) }()
+
+	var jobsWithScheduleOld []JobWithSchedule
+	if err = s.traceRepository(ctx, "GetJobsByIds", func() error {
+		var err error
+		jobsWithScheduleOld, err = t.repository.GetJobsByIds(getIdsFromUpdateScheduleList(scheduleInfo))
+		return err
+	}); err != nil {
+		s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "RetrievingFromRepository", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
+		return err
+	}
+	jobsWithScheduleNew, err := s.updatedJobs(jobsWithScheduleOld, scheduleInfo)
+	if err != nil {
+		return err
+	}
+	// the jobs keep their current cron_id,
+	// until they are rescheduled.
+	if err = s.traceRepository(ctx, "SetCronIdAndChangeScheduleAndJobInput", func() error {
+		return t.repository.SetCronIdAndChangeScheduleAndJobInput(jobsWithScheduleNew)
+	}); err != nil {
+		s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "UpdatingInRepository", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
+		return err
+	}
+
+	return t.deferChange(EventJobUpdated, getRawJobsFromJobsWithScheduleList(jobsWithScheduleNew), func() error {
+		s.scheduler.DeleteJobsWithSchedule(jobsWithScheduleNew)
+		if err := s.schedule(jobsWithScheduleNew); err != nil {
+			s.logger.Error(err, "Updating jobs", "Progress", "Error", "Details", "SetCronID", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
+			// the jobs are not running anymore.
+			s.metrics.pauseJobs(len(jobsWithScheduleNew))
+			return err
+		}
+		s.events.emitWithSchedule(EventJobUpdated, jobsWithScheduleNew)
+		s.logger.Info("Updating jobs", "Progress", "Done", "IDs", getIdsFromUpdateScheduleList(scheduleInfo))
+		return nil
+	})
+}
+
+// Committed applies the changes deferred by the operations of the Tx, to be called
+// once the caller has committed the transaction. The jobs are scheduled, and their
+// cron_id is stored in the repository outside of the transaction: in case of errors,
+// they are not scheduled, and the first error is returned, matching ErrNotApplied,
+// while the other changes are applied anyway. The jobs will be scheduled by the next Start.
+//
+// If the repository implements OutboxRepository, the changes are journaled within
+// the transaction, so that they are applied by the next Start even if Committed
+// is never called, e.g., because of a crash.
+func (t *Tx) Committed() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.done {
+		return ErrTxDone
+	}
+	t.smallBen.lock.Lock()
+	defer t.smallBen.lock.Unlock()
+	return t.apply()
+}
+
+// apply applies the pending changes, for callers holding
+// the lock of the Tx and the one of SmallBen,
+// deleting the entries of the outbox of the ones that have been applied.
+func (t *Tx) apply() error {
+	t.done = true
+	s := t.smallBen
+	var firstErr error
+	var applied []int64
+	for _, change := range t.pending {
+		if err := change.apply(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		applied = append(applied, change.entries...)
+	}
+	t.pending = nil
+	if repository, ok := s.repository.(OutboxRepository); ok && len(applied) > 0 {
+		// the changes are applied anyway: the entries
+		// left are applied once again by the next Start.
+		if err := repository.DeleteOutboxEntries(applied); err != nil {
+			s.logger.Error(err, "Clearing outbox", "Progress", "Error", "IDs", applied)
+		}
+	}
+	if firstErr != nil {
+		return withKind(ErrNotApplied, firstErr)
+	}
+	return nil
+}
+
+// RolledBack discards the changes deferred by the operations of the Tx,
+// to be called once the caller has rolled back the transaction.
+func (t *Tx) RolledBack() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done = true
+	t.pending = nil
+}
+
+// schedule adds `jobs` to the scheduler and stores their cron_id in the
+// repository, removing them from the scheduler in case of errors.
+func (s *SmallBen) schedule(jobs []JobWithSchedule) error {
+	s.scheduler.AddJobs(jobs)
+	if err := s.repository.SetCronId(jobs); err != nil {
+		s.scheduler.DeleteJobsWithSchedule(jobs)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package smallben
+
+import (
+	"testing"
+	"time"
+)
+
+func (s *SmallBenTestSuite) TestTx(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	repository := s.smallBen.repository.(*RepositorySQL)
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+
+	_, err = s.smallBen.WithTx(repository.db)
+	checkErrorIsOf(err, ErrInvalidTx, t)
+
+	// the jobs added within a transaction rolled back are discarded
+	tx, err := repository.db.Begin()
+	if err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	smallBenTx, err := s.smallBen.WithTx(tx)
+	if err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+	if err = smallBenTx.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Cannot roll back: %s", err.Error())
+	}
+	smallBenTx.RolledBack()
+	checkErrorIsOf(smallBenTx.AddJobs(jobs), ErrTxDone, t)
+	if len(s.smallBen.scheduler.cron.Entries()) != 0 {
+		t.Errorf("The jobs should not have been scheduled")
+	}
+	if count, _ := s.smallBen.repository.CountJobs(nil); count != 0 {
+		t.Errorf("The jobs should not have been added. Got: %d", count)
+	}
+
+	// the jobs are scheduled only once committed
+	if tx, err = repository.db.Begin(); err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	if smallBenTx, err = s.smallBen.WithTx(tx); err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+	if err = smallBenTx.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Cannot commit: %s", err.Error())
+	}
+	if len(s.smallBen.scheduler.cron.Entries()) != 0 {
+		t.Errorf("The jobs should not have been scheduled before Committed")
+	}
+	if err = smallBenTx.Committed(); err != nil {
+		t.Fatalf("Cannot apply the changes: %s", err.Error())
+	}
+	if len(s.smallBen.scheduler.cron.Entries()) != len(jobs) {
+		t.Errorf("The jobs should have been scheduled. Got: %d", len(s.smallBen.scheduler.cron.Entries()))
+	}
+	rawJobs, err := s.smallBen.repository.ListJobs(nil)
+	if err != nil {
+		t.Fatalf("Cannot list jobs: %s", err.Error())
+	}
+	for _, rawJob := range rawJobs {
+		if rawJob.CronID == DefaultCronID || rawJob.NextRunAt == nil {
+			t.Errorf("The cron id of the job %d should have been stored", rawJob.ID)
+		}
+	}
+
+	// many operations within the same transaction
+	if tx, err = repository.db.Begin(); err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	if smallBenTx, err = s.smallBen.WithTx(tx); err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+	if err = smallBenTx.PauseJobs(&PauseResumeOptions{JobIDs: []int64{jobs[0].ID}}); err != nil {
+		t.Fatalf("Fail to pause jobs: %s", err.Error())
+	}
+	cronExpression := "@every 90s"
+	if err = smallBenTx.UpdateJobs([]UpdateOption{{JobID: jobs[1].ID, CronExpression: &cronExpression}}); err != nil {
+		t.Fatalf("Fail to update jobs: %s", err.Error())
+	}
+	if err = smallBenTx.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{JobIDs: []int64{jobs[2].ID}}}); err != nil {
+		t.Fatalf("Fail to delete jobs: %s", err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Cannot commit: %s", err.Error())
+	}
+	if err = smallBenTx.Committed(); err != nil {
+		t.Fatalf("Cannot apply the changes: %s", err.Error())
+	}
+	if len(s.smallBen.scheduler.cron.Entries()) != len(jobs)-2 {
+		t.Errorf("Wrong number of scheduled jobs. Got: %d", len(s.smallBen.scheduler.cron.Entries()))
+	}
+	updated, err := s.smallBen.repository.GetJob(jobs[1].ID)
+	if err != nil {
+		t.Fatalf("Cannot get job: %s", err.Error())
+	}
+	if updated.rawJob.CronExpression != cronExpression || updated.rawJob.CronID == DefaultCronID {
+		t.Errorf("The job should have been rescheduled: %+v", updated.rawJob)
+	}
+
+	// resuming within a transaction
+	if tx, err = repository.db.Begin(); err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	if smallBenTx, err = s.smallBen.WithTx(tx); err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+	if err = smallBenTx.ResumeJobs(&PauseResumeOptions{JobIDs: []int64{jobs[0].ID}}); err != nil {
+		t.Fatalf("Fail to resume jobs: %s", err.Error())
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Cannot commit: %s", err.Error())
+	}
+	if err = smallBenTx.Committed(); err != nil {
+		t.Fatalf("Cannot apply the changes: %s", err.Error())
+	}
+	if len(s.smallBen.scheduler.cron.Entries()) != len(jobs)-1 {
+		t.Errorf("Wrong number of scheduled jobs. Got: %d", len(s.smallBen.scheduler.cron.Entries()))
+	}
+	checkErrorIsOf(smallBenTx.Committed(), ErrTxDone, t)
+
+	// the teardown cannot delete a job that is missing
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{
+		JobIDs: []int64{jobs[0].ID, jobs[1].ID, jobs[3].ID},
+	}})
+	if err != nil {
+		t.Errorf("Fail to delete: %s", err.Error())
+	}
+}
+
+func TestSmallBenTx(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestTx(t)
+		// the jobs have already been deleted
+		test.teardown(true, t)
+	}
+}
+
+// TestTxConcurrent tests that the operations of a Tx wait for
+// the ones of SmallBen, so that their checks are not interleaved.
+func (s *SmallBenTestSuite) TestTxConcurrent(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Fatalf("Cannot even start: %s", err.Error())
+	}
+	repository := s.smallBen.repository.(*RepositorySQL)
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+	if err = s.smallBen.AddJobs(jobs); err != nil {
+		t.Fatalf("Fail to add jobs: %s", err.Error())
+	}
+
+	tx, err := repository.db.Begin()
+	if err != nil {
+		t.Fatalf("Cannot begin: %s", err.Error())
+	}
+	smallBenTx, err := s.smallBen.WithTx(tx)
+	if err != nil {
+		t.Fatalf("Cannot use the transaction: %s", err.Error())
+	}
+
+	// an operation of SmallBen is in progress.
+	s.smallBen.lock.Lock()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- smallBenTx.PauseJobs(&PauseResumeOptions{JobIDs: []int64{jobs[0].ID}})
+	}()
+	select {
+	case err = <-errs:
+		t.Errorf("The operation of the transaction has not waited: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	s.smallBen.lock.Unlock()
+	select {
+	case err = <-errs:
+		if err != nil {
+			t.Errorf("Fail to act within the transaction: %s", err.Error())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("The operation of the transaction is blocked")
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Cannot commit: %s", err.Error())
+	}
+	if err = smallBenTx.Committed(); err != nil {
+		t.Errorf("Cannot apply the changes: %s", err.Error())
+	}
+	paused := true
+	pausedJobs, err := s.smallBen.ListJobs(&ListJobsOptions{Paused: &paused})
+	if err != nil {
+		t.Fatalf("Fail to list jobs: %s", err.Error())
+	}
+	if len(pausedJobs) != 1 || pausedJobs[0].ID != jobs[0].ID {
+		t.Errorf("Wrong paused jobs: %+v", pausedJobs)
+	}
+}
+
+func TestSmallBenTxConcurrent(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestTxConcurrent(t)
+		test.teardown(false, t)
+	}
+}
+
+// TestSmallBenTxNotSupported tests that the repositories that
+// cannot participate in a transaction are reported.
+func TestSmallBenTxNotSupported(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+	_, err := New(repository, &Config{}).WithTx(nil)
+	checkErrorIsOf(err, ErrTxNotSupported, t)
+}
@@ -0,0 +1,312 @@
+package smallben
+
+import (
+	"context"
+	"reflect"
+)
+
+// UpsertResult reports what UpsertJobs did.
+type UpsertResult struct {
+	// Created are the IDs of the jobs that have been added.
+	Created []int64
+	// Updated are the IDs of the jobs that have been updated.
+	Updated []int64
+	// Unchanged are the IDs of the jobs that were
+	// already up to date.
+	Unchanged []int64
+}
+
+// UpsertJobs adds the jobs of `jobs` that do not exist yet, and updates the other ones,
+// within a single repository transaction. Jobs are matched by ID or, if their ID is zero,
+// by their Name within their SuperGroupID. Jobs whose ID is still zero are assigned a new
+// ID, set in `jobs` just as in AddJobs.
+//
+// Existing jobs are rescheduled only if something used by their executions changed,
+// e.g., their schedule, their input or the CronJob itself, while their paused state
+// is left untouched: paused jobs are updated but stay paused.
+//
+// In case of errors, it is guaranteed that, in the worst case, the jobs to reschedule will be
+// removed from the scheduler while being in the repository with their old version.
+func (s *SmallBen) UpsertJobs(jobs []Job) (result UpsertResult, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err = s.journaled(func(tx *Tx) error {
+		result, err = tx.upsertJobs(jobs)
+		return err
+	}); err != nil {
+		return UpsertResult{}, err
+	}
+	return result, nil
+}
+
+// UpsertJobs adds and updates `jobs` in the repository within the transaction,
+// just as SmallBen.UpsertJobs does. They are scheduled by Committed.
+func (t *Tx) UpsertJobs(jobs []Job) (UpsertResult, error) {
+	end, err := t.begin()
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	defer end()
+	return t.upsertJobs(jobs)
+}
+
+// upsertJobs is Tx.UpsertJobs, for callers holding the locks of the Tx and of SmallBen.
+func (t *Tx) upsertJobs(jobs []Job) (result UpsertResult, err error) {
+	s := t.smallBen
+
+	ctx, span := s.startOperation("UpsertJobs", nil)
+	defer func() { endSpan(span, err) }()
+
+	plan, err := s.planUpsert(ctx, t.repository, jobs)
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	span.SetAttributes(AttributeJobIDs.Int64Slice(getIdsFromJobList(jobs)))
+	if len(plan.created)+len(plan.updated) == 0 {
+		s.logger.Info("Upserting jobs", "Progress", "Done", "Details", "NothingChanged", "IDs", getIdsFromJobList(jobs))
+		return plan.result, nil
+	}
+	if err = s.traceRepository(ctx, "UpsertJobs", func() error {
+		return t.repository.UpsertJobs(append(append([]JobWithSchedule(nil), plan.created...), plan.updated...))
+	}); err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "UpsertingInRepository", "IDs", getIdsFromJobList(jobs))
+		return UpsertResult{}, err
+	}
+
+	// the changes are journaled by their events.
+	created, err := t.journal(EventJobAdded, getRawJobsFromJobsWithScheduleList(plan.created))
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	updated, err := t.journal(EventJobUpdated, getRawJobsFromJobsWithScheduleList(plan.updated))
+	if err != nil {
+		return UpsertResult{}, err
+	}
+	t.pending = append(t.pending, pendingChange{entries: append(created, updated...), apply: func() error {
+		s.scheduler.DeleteJobs(plan.previous)
+		// the jobs exist anyway, even if they cannot be scheduled.
+		s.trackUpserted(plan)
+		if err := s.schedule(append(append([]JobWithSchedule(nil), plan.created...), plan.rescheduled()...)); err != nil {
+			s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "SetCronID", "IDs", getIdsFromJobList(jobs))
+			// the rescheduled jobs are not running anymore.
+			s.metrics.pauseJobs(len(plan.rescheduled()))
+			return err
+		}
+		s.events.emitWithSchedule(EventJobAdded, plan.created)
+		s.events.emitWithSchedule(EventJobUpdated, plan.updated)
+		s.metrics.addJobs(len(plan.created))
+		s.logger.Info("Upserting jobs", "Progress", "Done", "IDs", getIdsFromJobList(jobs))
+		return nil
+	}})
+	return plan.result, nil
+}
+
+// upsertPlan is what UpsertJobs does.
+type upsertPlan struct {
+	result UpsertResult
+	// created are the jobs to add.
+	created []JobWithSchedule
+	// createdJobs are the Job of created.
+	createdJobs []Job
+	// updated are the jobs to update.
+	updated []JobWithSchedule
+	// updatedJobs are the Job of updated.
+	updatedJobs []Job
+	// previous are the jobs to remove from the scheduler,
+	// i.e., the old version of the updated jobs to reschedule.
+	previous []RawJob
+	// reschedule specifies, for each job of updated,
+	// whether it has to be rescheduled.
+	reschedule []bool
+}
+
+// rescheduled returns the updated jobs to reschedule.
+func (p *upsertPlan) rescheduled() []JobWithSchedule {
+	var rescheduled []JobWithSchedule
+	for i := range p.updated {
+		if p.reschedule[i] {
+			rescheduled = append(rescheduled, p.updated[i])
+		}
+	}
+	return rescheduled
+}
+
+// planUpsert splits `jobs` into the ones to add to `repository`, the ones to update
+// and the unchanged ones, making sure their names and their upstreams are valid.
+func (s *SmallBen) planUpsert(ctx context.Context, repository Repository, jobs []Job) (plan upsertPlan, err error) {
+	// first, grab the jobs that already exist
+	existing, err := s.existingJobs(ctx, repository, jobs)
+	if err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "RetrievingFromRepository")
+		return plan, err
+	}
+	if err = s.assignIDs(jobs); err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "AssigningIDs")
+		return plan, err
+	}
+	s.logger.Info("Upserting jobs", "Progress", "InProgress", "IDs", getIdsFromJobList(jobs))
+
+	if err = s.checkNames(ctx, repository, jobs); err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "CheckingNames", "IDs", getIdsFromJobList(jobs))
+		return plan, err
+	}
+	if err = s.checkUpstreams(ctx, repository, jobs); err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "CheckingUpstreams", "IDs", getIdsFromJobList(jobs))
+		return plan, err
+	}
+
+	triggered, err := s.checkChains(ctx, repository, jobs)
+	if err != nil {
+		s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "CheckingChains", "IDs", getIdsFromJobList(jobs))
+		return plan, err
+	}
+
+	// split the jobs into the created and the updated ones.
+	for _, job := range jobs {
+		job.triggered = triggered[job.ID]
+		jobWithSchedule, err := job.toJobWithSchedule()
+		if err != nil {
+			s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "BuildingJobWithSchedule", "ID", job.ID)
+			return upsertPlan{}, err
+		}
+		// serialize the job, to compare it with the existing one
+		rawJob, err := jobWithSchedule.BuildJob()
+		if err != nil {
+			s.logger.Error(err, "Upserting jobs", "Progress", "Error", "Details", "BuildingRawJob", "ID", job.ID)
+			return upsertPlan{}, err
+		}
+		jobWithSchedule.rawJob = rawJob
+		old, ok := existing[job.ID]
+		if !ok {
+			plan.created = append(plan.created, jobWithSchedule)
+			plan.createdJobs = append(plan.createdJobs, job)
+			plan.result.Created = append(plan.result.Created, job.ID)
+			continue
+		}
+		if !jobChanged(&old, &jobWithSchedule.rawJob) {
+			plan.result.Unchanged = append(plan.result.Unchanged, job.ID)
+			continue
+		}
+		// keep the state of the existing job
+		jobWithSchedule.rawJob.Paused = old.Paused
+		jobWithSchedule.rawJob.CronID = old.CronID
+		jobWithSchedule.rawJob.CreatedAt = old.CreatedAt
+		jobWithSchedule.rawJob.NextRunAt = old.NextRunAt
+		jobWithSchedule.rawJob.LastRunAt = old.LastRunAt
+		jobWithSchedule.rawJob.LastOutcome = old.LastOutcome
+		toReschedule := !old.Paused && executionChanged(&old, &jobWithSchedule.rawJob)
+		if toReschedule {
+			plan.previous = append(plan.previous, old)
+		}
+		plan.updated = append(plan.updated, jobWithSchedule)
+		plan.updatedJobs = append(plan.updatedJobs, job)
+		plan.reschedule = append(plan.reschedule, toReschedule)
+		plan.result.Updated = append(plan.result.Updated, job.ID)
+	}
+	return plan, nil
+}
+
+// trackUpserted keeps track of the dependencies
+// of the jobs of `plan`, once they have been stored.
+func (s *SmallBen) trackUpserted(plan upsertPlan) {
+	s.workflows.add(plan.createdJobs)
+	s.workflows.remove(getIdsFromJobList(plan.updatedJobs))
+	s.workflows.add(plan.updatedJobs)
+}
+
+// existingJobs returns the jobs of `jobs` that are already in `repository`,
+// indexed by their ID. The ID of the jobs of `jobs` matched by their name is set.
+func (s *SmallBen) existingJobs(ctx context.Context, repository Repository, jobs []Job) (map[int64]RawJob, error) {
+	var ids []int64
+	var superGroupIDs []int64
+	var names []string
+	for _, job := range jobs {
+		if job.ID != 0 {
+			ids = append(ids, job.ID)
+		} else if job.Name != "" {
+			superGroupIDs = append(superGroupIDs, job.SuperGroupID)
+			names = append(names, job.Name)
+		}
+	}
+	existing := make(map[int64]RawJob, len(jobs))
+	if len(ids) > 0 {
+		var rawJobs []RawJob
+		if err := s.traceRepository(ctx, "ListJobs", func() error {
+			var err error
+			rawJobs, err = repository.ListJobs(&ListJobsOptions{JobIDs: ids, AllowMissing: true})
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		for _, rawJob := range rawJobs {
+			existing[rawJob.ID] = rawJob
+		}
+	}
+	if len(names) > 0 {
+		var rawJobs []RawJob
+		if err := s.traceRepository(ctx, "ListJobs", func() error {
+			var err error
+			rawJobs, err = repository.ListJobs(&ListJobsOptions{SuperGroupIDs: superGroupIDs, JobNames: names})
+			return err
+		}); err != nil {
+			return nil, err
+		}
+		byName := make(map[jobName]RawJob, len(rawJobs))
+		for _, rawJob := range rawJobs {
+			byName[jobName{superGroupID: rawJob.SuperGroupID, name: rawJob.Name}] = rawJob
+		}
+		for i := range jobs {
+			if jobs[i].ID != 0 || jobs[i].Name == "" {
+				continue
+			}
+			if rawJob, ok := byName[jobName{superGroupID: jobs[i].SuperGroupID, name: jobs[i].Name}]; ok {
+				jobs[i].ID = rawJob.ID
+				existing[rawJob.ID] = rawJob
+			}
+		}
+	}
+	return existing, nil
+}
+
+// jobChanged returns whether the definition of the job `new`
+// differs from the one of `old`.
+func jobChanged(old, new *RawJob) bool {
+	return executionChanged(old, new) ||
+		old.Description != new.Description ||
+		old.Owner != new.Owner ||
+		!reflect.DeepEqual(decodeLabels(old.Labels), decodeLabels(new.Labels))
+}
+
+// executionChanged returns whether the fields of `new` used
+// by its executions differ from the ones of `old`, so that
+// the job has to be rescheduled.
+func executionChanged(old, new *RawJob) bool {
+	return old.CronExpression != new.CronExpression ||
+		!sameSerializedJob(old.SerializedJob, new.SerializedJob) ||
+		old.SerializedJobInput != new.SerializedJobInput ||
+		old.GroupID != new.GroupID ||
+		old.SuperGroupID != new.SuperGroupID ||
+		old.Name != new.Name ||
+		old.UpstreamIDs != new.UpstreamIDs ||
+		old.OnSuccess != new.OnSuccess ||
+		old.OnFailure != new.OnFailure
+}
+
+// sameSerializedJob returns whether the serialized CronJob `old` equals `new`.
+// Since Gob encodes the maps in no particular order, the same CronJob may be
+// encoded differently, so the decoded jobs are compared in that case.
+func sameSerializedJob(old, new string) bool {
+	if old == new {
+		return true
+	}
+	oldJob, err := decodeJob(old)
+	if err != nil {
+		return false
+	}
+	newJob, err := decodeJob(new)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(oldJob, newJob)
+}
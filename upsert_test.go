@@ -0,0 +1,151 @@
+package smallben
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"reflect"
+	"testing"
+)
+
+// checkUpsertResult checks that `result` matches the expected IDs.
+func checkUpsertResult(result UpsertResult, created, updated, unchanged []int64, t *testing.T) {
+	if !reflect.DeepEqual(result.Created, created) || !reflect.DeepEqual(result.Updated, updated) ||
+		!reflect.DeepEqual(result.Unchanged, unchanged) {
+		t.Errorf("Wrong upsert result. Got: %+v, expected: %v created, %v updated, %v unchanged\n",
+			result, created, updated, unchanged)
+	}
+}
+
+func (s *SmallBenTestSuite) TestUpsert(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+	jobs := make([]Job, len(s.jobs))
+	copy(jobs, s.jobs)
+	jobs[3].Name = "last"
+	ids := getIdsFromJobList(jobs)
+
+	result, err := s.smallBen.UpsertJobs(jobs)
+	if err != nil {
+		t.Errorf("Fail to upsert jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkUpsertResult(result, ids, nil, nil, t)
+	err = s.smallBen.PauseJobs(&PauseResumeOptions{JobIDs: []int64{jobs[1].ID}})
+	if err != nil {
+		t.Errorf("Fail to pause jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	before, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: ids})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// nothing changed
+	result, err = s.smallBen.UpsertJobs(jobs)
+	if err != nil {
+		t.Errorf("Fail to upsert jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkUpsertResult(result, nil, nil, ids, t)
+
+	// change the schedule of the first job, the input of the
+	// second one, that is paused, and the description of the third one.
+	newExpression := "@every 75s"
+	jobs[0].CronExpression = newExpression
+	jobs[1].JobInput = map[string]interface{}{"test_id": 20}
+	jobs[2].Description = "Third job"
+	// the last job is matched by its name
+	jobs[3].ID = 0
+	result, err = s.smallBen.UpsertJobs(jobs)
+	if err != nil {
+		t.Errorf("Fail to upsert jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	checkUpsertResult(result, nil, ids[:3], ids[3:], t)
+	if jobs[3].ID != ids[3] {
+		t.Errorf("The job should have been matched by name. Got: %d, expected: %d\n", jobs[3].ID, ids[3])
+	}
+
+	after, err := s.smallBen.ListJobs(&ListJobsOptions{JobIDs: ids})
+	if err != nil {
+		t.Errorf("Fail to list jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if after[0].CronExpression != newExpression || after[0].cronID == before[0].cronID || after[0].Paused() {
+		t.Errorf("The first job should have been rescheduled: %+v\n", after[0])
+	}
+	if !after[1].Paused() || after[1].cronID != DefaultCronID || after[1].JobInput["test_id"] != float64(20) {
+		t.Errorf("The second job should have been updated, but still paused: %+v\n", after[1])
+	}
+	if after[2].Description != "Third job" || after[2].cronID != before[2].cronID {
+		t.Errorf("The third job should have been updated, without being rescheduled: %+v\n", after[2])
+	}
+	if !after[0].CreatedAt().Equal(before[0].CreatedAt()) {
+		t.Errorf("The creation time should have been kept\n")
+	}
+	// the paused job is not in the scheduler
+	if entries := len(s.smallBen.scheduler.cron.Entries()); entries != len(jobs)-1 {
+		t.Errorf("Wrong number of entries. Got: %d, expected: %d\n", entries, len(jobs)-1)
+	}
+}
+
+func TestSmallBenUpsert(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestUpsert(t)
+		test.teardown(false, t)
+	}
+}
+
+func init() {
+	gob.Register(&UpsertTestCronJob{})
+}
+
+// UpsertTestCronJob is a CronJob with a map field,
+// whose encodings differ from time to time.
+type UpsertTestCronJob struct {
+	Settings map[string]int
+}
+
+func (u *UpsertTestCronJob) Run(_ CronJobInput) {}
+
+// TestUpsertMapField tests that a job with a map field
+// is unchanged when upserted once again.
+func TestUpsertMapField(t *testing.T) {
+	repository, _ := newBoltTestRepository(t)
+	smallBen := New(repository, &Config{
+		Logger:          zapr.NewLogger(zap.NewExample()),
+		SchedulerConfig: SchedulerConfig{WithSeconds: true},
+	})
+	settings := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		settings[fmt.Sprint("key", i)] = i
+	}
+	job := Job{ID: 1, GroupID: 1, SuperGroupID: 1, CronExpression: "@every 70s",
+		Job: &UpsertTestCronJob{Settings: settings}}
+	result, err := smallBen.UpsertJobs([]Job{job})
+	if err != nil {
+		t.Fatalf("Fail to upsert jobs: %s", err.Error())
+	}
+	checkUpsertResult(result, []int64{1}, nil, nil, t)
+	for i := 0; i < 5; i++ {
+		if result, err = smallBen.UpsertJobs([]Job{job}); err != nil {
+			t.Fatalf("Fail to upsert jobs: %s", err.Error())
+		}
+		checkUpsertResult(result, nil, nil, []int64{1}, t)
+	}
+
+	settings["key0"] = 100
+	if result, err = smallBen.UpsertJobs([]Job{job}); err != nil {
+		t.Fatalf("Fail to upsert jobs: %s", err.Error())
+	}
+	checkUpsertResult(result, nil, []int64{1}, nil, t)
+}
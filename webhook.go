@@ -0,0 +1,381 @@
+package smallben
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader is the header containing the signature
+	// of the payload, in the form `sha256=<hex-encoded HMAC>`.
+	WebhookSignatureHeader = "X-SmallBen-Signature"
+	// DefaultWebhookMaxAttempts is the number of attempts done
+	// to deliver a webhook when WebhookConfig.MaxAttempts is not set.
+	DefaultWebhookMaxAttempts = 3
+	// DefaultWebhookBackoff is the wait before the first retry
+	// when WebhookConfig.Backoff is not set.
+	DefaultWebhookBackoff = time.Second
+	// DefaultWebhookWorkers is the number of goroutines delivering
+	// the webhooks when WebhookConfig.Workers is not set.
+	DefaultWebhookWorkers = 4
+	// DefaultWebhookQueueSize is the number of deliveries queued
+	// when WebhookConfig.QueueSize is not set.
+	DefaultWebhookQueueSize = 256
+	// webhookDrainLimit is the maximum number of bytes of a response
+	// read and discarded, so that its connection can be reused.
+	webhookDrainLimit = 64 << 10
+)
+
+// WebhookType is the type of a webhook.
+type WebhookType string
+
+const (
+	// WebhookJobFailed is sent when an execution of a job fails.
+	WebhookJobFailed = WebhookType("job_failed")
+	// WebhookJobRecovered is sent when an execution of a job succeeds
+	// after a failed one.
+	WebhookJobRecovered = WebhookType("job_recovered")
+)
+
+// WebhookDestination is an URL webhooks are sent to.
+type WebhookDestination struct {
+	// URL is where the payload is POSTed.
+	URL string
+	// Secret is the key used to sign the payload with HMAC-SHA256.
+	Secret string
+}
+
+// WebhookConfig configures a WebhookNotifier.
+type WebhookConfig struct {
+	// Destinations maps a SuperGroupID to the destinations
+	// of the webhooks about the jobs of that super group.
+	Destinations map[int64][]WebhookDestination
+	// DefaultDestinations are used for the jobs whose SuperGroupID
+	// is not in Destinations.
+	DefaultDestinations []WebhookDestination
+	// MaxAttempts is the maximum number of attempts to deliver a webhook.
+	// If not set, DefaultWebhookMaxAttempts is used.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled at each retry.
+	// If not set, DefaultWebhookBackoff is used.
+	Backoff time.Duration
+	// Workers is the number of goroutines delivering the webhooks.
+	// If not set, DefaultWebhookWorkers is used.
+	Workers int
+	// QueueSize is the number of deliveries waiting for a worker: when
+	// the queue is full, new deliveries are dropped, and logged.
+	// If not set, DefaultWebhookQueueSize is used.
+	QueueSize int
+	// Client is the client used to send the webhooks.
+	// If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Repository stores the log of the deliveries. If nil,
+	// deliveries are not persisted.
+	Repository WebhookDeliveryRepository
+	// Logger is the logger to use. If nil, nothing is logged.
+	Logger logr.Logger
+}
+
+// WebhookPayload is the JSON payload of a webhook.
+type WebhookPayload struct {
+	// Type is the type of the webhook.
+	Type WebhookType `json:"type"`
+	// JobID is the ID of the job.
+	JobID int64 `json:"job_id"`
+	// GroupID is the GroupID of the job.
+	GroupID int64 `json:"group_id"`
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64 `json:"super_group_id"`
+	// JobName is the name of the job, if any.
+	JobName string `json:"job_name,omitempty"`
+	// Time is when the execution finished.
+	Time time.Time `json:"time"`
+	// Error is the error the execution failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// WebhookDelivery is the log of the delivery of a webhook to a destination.
+type WebhookDelivery struct {
+	// ID is the ID of the delivery, assigned by the repository.
+	ID int64 `gorm:"primaryKey;column:id"`
+	// Type is the type of the webhook.
+	Type WebhookType `gorm:"column:type"`
+	// JobID is the ID of the job the webhook is about.
+	JobID int64 `gorm:"column:job_id"`
+	// SuperGroupID is the SuperGroupID of the job.
+	SuperGroupID int64 `gorm:"column:super_group_id"`
+	// URL is the destination of the webhook.
+	URL string `gorm:"column:url"`
+	// Attempts is the number of attempts done.
+	Attempts int `gorm:"column:attempts"`
+	// StatusCode is the status code of the last response, 0 if none.
+	StatusCode int `gorm:"column:status_code"`
+	// Delivered is whether the webhook has been delivered.
+	Delivered bool `gorm:"column:delivered"`
+	// Error is the error of the last attempt, if any.
+	Error string `gorm:"column:error_message"`
+	// CreatedAt specifies when the delivery has been completed.
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (w *WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// ListWebhookDeliveriesOptions defines the options
+// to use when listing the deliveries.
+// All options are *combined*, i.e., with an `AND`.
+type ListWebhookDeliveriesOptions struct {
+	// JobIDs filters the deliveries by the given job ID.
+	// If nil, it is ignored.
+	JobIDs []int64
+	// Delivered filters the deliveries by the outcome.
+	// If nil, it is ignored.
+	Delivered *bool
+}
+
+// WebhookDeliveryRepository is the interface storage backends
+// should implement to persist the log of the webhook deliveries.
+type WebhookDeliveryRepository interface {
+	// AddWebhookDelivery stores `delivery`, setting `delivery.ID`.
+	AddWebhookDelivery(delivery *WebhookDelivery) error
+	// ListWebhookDeliveries lists the deliveries according to `options`.
+	// If options is `nil`, no filtering is applied.
+	ListWebhookDeliveries(options *ListWebhookDeliveriesOptions) ([]WebhookDelivery, error)
+}
+
+// WebhookNotifier is a Listener sending webhooks when an execution
+// of a job fails, or succeeds after a failed one, according to the
+// outcome of its previous execution, as stored in the repository.
+// It should be registered by using Config.Listeners or SmallBen.Subscribe.
+//
+// The webhooks are delivered, and retried, by a pool of goroutines, so
+// that OnEvent never waits for the destinations: Close stops them.
+type WebhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+	// deliveries are the deliveries
+	// waiting for a worker.
+	deliveries chan webhookTask
+	// stop is closed by Close, to interrupt the retries.
+	stop chan struct{}
+	// lock protects closed, set by Close.
+	lock    sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// webhookTask is the delivery of a payload to a destination.
+type webhookTask struct {
+	payload     WebhookPayload
+	body        []byte
+	destination WebhookDestination
+}
+
+// NewWebhookNotifier returns a new WebhookNotifier configured by `config`,
+// starting its workers.
+func NewWebhookNotifier(config *WebhookConfig) *WebhookNotifier {
+	notifier := &WebhookNotifier{
+		config: *config,
+		client: config.Client,
+		stop:   make(chan struct{}),
+	}
+	if notifier.client == nil {
+		notifier.client = http.DefaultClient
+	}
+	if notifier.config.MaxAttempts <= 0 {
+		notifier.config.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+	if notifier.config.Backoff <= 0 {
+		notifier.config.Backoff = DefaultWebhookBackoff
+	}
+	if notifier.config.Workers <= 0 {
+		notifier.config.Workers = DefaultWebhookWorkers
+	}
+	if notifier.config.QueueSize <= 0 {
+		notifier.config.QueueSize = DefaultWebhookQueueSize
+	}
+	if notifier.config.Logger == nil {
+		notifier.config.Logger = zapr.NewLogger(zap.NewNop())
+	}
+	notifier.deliveries = make(chan webhookTask, notifier.config.QueueSize)
+	for i := 0; i < notifier.config.Workers; i++ {
+		notifier.workers.Add(1)
+		go notifier.work()
+	}
+	return notifier
+}
+
+// Close stops the workers, once they have delivered the webhooks
+// already queued, without retrying them anymore. The events
+// received afterwards are ignored.
+func (w *WebhookNotifier) Close() {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	w.closed = true
+	close(w.deliveries)
+	close(w.stop)
+	w.lock.Unlock()
+	w.workers.Wait()
+}
+
+// OnEvent queues a webhook if `event` is a failure, or if it is
+// a success after a failure. It implements the Listener interface.
+func (w *WebhookNotifier) OnEvent(event Event) {
+	var webhookType WebhookType
+	switch event.Type {
+	case EventJobFailed:
+		webhookType = WebhookJobFailed
+	case EventJobFinished:
+		if event.PreviousOutcome == JobOutcomeFailed {
+			webhookType = WebhookJobRecovered
+		}
+	}
+	if webhookType == "" {
+		return
+	}
+	payload := WebhookPayload{
+		Type:         webhookType,
+		JobID:        event.JobID,
+		GroupID:      event.GroupID,
+		SuperGroupID: event.SuperGroupID,
+		JobName:      event.JobName,
+		Time:         event.Time,
+	}
+	if event.Error != nil {
+		payload.Error = event.Error.Error()
+	}
+	w.notify(payload)
+}
+
+// destinations returns the destinations for the jobs of `superGroupID`.
+func (w *WebhookNotifier) destinations(superGroupID int64) []WebhookDestination {
+	if destinations, ok := w.config.Destinations[superGroupID]; ok {
+		return destinations
+	}
+	return w.config.DefaultDestinations
+}
+
+// notify queues the delivery of `payload` to all its destinations.
+func (w *WebhookNotifier) notify(payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		w.config.Logger.Error(err, "Sending webhook", "Progress", "Error", "Details", "Encoding", "ID", payload.JobID)
+		return
+	}
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.closed {
+		return
+	}
+	for _, destination := range w.destinations(payload.SuperGroupID) {
+		select {
+		case w.deliveries <- webhookTask{payload: payload, body: body, destination: destination}:
+		default:
+			w.config.Logger.Info("Sending webhook", "Progress", "Dropped", "URL", destination.URL, "ID", payload.JobID)
+		}
+	}
+}
+
+// work delivers the queued webhooks, logging
+// each delivery, until the queue is closed.
+func (w *WebhookNotifier) work() {
+	defer w.workers.Done()
+	for task := range w.deliveries {
+		delivery := w.deliver(task.destination, task.body)
+		delivery.Type = task.payload.Type
+		delivery.JobID = task.payload.JobID
+		delivery.SuperGroupID = task.payload.SuperGroupID
+		if w.config.Repository != nil {
+			if err := w.config.Repository.AddWebhookDelivery(&delivery); err != nil {
+				w.config.Logger.Error(err, "Sending webhook", "Progress", "Error", "Details", "AddingToRepository", "ID", task.payload.JobID)
+			}
+		}
+	}
+}
+
+// deliver POSTs `body` to `destination`, retrying with an
+// exponential backoff in case of errors, until Close.
+func (w *WebhookNotifier) deliver(destination WebhookDestination, body []byte) WebhookDelivery {
+	delivery := WebhookDelivery{URL: destination.URL}
+	backoff := w.config.Backoff
+	for delivery.Attempts < w.config.MaxAttempts {
+		if delivery.Attempts > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-w.stop:
+				delivery.CreatedAt = time.Now()
+				return delivery
+			}
+			backoff *= 2
+		}
+		delivery.Attempts++
+		statusCode, err := w.post(destination, body)
+		delivery.StatusCode = statusCode
+		if err == nil {
+			delivery.Delivered = true
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		w.config.Logger.Info("Sending webhook", "Progress", "Error", "URL", destination.URL,
+			"Attempt", delivery.Attempts, "Error", delivery.Error)
+	}
+	delivery.CreatedAt = time.Now()
+	return delivery
+}
+
+// post POSTs `body` to `destination`, returning an error
+// if the response status code is not 2xx.
+func (w *WebhookNotifier) post(destination WebhookDestination, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, destination.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, SignWebhook(destination.Secret, body))
+	response, err := w.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		// drain the body, so that the connection can be reused,
+		// unless it is too long to be worth it.
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, webhookDrainLimit))
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected status code: %d", response.StatusCode)
+	}
+	return response.StatusCode, nil
+}
+
+// SignWebhook returns the signature of `body` using `secret`,
+// as sent in the WebhookSignatureHeader header.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	// writing to a hash never fails
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook returns whether `signature` is the valid
+// signature of `body` using `secret`.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
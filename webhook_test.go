@@ -0,0 +1,282 @@
+package smallben
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWebhookServer records the payloads it receives,
+// failing the first `failures` requests.
+type testWebhookServer struct {
+	secret   string
+	failures int
+	requests int
+	payloads []WebhookPayload
+	lock     sync.Mutex
+}
+
+func (s *testWebhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if !VerifyWebhook(s.secret, body, r.Header.Get(WebhookSignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.requests++
+	if s.requests <= s.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.payloads = append(s.payloads, payload)
+}
+
+// testWebhookDeliveryRepository keeps the deliveries in memory.
+type testWebhookDeliveryRepository struct {
+	deliveries []WebhookDelivery
+	lock       sync.Mutex
+}
+
+func (r *testWebhookDeliveryRepository) AddWebhookDelivery(delivery *WebhookDelivery) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delivery.ID = int64(len(r.deliveries) + 1)
+	r.deliveries = append(r.deliveries, *delivery)
+	return nil
+}
+
+func (r *testWebhookDeliveryRepository) ListWebhookDeliveries(options *ListWebhookDeliveriesOptions) ([]WebhookDelivery, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]WebhookDelivery(nil), r.deliveries...), nil
+}
+
+// waitFor waits until `expected` deliveries have been logged.
+func (r *testWebhookDeliveryRepository) waitFor(expected int, t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if deliveries, _ := r.ListWebhookDeliveries(nil); len(deliveries) >= expected {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Wrong number of deliveries. Expected: %d\n", expected)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	server := &testWebhookServer{secret: "secret", failures: 1}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	otherServer := &testWebhookServer{secret: "other"}
+	otherHttpServer := httptest.NewServer(otherServer)
+	defer otherHttpServer.Close()
+
+	failingServer := &testWebhookServer{secret: "failing", failures: 100}
+	failingHttpServer := httptest.NewServer(failingServer)
+	defer failingHttpServer.Close()
+
+	repository := &testWebhookDeliveryRepository{}
+	notifier := NewWebhookNotifier(&WebhookConfig{
+		Destinations: map[int64][]WebhookDestination{
+			2: {{URL: otherHttpServer.URL, Secret: "other"}},
+			3: {{URL: failingHttpServer.URL, Secret: "failing"}},
+		},
+		DefaultDestinations: []WebhookDestination{{URL: httpServer.URL, Secret: "secret"}},
+		Backoff:             time.Millisecond,
+		Repository:          repository,
+		// a single worker delivers the webhooks in order
+		Workers: 1,
+	})
+
+	// a success without a previous failure is not notified
+	notifier.OnEvent(Event{Type: EventJobFinished, JobID: 1, SuperGroupID: 1})
+	// the failure is retried once
+	notifier.OnEvent(Event{Type: EventJobFailed, JobID: 1, SuperGroupID: 1, Error: errors.New("failed")})
+	// the recovery is notified, according to the previous outcome
+	notifier.OnEvent(Event{Type: EventJobFinished, JobID: 1, SuperGroupID: 1, PreviousOutcome: JobOutcomeFailed})
+	notifier.OnEvent(Event{Type: EventJobFinished, JobID: 1, SuperGroupID: 1, PreviousOutcome: JobOutcomeSucceeded})
+	// this one goes to the other destination
+	notifier.OnEvent(Event{Type: EventJobFailed, JobID: 2, SuperGroupID: 2, Error: errors.New("failed")})
+	// and this one to a destination always failing
+	notifier.OnEvent(Event{Type: EventJobFailed, JobID: 3, SuperGroupID: 3, Error: errors.New("failed")})
+	repository.waitFor(4, t)
+	notifier.Close()
+	notifier.OnEvent(Event{Type: EventJobFailed, JobID: 1, SuperGroupID: 1, Error: errors.New("failed")})
+
+	if len(server.payloads) != 2 {
+		t.Errorf("Wrong number of webhooks. Got: %d, expected: %d\n", len(server.payloads), 2)
+		t.FailNow()
+	}
+	if server.payloads[0].Type != WebhookJobFailed || server.payloads[0].Error != "failed" {
+		t.Errorf("Wrong first webhook. Got: %+v\n", server.payloads[0])
+	}
+	if server.payloads[1].Type != WebhookJobRecovered {
+		t.Errorf("Wrong second webhook. Got: %+v\n", server.payloads[1])
+	}
+	if len(otherServer.payloads) != 1 || otherServer.payloads[0].JobID != 2 {
+		t.Errorf("Wrong webhooks for the other destination. Got: %+v\n", otherServer.payloads)
+	}
+
+	if len(repository.deliveries) != 4 {
+		t.Errorf("Wrong number of deliveries. Got: %d, expected: %d\n", len(repository.deliveries), 4)
+		t.FailNow()
+	}
+	if repository.deliveries[0].Attempts != 2 || !repository.deliveries[0].Delivered {
+		t.Errorf("Wrong first delivery. Got: %+v\n", repository.deliveries[0])
+	}
+	last := repository.deliveries[3]
+	if last.Delivered || last.Attempts != DefaultWebhookMaxAttempts || last.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Wrong failed delivery. Got: %+v\n", last)
+	}
+}
+
+// TestWebhookNotifierClose tests that Close does not wait
+// for the retries of the deliveries in progress.
+func TestWebhookNotifierClose(t *testing.T) {
+	server := &testWebhookServer{secret: "secret", failures: 100}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	repository := &testWebhookDeliveryRepository{}
+	notifier := NewWebhookNotifier(&WebhookConfig{
+		DefaultDestinations: []WebhookDestination{{URL: httpServer.URL, Secret: "secret"}},
+		Backoff:             time.Hour,
+		Repository:          repository,
+	})
+	notifier.OnEvent(Event{Type: EventJobFailed, JobID: 1, SuperGroupID: 1, Error: errors.New("failed")})
+	// wait for the first attempt
+	for i := 0; i < 100; i++ {
+		server.lock.Lock()
+		requests := server.requests
+		server.lock.Unlock()
+		if requests > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		notifier.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close waited for the retries")
+	}
+	if len(repository.deliveries) != 1 || repository.deliveries[0].Delivered || repository.deliveries[0].Attempts != 1 {
+		t.Errorf("Wrong interrupted delivery. Got: %+v\n", repository.deliveries)
+	}
+}
+
+// drainedBody is a response body recording whether
+// it has been read until EOF before being closed.
+type drainedBody struct {
+	io.Reader
+	eof     bool
+	drained bool
+}
+
+func (b *drainedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+func (b *drainedBody) Close() error {
+	b.drained = b.eof
+	return nil
+}
+
+// roundTripperFunc allows to use a function as an http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// TestWebhookNotifierDrain checks that the responses are drained
+// before being closed, so that their connections can be reused.
+func TestWebhookNotifierDrain(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		body := &drainedBody{Reader: strings.NewReader(strings.Repeat("a", 4096))}
+		notifier := NewWebhookNotifier(&WebhookConfig{
+			Client: &http.Client{Transport: roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: status, Body: body, Request: request}, nil
+			})},
+		})
+		_, _ = notifier.post(WebhookDestination{URL: "http://localhost", Secret: "secret"}, []byte("{}"))
+		notifier.Close()
+		if !body.drained {
+			t.Errorf("The response with status %d has not been drained\n", status)
+		}
+	}
+}
+
+func (r *RepositoryTestSuite) TestWebhookDeliveries(t *testing.T) {
+	repository, ok := r.repository.(WebhookDeliveryRepository)
+	if !ok {
+		return
+	}
+	delivery := WebhookDelivery{
+		Type:      WebhookJobFailed,
+		JobID:     r.jobsToAdd[0].rawJob.ID,
+		URL:       "http://localhost",
+		Attempts:  1,
+		Delivered: true,
+	}
+	if err := repository.AddWebhookDelivery(&delivery); err != nil {
+		t.Errorf("Fail to add delivery: %s\n", err.Error())
+		t.FailNow()
+	}
+	if delivery.ID == 0 {
+		t.Errorf("The ID of the delivery has not been set\n")
+	}
+	delivered := false
+	deliveries, err := repository.ListWebhookDeliveries(&ListWebhookDeliveriesOptions{
+		JobIDs:    []int64{delivery.JobID},
+		Delivered: &delivered,
+	})
+	if err != nil {
+		t.Errorf("Fail to list deliveries: %s\n", err.Error())
+		t.FailNow()
+	}
+	for _, other := range deliveries {
+		if other.ID == delivery.ID {
+			t.Errorf("The delivery should have been filtered out\n")
+		}
+	}
+	deliveries, err = repository.ListWebhookDeliveries(&ListWebhookDeliveriesOptions{JobIDs: []int64{delivery.JobID}})
+	if err != nil {
+		t.Errorf("Fail to list deliveries: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(deliveries) == 0 || deliveries[0].ID != delivery.ID {
+		t.Errorf("The delivery has not been listed\n")
+	}
+}
+
+func TestRepositoryWebhookDeliveries(t *testing.T) {
+	tests := buildRepositoryTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.TestWebhookDeliveries(t)
+	}
+}
@@ -0,0 +1,489 @@
+package smallben
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrWorkflowJobWithSchedule is returned when a job
+	// with upstreams has a cron expression too.
+	// It is an ErrInvalidSchedule.
+	ErrWorkflowJobWithSchedule = fmt.Errorf("%w: a job with upstreams cannot have a cron expression", ErrInvalidSchedule)
+	// ErrWorkflowCycle is returned when the upstreams
+	// of the jobs form a cycle.
+	ErrWorkflowCycle = errors.New("workflow contains a cycle")
+	// ErrWorkflowMultipleRoots is returned when a job depends,
+	// directly or not, on more than one root job.
+	ErrWorkflowMultipleRoots = errors.New("workflow job depends on more than one root job")
+	// ErrJobHasDownstreams is returned when deleting a job
+	// whose downstream jobs are not deleted as well.
+	ErrJobHasDownstreams = errors.New("job has downstream jobs")
+	// ErrWorkflowsNotSupported is returned when using workflows
+	// with a repository not implementing WorkflowRepository.
+	ErrWorkflowsNotSupported = errors.New("repository does not support workflows")
+)
+
+// WorkflowStatus is the status of a workflow run,
+// or of a job within a workflow run.
+type WorkflowStatus string
+
+const (
+	// WorkflowStatusPending means the job has not been executed yet.
+	WorkflowStatusPending = WorkflowStatus("pending")
+	// WorkflowStatusRunning means the job, or the run, is in execution.
+	WorkflowStatusRunning = WorkflowStatus("running")
+	// WorkflowStatusSucceeded means the job, or every job of the run,
+	// completed successfully.
+	WorkflowStatusSucceeded = WorkflowStatus("succeeded")
+	// WorkflowStatusFailed means the job, or at least one job of the run,
+	// failed.
+	WorkflowStatusFailed = WorkflowStatus("failed")
+	// WorkflowStatusSkipped means the job has not been executed because
+	// it is paused or because one of its upstreams did not succeed.
+	// A run is skipped when some of its jobs have been skipped but none failed.
+	WorkflowStatusSkipped = WorkflowStatus("skipped")
+)
+
+// WorkflowRun is a single execution of a workflow, i.e.,
+// of a root job and all the jobs depending on it.
+type WorkflowRun struct {
+	// ID is the ID of the run, assigned by the repository.
+	ID int64 `gorm:"primaryKey;column:id"`
+	// RootJobID is the ID of the job that started the run.
+	RootJobID int64 `gorm:"column:root_job_id"`
+	// ScheduledAt is the time the root job fired. It identifies
+	// the logical schedule instance of the run.
+	ScheduledAt time.Time `gorm:"column:scheduled_at"`
+	// Status is the overall status of the run.
+	Status WorkflowStatus `gorm:"column:status"`
+	// CreatedAt specifies when this run has been created.
+	CreatedAt time.Time `gorm:"column:created_at"`
+	// UpdatedAt specifies the last time this run has been updated.
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+	// Jobs contains the state of each job of the run.
+	Jobs []WorkflowRunJob `gorm:"foreignKey:RunID"`
+}
+
+func (w *WorkflowRun) TableName() string {
+	return "workflow_runs"
+}
+
+// WorkflowRunJob is the state of a job within a WorkflowRun.
+type WorkflowRunJob struct {
+	// RunID is the ID of the run this job belongs to.
+	RunID int64 `gorm:"primaryKey;column:run_id"`
+	// JobID is the ID of the job.
+	JobID int64 `gorm:"primaryKey;column:job_id"`
+	// Status is the status of the job within the run.
+	Status WorkflowStatus `gorm:"column:status"`
+	// StartedAt is when the job started. It is zero if it has not started.
+	StartedAt time.Time `gorm:"column:started_at"`
+	// FinishedAt is when the job finished. It is zero if it has not finished.
+	FinishedAt time.Time `gorm:"column:finished_at"`
+	// Error describes why the job failed or has been skipped.
+	Error string `gorm:"column:error_message"`
+}
+
+func (w *WorkflowRunJob) TableName() string {
+	return "workflow_run_jobs"
+}
+
+// ListWorkflowRunsOptions defines the options
+// to use when listing workflow runs.
+// All options are *combined*, i.e., with an `AND`.
+type ListWorkflowRunsOptions struct {
+	// RootJobIDs filters the runs started by the given jobs.
+	// If nil, it is ignored.
+	RootJobIDs []int64
+	// Statuses filters the runs by their status.
+	// If nil, it is ignored.
+	Statuses []WorkflowStatus
+}
+
+// WorkflowRepository is the interface storage backends should
+// implement in order to support workflows, i.e., jobs with upstreams.
+type WorkflowRepository interface {
+	// AddWorkflowRun stores `run` and its jobs, setting `run.ID`.
+	AddWorkflowRun(run *WorkflowRun) error
+	// UpdateWorkflowRun updates the status of `run`, not of its jobs.
+	//
+	// It must return an error of type ErrorTypeIfMismatchCount()
+	// in case the run does not exist.
+	UpdateWorkflowRun(run *WorkflowRun) error
+	// UpdateWorkflowRunJob updates the status, the timings
+	// and the error of `job`.
+	//
+	// It must return an error of type ErrorTypeIfMismatchCount()
+	// in case the job is not part of the run.
+	UpdateWorkflowRunJob(job *WorkflowRunJob) error
+	// GetWorkflowRun returns the run whose ID is `runID`, together with its jobs.
+	// It must return an error of type ErrorTypeIfMismatchCount()
+	// in case the run does not exist.
+	GetWorkflowRun(runID int64) (WorkflowRun, error)
+	// ListWorkflowRuns lists the runs, together with their jobs, according to `options`.
+	// If options is `nil`, no filtering is applied.
+	ListWorkflowRuns(options *ListWorkflowRunsOptions) ([]WorkflowRun, error)
+	// ListUpstreams returns the upstreams of the jobs having
+	// any, indexed by the ID of the job.
+	ListUpstreams() (map[int64][]int64, error)
+}
+
+// workflowGraph keeps the dependencies between jobs.
+// It has its own lock since it is accessed by running jobs
+// and by the operations of Tx, that do not acquire the lock of SmallBen.
+type workflowGraph struct {
+	lock sync.RWMutex
+	// upstreams maps the ID of a job to the ID of its upstreams.
+	// Only jobs with upstreams are in it.
+	upstreams map[int64][]int64
+	// downstreams maps the ID of a job to the ID of the
+	// jobs depending on it. Only jobs with downstreams are in it.
+	downstreams map[int64][]int64
+}
+
+// newWorkflowGraph returns an empty workflowGraph.
+func newWorkflowGraph() *workflowGraph {
+	return &workflowGraph{
+		upstreams:   make(map[int64][]int64),
+		downstreams: make(map[int64][]int64),
+	}
+}
+
+// add adds the dependencies of `jobs` to the graph.
+func (g *workflowGraph) add(jobs []Job) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, job := range jobs {
+		if _, ok := g.upstreams[job.ID]; ok || len(job.UpstreamIDs) == 0 {
+			continue
+		}
+		g.upstreams[job.ID] = append([]int64(nil), job.UpstreamIDs...)
+		for _, upstream := range job.UpstreamIDs {
+			g.downstreams[upstream] = append(g.downstreams[upstream], job.ID)
+		}
+	}
+}
+
+// addUpstreams adds to the graph the jobs whose
+// upstreams are `upstreams`, indexed by the ID of the job.
+func (g *workflowGraph) addUpstreams(upstreams map[int64][]int64) {
+	jobs := make([]Job, 0, len(upstreams))
+	for id, upstreamIDs := range upstreams {
+		jobs = append(jobs, Job{ID: id, UpstreamIDs: upstreamIDs})
+	}
+	// keep the downstreams sorted by ID, as when listing the jobs.
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	g.add(jobs)
+}
+
+// remove removes the jobs whose ids are `jobsID` from the graph.
+func (g *workflowGraph) remove(jobsID []int64) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, id := range jobsID {
+		for _, upstream := range g.upstreams[id] {
+			var downstreams []int64
+			for _, downstream := range g.downstreams[upstream] {
+				if downstream != id {
+					downstreams = append(downstreams, downstream)
+				}
+			}
+			if len(downstreams) == 0 {
+				delete(g.downstreams, upstream)
+			} else {
+				g.downstreams[upstream] = downstreams
+			}
+		}
+		delete(g.upstreams, id)
+	}
+}
+
+// known returns whether the job whose id is `jobID` is in the graph.
+func (g *workflowGraph) known(jobID int64) bool {
+	_, isDownstream := g.upstreams[jobID]
+	_, isUpstream := g.downstreams[jobID]
+	return isDownstream || isUpstream
+}
+
+// isRoot returns whether `jobID` starts a workflow,
+// i.e., it has downstreams but no upstreams.
+func (g *workflowGraph) isRoot(jobID int64) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	_, hasUpstreams := g.upstreams[jobID]
+	_, hasDownstreams := g.downstreams[jobID]
+	return hasDownstreams && !hasUpstreams
+}
+
+// check checks that adding `jobs` to the graph keeps it valid,
+// i.e., with no cycles and where each job depends on a single root.
+// It returns the ids of the upstreams that are neither in the graph
+// nor in `jobs`, whose existence must be checked by the caller.
+func (g *workflowGraph) check(jobs []Job) ([]int64, error) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	upstreams := make(map[int64][]int64, len(g.upstreams)+len(jobs))
+	for id, ids := range g.upstreams {
+		upstreams[id] = ids
+	}
+	inBatch := make(map[int64]bool, len(jobs))
+	for _, job := range jobs {
+		inBatch[job.ID] = true
+		if len(job.UpstreamIDs) > 0 {
+			upstreams[job.ID] = job.UpstreamIDs
+		} else {
+			// the job may be already in the graph
+			delete(upstreams, job.ID)
+		}
+	}
+
+	var unknown []int64
+	seen := make(map[int64]bool)
+	for _, job := range jobs {
+		for _, upstream := range job.UpstreamIDs {
+			if upstream == job.ID {
+				return nil, ErrWorkflowCycle
+			}
+			if !inBatch[upstream] && !g.known(upstream) && !seen[upstream] {
+				seen[upstream] = true
+				unknown = append(unknown, upstream)
+			}
+		}
+	}
+
+	// now, compute the roots of each job, detecting cycles.
+	roots := make(map[int64]int64)
+	visiting := make(map[int64]bool)
+	var rootOf func(id int64) (int64, error)
+	rootOf = func(id int64) (int64, error) {
+		if root, ok := roots[id]; ok {
+			return root, nil
+		}
+		if visiting[id] {
+			return 0, ErrWorkflowCycle
+		}
+		ids, hasUpstreams := upstreams[id]
+		if !hasUpstreams {
+			return id, nil
+		}
+		visiting[id] = true
+		defer delete(visiting, id)
+		var result int64
+		for i, upstream := range ids {
+			root, err := rootOf(upstream)
+			if err != nil {
+				return 0, err
+			}
+			if i > 0 && root != result {
+				return 0, ErrWorkflowMultipleRoots
+			}
+			result = root
+		}
+		roots[id] = result
+		return result, nil
+	}
+	for _, job := range jobs {
+		if _, err := rootOf(job.ID); err != nil {
+			return nil, err
+		}
+	}
+	return unknown, nil
+}
+
+// checkDelete makes sure that deleting `jobsID` does not
+// leave any downstream job without one of its upstreams.
+func (g *workflowGraph) checkDelete(jobsID []int64) error {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	deleted := make(map[int64]bool, len(jobsID))
+	for _, id := range jobsID {
+		deleted[id] = true
+	}
+	for _, id := range jobsID {
+		for _, downstream := range g.downstreams[id] {
+			if !deleted[downstream] {
+				return fmt.Errorf("%w: job %d is an upstream of job %d", ErrJobHasDownstreams, id, downstream)
+			}
+		}
+	}
+	return nil
+}
+
+// workflow returns the ids of all the jobs depending on `rootID`,
+// root included, together with a copy of their upstreams and downstreams.
+func (g *workflowGraph) workflow(rootID int64) ([]int64, map[int64][]int64, map[int64][]int64) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	ids := []int64{rootID}
+	upstreams := make(map[int64][]int64)
+	downstreams := make(map[int64][]int64)
+	visited := map[int64]bool{rootID: true}
+	for i := 0; i < len(ids); i++ {
+		downstreams[ids[i]] = append([]int64(nil), g.downstreams[ids[i]]...)
+		upstreams[ids[i]] = append([]int64(nil), g.upstreams[ids[i]]...)
+		for _, downstream := range g.downstreams[ids[i]] {
+			if !visited[downstream] {
+				visited[downstream] = true
+				ids = append(ids, downstream)
+			}
+		}
+	}
+	return ids, upstreams, downstreams
+}
+
+// runWorkflow executes the workflow whose root is `root`.
+// Jobs are executed one at a time, together with the jobs chained to them:
+// a job is executed as soon as all of its upstreams succeeded. Jobs that cannot be executed because
+// one of their upstreams failed, or has been skipped, are marked as skipped.
+// The state of the run is persisted after each step.
+// The whole run happens within the execution of the root, hence
+// the wrappers of SchedulerConfig, like SkipIfStillRunning, apply
+// to it as a whole: with them, the root does not fire again
+// until its run is over.
+func (s *SmallBen) runWorkflow(root JobWithSchedule) {
+	repository, ok := s.repository.(WorkflowRepository)
+	if !ok {
+		// cannot happen, since jobs with upstreams are refused
+		// by AddJobs in this case.
+		runJobWithSchedule(root)
+		return
+	}
+
+	ids, upstreams, downstreams := s.workflows.workflow(root.rawJob.ID)
+	run := WorkflowRun{
+		RootJobID:   root.rawJob.ID,
+		ScheduledAt: time.Now(),
+		Status:      WorkflowStatusRunning,
+		Jobs:        make([]WorkflowRunJob, len(ids)),
+	}
+	// position of each job within run.Jobs
+	positions := make(map[int64]int, len(ids))
+	for i, id := range ids {
+		run.Jobs[i] = WorkflowRunJob{JobID: id, Status: WorkflowStatusPending}
+		positions[id] = i
+	}
+
+	s.logger.Info("Running workflow", "Progress", "InProgress", "RootID", run.RootJobID, "IDs", ids)
+	if err := repository.AddWorkflowRun(&run); err != nil {
+		s.logger.Error(err, "Running workflow", "Progress", "Error", "Details", "AddingToRepository", "RootID", run.RootJobID)
+		return
+	}
+
+	// update sets the state of the job in position i
+	// and persists it.
+	update := func(i int, status WorkflowStatus, cause error) {
+		run.Jobs[i].Status = status
+		now := time.Now()
+		switch status {
+		case WorkflowStatusRunning:
+			run.Jobs[i].StartedAt = now
+		default:
+			run.Jobs[i].FinishedAt = now
+		}
+		if cause != nil {
+			run.Jobs[i].Error = cause.Error()
+		}
+		if err := repository.UpdateWorkflowRunJob(&run.Jobs[i]); err != nil {
+			s.logger.Error(err, "Running workflow", "Progress", "Error", "Details", "UpdatingInRepository",
+				"RunID", run.ID, "ID", run.Jobs[i].JobID)
+		}
+	}
+
+	queue := []int64{root.rawJob.ID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		i := positions[id]
+
+		job := root
+		if id != root.rawJob.ID {
+			var err error
+			// always grab the latest version of the job
+			if job, err = s.repository.GetJob(id); err != nil {
+				update(i, WorkflowStatusFailed, err)
+				continue
+			}
+			if job.rawJob.Paused {
+				update(i, WorkflowStatusSkipped, errors.New("job is paused"))
+				s.metrics.executions.skipRun(&job.rawJob)
+				continue
+			}
+		}
+
+		update(i, WorkflowStatusRunning, nil)
+		job.runInput.WorkflowRunID = run.ID
+		if err := s.runChain(job); err != nil {
+			update(i, WorkflowStatusFailed, err)
+			continue
+		}
+		update(i, WorkflowStatusSucceeded, nil)
+
+		// now, look for the downstreams that are ready.
+		for _, downstream := range downstreams[id] {
+			ready := true
+			for _, upstream := range upstreams[downstream] {
+				if run.Jobs[positions[upstream]].Status != WorkflowStatusSucceeded {
+					ready = false
+					break
+				}
+			}
+			if ready && run.Jobs[positions[downstream]].Status == WorkflowStatusPending {
+				queue = append(queue, downstream)
+			}
+		}
+	}
+
+	// the jobs still pending will never be executed.
+	run.Status = WorkflowStatusSucceeded
+	for i := range run.Jobs {
+		if run.Jobs[i].Status == WorkflowStatusPending {
+			update(i, WorkflowStatusSkipped, errors.New("upstream did not succeed"))
+			// the groups are needed by the metrics
+			skipped := RawJob{ID: run.Jobs[i].JobID}
+			if job, err := s.repository.GetJob(skipped.ID); err == nil {
+				skipped = job.rawJob
+			}
+			s.metrics.executions.skipRun(&skipped)
+		}
+		switch run.Jobs[i].Status {
+		case WorkflowStatusFailed:
+			run.Status = WorkflowStatusFailed
+		case WorkflowStatusSkipped:
+			if run.Status != WorkflowStatusFailed {
+				run.Status = WorkflowStatusSkipped
+			}
+		}
+	}
+	if err := repository.UpdateWorkflowRun(&run); err != nil {
+		s.logger.Error(err, "Running workflow", "Progress", "Error", "Details", "UpdatingInRepository", "RunID", run.ID)
+		return
+	}
+	s.logger.Info("Running workflow", "Progress", "Done", "RunID", run.ID, "Status", run.Status)
+}
+
+// GetWorkflowRun returns the workflow run whose id is `runID`.
+// It returns ErrWorkflowsNotSupported if the repository
+// does not implement WorkflowRepository.
+func (s *SmallBen) GetWorkflowRun(runID int64) (WorkflowRun, error) {
+	repository, ok := s.repository.(WorkflowRepository)
+	if !ok {
+		return WorkflowRun{}, ErrWorkflowsNotSupported
+	}
+	return repository.GetWorkflowRun(runID)
+}
+
+// ListWorkflowRuns returns the workflow runs according to `options`.
+// It returns ErrWorkflowsNotSupported if the repository
+// does not implement WorkflowRepository.
+func (s *SmallBen) ListWorkflowRuns(options *ListWorkflowRunsOptions) ([]WorkflowRun, error) {
+	repository, ok := s.repository.(WorkflowRepository)
+	if !ok {
+		return nil, ErrWorkflowsNotSupported
+	}
+	return repository.ListWorkflowRuns(options)
+}
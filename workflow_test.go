@@ -0,0 +1,293 @@
+package smallben
+
+import (
+	"encoding/gob"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// workflowExecuted keeps track of the execution
+// of the jobs during the workflow tests.
+var workflowExecuted = struct {
+	data map[int64]int64
+	lock sync.Mutex
+}{data: make(map[int64]int64)}
+
+// WorkflowTestCronJob records the workflow run
+// it has been executed within.
+type WorkflowTestCronJob struct{}
+
+func (w *WorkflowTestCronJob) Run(input CronJobInput) {
+	workflowExecuted.lock.Lock()
+	defer workflowExecuted.lock.Unlock()
+	workflowExecuted.data[input.JobID] = input.WorkflowRunID
+}
+
+// WorkflowTestFailingCronJob always fails.
+type WorkflowTestFailingCronJob struct{}
+
+func (w *WorkflowTestFailingCronJob) Run(input CronJobInput) {
+	panic("failing on purpose")
+}
+
+func init() {
+	gob.Register(&WorkflowTestCronJob{})
+	gob.Register(&WorkflowTestFailingCronJob{})
+}
+
+type testWorkflowGraphCheck struct {
+	existing []Job
+	toAdd    []Job
+	unknown  []int64
+	err      error
+}
+
+func (w *testWorkflowGraphCheck) test(t *testing.T) {
+	graph := newWorkflowGraph()
+	graph.add(w.existing)
+	unknown, err := graph.check(w.toAdd)
+	if w.err != nil {
+		checkErrorIsOf(err, w.err, t)
+	} else if err != nil {
+		t.Errorf("Fail to check the graph: %s\n", err.Error())
+	}
+	if len(unknown) != len(w.unknown) {
+		t.Errorf("Unknown upstreams mismatch. Got: %v, expected: %v\n", unknown, w.unknown)
+	}
+}
+
+func TestWorkflowGraphCheck(t *testing.T) {
+	pairs := []testWorkflowGraphCheck{
+		{
+			// a simple chain within the batch
+			toAdd: []Job{
+				{ID: 1},
+				{ID: 2, UpstreamIDs: []int64{1}},
+				{ID: 3, UpstreamIDs: []int64{2}},
+			},
+		},
+		{
+			// the upstream is not known
+			toAdd: []Job{
+				{ID: 2, UpstreamIDs: []int64{1}},
+			},
+			unknown: []int64{1},
+		},
+		{
+			// the upstream is in the graph
+			existing: []Job{
+				{ID: 2, UpstreamIDs: []int64{1}},
+			},
+			toAdd: []Job{
+				{ID: 3, UpstreamIDs: []int64{1, 2}},
+			},
+		},
+		{
+			// a job depending on itself
+			toAdd: []Job{
+				{ID: 1, UpstreamIDs: []int64{1}},
+			},
+			err: ErrWorkflowCycle,
+		},
+		{
+			// a cycle within the batch
+			toAdd: []Job{
+				{ID: 1, UpstreamIDs: []int64{3}},
+				{ID: 2, UpstreamIDs: []int64{1}},
+				{ID: 3, UpstreamIDs: []int64{2}},
+			},
+			err: ErrWorkflowCycle,
+		},
+		{
+			// depending on two roots
+			existing: []Job{
+				{ID: 2, UpstreamIDs: []int64{1}},
+			},
+			toAdd: []Job{
+				{ID: 3, UpstreamIDs: []int64{2, 4}},
+			},
+			err: ErrWorkflowMultipleRoots,
+		},
+	}
+
+	for _, pair := range pairs {
+		pair.test(t)
+	}
+}
+
+func TestWorkflowGraph(t *testing.T) {
+	graph := newWorkflowGraph()
+	graph.add([]Job{
+		{ID: 1},
+		{ID: 2, UpstreamIDs: []int64{1}},
+		{ID: 3, UpstreamIDs: []int64{1}},
+		{ID: 4, UpstreamIDs: []int64{2, 3}},
+	})
+
+	if !graph.isRoot(1) || graph.isRoot(2) || graph.isRoot(5) {
+		t.Errorf("Roots are wrong\n")
+	}
+	ids, _, _ := graph.workflow(1)
+	if len(ids) != 4 {
+		t.Errorf("Workflow is wrong. Got: %v\n", ids)
+	}
+
+	// cannot delete an upstream without its downstreams
+	checkErrorIsOf(graph.checkDelete([]int64{2}), ErrJobHasDownstreams, t)
+	if err := graph.checkDelete([]int64{2, 4}); err != nil {
+		t.Errorf("Fail to check the deletion: %s\n", err.Error())
+	}
+
+	graph.remove([]int64{4, 3, 2})
+	if graph.isRoot(1) {
+		t.Errorf("Job 1 should not be a root anymore\n")
+	}
+}
+
+func (s *SmallBenTestSuite) TestWorkflow(t *testing.T) {
+	err := s.smallBen.Start()
+	if err != nil {
+		t.Errorf("Cannot even start: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// a job with upstreams cannot have a schedule
+	err = s.smallBen.AddJobs([]Job{{ID: 1000, CronExpression: "@every 1s", UpstreamIDs: []int64{1}}})
+	checkErrorIsOf(err, ErrWorkflowJobWithSchedule, t)
+
+	err = s.smallBen.AddJobs(s.jobs)
+	if err != nil {
+		t.Errorf("Fail to add jobs: %s\n", err.Error())
+		t.FailNow()
+	}
+
+	// only the root has been scheduled
+	if len(s.smallBen.scheduler.cron.Entries()) != 1 {
+		t.Errorf("Only the root should have been scheduled. Got: %d\n", len(s.smallBen.scheduler.cron.Entries()))
+	}
+
+	// the dependencies are loaded from the repository on restart
+	upstreams, err := s.smallBen.repository.(WorkflowRepository).ListUpstreams()
+	if err != nil {
+		t.Fatalf("Fail to list the upstreams: %s", err.Error())
+	}
+	if len(upstreams) != len(s.jobs)-1 {
+		t.Errorf("Wrong number of jobs with upstreams. Got: %d, expected: %d", len(upstreams), len(s.jobs)-1)
+	}
+	restarted := New(s.smallBen.repository, &Config{})
+	if err = restarted.fillWorkflows(); err != nil {
+		t.Fatalf("Fail to fill the workflows: %s", err.Error())
+	}
+	if !reflect.DeepEqual(restarted.workflows.upstreams, s.smallBen.workflows.upstreams) ||
+		!reflect.DeepEqual(restarted.workflows.downstreams, s.smallBen.workflows.downstreams) {
+		t.Errorf("Wrong dependencies. Got: %v, expected: %v", restarted.workflows.upstreams, s.smallBen.workflows.upstreams)
+	}
+
+	// the root cannot be deleted alone
+	err = s.smallBen.DeleteJobs(&DeleteOptions{PauseResumeOptions: PauseResumeOptions{
+		JobIDs: []int64{s.jobs[0].ID},
+	}})
+	checkErrorIsOf(err, ErrJobHasDownstreams, t)
+
+	// wait for the root to fire, then stop
+	// to wait for the run to complete.
+	time.Sleep(1500 * time.Millisecond)
+	s.smallBen.Stop()
+
+	runs, err := s.smallBen.ListWorkflowRuns(&ListWorkflowRunsOptions{RootJobIDs: []int64{s.jobs[0].ID}})
+	if err != nil {
+		t.Errorf("Fail to list workflow runs: %s\n", err.Error())
+		t.FailNow()
+	}
+	if len(runs) == 0 {
+		t.Errorf("No workflow run has been done\n")
+		t.FailNow()
+	}
+
+	run, err := s.smallBen.GetWorkflowRun(runs[0].ID)
+	if err != nil {
+		t.Errorf("Fail to get workflow run: %s\n", err.Error())
+		t.FailNow()
+	}
+	if run.Status != WorkflowStatusFailed {
+		t.Errorf("Wrong run status. Got: %s, expected: %s\n", run.Status, WorkflowStatusFailed)
+	}
+
+	expected := map[int64]WorkflowStatus{
+		101: WorkflowStatusSucceeded,
+		102: WorkflowStatusSucceeded,
+		103: WorkflowStatusFailed,
+		104: WorkflowStatusSucceeded,
+		105: WorkflowStatusSkipped,
+	}
+	if len(run.Jobs) != len(expected) {
+		t.Errorf("Wrong number of jobs in the run. Got: %d, expected: %d\n", len(run.Jobs), len(expected))
+	}
+	for _, job := range run.Jobs {
+		if job.Status != expected[job.JobID] {
+			t.Errorf("Wrong status for job %d. Got: %s, expected: %s\n", job.JobID, job.Status, expected[job.JobID])
+		}
+	}
+
+	// downstream jobs receive the id of the run
+	workflowExecuted.lock.Lock()
+	defer workflowExecuted.lock.Unlock()
+	if workflowExecuted.data[104] != run.ID {
+		t.Errorf("Wrong WorkflowRunID. Got: %d, expected: %d\n", workflowExecuted.data[104], run.ID)
+	}
+}
+
+func TestSmallBenWorkflow(t *testing.T) {
+	tests := buildSmallBenTestSuite(t)
+
+	for _, test := range tests {
+		test.setup(t)
+		test.jobs = WorkflowJobsToUse
+		test.TestWorkflow(t)
+		test.teardown(false, t)
+	}
+}
+
+// WorkflowJobsToUse is a workflow where
+// 101 -> 102 -> 104 succeed, while
+// 101 -> 103 fails, hence 105 is skipped.
+var WorkflowJobsToUse = []Job{
+	{
+		ID:             101,
+		GroupID:        1,
+		SuperGroupID:   1,
+		CronExpression: "@every 1s",
+		Job:            &WorkflowTestCronJob{},
+		JobInput:       map[string]interface{}{},
+	}, {
+		ID:           102,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &WorkflowTestCronJob{},
+		JobInput:     map[string]interface{}{},
+		UpstreamIDs:  []int64{101},
+	}, {
+		ID:           103,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &WorkflowTestFailingCronJob{},
+		JobInput:     map[string]interface{}{},
+		UpstreamIDs:  []int64{101},
+	}, {
+		ID:           104,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &WorkflowTestCronJob{},
+		JobInput:     map[string]interface{}{},
+		UpstreamIDs:  []int64{102},
+	}, {
+		ID:           105,
+		GroupID:      1,
+		SuperGroupID: 1,
+		Job:          &WorkflowTestCronJob{},
+		JobInput:     map[string]interface{}{},
+		UpstreamIDs:  []int64{103},
+	},
+}